@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"forge/internal/config"
+	"forge/internal/utils"
+	"forge/internal/worker"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage: admin <command> [flags]
+
+Commands:
+  dlq list                      List the messages in the dead-letter queue
+  dlq redrive [-all] [ids...]   Send dead letters back to the build queue
+`
+
+func main() {
+	if config.Getenv("APP_ENV", "") == "local" {
+		if err := godotenv.Load(); err != nil {
+			log.Println("No .env file found")
+		}
+	}
+
+	if len(os.Args) < 3 || os.Args[1] != "dlq" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[2] {
+	case "list":
+		err = listDeadLetters(os.Args[3:])
+	case "redrive":
+		err = redriveDeadLetters(os.Args[3:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newDeadLetterQueue() (*worker.DeadLetterQueue, error) {
+	dlqURL := config.Getenv("AWS_SQS_DLQ_URL", "")
+	if dlqURL == "" {
+		return nil, fmt.Errorf("AWS_SQS_DLQ_URL is not set")
+	}
+
+	sqsSvc, err := utils.GetSQSService(config.LoadAWS())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SQS service: %w", err)
+	}
+
+	return worker.NewDeadLetterQueue(sqsSvc, config.Getenv("AWS_SQS_URL", ""), dlqURL), nil
+}
+
+func listDeadLetters(args []string) error {
+	flags := flag.NewFlagSet("dlq list", flag.ExitOnError)
+	showBody := flags.Bool("body", false, "print the message bodies")
+	flags.Parse(args)
+
+	dlq, err := newDeadLetterQueue()
+	if err != nil {
+		return err
+	}
+
+	// Listed messages are hidden briefly, they reappear in the queue afterwards
+	letters, err := dlq.List(context.Background(), 30*time.Second)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MESSAGE ID\tTYPE\tREASON\tATTEMPTS\tDEAD-LETTERED AT\tERROR")
+	for _, letter := range letters {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", letter.MessageId, letter.MessageType, letter.Reason, letter.ReceiveCount, letter.DeadLetterAt, letter.Error)
+		if *showBody {
+			fmt.Fprintf(w, "\t%s\n", letter.Body)
+		}
+	}
+	w.Flush()
+
+	fmt.Printf("%d dead letter(s)\n", len(letters))
+	return nil
+}
+
+func redriveDeadLetters(args []string) error {
+	flags := flag.NewFlagSet("dlq redrive", flag.ExitOnError)
+	all := flags.Bool("all", false, "redrive every dead letter")
+	flags.Parse(args)
+
+	if !*all && flags.NArg() == 0 {
+		return fmt.Errorf("pass the message ids to redrive, or -all")
+	}
+
+	dlq, err := newDeadLetterQueue()
+	if err != nil {
+		return err
+	}
+
+	letters, err := dlq.List(context.Background(), 5*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	selected := letters
+	if !*all {
+		wanted := make(map[string]bool, flags.NArg())
+		for _, id := range flags.Args() {
+			wanted[id] = true
+		}
+
+		selected = nil
+		for _, letter := range letters {
+			if wanted[letter.MessageId] {
+				selected = append(selected, letter)
+				delete(wanted, letter.MessageId)
+			}
+		}
+		for id := range wanted {
+			log.Printf("Dead letter %s not found", id)
+		}
+	}
+
+	redriven, err := dlq.Redrive(context.Background(), selected)
+	fmt.Printf("Redrove %d dead letter(s)\n", redriven)
+	return err
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"forge/internal/cli"
+	"forge/internal/config"
+	"forge/internal/deployment"
+	"forge/internal/dev"
+	"forge/internal/provenance"
+	"forge/internal/utils"
+	"forge/internal/worker"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const usage = `Usage: aether [-profile name] [-config path] [-json] <command> [flags] <project>
+
+Commands:
+  deploy [-repo url] [-ref ref] [-commit sha] [-build-command cmd] [-env KEY=VALUE]... [-preview branch] <project>
+                                        Enqueue a build, the repository defaults to the push trigger
+  logs [-n lines] [-f] <project>        Print the build logs, -f follows them
+  deployments [-n count] <project>      List the deployments, newest first
+  rollback [-to deployment] <project>   Make the deployment before the live one live again
+  open [-print] [-preview branch] <project>
+                                        Open the live URL in a browser
+  verify -key pub.pem [-deployment id] <project>
+                                        Verify the signed provenance of the live or given deployment
+  verify -key pub.pem -envelope file [-dir dir]
+                                        Verify a downloaded provenance, and the files in dir
+  dev [-addr address] [-data dir] [-api-token token] [-repo url] [-ref ref] [-build-command cmd] [-env KEY=VALUE]... [-project id]
+                                        Run forge, logify and the proxy locally against a local Postgres
+
+Profiles are read from AETHER_CONFIG, or ~/.aether/config.yaml.
+`
+
+func main() {
+	log.SetFlags(0)
+
+	flags := flag.NewFlagSet("aether", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	profileName := flags.String("profile", "", "profile to use, defaults to AETHER_PROFILE or the default profile")
+	profilePath := flags.String("config", cli.DefaultProfilePath(), "profile file")
+	jsonOutput := flags.Bool("json", false, "print JSON instead of text")
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	args := flags.Args()
+	// The development server needs no profile
+	if args[0] == "dev" {
+		if err := runDev(ctx, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	profile, err := cli.LoadProfile(*profilePath, *profileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c := &cli.CLI{
+		Profile: profile,
+		Out:     os.Stdout,
+		JSON:    *jsonOutput || profile.Output == "json",
+	}
+
+	switch args[0] {
+	case "deploy":
+		err = deploy(ctx, c, args[1:])
+	case "logs":
+		err = logs(ctx, c, args[1:])
+	case "deployments":
+		err = deployments(ctx, c, args[1:])
+	case "rollback":
+		err = rollback(ctx, c, args[1:])
+	case "open":
+		err = open(c, args[1:])
+	case "verify":
+		err = verify(ctx, c, args[1:])
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// projectArg returns the single project argument of a command.
+func projectArg(flags *flag.FlagSet) string {
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "%s takes a single project id\n", flags.Name())
+		flags.Usage()
+		os.Exit(2)
+	}
+	return flags.Arg(0)
+}
+
+func newQueue(profile *cli.Profile) (*worker.BuildQueue, error) {
+	if profile.QueueURL == "" {
+		return nil, fmt.Errorf("queue_url is not set in the profile")
+	}
+	sqsSvc, err := utils.GetSQSService(profile.AWS())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SQS service: %w", err)
+	}
+	return worker.NewBuildQueue(sqsSvc, profile.QueueURL), nil
+}
+
+func newStore(profile *cli.Profile) (*deployment.Store, error) {
+	if profile.Bucket == "" {
+		return nil, fmt.Errorf("bucket is not set in the profile")
+	}
+	s3Client, err := utils.GetS3Service(profile.AWS())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	return deployment.NewStore(deployment.NewS3Bucket(s3Client, profile.Bucket)), nil
+}
+
+// envFlag collects repeated KEY=VALUE flags.
+type envFlag map[string]string
+
+func (e envFlag) String() string { return "" }
+
+func (e envFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid env %q, it must be KEY=VALUE", value)
+	}
+	e[key] = val
+	return nil
+}
+
+func deploy(ctx context.Context, c *cli.CLI, args []string) error {
+	flags := flag.NewFlagSet("deploy", flag.ExitOnError)
+	env := envFlag{}
+	opts := cli.DeployOptions{}
+	flags.StringVar(&opts.RepoURL, "repo", "", "repository URL, defaults to the push trigger of the project")
+	flags.StringVar(&opts.Ref, "ref", "", "branch or tag to build")
+	flags.StringVar(&opts.CommitSHA, "commit", "", "commit to build")
+	flags.StringVar(&opts.BuildCommand, "build-command", "", "build command")
+	flags.StringVar(&opts.PreviewBranch, "preview", "", "build a preview of this branch instead of a live deployment")
+	flags.Var(env, "env", "build environment variable as KEY=VALUE, repeatable")
+	flags.Parse(args)
+	opts.ProjectId = projectArg(flags)
+	if len(env) > 0 {
+		opts.Env = env
+	}
+
+	queue, err := newQueue(c.Profile)
+	if err != nil {
+		return err
+	}
+	c.Queue = queue
+	return c.Deploy(ctx, opts)
+}
+
+func logs(ctx context.Context, c *cli.CLI, args []string) error {
+	flags := flag.NewFlagSet("logs", flag.ExitOnError)
+	lines := flags.Int("n", 50, "number of lines to print")
+	follow := flags.Bool("f", false, "keep printing new lines")
+	interval := flags.Duration("interval", 2*time.Second, "how often followed logs are polled")
+	flags.Parse(args)
+	projectId := projectArg(flags)
+
+	if *lines < 1 {
+		return fmt.Errorf("invalid -n: %d", *lines)
+	}
+	return c.Logs(ctx, projectId, *lines, *follow, *interval)
+}
+
+func deployments(ctx context.Context, c *cli.CLI, args []string) error {
+	flags := flag.NewFlagSet("deployments", flag.ExitOnError)
+	count := flags.Int("n", 20, "number of deployments to list, 0 lists all")
+	flags.Parse(args)
+	projectId := projectArg(flags)
+
+	store, err := newStore(c.Profile)
+	if err != nil {
+		return err
+	}
+	c.Deployments = store
+	return c.ListDeployments(ctx, projectId, *count)
+}
+
+func rollback(ctx context.Context, c *cli.CLI, args []string) error {
+	flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+	to := flags.String("to", "", "deployment to make live, defaults to the one before the live one")
+	flags.Parse(args)
+	projectId := projectArg(flags)
+
+	queue, err := newQueue(c.Profile)
+	if err != nil {
+		return err
+	}
+	c.Queue = queue
+	return c.Rollback(ctx, projectId, *to)
+}
+
+func open(c *cli.CLI, args []string) error {
+	flags := flag.NewFlagSet("open", flag.ExitOnError)
+	printOnly := flags.Bool("print", false, "only print the URL")
+	preview := flags.String("preview", "", "open the preview of this branch")
+	flags.Parse(args)
+	projectId := projectArg(flags)
+
+	// Scripts only want the URL
+	return c.Open(projectId, *preview, !*printOnly && !c.JSON)
+}
+
+func verify(ctx context.Context, c *cli.CLI, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyFile := flags.String("key", "", "PEM ed25519 public key the provenance must be signed with")
+	opts := cli.VerifyOptions{}
+	flags.StringVar(&opts.DeploymentId, "deployment", "", "deployment to verify, defaults to the live one")
+	flags.StringVar(&opts.EnvelopeFile, "envelope", "", "verify this provenance file instead of the one in the bucket")
+	flags.StringVar(&opts.Dir, "dir", "", "with -envelope, check the files in this directory")
+	flags.Parse(args)
+	if *keyFile == "" {
+		return fmt.Errorf("-key is required")
+	}
+	publicKey, err := provenance.ReadPublicKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	opts.PublicKey = publicKey
+
+	// A downloaded provenance needs no project
+	if opts.EnvelopeFile != "" {
+		if flags.NArg() != 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		return c.Verify(ctx, opts)
+	}
+	if opts.Dir != "" {
+		return fmt.Errorf("-dir needs -envelope")
+	}
+	opts.ProjectId = projectArg(flags)
+
+	store, err := newStore(c.Profile)
+	if err != nil {
+		return err
+	}
+	c.Deployments = store
+	return c.Verify(ctx, opts)
+}
+
+func runDev(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("dev", flag.ExitOnError)
+	cfg := &dev.Config{}
+	env := envFlag{}
+	req := &dev.DeployRequest{}
+	flags.StringVar(&cfg.Address, "addr", "localhost:8080", "address serving the sites, the logs and the development API")
+	flags.StringVar(&cfg.DataDir, "data", ".aether", "directory keeping the deployments")
+	flags.StringVar(&cfg.APIToken, "api-token", config.Getenv("API_TOKEN", ""), "token of the forge API, it is not served when empty")
+	projectId := flags.String("project", "", "project id to deploy -repo as, defaults to a new one")
+	flags.StringVar(&req.RepoURL, "repo", "", "repository to deploy once the server started")
+	flags.StringVar(&req.Ref, "ref", "", "branch or tag to build")
+	flags.StringVar(&req.BuildCommand, "build-command", "", "build command")
+	flags.Var(env, "env", "build environment variable as KEY=VALUE, repeatable")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if len(env) > 0 {
+		req.Env = env
+	}
+	build, err := config.LoadBuild()
+	if err != nil {
+		return err
+	}
+	cfg.AllowedGitHosts = build.AllowedGitHosts
+	cfg.Sandbox = build.Sandbox
+	cfg.Provenance = build.Provenance
+	dbConfig, err := config.LoadDatabase()
+	if err != nil {
+		return err
+	}
+
+	stores, err := dev.OpenStores(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer stores.DB.Close()
+	server, err := dev.New(cfg, stores, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if req.RepoURL != "" {
+		if *projectId == "" {
+			*projectId = uuid.NewString()
+		}
+		queued, err := server.Deploy(ctx, *projectId, req)
+		if err != nil {
+			return err
+		}
+		log.Printf("Deploying %s as project %s, it is served at %s once built", req.RepoURL, queued.ProjectId, queued.URL)
+	}
+	return server.Run(ctx)
+}
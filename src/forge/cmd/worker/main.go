@@ -1,41 +1,153 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"forge/internal"
+	"forge/internal/api"
+	"forge/internal/config"
+	"forge/internal/database"
+	"forge/internal/deployment"
+	"forge/internal/grpctls"
 	"forge/internal/monitor"
 	"forge/internal/service"
+	"forge/internal/tracing"
+	"forge/internal/utils"
+	"forge/internal/webhook"
 	"forge/internal/worker"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Start the metrics server in a separate goroutine
-	go monitor.StartMetricsServer()
+	configFile := flag.String("config", config.Getenv("CONFIG_FILE", ""), "YAML file with settings that are not set in the environment")
+	checkConfig := flag.Bool("check-config", false, "validate the config, print it and exit")
+	flag.Parse()
 
-	appEnv := os.Getenv("APP_ENV")
-	if appEnv == "local" {
+	if config.Getenv("APP_ENV", "") == "local" {
 		if err := godotenv.Load(); err != nil {
 			log.Println("No .env file found")
 		}
 	}
 
-	GRPC_SERVER_ADDRESS := os.Getenv("GRPC_SERVER_ADDRESS")
-	grpcClient := internal.NewGrpcClient(GRPC_SERVER_ADDRESS)
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Invalid config:\n%v", err)
+	}
+	if *checkConfig {
+		cfg.Print(os.Stdout)
+		fmt.Println("Config is valid")
+		return
+	}
+	cfg.Print(log.Writer())
+
+	// The metrics server keeps serving probes while the worker drains
+	metricsServer := monitor.StartMetricsServer()
+	defer metricsServer.Shutdown(context.Background())
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "forge", cfg.TracesExporter, cfg.OTLPEndpoint)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	creds, err := grpctls.ClientCredentials(cfg.GRPCTLS)
+	if err != nil {
+		log.Fatalf("Failed to load gRPC credentials: %v", err)
+	}
+
+	grpcClient := internal.NewGrpcClient(cfg.LaunchpadAddress, creds)
 	defer grpcClient.Close()
 
-	projectService := service.NewProjectServiceClient(grpcClient)
+	// Rollouts send SIGTERM, in-flight builds are drained before the connections are closed
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	outbox, err := service.NewOutbox(cfg.Status.OutboxDir)
+	if err != nil {
+		log.Fatalf("Failed to open status outbox: %v", err)
+	}
+	projectService := service.NewProjectServiceClient(grpcClient, outbox, cfg.Status)
+	// Status updates left by a previous run are replayed first
+	go projectService.RunOutbox(ctx)
 
-	LOGS_GRPC_SERVER_ADDRESS := os.Getenv("LOGS_GRPC_SERVER_ADDRESS")
-	logGrpcClient := internal.NewGrpcClient(LOGS_GRPC_SERVER_ADDRESS)
+	logGrpcClient := internal.NewGrpcClient(cfg.LogifyAddress, creds)
 	defer logGrpcClient.Close()
 
 	logService := service.NewProjectLogServiceClient(logGrpcClient)
 
-	queueURL := os.Getenv("AWS_SQS_URL")
-	workerType := os.Getenv("WORKER_TYPE")
+	monitor.Health.AddHealth("docker", utils.DockerCheck(cfg.Sandbox.DockerHost))
+	monitor.Health.AddHealth("launchpad", internal.GrpcCheck(grpcClient))
+	monitor.Health.AddHealth("logify", internal.GrpcCheck(logGrpcClient))
+
+	db := database.New(cfg.Database)
+	defer db.Close()
+
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	webhookStore := database.NewWebhookStore(cfg.Database)
+	dispatcher := webhook.NewDispatcher(webhookStore, cfg.Webhooks)
+	go dispatcher.Run(ctx)
+
+	sqsSvc, err := utils.GetSQSService(&cfg.AWS)
+	if err != nil {
+		log.Fatalf("Failed to get SQS service %v", err)
+	}
+	s3Client, err := utils.GetS3Service(&cfg.AWS)
+	if err != nil {
+		log.Fatalf("Failed to create S3 client: %v", err)
+	}
+	store := deployment.NewStore(deployment.NewS3Bucket(s3Client, cfg.BucketName))
+
+	previewStore := database.NewPreviewStore(cfg.Database)
+	go worker.RunPreviewExpiry(ctx, store, previewStore, cfg.Previews.ExpiryInterval)
+
+	if cfg.API.Enabled() {
+		apiServices := &api.Services{
+			Webhooks: webhookStore,
+			Triggers: database.NewTriggerStore(cfg.Database),
+			Previews: previewStore,
+			Builds:   worker.NewBuildQueue(sqsSvc, cfg.Queue.URL),
+		}
+		for _, server := range []*http.Server{api.NewServer(cfg.API, apiServices), api.NewHooksServer(cfg.API, apiServices)} {
+			go func() {
+				if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					log.Fatalf("API server failed: %v", err)
+				}
+			}()
+			defer server.Shutdown(context.Background())
+		}
+	}
+
+	registry, err := worker.NewRegistry(&worker.Services{
+		Projects:       projectService,
+		Logs:           logService,
+		DB:             db,
+		Webhooks:       dispatcher,
+		Previews:       previewStore,
+		Store:          store,
+		Provenance:     cfg.Provenance,
+		Retention:      cfg.Retention,
+		Repos:          utils.NewRepoURLValidator(cfg.AllowedGitHosts),
+		Retry:          cfg.Queue.Retry,
+		LeaseTTL:       cfg.LeaseTTL,
+		PreviewConfig:  cfg.Previews,
+		Sandbox:        cfg.Sandbox,
+		TrustedSenders: cfg.TrustedSenders,
+	}, cfg.Registry)
+	if err != nil {
+		log.Fatalf("Failed to create message registry: %v", err)
+	}
 
-	worker.Run(queueURL, workerType, projectService, logService)
+	worker.Run(ctx, sqsSvc, cfg.Queue, registry)
+	log.Println("Worker stopped")
 }
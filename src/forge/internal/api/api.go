@@ -0,0 +1,275 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forge/internal/database"
+	"forge/internal/webhook"
+	"forge/internal/worker"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Config locates the forge HTTP API.
+type Config struct {
+	Address string
+	// HooksAddress serves the webhooks of git hosts, it is the only part
+	// meant to be reachable from the internet.
+	HooksAddress string
+	// Token authenticates callers, the API is disabled when it is empty.
+	Token string
+}
+
+// Default addresses of the API and the hooks.
+const (
+	DefaultAddress      = ":8081"
+	DefaultHooksAddress = ":8082"
+)
+
+// Enabled reports whether the API is served.
+func (c *Config) Enabled() bool {
+	return c.Token != ""
+}
+
+// maxDeliveries bounds the deliveries listed at once.
+const maxDeliveries = 200
+
+// Services are used by the API handlers.
+type Services struct {
+	Webhooks database.WebhookStore
+	Triggers database.TriggerStore
+	Previews database.PreviewStore
+	Builds   BuildQueue
+}
+
+// BuildQueue enqueues Build and DeletePreview messages.
+type BuildQueue interface {
+	Enqueue(ctx context.Context, msg *worker.BuildMessage) (string, error)
+	EnqueueDeletePreview(ctx context.Context, msg *worker.DeletePreviewMessage) (string, error)
+}
+
+// NewHandler returns the API, every request must carry the token as a bearer token:
+//
+//	POST   /projects/{projectId}/webhooks                                 create an endpoint, the response holds its secret
+//	GET    /projects/{projectId}/webhooks                                 list the endpoints
+//	DELETE /projects/{projectId}/webhooks/{webhookId}                     delete an endpoint
+//	GET    /projects/{projectId}/webhooks/deliveries?status=&limit=       list the latest deliveries
+//	POST   /projects/{projectId}/webhooks/deliveries/{deliveryId}/replay  send a delivery again
+//	PUT    /projects/{projectId}/push-trigger                             create or update the push trigger, a new one holds its secret
+//	GET    /projects/{projectId}/push-trigger                             get the push trigger
+//	DELETE /projects/{projectId}/push-trigger                             delete the push trigger
+//	GET    /projects/{projectId}/previews                                 list the branch previews
+func NewHandler(token string, services *Services) http.Handler {
+	h := &handler{services: services}
+
+	projects := http.NewServeMux()
+	projects.HandleFunc("POST /projects/{projectId}/webhooks", h.createWebhook)
+	projects.HandleFunc("GET /projects/{projectId}/webhooks", h.listWebhooks)
+	projects.HandleFunc("DELETE /projects/{projectId}/webhooks/{webhookId}", h.deleteWebhook)
+	projects.HandleFunc("GET /projects/{projectId}/webhooks/deliveries", h.listDeliveries)
+	projects.HandleFunc("POST /projects/{projectId}/webhooks/deliveries/{deliveryId}/replay", h.replayDelivery)
+	projects.HandleFunc("PUT /projects/{projectId}/push-trigger", h.putPushTrigger)
+	projects.HandleFunc("GET /projects/{projectId}/push-trigger", h.getPushTrigger)
+	projects.HandleFunc("DELETE /projects/{projectId}/push-trigger", h.deletePushTrigger)
+	projects.HandleFunc("GET /projects/{projectId}/previews", h.listPreviews)
+
+	return authenticate(token, projects)
+}
+
+// NewHooksHandler receives the webhooks of git hosts, they are verified with
+// the secrets of the matching push triggers:
+//
+//	POST /hooks/push  receive a GitHub, GitLab or Gitea push webhook
+func NewHooksHandler(services *Services) http.Handler {
+	h := &handler{services: services}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /hooks/push", h.receivePush)
+	return mux
+}
+
+// NewServer returns the API server, it is started by the caller.
+func NewServer(cfg *Config, services *Services) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Address,
+		Handler:           NewHandler(cfg.Token, services),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// NewHooksServer returns the server of the git host webhooks, it is started by the caller.
+func NewHooksServer(cfg *Config, services *Services) *http.Server {
+	return &http.Server{
+		Addr:              cfg.HooksAddress,
+		Handler:           NewHooksHandler(services),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+func authenticate(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			WriteError(w, http.StatusUnauthorized, "invalid API token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type handler struct {
+	services *Services
+}
+
+type createWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+func (h *handler) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req createWebhookRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := validateURL(req.URL); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(webhook.EventTypes, event) {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("unknown event type %q", event))
+			return
+		}
+	}
+	if req.Events == nil {
+		req.Events = []string{}
+	}
+
+	secret, err := newSecret("whsec_")
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	endpoint := &database.WebhookEndpoint{
+		Id:        uuid.NewString(),
+		ProjectId: r.PathValue("projectId"),
+		URL:       req.URL,
+		Secret:    secret,
+		Events:    req.Events,
+	}
+	if err := h.services.Webhooks.CreateWebhookEndpoint(r.Context(), endpoint); err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusCreated, endpoint)
+}
+
+func (h *handler) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := h.services.Webhooks.ListWebhookEndpoints(r.Context(), r.PathValue("projectId"))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, endpoints)
+}
+
+func (h *handler) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	err := h.services.Webhooks.DeleteWebhookEndpoint(r.Context(), r.PathValue("projectId"), r.PathValue("webhookId"))
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		WriteError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if !slices.Contains([]string{"", database.DeliveryPending, database.DeliverySucceeded, database.DeliveryFailed}, status) {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid status %q", status))
+		return
+	}
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxDeliveries {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit %q", value))
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := h.services.Webhooks.ListWebhookDeliveries(r.Context(), r.PathValue("projectId"), status, limit)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, deliveries)
+}
+
+func (h *handler) replayDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, err := h.services.Webhooks.ReplayWebhookDelivery(r.Context(), r.PathValue("projectId"), r.PathValue("deliveryId"))
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		WriteError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusAccepted, delivery)
+}
+
+// validateURL only checks the form of an endpoint URL, its address is
+// checked on every delivery.
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q, it must be an absolute http or https URL", rawURL)
+	}
+	if u.User != nil {
+		return errors.New("webhook URL must not contain credentials")
+	}
+	return nil
+}
+
+// newSecret returns a random signing secret.
+func newSecret(prefix string) (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return prefix + hex.EncodeToString(key), nil
+}
+
+// WriteJSON writes body as the JSON response of a request.
+func WriteJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// WriteError writes the JSON error response of a request.
+func WriteError(w http.ResponseWriter, status int, message string) {
+	WriteJSON(w, status, map[string]string{"error": message})
+}
+
+func writeInternalError(w http.ResponseWriter, err error) {
+	log.Printf("API request failed: %v", err)
+	WriteError(w, http.StatusInternalServerError, "internal error")
+}
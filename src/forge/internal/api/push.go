@@ -0,0 +1,286 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forge/internal/database"
+	"forge/internal/push"
+	"forge/internal/worker"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// maxPushBody bounds the push webhooks read, git hosts cap them a little lower.
+const maxPushBody = 25 << 20
+
+type putPushTriggerRequest struct {
+	RepoURL      string `json:"repoURL"`
+	Branch       string `json:"branch"`
+	BuildCommand string `json:"buildCommand"`
+	Previews     bool   `json:"previews"`
+}
+
+// QueuedBuild is a build enqueued for a push.
+type QueuedBuild struct {
+	ProjectId    string `json:"projectId"`
+	DeploymentId string `json:"deploymentId"`
+	MessageId    string `json:"messageId"`
+	// PreviewBranch is set for builds of branch previews.
+	PreviewBranch string `json:"previewBranch,omitempty"`
+}
+
+// QueuedPreviewDeletion is the deletion of a preview enqueued for a deleted branch.
+type QueuedPreviewDeletion struct {
+	ProjectId string `json:"projectId"`
+	Branch    string `json:"branch"`
+	MessageId string `json:"messageId"`
+}
+
+func (h *handler) putPushTrigger(w http.ResponseWriter, r *http.Request) {
+	var req putPushTriggerRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	repo := push.NormalizeRepoURL(req.RepoURL)
+	if repo == "" {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid repository URL %q", req.RepoURL))
+		return
+	}
+	if req.Branch == "" {
+		req.Branch = "main"
+	}
+	if strings.HasPrefix(req.Branch, "refs/") {
+		WriteError(w, http.StatusBadRequest, "branch must be a branch name, not a ref")
+		return
+	}
+
+	secret, err := newSecret("pushsec_")
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	trigger := &database.PushTrigger{
+		ProjectId:    r.PathValue("projectId"),
+		Repo:         repo,
+		RepoURL:      req.RepoURL,
+		Branch:       req.Branch,
+		BuildCommand: req.BuildCommand,
+		Previews:     req.Previews,
+		Secret:       secret,
+	}
+	created, err := h.services.Triggers.PutPushTrigger(r.Context(), trigger)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if !created {
+		// The secret is only shown once, the git host is already set up with it
+		trigger.Secret = ""
+		WriteJSON(w, http.StatusOK, trigger)
+		return
+	}
+	WriteJSON(w, http.StatusCreated, trigger)
+}
+
+func (h *handler) getPushTrigger(w http.ResponseWriter, r *http.Request) {
+	trigger, err := h.services.Triggers.GetPushTrigger(r.Context(), r.PathValue("projectId"))
+	if errors.Is(err, database.ErrTriggerNotFound) {
+		WriteError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, trigger)
+}
+
+func (h *handler) deletePushTrigger(w http.ResponseWriter, r *http.Request) {
+	err := h.services.Triggers.DeletePushTrigger(r.Context(), r.PathValue("projectId"))
+	if errors.Is(err, database.ErrTriggerNotFound) {
+		WriteError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// receivePush enqueues a build of every project deployed from the pushed
+// repository and branch, and a preview build of the projects with previews
+// when another branch is pushed. Deleting a branch deletes its previews. Each
+// project has its own secret, so a webhook only builds the projects whose
+// secret signed it. A webhook is processed once, redelivering it after a
+// failure enqueues the same deployments again.
+func (h *handler) receivePush(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPushBody))
+	if err != nil {
+		WriteError(w, http.StatusRequestEntityTooLarge, "push webhook is too large")
+		return
+	}
+
+	p, err := push.Parse(r.Header, body)
+	if errors.Is(err, push.ErrNotPush) {
+		// Pings and other events are acknowledged, so git hosts show the webhook as working
+		WriteJSON(w, http.StatusAccepted, map[string]string{"ignored": err.Error()})
+		return
+	}
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Unsigned webhooks are refused before looking up the repository, and an
+	// unknown repository is answered like a wrong signature, so a caller
+	// without a secret learns nothing about the projects
+	if !push.Signed(p.Provider, r.Header) {
+		WriteError(w, http.StatusUnauthorized, "invalid webhook signature")
+		return
+	}
+	triggers, err := h.findTriggers(r.Context(), p)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	var verified []database.PushTrigger
+	for _, trigger := range triggers {
+		if push.Verify(p.Provider, r.Header, body, trigger.Secret) {
+			verified = append(verified, trigger)
+		}
+	}
+	if len(verified) == 0 {
+		WriteError(w, http.StatusUnauthorized, "invalid webhook signature")
+		return
+	}
+
+	branch, ok := p.Branch()
+	if !ok {
+		WriteJSON(w, http.StatusAccepted, map[string]string{"ignored": "not a push to a branch"})
+		return
+	}
+
+	// Signatures do not cover the delivery id nor expire, so a webhook is also
+	// recognized by its body when it is replayed with other headers
+	digest := push.Digest(body)
+	claimed, err := h.services.Triggers.ClaimPushDelivery(r.Context(), digest, p.Delivery)
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	if !claimed {
+		WriteJSON(w, http.StatusOK, map[string]string{"ignored": "push was received already"})
+		return
+	}
+
+	builds := []QueuedBuild{}
+	deletions := []QueuedPreviewDeletion{}
+	for _, trigger := range verified {
+		var err error
+		switch {
+		case p.Deleted() && trigger.Previews && trigger.Branch != branch:
+			var deletion *QueuedPreviewDeletion
+			if deletion, err = h.enqueuePreviewDeletion(r.Context(), trigger, branch, p.Delivery); err == nil {
+				deletions = append(deletions, *deletion)
+			}
+		case p.Deleted():
+			continue
+		case trigger.Branch == branch:
+			var build *QueuedBuild
+			if build, err = h.enqueueBuild(r.Context(), trigger, p.After, "", digest); err == nil {
+				builds = append(builds, *build)
+			}
+		case trigger.Previews:
+			var build *QueuedBuild
+			if build, err = h.enqueueBuild(r.Context(), trigger, p.After, branch, digest); err == nil {
+				builds = append(builds, *build)
+			}
+		}
+		if err != nil {
+			// Git hosts let the webhook be redelivered, the builds enqueued
+			// already are enqueued again with the same deployment ids
+			log.Printf("Failed to enqueue message of project %s for push %s: %v", trigger.ProjectId, p.Delivery, err)
+			if err := h.services.Triggers.ReleasePushDelivery(context.WithoutCancel(r.Context()), digest); err != nil {
+				log.Printf("Failed to release push %s: %v", p.Delivery, err)
+			}
+			WriteError(w, http.StatusServiceUnavailable, "failed to enqueue build")
+			return
+		}
+	}
+
+	log.Printf("Push %s to %s@%s queued %d builds and %d preview deletions", p.Delivery, p.RepoURLs[0], branch, len(builds), len(deletions))
+	WriteJSON(w, http.StatusAccepted, map[string]any{"builds": builds, "previewDeletions": deletions})
+}
+
+// findTriggers returns the push triggers of every project built from the pushed repository.
+func (h *handler) findTriggers(ctx context.Context, p *push.Push) ([]database.PushTrigger, error) {
+	var triggers []database.PushTrigger
+	seen := make(map[string]bool)
+	for _, repo := range p.RepoURLs {
+		found, err := h.services.Triggers.FindPushTriggers(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, trigger := range found {
+			if !seen[trigger.ProjectId] {
+				seen[trigger.ProjectId] = true
+				triggers = append(triggers, trigger)
+			}
+		}
+	}
+	return triggers, nil
+}
+
+// enqueueBuild enqueues a build of the pushed commit, or of a preview of
+// previewBranch when it is not empty. The deployment id is derived from the
+// push, so enqueueing it again for a redelivered push does not build twice.
+func (h *handler) enqueueBuild(ctx context.Context, trigger database.PushTrigger, commitSHA, previewBranch, digest string) (*QueuedBuild, error) {
+	msg := &worker.BuildMessage{
+		ProjectId:     trigger.ProjectId,
+		DeploymentId:  uuid.NewSHA1(uuid.NameSpaceOID, []byte(digest+"/"+trigger.ProjectId+"/"+previewBranch)).String(),
+		RepoURL:       trigger.RepoURL,
+		Ref:           trigger.Branch,
+		CommitSHA:     commitSHA,
+		BuildCommand:  trigger.BuildCommand,
+		PreviewBranch: previewBranch,
+	}
+	if previewBranch != "" {
+		msg.Ref = previewBranch
+	}
+	messageId, err := h.services.Builds.Enqueue(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	return &QueuedBuild{ProjectId: trigger.ProjectId, DeploymentId: msg.DeploymentId, MessageId: messageId, PreviewBranch: previewBranch}, nil
+}
+
+func (h *handler) enqueuePreviewDeletion(ctx context.Context, trigger database.PushTrigger, branch, delivery string) (*QueuedPreviewDeletion, error) {
+	msg := &worker.DeletePreviewMessage{
+		ProjectId:   trigger.ProjectId,
+		Branch:      branch,
+		RequestedBy: strings.TrimSpace("push " + delivery),
+	}
+	messageId, err := h.services.Builds.EnqueueDeletePreview(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	return &QueuedPreviewDeletion{ProjectId: trigger.ProjectId, Branch: branch, MessageId: messageId}, nil
+}
+
+func (h *handler) listPreviews(w http.ResponseWriter, r *http.Request) {
+	previews, err := h.services.Previews.ListPreviews(r.Context(), r.PathValue("projectId"))
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, previews)
+}
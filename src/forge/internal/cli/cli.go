@@ -0,0 +1,389 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forge/internal/database"
+	"forge/internal/deployment"
+	"forge/internal/worker"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Queue enqueues the jobs sent by the CLI.
+type Queue interface {
+	Enqueue(ctx context.Context, msg *worker.BuildMessage) (string, error)
+	EnqueueRollback(ctx context.Context, msg *worker.RollbackMessage) (string, error)
+}
+
+// Deployments reads the deployment records of projects and their provenance.
+type Deployments interface {
+	ListRecords(ctx context.Context, projectId string) ([]*deployment.Record, error)
+	LiveRecord(ctx context.Context, projectId string) (*deployment.Record, error)
+	GetRecord(ctx context.Context, projectId, deploymentId string) (*deployment.Record, error)
+	GetProvenance(ctx context.Context, projectId, deploymentId string) ([]byte, error)
+	ArtifactDigests(ctx context.Context, projectId, deploymentId string) (map[string]string, error)
+}
+
+// CLI runs the commands of the aether CLI against a profile.
+type CLI struct {
+	Profile *Profile
+	Out     io.Writer
+	// JSON prints results as JSON for scripts instead of text.
+	JSON bool
+
+	Queue       Queue
+	Deployments Deployments
+	HTTP        *http.Client
+	// Browse opens a URL, it defaults to the browser of the system.
+	Browse func(url string) error
+}
+
+// DeployOptions describe the build enqueued by Deploy. The repository, ref and
+// build command default to the push trigger of the project.
+type DeployOptions struct {
+	ProjectId     string
+	RepoURL       string
+	Ref           string
+	CommitSHA     string
+	BuildCommand  string
+	Env           map[string]string
+	PreviewBranch string
+}
+
+// QueuedJob is a job enqueued by the CLI.
+type QueuedJob struct {
+	ProjectId    string `json:"projectId"`
+	DeploymentId string `json:"deploymentId,omitempty"`
+	MessageId    string `json:"messageId"`
+}
+
+// Deploy enqueues a Build job.
+func (c *CLI) Deploy(ctx context.Context, opts DeployOptions) error {
+	if opts.RepoURL == "" {
+		trigger, err := c.pushTrigger(ctx, opts.ProjectId)
+		if err != nil {
+			return fmt.Errorf("no repository given and the push trigger is not available: %w", err)
+		}
+		opts.RepoURL = trigger.RepoURL
+		if opts.Ref == "" {
+			opts.Ref = trigger.Branch
+		}
+		if opts.BuildCommand == "" {
+			opts.BuildCommand = trigger.BuildCommand
+		}
+	}
+
+	msg := &worker.BuildMessage{
+		ProjectId:     opts.ProjectId,
+		DeploymentId:  uuid.NewString(),
+		RepoURL:       opts.RepoURL,
+		Ref:           opts.Ref,
+		CommitSHA:     opts.CommitSHA,
+		BuildCommand:  opts.BuildCommand,
+		Env:           opts.Env,
+		PreviewBranch: opts.PreviewBranch,
+	}
+	if msg.PreviewBranch != "" && msg.Ref == "" {
+		msg.Ref = msg.PreviewBranch
+	}
+	messageId, err := c.Queue.Enqueue(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	job := QueuedJob{ProjectId: msg.ProjectId, DeploymentId: msg.DeploymentId, MessageId: messageId}
+	return c.print(job, func(w io.Writer) {
+		fmt.Fprintf(w, "Queued deployment %s of project %s\n", job.DeploymentId, job.ProjectId)
+	})
+}
+
+// pushTrigger reads the push trigger of a project from the forge API.
+func (c *CLI) pushTrigger(ctx context.Context, projectId string) (*database.PushTrigger, error) {
+	if err := c.Profile.require("api_url"); err != nil {
+		return nil, err
+	}
+	endpoint := strings.TrimSuffix(c.Profile.APIURL, "/") + "/projects/" + url.PathEscape(projectId) + "/push-trigger"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Profile.APIToken)
+
+	var trigger database.PushTrigger
+	if err := c.getJSON(req, &trigger); err != nil {
+		return nil, err
+	}
+	return &trigger, nil
+}
+
+// followLimit is how many lines are fetched when polling followed logs.
+const followLimit = 500
+
+// LogEntry is a build log line served by logify.
+type LogEntry struct {
+	ProjectId string `json:"projectId"`
+	Timestamp int64  `json:"timestamp"`
+	Log       string `json:"log"`
+}
+
+// Logs prints the latest limit log lines of a project, oldest first. When
+// follow is set, it keeps polling logify every interval and prints new lines
+// until ctx is done.
+func (c *CLI) Logs(ctx context.Context, projectId string, limit int, follow bool, interval time.Duration) error {
+	if err := c.Profile.require("logify_url"); err != nil {
+		return err
+	}
+
+	var tail LogTail
+	fetch := limit
+	for {
+		entries, err := c.fetchLogs(ctx, projectId, fetch)
+		if err != nil {
+			if follow && ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, entry := range tail.New(entries) {
+			c.printLog(entry)
+		}
+		if !follow {
+			return nil
+		}
+		// Later polls look further back, so lines logged in between are not missed
+		fetch = max(limit, followLimit)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (c *CLI) fetchLogs(ctx context.Context, projectId string, limit int) ([]LogEntry, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/%s/logs?limit=%d", strings.TrimSuffix(c.Profile.LogifyURL, "/"), url.PathEscape(projectId), limit)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logify returns null when there are no logs
+	var entries []LogEntry
+	if err := c.getJSON(req, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *CLI) printLog(entry LogEntry) {
+	if c.JSON {
+		// One object per line, so followed logs can be piped
+		json.NewEncoder(c.Out).Encode(entry)
+		return
+	}
+	fmt.Fprintf(c.Out, "%s  %s\n", time.Unix(entry.Timestamp, 0).Format(time.DateTime), entry.Log)
+}
+
+// LogTail keeps track of the log lines already printed. Lines only have a
+// timestamp in seconds, so the lines of the latest second are remembered.
+type LogTail struct {
+	last int64
+	seen map[string]int
+}
+
+// New returns the entries, given newest first as logify serves them, that were
+// not returned before, oldest first.
+func (t *LogTail) New(entries []LogEntry) []LogEntry {
+	var fresh []LogEntry
+	counts := make(map[string]int)
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Timestamp < t.last {
+			continue
+		}
+		if entry.Timestamp == t.last {
+			// Repeated lines of the same second are told apart by their count
+			counts[entry.Log]++
+			if counts[entry.Log] <= t.seen[entry.Log] {
+				continue
+			}
+		}
+		fresh = append(fresh, entry)
+	}
+
+	for _, entry := range fresh {
+		if entry.Timestamp > t.last {
+			t.last = entry.Timestamp
+			t.seen = make(map[string]int)
+		}
+		t.seen[entry.Log]++
+	}
+	return fresh
+}
+
+// ListedDeployment is a deployment record listed by ListDeployments and the
+// development server, marked when it is live.
+type ListedDeployment struct {
+	*deployment.Record
+	Live bool `json:"live"`
+}
+
+// ListDeployments prints the latest limit deployments of a project, newest first.
+func (c *CLI) ListDeployments(ctx context.Context, projectId string, limit int) error {
+	records, err := c.Deployments.ListRecords(ctx, projectId)
+	if err != nil {
+		return err
+	}
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+	}
+
+	liveId := ""
+	live, err := c.Deployments.LiveRecord(ctx, projectId)
+	if err == nil {
+		liveId = live.DeploymentId
+	} else if !deployment.IsNotFound(err) {
+		return err
+	}
+
+	listed := make([]ListedDeployment, len(records))
+	for i, record := range records {
+		listed[i] = ListedDeployment{Record: record, Live: record.DeploymentId == liveId}
+	}
+
+	return c.print(listed, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "DEPLOYMENT\tSTATUS\tCOMMIT\tCREATED\tDURATION\tLIVE\tPREVIEW")
+		for _, d := range listed {
+			duration := ""
+			if !d.FinishedAt.IsZero() {
+				duration = d.FinishedAt.Sub(d.CreatedAt).Round(time.Second).String()
+			}
+			liveMark := ""
+			if d.Live {
+				liveMark = "*"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", d.DeploymentId, d.Status, shortSHA(d.CommitSHA),
+				d.CreatedAt.Local().Format(time.DateTime), duration, liveMark, d.PreviewBranch)
+		}
+		tw.Flush()
+	})
+}
+
+// Rollback enqueues a Rollback job, to the deployment before the live one
+// when deploymentId is empty.
+func (c *CLI) Rollback(ctx context.Context, projectId, deploymentId string) error {
+	messageId, err := c.Queue.EnqueueRollback(ctx, &worker.RollbackMessage{ProjectId: projectId, DeploymentId: deploymentId})
+	if err != nil {
+		return err
+	}
+
+	job := QueuedJob{ProjectId: projectId, DeploymentId: deploymentId, MessageId: messageId}
+	return c.print(job, func(w io.Writer) {
+		if deploymentId == "" {
+			fmt.Fprintf(w, "Queued rollback of project %s to the deployment before the live one\n", projectId)
+		} else {
+			fmt.Fprintf(w, "Queued rollback of project %s to deployment %s\n", projectId, deploymentId)
+		}
+	})
+}
+
+// LiveURL returns the URL the proxy serves a project at, or the preview of
+// branch when it is not empty.
+func (c *CLI) LiveURL(projectId, branch string) (string, error) {
+	if err := c.Profile.require("proxy_url"); err != nil {
+		return "", err
+	}
+	site := projectId
+	if branch != "" {
+		site = deployment.PreviewSite(projectId, deployment.PreviewSlug(branch))
+	}
+	return strings.TrimSuffix(c.Profile.ProxyURL, "/") + "/" + site + "/", nil
+}
+
+// Open opens the live URL of a project, or only prints it when browse is false.
+func (c *CLI) Open(projectId, branch string, browse bool) error {
+	liveURL, err := c.LiveURL(projectId, branch)
+	if err != nil {
+		return err
+	}
+	if err := c.print(map[string]string{"url": liveURL}, func(w io.Writer) { fmt.Fprintln(w, liveURL) }); err != nil {
+		return err
+	}
+	if !browse {
+		return nil
+	}
+
+	open := c.Browse
+	if open == nil {
+		open = browseURL
+	}
+	return open(liveURL)
+}
+
+// print writes v as JSON, or calls text when the output is text.
+func (c *CLI) print(v any, text func(w io.Writer)) error {
+	if !c.JSON {
+		text(c.Out)
+		return nil
+	}
+	encoder := json.NewEncoder(c.Out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (c *CLI) getJSON(req *http.Request, v any) error {
+	client := c.HTTP
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", req.URL.Redacted(), err)
+	}
+	return nil
+}
+
+func browseURL(target string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", target)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	if err := cmd.Start(); err != nil {
+		return errors.New("failed to open a browser, open the URL above instead")
+	}
+	return nil
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
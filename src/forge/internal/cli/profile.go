@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"forge/internal/config"
+	"forge/internal/utils"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile locates the services of an Aether installation.
+type Profile struct {
+	Name string `yaml:"-"`
+	// Region and the optional credentials are used for the build queue and the
+	// bucket, AWS_* environment variables take precedence. LoadProfile applies
+	// them.
+	Region          string `yaml:"region"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	QueueURL        string `yaml:"queue_url"`
+	Bucket          string `yaml:"bucket"`
+	// LogifyURL serves the build logs, such as http://logify:8080.
+	LogifyURL string `yaml:"logify_url"`
+	// ProxyURL serves the deployed projects.
+	ProxyURL string `yaml:"proxy_url"`
+	// APIURL and APIToken reach the forge API, deploy reads the push trigger
+	// of a project from it when no repository is given.
+	APIURL   string `yaml:"api_url"`
+	APIToken string `yaml:"api_token"`
+	// Output is the default output format, text or json.
+	Output string `yaml:"output"`
+}
+
+// profileFile is the layout of the profile file:
+//
+//	default_profile: prod
+//	profiles:
+//	  prod:
+//	    region: us-east-1
+//	    queue_url: https://sqs.us-east-1.amazonaws.com/123456789012/aether-queue
+//	    ...
+type profileFile struct {
+	DefaultProfile string              `yaml:"default_profile"`
+	Profiles       map[string]*Profile `yaml:"profiles"`
+}
+
+// DefaultProfilePath returns AETHER_CONFIG, or ~/.aether/config.yaml.
+func DefaultProfilePath() string {
+	if path := config.Getenv("AETHER_CONFIG", ""); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".aether", "config.yaml")
+	}
+	return filepath.Join(home, ".aether", "config.yaml")
+}
+
+// LoadProfile reads a profile from the profile file at path. An empty name
+// selects AETHER_PROFILE, then the default profile of the file, then "default".
+func LoadProfile(path, name string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile file: %w", err)
+	}
+
+	var file profileFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse profile file %s: %w", path, err)
+	}
+
+	if name == "" {
+		name = config.Getenv("AETHER_PROFILE", file.DefaultProfile)
+	}
+	if name == "" {
+		name = "default"
+	}
+	profile, ok := file.Profiles[name]
+	if !ok || profile == nil {
+		return nil, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+	profile.Name = name
+
+	// The environment overrides the profile
+	profile.Region = config.Getenv("AWS_REGION", profile.Region)
+	profile.AccessKeyID = config.Getenv("AWS_ACCESS_KEY_ID", profile.AccessKeyID)
+	profile.SecretAccessKey = config.Getenv("AWS_SECRET_ACCESS_KEY", profile.SecretAccessKey)
+	profile.QueueURL = config.Getenv("AWS_SQS_URL", profile.QueueURL)
+	profile.Bucket = config.Getenv("AWS_BUCKET_NAME", profile.Bucket)
+
+	if profile.Output == "" {
+		profile.Output = "text"
+	}
+	if profile.Output != "text" && profile.Output != "json" {
+		return nil, fmt.Errorf("invalid output of profile %q, it must be text or json: %q", name, profile.Output)
+	}
+	return profile, nil
+}
+
+// AWS returns the AWS region and credentials of the profile.
+func (p *Profile) AWS() *utils.AWSConfig {
+	return &utils.AWSConfig{
+		Region:          p.Region,
+		AccessKeyID:     p.AccessKeyID,
+		SecretAccessKey: p.SecretAccessKey,
+		SessionToken:    config.Getenv("AWS_SESSION_TOKEN", ""),
+	}
+}
+
+// require returns an error naming the first setting of the profile that is empty.
+func (p *Profile) require(settings ...string) error {
+	values := map[string]string{
+		"queue_url":  p.QueueURL,
+		"bucket":     p.Bucket,
+		"logify_url": p.LogifyURL,
+		"proxy_url":  p.ProxyURL,
+		"api_url":    p.APIURL,
+	}
+	var errs []error
+	for _, setting := range settings {
+		if values[setting] == "" {
+			errs = append(errs, fmt.Errorf("%s is not set in profile %q", setting, p.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,188 @@
+package cli
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forge/internal/deployment"
+	"forge/internal/provenance"
+	"io"
+	"os"
+)
+
+// VerifyOptions select the provenance checked by Verify. The envelope and
+// files are read from the bucket unless EnvelopeFile is set.
+type VerifyOptions struct {
+	ProjectId string
+	// DeploymentId defaults to the live deployment.
+	DeploymentId string
+	PublicKey    ed25519.PublicKey
+	// EnvelopeFile is a provenance envelope downloaded earlier, it is checked
+	// against the files in Dir, or only its signature when Dir is empty.
+	EnvelopeFile string
+	Dir          string
+}
+
+// VerifiedProvenance is the provenance checked by Verify.
+type VerifiedProvenance struct {
+	DeploymentId string                `json:"deploymentId"`
+	KeyID        string                `json:"keyId"`
+	Repository   string                `json:"repository"`
+	CommitSHA    string                `json:"commitSha"`
+	BuilderImage string                `json:"builderImage,omitempty"`
+	Files        int                   `json:"files"`
+	FilesChecked bool                  `json:"filesChecked"`
+	Statement    *provenance.Statement `json:"statement"`
+}
+
+// Verify checks the signature of the provenance of a deployment and that the
+// files it describes are the deployed ones.
+func (c *CLI) Verify(ctx context.Context, opts VerifyOptions) error {
+	if opts.EnvelopeFile != "" {
+		return c.verifyFile(opts)
+	}
+
+	record, err := c.verifiedRecord(ctx, opts.ProjectId, opts.DeploymentId)
+	if err != nil {
+		return err
+	}
+	data, err := c.Deployments.GetProvenance(ctx, record.ProjectId, record.DeploymentId)
+	if deployment.IsNotFound(err) {
+		return fmt.Errorf("deployment %s has no provenance, it was built without a signing key", record.DeploymentId)
+	}
+	if err != nil {
+		return err
+	}
+	statement, err := verifyEnvelope(data, opts.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	// The statement must describe this deployment, not another signed one
+	build := statement.Predicate
+	if build.RunDetails.Metadata.InvocationID != record.DeploymentId {
+		return fmt.Errorf("provenance is of deployment %s, not %s", build.RunDetails.Metadata.InvocationID, record.DeploymentId)
+	}
+	if build.BuildDefinition.ExternalParameters.Repository != record.RepoURL {
+		return fmt.Errorf("provenance is of repository %s, deployment %s was built from %s",
+			build.BuildDefinition.ExternalParameters.Repository, record.DeploymentId, record.RepoURL)
+	}
+	if commit := statementCommit(statement); commit != record.CommitSHA {
+		return fmt.Errorf("provenance is of commit %s, deployment %s was built from %s", commit, record.DeploymentId, record.CommitSHA)
+	}
+
+	digests, err := c.Deployments.ArtifactDigests(ctx, record.ProjectId, record.ArtifactsFrom)
+	if err != nil {
+		return err
+	}
+	if err := statement.CheckSubjects(digests); err != nil {
+		return fmt.Errorf("files of deployment %s do not match its provenance:\n%w", record.DeploymentId, err)
+	}
+	return c.printVerified(statement, opts.PublicKey, true)
+}
+
+// verifiedRecord returns the record of a succeeded deployment, the live one
+// when deploymentId is empty.
+func (c *CLI) verifiedRecord(ctx context.Context, projectId, deploymentId string) (*deployment.Record, error) {
+	if deploymentId == "" {
+		record, err := c.Deployments.LiveRecord(ctx, projectId)
+		if deployment.IsNotFound(err) {
+			return nil, fmt.Errorf("project %s has no live deployment", projectId)
+		}
+		return record, err
+	}
+
+	record, err := c.Deployments.GetRecord(ctx, projectId, deploymentId)
+	if deployment.IsNotFound(err) {
+		return nil, fmt.Errorf("deployment %s of project %s not found", deploymentId, projectId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != deployment.StatusSucceeded {
+		return nil, fmt.Errorf("deployment %s is %s, only succeeded deployments have provenance", deploymentId, record.Status)
+	}
+	return record, nil
+}
+
+func (c *CLI) verifyFile(opts VerifyOptions) error {
+	data, err := os.ReadFile(opts.EnvelopeFile)
+	if err != nil {
+		return fmt.Errorf("failed to read provenance: %w", err)
+	}
+	statement, err := verifyEnvelope(data, opts.PublicKey)
+	if err != nil {
+		return err
+	}
+	if opts.Dir == "" {
+		return c.printVerified(statement, opts.PublicKey, false)
+	}
+
+	digests, err := provenance.DirDigests(opts.Dir)
+	if err != nil {
+		return err
+	}
+	if err := statement.CheckSubjects(digests); err != nil {
+		return fmt.Errorf("files in %s do not match the provenance:\n%w", opts.Dir, err)
+	}
+	return c.printVerified(statement, opts.PublicKey, true)
+}
+
+func verifyEnvelope(data []byte, publicKey ed25519.PublicKey) (*provenance.Statement, error) {
+	var envelope provenance.Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid provenance envelope: %w", err)
+	}
+	statement, err := provenance.Verify(&envelope, publicKey)
+	if errors.Is(err, provenance.ErrInvalidSignature) {
+		return nil, fmt.Errorf("%w with key %s", err, provenance.KeyID(publicKey))
+	}
+	return statement, err
+}
+
+// statementCommit returns the commit of the source repository of a statement.
+func statementCommit(statement *provenance.Statement) string {
+	for _, dependency := range statement.Predicate.BuildDefinition.ResolvedDependencies {
+		if commit, ok := dependency.Digest["gitCommit"]; ok {
+			return commit
+		}
+	}
+	return ""
+}
+
+// builderImage returns the digest of the builder image of a statement.
+func builderImage(statement *provenance.Statement) string {
+	for _, dependency := range statement.Predicate.BuildDefinition.ResolvedDependencies {
+		if digest, ok := dependency.Digest["sha256"]; ok {
+			return dependency.URI + "@sha256:" + digest
+		}
+	}
+	return ""
+}
+
+func (c *CLI) printVerified(statement *provenance.Statement, publicKey ed25519.PublicKey, filesChecked bool) error {
+	verified := VerifiedProvenance{
+		DeploymentId: statement.Predicate.RunDetails.Metadata.InvocationID,
+		KeyID:        provenance.KeyID(publicKey),
+		Repository:   statement.Predicate.BuildDefinition.ExternalParameters.Repository,
+		CommitSHA:    statementCommit(statement),
+		BuilderImage: builderImage(statement),
+		Files:        len(statement.Subject),
+		FilesChecked: filesChecked,
+		Statement:    statement,
+	}
+	return c.print(verified, func(w io.Writer) {
+		fmt.Fprintf(w, "Verified provenance of deployment %s signed with key %s\n", verified.DeploymentId, verified.KeyID)
+		fmt.Fprintf(w, "  source:  %s@%s\n", verified.Repository, verified.CommitSHA)
+		if verified.BuilderImage != "" {
+			fmt.Fprintf(w, "  builder: %s\n", verified.BuilderImage)
+		}
+		if filesChecked {
+			fmt.Fprintf(w, "  files:   %d match their digests\n", verified.Files)
+		} else {
+			fmt.Fprintf(w, "  files:   %d, not checked\n", verified.Files)
+		}
+	})
+}
@@ -0,0 +1,350 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"forge/internal/api"
+	"forge/internal/database"
+	"forge/internal/grpctls"
+	"forge/internal/provenance"
+	"forge/internal/service"
+	"forge/internal/utils"
+	"forge/internal/webhook"
+	"forge/internal/worker"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// Keys are the settings of the worker. They are read from environment
+// variables, and from the config file for variables that are not set.
+var Keys = []string{
+	"APP_ENV",
+	"GRPC_SERVER_ADDRESS",
+	"LOGS_GRPC_SERVER_ADDRESS",
+	"GRPC_TLS_MODE",
+	"GRPC_TLS_CA_FILE",
+	"GRPC_TLS_CERT_FILE",
+	"GRPC_TLS_KEY_FILE",
+	"GRPC_TLS_SERVER_NAME",
+	"AWS_REGION",
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_SQS_URL",
+	"AWS_SQS_CONTROL_URL",
+	"AWS_SQS_DLQ_URL",
+	"AWS_BUCKET_NAME",
+	"DB_HOST",
+	"DB_PORT",
+	"DB_USERNAME",
+	"DB_PASSWORD",
+	"DB_DATABASE",
+	"WORKER_TYPES",
+	"WORKER_TYPE",
+	"UNKNOWN_MESSAGE_TYPES",
+	"TRUSTED_SENDER_IDS",
+	"BUILD_MAX_ATTEMPTS",
+	"BUILD_RETRY_DELAY",
+	"MESSAGE_VISIBILITY_TIMEOUT",
+	"MESSAGE_VISIBILITY_CEILING",
+	"BUILD_LEASE_TTL",
+	"DRAIN_TIMEOUT",
+	"STATUS_OUTBOX_DIR",
+	"STATUS_MAX_ATTEMPTS",
+	"STATUS_RETRY_DELAY",
+	"WEBHOOK_MAX_ATTEMPTS",
+	"WEBHOOK_RETRY_DELAY",
+	"WEBHOOK_ALLOW_PRIVATE_ADDRESSES",
+	"API_ADDRESS",
+	"HOOKS_ADDRESS",
+	"API_TOKEN",
+	"PREVIEW_TTL",
+	"PREVIEW_URL_TEMPLATE",
+	"PREVIEW_EXPIRY_INTERVAL",
+	"PROVENANCE_SIGNING_KEY",
+	"PROVENANCE_BUILDER_ID",
+	"ALLOWED_GIT_HOSTS",
+	"DOCKER_HOST",
+	"BUILD_NODE_VERSION",
+	"BUILD_USER",
+	"BUILD_TIMEOUT",
+	"BUILD_CPUS",
+	"BUILD_MEMORY",
+	"BUILD_PIDS_LIMIT",
+	"BUILD_DISK_LIMIT",
+	"BUILD_SECCOMP_PROFILE",
+	"DEPS_CACHE_MAX_SIZE",
+	"DEPLOYMENT_RETENTION",
+	"OTEL_TRACES_EXPORTER",
+	"OTEL_EXPORTER_OTLP_ENDPOINT",
+}
+
+// required settings have no usable default.
+var required = []string{
+	"GRPC_SERVER_ADDRESS",
+	"LOGS_GRPC_SERVER_ADDRESS",
+	"AWS_REGION",
+	"AWS_SQS_URL",
+	"AWS_BUCKET_NAME",
+	"DB_HOST",
+	"DB_PORT",
+	"DB_USERNAME",
+	"DB_DATABASE",
+}
+
+const redacted = "[redacted]"
+
+// Config is the effective configuration of the worker.
+type Config struct {
+	AppEnv           string
+	LaunchpadAddress string
+	LogifyAddress    string
+	AWS              utils.AWSConfig
+	BucketName       string
+	AllowedGitHosts  []string
+	// TrustedSenders may name who cancelled a deployment in Cancel messages.
+	TrustedSenders []string
+	TracesExporter string
+	OTLPEndpoint   string
+	GRPCTLS        *grpctls.Config
+	Database       database.Config
+	Queue          *worker.QueueConfig
+	Registry       *worker.RegistryConfig
+	LeaseTTL       time.Duration
+	Status         *service.StatusConfig
+	Webhooks       *webhook.Config
+	API            *api.Config
+	Previews       *worker.PreviewConfig
+	Provenance     *provenance.Config
+	Sandbox        *utils.SandboxConfig
+	Retention      int
+}
+
+// Load reads the config from the environment and the YAML file at path, when
+// path is not empty, and validates it. Every invalid setting is reported.
+func Load(path string) (*Config, error) {
+	var s source
+	if path != "" {
+		var err error
+		if s, err = readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, key := range required {
+		if s.lookup(key) == "" {
+			errs = append(errs, fmt.Errorf("%s is required", key))
+		}
+	}
+
+	cfg := &Config{
+		AppEnv:           s.lookup("APP_ENV"),
+		LaunchpadAddress: s.lookup("GRPC_SERVER_ADDRESS"),
+		LogifyAddress:    s.lookup("LOGS_GRPC_SERVER_ADDRESS"),
+		AWS:              loadAWS(s),
+		BucketName:       s.lookup("AWS_BUCKET_NAME"),
+		AllowedGitHosts:  s.list("ALLOWED_GIT_HOSTS", utils.DefaultAllowedGitHosts),
+		TrustedSenders:   s.list("TRUSTED_SENDER_IDS", nil),
+		TracesExporter:   s.get("OTEL_TRACES_EXPORTER", "none"),
+		OTLPEndpoint:     s.lookup("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		Database:         loadDatabase(s),
+		Queue: &worker.QueueConfig{
+			URL:           s.lookup("AWS_SQS_URL"),
+			ControlURL:    s.lookup("AWS_SQS_CONTROL_URL"),
+			DeadLetterURL: s.lookup("AWS_SQS_DLQ_URL"),
+		},
+		API: loadAPI(s),
+	}
+
+	if !slices.Contains([]string{"none", "stdout", "otlp"}, cfg.TracesExporter) {
+		errs = append(errs, s.invalid("OTEL_TRACES_EXPORTER", "it must be none, stdout or otlp"))
+	}
+
+	var err error
+	if cfg.GRPCTLS, err = loadGRPCTLS(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Registry, err = loadRegistry(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Queue.Retry, err = loadRetryPolicy(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Queue.Heartbeat, err = loadHeartbeat(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Queue.DrainTimeout, err = loadDrainTimeout(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.LeaseTTL, err = loadLeaseTTL(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Status, err = loadStatus(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Webhooks, err = loadWebhooks(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Previews, err = loadPreviews(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Provenance, err = loadProvenance(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Sandbox, err = loadSandbox(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Retention, err = loadRetention(s); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// LoadAWS reads the AWS region and credentials from the environment, for tools
+// that have no config file.
+func LoadAWS() *utils.AWSConfig {
+	cfg := loadAWS(nil)
+	return &cfg
+}
+
+// LoadDatabase reads the database settings from the environment, for tools
+// that have no config file.
+func LoadDatabase() (database.Config, error) {
+	var errs []error
+	for _, key := range []string{"DB_HOST", "DB_PORT", "DB_USERNAME", "DB_DATABASE"} {
+		if Getenv(key, "") == "" {
+			errs = append(errs, fmt.Errorf("%s is required", key))
+		}
+	}
+	return loadDatabase(nil), errors.Join(errs...)
+}
+
+// Build holds the settings of builds run outside of the worker, such as by
+// the development server.
+type Build struct {
+	AllowedGitHosts []string
+	Sandbox         *utils.SandboxConfig
+	Provenance      *provenance.Config
+}
+
+// LoadBuild reads the build settings from the environment, for tools that
+// have no config file.
+func LoadBuild() (*Build, error) {
+	var s source
+	cfg := &Build{
+		AllowedGitHosts: s.list("ALLOWED_GIT_HOSTS", utils.DefaultAllowedGitHosts),
+	}
+
+	var errs []error
+	var err error
+	if cfg.Sandbox, err = loadSandbox(s); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Provenance, err = loadProvenance(s); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+func loadDatabase(s source) database.Config {
+	return database.Config{
+		Host:     s.lookup("DB_HOST"),
+		Port:     s.lookup("DB_PORT"),
+		Username: s.lookup("DB_USERNAME"),
+		Password: s.lookup("DB_PASSWORD"),
+		Database: s.lookup("DB_DATABASE"),
+	}
+}
+
+func loadAWS(s source) utils.AWSConfig {
+	return utils.AWSConfig{
+		Region:          s.lookup("AWS_REGION"),
+		AccessKeyID:     s.lookup("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: s.lookup("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    s.lookup("AWS_SESSION_TOKEN"),
+	}
+}
+
+// Print writes the config with its secrets redacted.
+func (c *Config) Print(w io.Writer) {
+	password := ""
+	if c.Database.Password != "" {
+		password = redacted
+	}
+	accessKey := ""
+	if c.AWS.AccessKeyID != "" {
+		accessKey = redacted
+	}
+	apiAddress := "disabled"
+	if c.API.Enabled() {
+		apiAddress = fmt.Sprintf("%s, hooks %s (token: %s)", c.API.Address, c.API.HooksAddress, redacted)
+	}
+	attestation := "disabled"
+	if c.Provenance.Enabled() {
+		attestation = fmt.Sprintf("%s, key %s", c.Provenance.BuilderID, c.Provenance.Signer.KeyID())
+	}
+	workerTypes := strings.Join(c.Registry.Types, ",")
+	if workerTypes == "" {
+		workerTypes = "all"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, line := range [][2]string{
+		{"app env", c.AppEnv},
+		{"launchpad address", c.LaunchpadAddress},
+		{"logify address", c.LogifyAddress},
+		{"grpc tls", c.GRPCTLS.Mode},
+		{"aws region", c.AWS.Region},
+		{"aws credentials", accessKey},
+		{"queue", c.Queue.URL},
+		{"control queue", c.Queue.ControlURL},
+		{"dead-letter queue", c.Queue.DeadLetterURL},
+		{"bucket", c.BucketName},
+		{"database", fmt.Sprintf("%s@%s:%s/%s (password: %s)", c.Database.Username, c.Database.Host, c.Database.Port, c.Database.Database, password)},
+		{"message types", workerTypes},
+		{"unknown message types", c.Registry.Unknown},
+		{"trusted senders", strings.Join(c.TrustedSenders, ",")},
+		{"max attempts", fmt.Sprint(c.Queue.Retry.MaxAttempts)},
+		{"retry delay", c.Queue.Retry.BaseDelay.String()},
+		{"visibility timeout", c.Queue.Heartbeat.VisibilityTimeout.String()},
+		{"visibility ceiling", c.Queue.Heartbeat.Ceiling.String()},
+		{"build lease ttl", c.LeaseTTL.String()},
+		{"drain timeout", c.Queue.DrainTimeout.String()},
+		{"status outbox", c.Status.OutboxDir},
+		{"status max attempts", fmt.Sprint(c.Status.MaxAttempts)},
+		{"status retry delay", c.Status.BaseDelay.String()},
+		{"webhook max attempts", fmt.Sprint(c.Webhooks.MaxAttempts)},
+		{"webhook retry delay", c.Webhooks.BaseDelay.String()},
+		{"webhook private addresses", fmt.Sprint(c.Webhooks.AllowPrivate)},
+		{"api", apiAddress},
+		{"preview ttl", c.Previews.TTL.String()},
+		{"preview url", c.Previews.URLTemplate},
+		{"provenance", attestation},
+		{"allowed git hosts", strings.Join(c.AllowedGitHosts, ",")},
+		{"docker host", c.Sandbox.DockerHost},
+		{"build timeout", c.Sandbox.Timeout.String()},
+		{"build cpus", fmt.Sprint(float64(c.Sandbox.NanoCPUs) / 1e9)},
+		{"build memory", units.BytesSize(float64(c.Sandbox.MemoryBytes))},
+		{"build pids limit", fmt.Sprint(c.Sandbox.PidsLimit)},
+		{"deps cache max size", units.BytesSize(float64(c.Sandbox.DepsCacheMaxSize))},
+		{"deployment retention", fmt.Sprint(c.Retention)},
+		{"traces exporter", c.TracesExporter},
+		{"otlp endpoint", c.OTLPEndpoint},
+	} {
+		fmt.Fprintf(tw, "%s:\t%s\n", line[0], line[1])
+	}
+	tw.Flush()
+}
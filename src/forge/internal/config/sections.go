@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"forge/internal/api"
+	"forge/internal/deployment"
+	"forge/internal/grpctls"
+	"forge/internal/provenance"
+	"forge/internal/service"
+	"forge/internal/utils"
+	"forge/internal/webhook"
+	"forge/internal/worker"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each section starts from the defaults of its package and reads the settings
+// that are set.
+
+func loadGRPCTLS(s source) (*grpctls.Config, error) {
+	cfg := &grpctls.Config{
+		Mode:       s.get("GRPC_TLS_MODE", grpctls.ModeNone),
+		CAFile:     s.lookup("GRPC_TLS_CA_FILE"),
+		CertFile:   s.lookup("GRPC_TLS_CERT_FILE"),
+		KeyFile:    s.lookup("GRPC_TLS_KEY_FILE"),
+		ServerName: s.lookup("GRPC_TLS_SERVER_NAME"),
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadRegistry(s source) (*worker.RegistryConfig, error) {
+	cfg := &worker.RegistryConfig{
+		// WORKER_TYPE is the single type workers handled before WORKER_TYPES
+		Types:   s.list("WORKER_TYPES", s.list("WORKER_TYPE", nil)),
+		Unknown: s.get("UNKNOWN_MESSAGE_TYPES", worker.UnknownReject),
+	}
+	if cfg.Unknown != worker.UnknownReject && cfg.Unknown != worker.UnknownRelease {
+		return nil, s.invalid("UNKNOWN_MESSAGE_TYPES", fmt.Sprintf("it must be %q or %q", worker.UnknownReject, worker.UnknownRelease))
+	}
+	return cfg, nil
+}
+
+func loadRetryPolicy(s source) (*worker.RetryPolicy, error) {
+	policy := worker.DefaultRetryPolicy()
+	if err := s.integer("BUILD_MAX_ATTEMPTS", &policy.MaxAttempts); err != nil || policy.MaxAttempts < 1 {
+		return nil, s.invalid("BUILD_MAX_ATTEMPTS", "")
+	}
+	if err := s.duration("BUILD_RETRY_DELAY", &policy.BaseDelay); err != nil || policy.BaseDelay < 0 {
+		return nil, s.invalid("BUILD_RETRY_DELAY", "")
+	}
+	return policy, nil
+}
+
+func loadHeartbeat(s source) (*worker.HeartbeatConfig, error) {
+	defaults := worker.DefaultHeartbeatConfig()
+	visibilityTimeout, ceiling := defaults.VisibilityTimeout, defaults.Ceiling
+	if err := s.duration("MESSAGE_VISIBILITY_TIMEOUT", &visibilityTimeout); err != nil || visibilityTimeout < 30*time.Second {
+		return nil, s.invalid("MESSAGE_VISIBILITY_TIMEOUT", "it must be at least 30s")
+	}
+	// SQS keeps a message invisible for at most 12 hours after it was received,
+	// including the visibility timeout granted after the ceiling
+	if err := s.duration("MESSAGE_VISIBILITY_CEILING", &ceiling); err != nil || ceiling < visibilityTimeout || ceiling+visibilityTimeout > 12*time.Hour {
+		return nil, s.invalid("MESSAGE_VISIBILITY_CEILING", "it must be between the visibility timeout and 12h")
+	}
+	return worker.NewHeartbeatConfig(visibilityTimeout, ceiling), nil
+}
+
+func loadLeaseTTL(s source) (time.Duration, error) {
+	ttl := worker.DefaultLeaseTTL
+	if err := s.duration("BUILD_LEASE_TTL", &ttl); err != nil || ttl < 3*time.Second {
+		return 0, s.invalid("BUILD_LEASE_TTL", "")
+	}
+	return ttl, nil
+}
+
+func loadDrainTimeout(s source) (time.Duration, error) {
+	timeout := worker.DefaultDrainTimeout
+	if err := s.duration("DRAIN_TIMEOUT", &timeout); err != nil || timeout < 0 {
+		return 0, s.invalid("DRAIN_TIMEOUT", "")
+	}
+	return timeout, nil
+}
+
+func loadStatus(s source) (*service.StatusConfig, error) {
+	cfg := service.DefaultStatusConfig()
+	cfg.OutboxDir = s.get("STATUS_OUTBOX_DIR", cfg.OutboxDir)
+	if err := s.integer("STATUS_MAX_ATTEMPTS", &cfg.MaxAttempts); err != nil || cfg.MaxAttempts < 1 {
+		return nil, s.invalid("STATUS_MAX_ATTEMPTS", "")
+	}
+	if err := s.duration("STATUS_RETRY_DELAY", &cfg.BaseDelay); err != nil || cfg.BaseDelay <= 0 {
+		return nil, s.invalid("STATUS_RETRY_DELAY", "")
+	}
+	return cfg, nil
+}
+
+func loadWebhooks(s source) (*webhook.Config, error) {
+	cfg := webhook.DefaultConfig()
+	if err := s.integer("WEBHOOK_MAX_ATTEMPTS", &cfg.MaxAttempts); err != nil || cfg.MaxAttempts < 1 {
+		return nil, s.invalid("WEBHOOK_MAX_ATTEMPTS", "")
+	}
+	if err := s.duration("WEBHOOK_RETRY_DELAY", &cfg.BaseDelay); err != nil || cfg.BaseDelay <= 0 {
+		return nil, s.invalid("WEBHOOK_RETRY_DELAY", "")
+	}
+	if err := s.boolean("WEBHOOK_ALLOW_PRIVATE_ADDRESSES", &cfg.AllowPrivate); err != nil {
+		return nil, s.invalid("WEBHOOK_ALLOW_PRIVATE_ADDRESSES", "")
+	}
+	return cfg, nil
+}
+
+func loadAPI(s source) *api.Config {
+	return &api.Config{
+		Address:      s.get("API_ADDRESS", api.DefaultAddress),
+		HooksAddress: s.get("HOOKS_ADDRESS", api.DefaultHooksAddress),
+		Token:        s.lookup("API_TOKEN"),
+	}
+}
+
+func loadPreviews(s source) (*worker.PreviewConfig, error) {
+	cfg := worker.DefaultPreviewConfig()
+	cfg.URLTemplate = s.lookup("PREVIEW_URL_TEMPLATE")
+	if err := s.duration("PREVIEW_TTL", &cfg.TTL); err != nil || cfg.TTL < time.Minute {
+		return nil, s.invalid("PREVIEW_TTL", "")
+	}
+	if err := s.duration("PREVIEW_EXPIRY_INTERVAL", &cfg.ExpiryInterval); err != nil || cfg.ExpiryInterval <= 0 {
+		return nil, s.invalid("PREVIEW_EXPIRY_INTERVAL", "")
+	}
+	if cfg.URLTemplate != "" && !strings.Contains(cfg.URLTemplate, "{site}") {
+		return nil, s.invalid("PREVIEW_URL_TEMPLATE", "it must contain {site}")
+	}
+	return cfg, nil
+}
+
+func loadProvenance(s source) (*provenance.Config, error) {
+	return provenance.LoadConfig(s.lookup("PROVENANCE_SIGNING_KEY"), s.lookup("PROVENANCE_BUILDER_ID"))
+}
+
+func loadSandbox(s source) (*utils.SandboxConfig, error) {
+	cfg := utils.DefaultSandboxConfig()
+	cfg.DockerHost = s.get("DOCKER_HOST", cfg.DockerHost)
+	cfg.NodeVersion = s.get("BUILD_NODE_VERSION", cfg.NodeVersion)
+	cfg.User = s.get("BUILD_USER", cfg.User)
+	cfg.DiskLimit = s.lookup("BUILD_DISK_LIMIT")
+	cfg.SeccompProfile = s.get("BUILD_SECCOMP_PROFILE", cfg.SeccompProfile)
+
+	if err := s.duration("BUILD_TIMEOUT", &cfg.Timeout); err != nil || cfg.Timeout <= 0 {
+		return nil, s.invalid("BUILD_TIMEOUT", "")
+	}
+	if value := s.lookup("BUILD_CPUS"); value != "" {
+		cpus, err := strconv.ParseFloat(value, 64)
+		if err != nil || cpus <= 0 {
+			return nil, s.invalid("BUILD_CPUS", "")
+		}
+		cfg.NanoCPUs = int64(cpus * 1e9)
+	}
+	if err := s.bytes("BUILD_MEMORY", &cfg.MemoryBytes); err != nil || cfg.MemoryBytes <= 0 {
+		return nil, s.invalid("BUILD_MEMORY", "")
+	}
+	if value := s.lookup("BUILD_PIDS_LIMIT"); value != "" {
+		pids, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || pids <= 0 {
+			return nil, s.invalid("BUILD_PIDS_LIMIT", "")
+		}
+		cfg.PidsLimit = pids
+	}
+	if err := s.bytes("DEPS_CACHE_MAX_SIZE", &cfg.DepsCacheMaxSize); err != nil {
+		return nil, s.invalid("DEPS_CACHE_MAX_SIZE", "")
+	}
+	return cfg, nil
+}
+
+func loadRetention(s source) (int, error) {
+	retention := deployment.DefaultRetention
+	if err := s.integer("DEPLOYMENT_RETENTION", &retention); err != nil || retention < 1 {
+		return 0, s.invalid("DEPLOYMENT_RETENTION", "it must be a positive number")
+	}
+	return retention, nil
+}
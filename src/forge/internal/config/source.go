@@ -0,0 +1,148 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"gopkg.in/yaml.v3"
+)
+
+// source looks settings up in the environment, then in the settings of the
+// config file, so the environment overrides the file.
+type source map[string]string
+
+// Getenv returns the environment variable key, or fallback when it is not
+// set. Tools without a config file read their settings with it.
+func Getenv(key, fallback string) string {
+	return source(nil).get(key, fallback)
+}
+
+// lookup returns the value of a setting, or an empty string when it is not set.
+func (s source) lookup(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return s[key]
+}
+
+// get returns the value of a setting, or fallback when it is not set.
+func (s source) get(key, fallback string) string {
+	if value := s.lookup(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// list returns the comma-separated items of a setting, or fallback when it is
+// not set.
+func (s source) list(key string, fallback []string) []string {
+	value := s.lookup(key)
+	if value == "" {
+		return fallback
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// invalid returns the error of an invalid setting, hint tells the valid values.
+func (s source) invalid(key, hint string) error {
+	if hint != "" {
+		return fmt.Errorf("invalid %s, %s: %q", key, hint, s.lookup(key))
+	}
+	return fmt.Errorf("invalid %s: %q", key, s.lookup(key))
+}
+
+// The parse methods set value from a setting and leave it unchanged when the
+// setting is not set.
+
+func (s source) duration(key string, value *time.Duration) error {
+	if raw := s.lookup(key); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		*value = parsed
+	}
+	return nil
+}
+
+func (s source) integer(key string, value *int) error {
+	if raw := s.lookup(key); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		*value = parsed
+	}
+	return nil
+}
+
+func (s source) boolean(key string, value *bool) error {
+	if raw := s.lookup(key); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		*value = parsed
+	}
+	return nil
+}
+
+func (s source) bytes(key string, value *int64) error {
+	if raw := s.lookup(key); raw != "" {
+		parsed, err := units.RAMInBytes(raw)
+		if err != nil {
+			return err
+		}
+		*value = parsed
+	}
+	return nil
+}
+
+// readFile returns the settings of a YAML file. Lists are joined with commas.
+func readFile(path string) (source, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	settings := make(source, len(values))
+	var errs []error
+	for key, value := range values {
+		if !slices.Contains(Keys, key) {
+			errs = append(errs, fmt.Errorf("unknown setting %s in config file %s", key, path))
+			continue
+		}
+
+		switch value := value.(type) {
+		case nil:
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			settings[key] = strings.Join(items, ",")
+		case map[string]any:
+			errs = append(errs, fmt.Errorf("setting %s in config file %s must not be a map", key, path))
+		default:
+			settings[key] = fmt.Sprint(value)
+		}
+	}
+	return settings, errors.Join(errs...)
+}
@@ -0,0 +1,405 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// LeaseState is the outcome of acquiring or renewing a build lease.
+type LeaseState int
+
+const (
+	// LeaseHeld means the deployment holds the lease and is the newest one requested.
+	LeaseHeld LeaseState = iota
+	// LeaseBusy means an older deployment of the project still holds the lease.
+	LeaseBusy
+	// LeaseSuperseded means a newer deployment of the project was requested.
+	LeaseSuperseded
+	// LeaseCancelled means the deployment was cancelled.
+	LeaseCancelled
+	// LeaseDeleted means the project was deleted.
+	LeaseDeleted
+)
+
+var (
+	// ErrLeaseNotHeld is returned when renewing a lease that expired or was taken over.
+	ErrLeaseNotHeld = errors.New("build lease is not held")
+
+	// ErrNotCancelled is returned when looking up the cancellation of a deployment that was not cancelled.
+	ErrNotCancelled = errors.New("deployment was not cancelled")
+
+	// ErrNoBuildLease is returned when looking up the build lease of a project that has none.
+	ErrNoBuildLease = errors.New("project has no build lease")
+)
+
+// BuildLease is the state of the build lease of a project.
+type BuildLease struct {
+	// LatestDeploymentId is the newest deployment requested.
+	LatestDeploymentId string
+	// ActiveDeploymentId is the deployment being built, empty when none is.
+	ActiveDeploymentId string
+}
+
+// Cancellation records who cancelled a deployment.
+type Cancellation struct {
+	DeploymentId string
+	ProjectId    string
+	CancelledBy  string
+	CancelledAt  time.Time
+}
+
+// Service represents a service that interacts with a database.
+type Service interface {
+	// Migrate database
+	Migrate() error
+
+	// Close terminates the database connection.
+	// It returns an error if the connection cannot be closed.
+	Close() error
+
+	// AcquireBuildLease records deploymentId as requested at requestedAt and
+	// takes the project's build lease for holder when no other deployment holds it.
+	AcquireBuildLease(ctx context.Context, projectId, deploymentId string, requestedAt time.Time, holder string, ttl time.Duration) (LeaseState, error)
+	// RenewBuildLease extends a held lease, it reports LeaseDeleted once the
+	// project was deleted, LeaseCancelled once the deployment was cancelled and
+	// LeaseSuperseded once a newer deployment was requested.
+	RenewBuildLease(ctx context.Context, projectId, deploymentId, holder string, ttl time.Duration) (LeaseState, error)
+	// ReleaseBuildLease gives up a held lease.
+	ReleaseBuildLease(ctx context.Context, projectId, deploymentId, holder string) error
+	// RecordBuildFailure counts a build of deploymentId that failed with an
+	// internal error and returns how many of its builds failed so far. The
+	// count starts again when redriveId differs from that of the last failure.
+	RecordBuildFailure(ctx context.Context, projectId, deploymentId, redriveId string) (int, error)
+
+	// GetBuildLease returns the build lease of a project, or ErrNoBuildLease
+	// when none of its deployments was built or the project was deleted.
+	GetBuildLease(ctx context.Context, projectId string) (*BuildLease, error)
+	// CancelBuild records the cancellation of a deployment. Its worker stops
+	// when it renews the build lease, a deployment still queued is dropped
+	// when it is received.
+	CancelBuild(ctx context.Context, projectId, deploymentId, cancelledBy string) error
+	// GetCancellation returns the cancellation of a deployment, or ErrNotCancelled.
+	GetCancellation(ctx context.Context, deploymentId string) (*Cancellation, error)
+
+	// DeleteProject marks a project as deleted, its builds are dropped from
+	// then on. It reports whether a deployment of the project is still being built.
+	DeleteProject(ctx context.Context, projectId string) (bool, error)
+}
+
+type service struct {
+	db       *sql.DB
+	database string
+}
+
+// Config locates the forge database.
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Database string
+}
+
+// URL returns the connection string of the database.
+func (c Config) URL() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.Username, c.Password),
+		Host:     net.JoinHostPort(c.Host, c.Port),
+		Path:     c.Database,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
+var dbInstance *service
+
+func New(cfg Config) Service {
+	// Reuse Connection
+	if dbInstance != nil {
+		return dbInstance
+	}
+
+	db, err := sql.Open("pgx", cfg.URL())
+	if err != nil {
+		log.Fatalf("Failed to open database connection: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	dbInstance = &service{
+		db:       db,
+		database: cfg.Database,
+	}
+	return dbInstance
+}
+
+// Close closes the database connection.
+func (s *service) Close() error {
+	log.Printf("Disconnected from database: %s", s.database)
+	return s.db.Close()
+}
+
+func (s *service) Migrate() error {
+	migrationPath := "file://./migrations"
+
+	driver, err := postgres.WithInstance(s.db, &postgres.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to create postgres driver: %w", err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance(migrationPath, "postgres", driver)
+	if err != nil {
+		return fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	log.Println("Migrations applied successfully")
+	return nil
+}
+
+func (s *service) AcquireBuildLease(ctx context.Context, projectId, deploymentId string, requestedAt time.Time, holder string, ttl time.Duration) (LeaseState, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO build_leases (project_id, latest_deployment_id, latest_requested_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (project_id) DO NOTHING
+    `, projectId, deploymentId, requestedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert build lease: %w", err)
+	}
+
+	var (
+		latestId     string
+		latestAt     time.Time
+		activeId     sql.NullString
+		activeHolder sql.NullString
+		leaseLive    bool
+		deleted      bool
+	)
+	err = tx.QueryRowContext(ctx, `
+        SELECT latest_deployment_id, latest_requested_at, active_deployment_id, holder,
+               COALESCE(expires_at > now(), false), deleted_at IS NOT NULL
+        FROM build_leases
+        WHERE project_id = $1
+        FOR UPDATE
+    `, projectId).Scan(&latestId, &latestAt, &activeId, &activeHolder, &leaseLive, &deleted)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock build lease: %w", err)
+	}
+	if deleted {
+		return LeaseDeleted, tx.Commit()
+	}
+
+	var cancelled bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT EXISTS (SELECT 1 FROM build_cancellations WHERE deployment_id = $1)
+    `, deploymentId).Scan(&cancelled)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up build cancellation: %w", err)
+	}
+	if cancelled {
+		return LeaseCancelled, tx.Commit()
+	}
+
+	// Ties are broken by deployment id so every worker reaches the same decision
+	if latestId != deploymentId && (latestAt.After(requestedAt) || (latestAt.Equal(requestedAt) && latestId > deploymentId)) {
+		return LeaseSuperseded, tx.Commit()
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE build_leases SET latest_deployment_id = $2, latest_requested_at = $3
+        WHERE project_id = $1
+    `, projectId, deploymentId, requestedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update build lease: %w", err)
+	}
+
+	ownLease := activeId.String == deploymentId && activeHolder.String == holder
+	if activeId.Valid && leaseLive && !ownLease {
+		// The older deployment notices it was superseded when it renews its lease
+		return LeaseBusy, tx.Commit()
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE build_leases
+        SET active_deployment_id = $2, holder = $3, expires_at = now() + $4 * interval '1 millisecond'
+        WHERE project_id = $1
+    `, projectId, deploymentId, holder, ttl.Milliseconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to take build lease: %w", err)
+	}
+
+	return LeaseHeld, tx.Commit()
+}
+
+func (s *service) RenewBuildLease(ctx context.Context, projectId, deploymentId, holder string, ttl time.Duration) (LeaseState, error) {
+	var (
+		latestId  string
+		deleted   bool
+		cancelled bool
+	)
+	err := s.db.QueryRowContext(ctx, `
+        UPDATE build_leases SET expires_at = now() + $4 * interval '1 millisecond'
+        WHERE project_id = $1 AND active_deployment_id = $2 AND holder = $3
+        RETURNING latest_deployment_id, deleted_at IS NOT NULL,
+                  EXISTS (SELECT 1 FROM build_cancellations WHERE deployment_id = $2)
+    `, projectId, deploymentId, holder, ttl.Milliseconds()).Scan(&latestId, &deleted, &cancelled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrLeaseNotHeld
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to renew build lease: %w", err)
+	}
+
+	if deleted {
+		return LeaseDeleted, nil
+	}
+	if cancelled {
+		return LeaseCancelled, nil
+	}
+	if latestId != deploymentId {
+		return LeaseSuperseded, nil
+	}
+	return LeaseHeld, nil
+}
+
+func (s *service) ReleaseBuildLease(ctx context.Context, projectId, deploymentId, holder string) error {
+	_, err := s.db.ExecContext(ctx, `
+        UPDATE build_leases SET active_deployment_id = NULL, holder = NULL, expires_at = NULL
+        WHERE project_id = $1 AND active_deployment_id = $2 AND holder = $3
+    `, projectId, deploymentId, holder)
+	if err != nil {
+		return fmt.Errorf("failed to release build lease: %w", err)
+	}
+	return nil
+}
+
+func (s *service) RecordBuildFailure(ctx context.Context, projectId, deploymentId, redriveId string) (int, error) {
+	var failures int
+	err := s.db.QueryRowContext(ctx, `
+        INSERT INTO build_attempts (deployment_id, project_id, failures, redrive_id)
+        VALUES ($1, $2, 1, $3)
+        ON CONFLICT (deployment_id) DO UPDATE SET
+            failures = CASE WHEN build_attempts.redrive_id = EXCLUDED.redrive_id THEN build_attempts.failures + 1 ELSE 1 END,
+            redrive_id = EXCLUDED.redrive_id,
+            updated_at = now()
+        RETURNING failures
+    `, deploymentId, projectId, redriveId).Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record build failure: %w", err)
+	}
+	return failures, nil
+}
+
+func (s *service) GetBuildLease(ctx context.Context, projectId string) (*BuildLease, error) {
+	var (
+		lease    BuildLease
+		activeId sql.NullString
+	)
+	err := s.db.QueryRowContext(ctx, `
+        SELECT latest_deployment_id,
+               CASE WHEN COALESCE(expires_at > now(), false) THEN active_deployment_id END
+        FROM build_leases
+        WHERE project_id = $1 AND deleted_at IS NULL
+    `, projectId).Scan(&lease.LatestDeploymentId, &activeId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoBuildLease
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up build lease: %w", err)
+	}
+	lease.ActiveDeploymentId = activeId.String
+	return &lease, nil
+}
+
+func (s *service) CancelBuild(ctx context.Context, projectId, deploymentId, cancelledBy string) error {
+	_, err := s.db.ExecContext(ctx, `
+        INSERT INTO build_cancellations (deployment_id, project_id, cancelled_by)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (deployment_id) DO NOTHING
+    `, deploymentId, projectId, cancelledBy)
+	if err != nil {
+		return fmt.Errorf("failed to record build cancellation: %w", err)
+	}
+	return nil
+}
+
+func (s *service) GetCancellation(ctx context.Context, deploymentId string) (*Cancellation, error) {
+	cancellation := &Cancellation{DeploymentId: deploymentId}
+	err := s.db.QueryRowContext(ctx, `
+        SELECT project_id, cancelled_by, cancelled_at
+        FROM build_cancellations
+        WHERE deployment_id = $1
+    `, deploymentId).Scan(&cancellation.ProjectId, &cancellation.CancelledBy, &cancellation.CancelledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotCancelled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up build cancellation: %w", err)
+	}
+	return cancellation, nil
+}
+
+func (s *service) DeleteProject(ctx context.Context, projectId string) (bool, error) {
+	// The row stays as a tombstone so builds still queued for the project are dropped
+	var building bool
+	err := s.db.QueryRowContext(ctx, `
+        INSERT INTO build_leases (project_id, latest_deployment_id, latest_requested_at, deleted_at)
+        VALUES ($1, '', now(), now())
+        ON CONFLICT (project_id) DO UPDATE SET deleted_at = COALESCE(build_leases.deleted_at, now())
+        RETURNING active_deployment_id IS NOT NULL AND COALESCE(expires_at > now(), false)
+    `, projectId).Scan(&building)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark project deleted: %w", err)
+	}
+
+	// Previews are built under the leases of their branches
+	var previewBuilding bool
+	err = s.db.QueryRowContext(ctx, `
+        WITH marked AS (
+            UPDATE build_leases SET deleted_at = COALESCE(deleted_at, now())
+            WHERE project_id LIKE $1 || '/previews/%'
+            RETURNING active_deployment_id IS NOT NULL AND COALESCE(expires_at > now(), false) AS building
+        )
+        SELECT COALESCE(bool_or(building), false) FROM marked
+    `, projectId).Scan(&previewBuilding)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark previews deleted: %w", err)
+	}
+
+	// Deliveries are removed with their endpoints
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM webhook_endpoints WHERE project_id = $1`, projectId); err != nil {
+		return false, fmt.Errorf("failed to delete webhook endpoints: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM push_triggers WHERE project_id = $1`, projectId); err != nil {
+		return false, fmt.Errorf("failed to delete push trigger: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM previews WHERE project_id = $1`, projectId); err != nil {
+		return false, fmt.Errorf("failed to delete previews: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM build_attempts WHERE project_id = $1`, projectId); err != nil {
+		return false, fmt.Errorf("failed to delete build attempts: %w", err)
+	}
+	return building || previewBuilding, nil
+}
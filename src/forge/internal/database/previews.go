@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Preview is the served preview of a branch of a project.
+type Preview struct {
+	ProjectId    string    `json:"projectId"`
+	Slug         string    `json:"slug"`
+	Branch       string    `json:"branch"`
+	DeploymentId string    `json:"deploymentId"`
+	URL          string    `json:"url,omitempty"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// PreviewStore keeps the previews served for each project.
+type PreviewStore interface {
+	// SavePreview records the deployment served as the preview of a branch and
+	// when the preview expires.
+	SavePreview(ctx context.Context, preview *Preview) error
+	// ListPreviews returns the previews of a project, newest first.
+	ListPreviews(ctx context.Context, projectId string) ([]Preview, error)
+	// ExpiredPreviews returns up to limit previews that expired before now.
+	ExpiredPreviews(ctx context.Context, now time.Time, limit int) ([]Preview, error)
+	// DeletePreview forgets the preview of a branch. When deploymentId is not
+	// empty, it is only forgotten while it still serves that deployment. It
+	// reports whether a preview was deleted.
+	DeletePreview(ctx context.Context, projectId, slug, deploymentId string) (bool, error)
+}
+
+// NewPreviewStore returns the preview store of the forge database.
+func NewPreviewStore(cfg Config) PreviewStore {
+	return New(cfg).(*service)
+}
+
+// PreviewLeaseKey returns the build lease previews of a branch are built
+// under, so they neither wait for nor supersede the builds of the project.
+func PreviewLeaseKey(projectId, slug string) string {
+	return projectId + "/previews/" + slug
+}
+
+func (s *service) SavePreview(ctx context.Context, preview *Preview) error {
+	err := s.db.QueryRowContext(ctx, `
+        INSERT INTO previews (project_id, slug, branch, deployment_id, url, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (project_id, slug) DO UPDATE SET
+            branch = EXCLUDED.branch,
+            deployment_id = EXCLUDED.deployment_id,
+            url = EXCLUDED.url,
+            expires_at = EXCLUDED.expires_at,
+            updated_at = now()
+        RETURNING created_at, updated_at
+    `, preview.ProjectId, preview.Slug, preview.Branch, preview.DeploymentId, preview.URL, preview.ExpiresAt,
+	).Scan(&preview.CreatedAt, &preview.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save preview: %w", err)
+	}
+	return nil
+}
+
+func (s *service) ListPreviews(ctx context.Context, projectId string) ([]Preview, error) {
+	return s.queryPreviews(ctx, `
+        SELECT project_id, slug, branch, deployment_id, url, expires_at, created_at, updated_at
+        FROM previews
+        WHERE project_id = $1
+        ORDER BY updated_at DESC
+    `, projectId)
+}
+
+func (s *service) ExpiredPreviews(ctx context.Context, now time.Time, limit int) ([]Preview, error) {
+	return s.queryPreviews(ctx, `
+        SELECT project_id, slug, branch, deployment_id, url, expires_at, created_at, updated_at
+        FROM previews
+        WHERE expires_at < $1
+        ORDER BY expires_at
+        LIMIT $2
+    `, now, limit)
+}
+
+func (s *service) DeletePreview(ctx context.Context, projectId, slug, deploymentId string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+        DELETE FROM previews
+        WHERE project_id = $1 AND slug = $2 AND ($3 = '' OR deployment_id = $3)
+    `, projectId, slug, deploymentId)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete preview: %w", err)
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted > 0, nil
+}
+
+func (s *service) queryPreviews(ctx context.Context, query string, args ...any) ([]Preview, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list previews: %w", err)
+	}
+	defer rows.Close()
+
+	previews := []Preview{}
+	for rows.Next() {
+		var p Preview
+		err := rows.Scan(&p.ProjectId, &p.Slug, &p.Branch, &p.DeploymentId, &p.URL, &p.ExpiresAt, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read preview: %w", err)
+		}
+		previews = append(previews, p)
+	}
+	return previews, rows.Err()
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTriggerNotFound is returned for a project without a push trigger.
+var ErrTriggerNotFound = errors.New("push trigger not found")
+
+// PushTrigger deploys a project when its branch is pushed to.
+type PushTrigger struct {
+	ProjectId string `json:"projectId"`
+	// Repo is the normalized RepoURL that push webhooks are matched on.
+	Repo         string `json:"-"`
+	RepoURL      string `json:"repoURL"`
+	Branch       string `json:"branch"`
+	BuildCommand string `json:"buildCommand"`
+	// Previews builds a preview of every other branch pushed to.
+	Previews  bool      `json:"previews"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// TriggerStore keeps the push triggers of projects.
+type TriggerStore interface {
+	// PutPushTrigger creates the trigger of a project, or updates it and keeps
+	// its secret. It reports whether the trigger was created.
+	PutPushTrigger(ctx context.Context, trigger *PushTrigger) (bool, error)
+	// GetPushTrigger returns the trigger of a project without its secret, or ErrTriggerNotFound.
+	GetPushTrigger(ctx context.Context, projectId string) (*PushTrigger, error)
+	DeletePushTrigger(ctx context.Context, projectId string) error
+	// FindPushTriggers returns the triggers of every project built from repo, with their secrets.
+	FindPushTriggers(ctx context.Context, repo string) ([]PushTrigger, error)
+	// ClaimPushDelivery records a push webhook by the digest of its body and
+	// its delivery id, which may be empty. It reports false when either was
+	// received already.
+	ClaimPushDelivery(ctx context.Context, digest, delivery string) (bool, error)
+	// ReleasePushDelivery forgets a claimed push webhook, so its redelivery is
+	// processed.
+	ReleasePushDelivery(ctx context.Context, digest string) error
+}
+
+// pushDeliveryRetention is how long push webhooks are remembered. Git hosts
+// redeliver a webhook within days, and an older replay names deployments that
+// were built already, which workers drop.
+const pushDeliveryRetention = 30 * 24 * time.Hour
+
+// NewTriggerStore returns the push trigger store of the forge database.
+func NewTriggerStore(cfg Config) TriggerStore {
+	return New(cfg).(*service)
+}
+
+func (s *service) PutPushTrigger(ctx context.Context, trigger *PushTrigger) (bool, error) {
+	var created bool
+	err := s.db.QueryRowContext(ctx, `
+        INSERT INTO push_triggers (project_id, repo, repo_url, branch, build_command, previews, secret)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        ON CONFLICT (project_id) DO UPDATE SET
+            repo = EXCLUDED.repo,
+            repo_url = EXCLUDED.repo_url,
+            branch = EXCLUDED.branch,
+            build_command = EXCLUDED.build_command,
+            previews = EXCLUDED.previews,
+            updated_at = now()
+        RETURNING secret, created_at, updated_at, xmax = 0
+    `, trigger.ProjectId, trigger.Repo, trigger.RepoURL, trigger.Branch, trigger.BuildCommand, trigger.Previews, trigger.Secret,
+	).Scan(&trigger.Secret, &trigger.CreatedAt, &trigger.UpdatedAt, &created)
+	if err != nil {
+		return false, fmt.Errorf("failed to save push trigger: %w", err)
+	}
+	return created, nil
+}
+
+func (s *service) GetPushTrigger(ctx context.Context, projectId string) (*PushTrigger, error) {
+	var trigger PushTrigger
+	err := s.db.QueryRowContext(ctx, `
+        SELECT project_id, repo, repo_url, branch, build_command, previews, created_at, updated_at
+        FROM push_triggers
+        WHERE project_id = $1
+    `, projectId).Scan(&trigger.ProjectId, &trigger.Repo, &trigger.RepoURL, &trigger.Branch, &trigger.BuildCommand,
+		&trigger.Previews, &trigger.CreatedAt, &trigger.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTriggerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get push trigger: %w", err)
+	}
+	return &trigger, nil
+}
+
+func (s *service) DeletePushTrigger(ctx context.Context, projectId string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM push_triggers WHERE project_id = $1`, projectId)
+	if err != nil {
+		return fmt.Errorf("failed to delete push trigger: %w", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrTriggerNotFound
+	}
+	return nil
+}
+
+func (s *service) FindPushTriggers(ctx context.Context, repo string) ([]PushTrigger, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT project_id, repo, repo_url, branch, build_command, previews, secret, created_at, updated_at
+        FROM push_triggers
+        WHERE repo = $1
+    `, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find push triggers: %w", err)
+	}
+	defer rows.Close()
+
+	var triggers []PushTrigger
+	for rows.Next() {
+		var t PushTrigger
+		err := rows.Scan(&t.ProjectId, &t.Repo, &t.RepoURL, &t.Branch, &t.BuildCommand, &t.Previews, &t.Secret, &t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read push trigger: %w", err)
+		}
+		triggers = append(triggers, t)
+	}
+	return triggers, rows.Err()
+}
+
+func (s *service) ClaimPushDelivery(ctx context.Context, digest, delivery string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM push_deliveries WHERE received_at < $1`, time.Now().Add(-pushDeliveryRetention))
+	if err != nil {
+		return false, fmt.Errorf("failed to prune push deliveries: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO push_deliveries (digest, delivery)
+        VALUES ($1, NULLIF($2, ''))
+        ON CONFLICT DO NOTHING
+    `, digest, delivery)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim push delivery: %w", err)
+	}
+	claimed, _ := result.RowsAffected()
+	return claimed == 1, tx.Commit()
+}
+
+func (s *service) ReleasePushDelivery(ctx context.Context, digest string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM push_deliveries WHERE digest = $1`, digest); err != nil {
+		return fmt.Errorf("failed to release push delivery: %w", err)
+	}
+	return nil
+}
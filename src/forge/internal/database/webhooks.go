@@ -0,0 +1,265 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Statuses of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// ErrWebhookNotFound is returned for a webhook endpoint or delivery that does
+// not exist in the project.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// WebhookEndpoint is a URL receiving the deployment events of a project.
+type WebhookEndpoint struct {
+	Id        string    `json:"id"`
+	ProjectId string    `json:"projectId"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery is an event sent, or to be sent, to a webhook endpoint.
+type WebhookDelivery struct {
+	Id             string          `json:"id"`
+	EndpointId     string          `json:"endpointId"`
+	ProjectId      string          `json:"projectId"`
+	EventId        string          `json:"eventId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt"`
+	ResponseStatus int             `json:"responseStatus,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	ReplayOf       string          `json:"replayOf,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	DeliveredAt    time.Time       `json:"deliveredAt,omitempty"`
+
+	// URL and Secret of the endpoint, only set on claimed deliveries.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// WebhookStore keeps webhook endpoints and their delivery log.
+type WebhookStore interface {
+	CreateWebhookEndpoint(ctx context.Context, endpoint *WebhookEndpoint) error
+	// ListWebhookEndpoints returns the endpoints of a project, without their secrets.
+	ListWebhookEndpoints(ctx context.Context, projectId string) ([]WebhookEndpoint, error)
+	DeleteWebhookEndpoint(ctx context.Context, projectId, endpointId string) error
+
+	// EnqueueWebhookDeliveries creates a pending delivery of an event for every
+	// endpoint of the project subscribed to its type, and returns how many.
+	EnqueueWebhookDeliveries(ctx context.Context, projectId, eventId, eventType string, payload []byte) (int, error)
+	// ClaimWebhookDeliveries returns up to limit pending deliveries that are
+	// due, and postpones them by lease so other workers skip them meanwhile.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error)
+	// UpdateWebhookDelivery saves the outcome of an attempt.
+	UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	// ListWebhookDeliveries returns the latest deliveries of a project, of
+	// every status when status is empty.
+	ListWebhookDeliveries(ctx context.Context, projectId, status string, limit int) ([]WebhookDelivery, error)
+	// ReplayWebhookDelivery creates a pending delivery with the payload of an
+	// earlier one.
+	ReplayWebhookDelivery(ctx context.Context, projectId, deliveryId string) (*WebhookDelivery, error)
+}
+
+// NewWebhookStore returns the webhook store of the forge database.
+func NewWebhookStore(cfg Config) WebhookStore {
+	return New(cfg).(*service)
+}
+
+func (s *service) CreateWebhookEndpoint(ctx context.Context, endpoint *WebhookEndpoint) error {
+	events, err := json.Marshal(endpoint.Events)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook events: %w", err)
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+        INSERT INTO webhook_endpoints (id, project_id, url, secret, events)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING created_at
+    `, endpoint.Id, endpoint.ProjectId, endpoint.URL, endpoint.Secret, events).Scan(&endpoint.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook endpoint: %w", err)
+	}
+	return nil
+}
+
+func (s *service) ListWebhookEndpoints(ctx context.Context, projectId string) ([]WebhookEndpoint, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, project_id, url, events, created_at
+        FROM webhook_endpoints
+        WHERE project_id = $1
+        ORDER BY created_at
+    `, projectId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook endpoints: %w", err)
+	}
+	defer rows.Close()
+
+	endpoints := []WebhookEndpoint{}
+	for rows.Next() {
+		var endpoint WebhookEndpoint
+		var events []byte
+		if err := rows.Scan(&endpoint.Id, &endpoint.ProjectId, &endpoint.URL, &events, &endpoint.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read webhook endpoint: %w", err)
+		}
+		if err := json.Unmarshal(events, &endpoint.Events); err != nil {
+			return nil, fmt.Errorf("failed to decode webhook events: %w", err)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, rows.Err()
+}
+
+func (s *service) DeleteWebhookEndpoint(ctx context.Context, projectId, endpointId string) error {
+	result, err := s.db.ExecContext(ctx, `
+        DELETE FROM webhook_endpoints WHERE project_id = $1 AND id = $2
+    `, projectId, endpointId)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook endpoint: %w", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (s *service) EnqueueWebhookDeliveries(ctx context.Context, projectId, eventId, eventType string, payload []byte) (int, error) {
+	// An empty list of events subscribes to every event type
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO webhook_deliveries (id, endpoint_id, project_id, event_id, event_type, payload)
+        SELECT gen_random_uuid()::text, id, project_id, $2, $3, $4
+        FROM webhook_endpoints
+        WHERE project_id = $1 AND (events = '[]' OR events ? $3)
+    `, projectId, eventId, eventType, payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook deliveries: %w", err)
+	}
+	created, _ := result.RowsAffected()
+	return int(created), nil
+}
+
+func (s *service) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        UPDATE webhook_deliveries d
+        SET next_attempt_at = now() + make_interval(secs => $2)
+        FROM webhook_endpoints e
+        WHERE e.id = d.endpoint_id AND d.id IN (
+            SELECT id FROM webhook_deliveries
+            WHERE status = 'pending' AND next_attempt_at <= now()
+            ORDER BY next_attempt_at
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING d.id, d.endpoint_id, d.project_id, d.event_id, d.event_type, d.payload,
+            d.status, d.attempts, d.created_at, e.url, e.secret
+    `, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload []byte
+		err := rows.Scan(&d.Id, &d.EndpointId, &d.ProjectId, &d.EventId, &d.EventType, &payload,
+			&d.Status, &d.Attempts, &d.CreatedAt, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook delivery: %w", err)
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *service) UpdateWebhookDelivery(ctx context.Context, d *WebhookDelivery) error {
+	_, err := s.db.ExecContext(ctx, `
+        UPDATE webhook_deliveries
+        SET status = $2, attempts = $3, next_attempt_at = $4, response_status = $5,
+            last_error = $6, delivered_at = $7
+        WHERE id = $1
+    `, d.Id, d.Status, d.Attempts, d.NextAttemptAt, sql.NullInt32{Int32: int32(d.ResponseStatus), Valid: d.ResponseStatus != 0},
+		sql.NullString{String: d.LastError, Valid: d.LastError != ""}, sql.NullTime{Time: d.DeliveredAt, Valid: !d.DeliveredAt.IsZero()})
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (s *service) ListWebhookDeliveries(ctx context.Context, projectId, status string, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, endpoint_id, project_id, event_id, event_type, payload, status, attempts,
+            next_attempt_at, response_status, last_error, replay_of, created_at, delivered_at
+        FROM webhook_deliveries
+        WHERE project_id = $1 AND ($2 = '' OR status = $2)
+        ORDER BY created_at DESC
+        LIMIT $3
+    `, projectId, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *service) ReplayWebhookDelivery(ctx context.Context, projectId, deliveryId string) (*WebhookDelivery, error) {
+	row := s.db.QueryRowContext(ctx, `
+        INSERT INTO webhook_deliveries (id, endpoint_id, project_id, event_id, event_type, payload, replay_of)
+        SELECT gen_random_uuid()::text, endpoint_id, project_id, event_id, event_type, payload, id
+        FROM webhook_deliveries
+        WHERE project_id = $1 AND id = $2
+        RETURNING id, endpoint_id, project_id, event_id, event_type, payload, status, attempts,
+            next_attempt_at, response_status, last_error, replay_of, created_at, delivered_at
+    `, projectId, deliveryId)
+	d, err := scanDelivery(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	return d, err
+}
+
+func scanDelivery(row interface{ Scan(...any) error }) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload []byte
+	var responseStatus sql.NullInt32
+	var lastError, replayOf sql.NullString
+	var deliveredAt sql.NullTime
+	err := row.Scan(&d.Id, &d.EndpointId, &d.ProjectId, &d.EventId, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &responseStatus, &lastError, &replayOf, &d.CreatedAt, &deliveredAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook delivery: %w", err)
+	}
+	d.Payload = payload
+	d.ResponseStatus = int(responseStatus.Int32)
+	d.LastError = lastError.String
+	d.ReplayOf = replayOf.String
+	d.DeliveredAt = deliveredAt.Time
+	return &d, nil
+}
@@ -0,0 +1,132 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ErrNotFound is returned when an object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// Bucket is the object storage deployments are kept in.
+type Bucket interface {
+	PutObject(ctx context.Context, key string, body io.Reader, contentType string) error
+	GetObject(ctx context.Context, key string) ([]byte, error)
+	ListObjects(ctx context.Context, prefix string) ([]string, error)
+	CopyObject(ctx context.Context, srcKey, dstKey string) error
+	DeleteObjects(ctx context.Context, keys []string) error
+}
+
+type s3Bucket struct {
+	client *s3.Client
+	name   string
+}
+
+// NewS3Bucket returns a Bucket backed by an S3 bucket.
+func NewS3Bucket(client *s3.Client, name string) Bucket {
+	return &s3Bucket{
+		client: client,
+		name:   name,
+	}
+}
+
+func (b *s3Bucket) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(b.name),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put s3://%s/%s: %w", b.name, key, err)
+	}
+	return nil
+}
+
+func (b *s3Bucket) GetObject(ctx context.Context, key string) ([]byte, error) {
+	out, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get s3://%s/%s: %w", b.name, key, err)
+	}
+	defer out.Body.Close()
+
+	return io.ReadAll(out.Body)
+}
+
+func (b *s3Bucket) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.name),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: %w", b.name, prefix, err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	return keys, nil
+}
+
+func (b *s3Bucket) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	_, err := b.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(b.name),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(escapeCopySource(b.name + "/" + srcKey)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+func (b *s3Bucket) DeleteObjects(ctx context.Context, keys []string) error {
+	// DeleteObjects accepts at most 1000 keys per request
+	for start := 0; start < len(keys); start += 1000 {
+		end := min(start+1000, len(keys))
+
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		out, err := b.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(b.name),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete objects: %w", err)
+		}
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("failed to delete %s: %s", aws.ToString(out.Errors[0].Key), aws.ToString(out.Errors[0].Message))
+		}
+	}
+	return nil
+}
+
+// escapeCopySource URL-encodes every segment of a copy source, keeping the slashes.
+func escapeCopySource(source string) string {
+	segments := strings.Split(source, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,148 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type dirBucket struct {
+	root string
+}
+
+// NewDirBucket returns a Bucket that keeps objects as files under root, keys
+// are paths relative to it. It is used by the local development mode.
+func NewDirBucket(root string) Bucket {
+	return &dirBucket{root: root}
+}
+
+// path returns the file of a key, keys must not leave the root.
+func (b *dirBucket) path(key string) (string, error) {
+	if key == "" || strings.HasSuffix(key, "/") || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(b.root, filepath.FromSlash(key)), nil
+}
+
+func (b *dirBucket) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	file, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("failed to put %s: %w", key, err)
+	}
+
+	// Readers never see a partly written object
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".put-*")
+	if err != nil {
+		return fmt.Errorf("failed to put %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to put %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to put %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to put %s: %w", key, err)
+	}
+	return nil
+}
+
+func (b *dirBucket) GetObject(ctx context.Context, key string) ([]byte, error) {
+	file, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) || isDirErr(file, err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", key, err)
+	}
+	return data, nil
+}
+
+func (b *dirBucket) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	// Only the directory holding the prefix is walked
+	dir := path.Dir(prefix + "x")
+	start := b.root
+	if dir != "." {
+		if !filepath.IsLocal(filepath.FromSlash(dir)) {
+			return nil, fmt.Errorf("invalid object prefix %q", prefix)
+		}
+		start = filepath.Join(b.root, filepath.FromSlash(dir))
+	}
+
+	var keys []string
+	err := filepath.WalkDir(start, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".put-") {
+			return nil
+		}
+		rel, err := filepath.Rel(b.root, file)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (b *dirBucket) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	src, err := b.path(srcKey)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcKey, dstKey, err)
+	}
+	defer file.Close()
+	return b.PutObject(ctx, dstKey, file, "")
+}
+
+func (b *dirBucket) DeleteObjects(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		file, err := b.path(key)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// isDirErr reports whether reading file failed because it is a directory.
+func isDirErr(file string, err error) bool {
+	if err == nil {
+		return false
+	}
+	info, statErr := os.Stat(file)
+	return statErr == nil && info.IsDir()
+}
@@ -0,0 +1,51 @@
+package deployment
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+// maxSlugLength keeps "<slug>--<project id>" within the 63 characters of a DNS label.
+const maxSlugLength = 24
+
+// PreviewSlug returns the name a branch preview is served under. It is the
+// lowercased branch with runs of other characters than letters and digits
+// replaced by a dash. Unless that is the branch itself, the slug is suffixed
+// with a hash of the branch, shortened first when it is long, so branches such
+// as "Feature/Login" and "feature-login" stay distinct.
+func PreviewSlug(branch string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(branch) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+
+	if slug != branch || len(slug) > maxSlugLength {
+		sum := sha1.Sum([]byte(branch))
+		hash := hex.EncodeToString(sum[:])[:6]
+		if len(slug) > maxSlugLength-len(hash)-1 {
+			slug = strings.TrimSuffix(slug[:maxSlugLength-len(hash)-1], "-")
+		}
+		if slug == "" {
+			return hash
+		}
+		slug += "-" + hash
+	}
+	return slug
+}
+
+// PreviewSite returns the name the proxy serves a branch preview at, it is
+// used as the first path segment or host label.
+func PreviewSite(projectId, slug string) string {
+	return slug + "--" + projectId
+}
@@ -0,0 +1,615 @@
+package deployment
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Deployment statuses.
+const (
+	StatusBuilding   = "BUILDING"
+	StatusSucceeded  = "SUCCEEDED"
+	StatusFailed     = "FAILED"
+	StatusSuperseded = "SUPERSEDED"
+	StatusCancelled  = "CANCELLED"
+)
+
+// Record describes a single deployment of a project.
+type Record struct {
+	DeploymentId  string `json:"deploymentId"`
+	ProjectId     string `json:"projectId"`
+	Status        string `json:"status"`
+	FailureReason string `json:"failureReason,omitempty"`
+	CancelledBy   string `json:"cancelledBy,omitempty"`
+
+	Fingerprint  string `json:"fingerprint,omitempty"`
+	RepoURL      string `json:"repoURL"`
+	CommitSHA    string `json:"commitSha,omitempty"`
+	BuildCommand string `json:"buildCommand"`
+	BuilderImage string `json:"builderImage,omitempty"`
+	Framework    string `json:"framework,omitempty"`
+	NodeVersion  string `json:"nodeVersion,omitempty"`
+
+	// OutputFiles and OutputBytes describe the uploaded artifacts.
+	OutputFiles int   `json:"outputFiles,omitempty"`
+	OutputBytes int64 `json:"outputBytes,omitempty"`
+
+	// ArtifactsFrom is the deployment whose artifacts this deployment serves.
+	// It differs from DeploymentId when the build was skipped because an
+	// identical deployment already existed.
+	ArtifactsFrom string `json:"artifactsFrom"`
+
+	CreatedAt  time.Time `json:"createdAt"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+	// PromotedAt is when the deployment was last made live.
+	PromotedAt time.Time `json:"promotedAt,omitempty"`
+
+	// PreviewBranch is set on preview deployments, they are published under
+	// the preview prefix of the branch and never made live.
+	PreviewBranch string `json:"previewBranch,omitempty"`
+	PreviewURL    string `json:"previewURL,omitempty"`
+}
+
+// DefaultRetention is how many successful deployments of a project keep their
+// artifacts to roll back to when no retention is configured.
+const DefaultRetention = 10
+
+// ErrNoArtifacts is returned when a deployment is published whose artifacts
+// were pruned or never uploaded.
+var ErrNoArtifacts = errors.New("deployment has no artifacts")
+
+// ErrDeleteIncomplete is returned by DeleteProject when objects of the project are left.
+var ErrDeleteIncomplete = errors.New("project objects are left after deleting them")
+
+// Store keeps deployment artifacts and records in a bucket. Only the sites/
+// prefix is public, the proxy serves it and resolves the pointer objects,
+// which hold the id of the deployment whose artifacts they serve:
+//
+//	sites/<project>/deployments/<deployment>/                   artifacts of a deployment
+//	sites/<project>/live                                        pointer to the live deployment
+//	sites/<project>/previews/<branch>                           pointer to the preview of a branch
+//	projects/<project>/deployments/<deployment>.json            deployment record
+//	projects/<project>/deployments/<deployment>/provenance.json signed provenance of a deployment
+//	projects/<project>/fingerprints/<fingerprint>.json          successful deployment with that fingerprint
+type Store struct {
+	bucket Bucket
+}
+
+func NewStore(bucket Bucket) *Store {
+	return &Store{
+		bucket: bucket,
+	}
+}
+
+// SitesPrefix is the public prefix of the bucket the proxy serves.
+const SitesPrefix = "sites/"
+
+func projectPrefix(projectId string) string {
+	return fmt.Sprintf("projects/%s/", projectId)
+}
+
+func sitePrefix(projectId string) string {
+	return fmt.Sprintf("%s%s/", SitesPrefix, projectId)
+}
+
+// LivePointerKey returns the key of the pointer to the live deployment of a project.
+func LivePointerKey(projectId string) string {
+	return sitePrefix(projectId) + "live"
+}
+
+// PreviewPointerKey returns the key of the pointer to the preview of a branch,
+// slug is the PreviewSlug of the branch.
+func PreviewPointerKey(projectId, slug string) string {
+	return fmt.Sprintf("%spreviews/%s", sitePrefix(projectId), slug)
+}
+
+// ArtifactsPrefix returns the prefix the artifacts of a deployment are stored under.
+func ArtifactsPrefix(projectId, deploymentId string) string {
+	return fmt.Sprintf("%sdeployments/%s/", sitePrefix(projectId), deploymentId)
+}
+
+// ProvenanceKey returns the key of the signed provenance of a deployment,
+// next to its record.
+func ProvenanceKey(projectId, deploymentId string) string {
+	return fmt.Sprintf("%sdeployments/%s/provenance.json", projectPrefix(projectId), deploymentId)
+}
+
+func recordKey(projectId, deploymentId string) string {
+	return fmt.Sprintf("%sdeployments/%s.json", projectPrefix(projectId), deploymentId)
+}
+
+func fingerprintKey(projectId, fingerprint string) string {
+	return fmt.Sprintf("%sfingerprints/%s.json", projectPrefix(projectId), fingerprint)
+}
+
+// SaveRecord writes a deployment record, indexing it by fingerprint once it succeeded.
+func (s *Store) SaveRecord(ctx context.Context, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal deployment record: %w", err)
+	}
+
+	if err := s.bucket.PutObject(ctx, recordKey(record.ProjectId, record.DeploymentId), bytes.NewReader(data), "application/json"); err != nil {
+		return err
+	}
+
+	if record.Status == StatusSucceeded && record.Fingerprint != "" {
+		index, err := json.Marshal(map[string]string{"deploymentId": record.DeploymentId})
+		if err != nil {
+			return fmt.Errorf("failed to marshal fingerprint index: %w", err)
+		}
+		if err := s.bucket.PutObject(ctx, fingerprintKey(record.ProjectId, record.Fingerprint), bytes.NewReader(index), "application/json"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetRecord returns a deployment record, or ErrNotFound.
+func (s *Store) GetRecord(ctx context.Context, projectId, deploymentId string) (*Record, error) {
+	data, err := s.bucket.GetObject(ctx, recordKey(projectId, deploymentId))
+	if err != nil {
+		return nil, err
+	}
+
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal deployment record %s: %w", deploymentId, err)
+	}
+	return &record, nil
+}
+
+// ListRecords returns all deployment records of a project, newest first.
+func (s *Store) ListRecords(ctx context.Context, projectId string) ([]*Record, error) {
+	prefix := projectPrefix(projectId) + "deployments/"
+	keys, err := s.bucket.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, prefix)
+		if strings.Contains(name, "/") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		record, err := s.GetRecord(ctx, projectId, strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.After(records[j].CreatedAt)
+	})
+	return records, nil
+}
+
+// DeleteProject removes every object of a project, its artifacts, pointers
+// and records, and verifies none are left. It returns how many were removed.
+func (s *Store) DeleteProject(ctx context.Context, projectId string) (int, error) {
+	if projectId == "" {
+		return 0, errors.New("project id is required")
+	}
+
+	removed := 0
+	// The site goes first, so nothing is served once the records are gone
+	for _, prefix := range []string{sitePrefix(projectId), projectPrefix(projectId)} {
+		keys, err := s.bucket.ListObjects(ctx, prefix)
+		if err != nil {
+			return removed, err
+		}
+		if err := s.bucket.DeleteObjects(ctx, keys); err != nil {
+			return removed, err
+		}
+		removed += len(keys)
+
+		remaining, err := s.bucket.ListObjects(ctx, prefix)
+		if err != nil {
+			return removed, fmt.Errorf("failed to verify deletion: %w", err)
+		}
+		if len(remaining) > 0 {
+			return removed, fmt.Errorf("%w: %d objects left under %s", ErrDeleteIncomplete, len(remaining), prefix)
+		}
+	}
+	return removed, nil
+}
+
+// LiveRecord returns the deployment that was promoted last, or ErrNotFound.
+func (s *Store) LiveRecord(ctx context.Context, projectId string) (*Record, error) {
+	records, err := s.ListRecords(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+
+	var live *Record
+	for _, record := range records {
+		if !record.PromotedAt.IsZero() && (live == nil || record.PromotedAt.After(live.PromotedAt)) {
+			live = record
+		}
+	}
+	if live == nil {
+		return nil, ErrNotFound
+	}
+	return live, nil
+}
+
+// FindByFingerprint returns the successful deployment built with the given
+// fingerprint whose artifacts are still available, or ErrNotFound.
+func (s *Store) FindByFingerprint(ctx context.Context, projectId, fingerprint string) (*Record, error) {
+	data, err := s.bucket.GetObject(ctx, fingerprintKey(projectId, fingerprint))
+	if err != nil {
+		return nil, err
+	}
+
+	var index struct {
+		DeploymentId string `json:"deploymentId"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal fingerprint index: %w", err)
+	}
+
+	record, err := s.GetRecord(ctx, projectId, index.DeploymentId)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != StatusSucceeded || record.Fingerprint != fingerprint {
+		return nil, ErrNotFound
+	}
+
+	artifacts, err := s.bucket.ListObjects(ctx, ArtifactsPrefix(projectId, record.ArtifactsFrom))
+	if err != nil {
+		return nil, err
+	}
+	if len(artifacts) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return record, nil
+}
+
+// UploadStats counts what Upload stored.
+type UploadStats struct {
+	Files int
+	Bytes int64
+	// Digests are the hex sha256 digests of the files by their path in the artifacts.
+	Digests map[string]string
+}
+
+// Upload stores the files in dir as the artifacts of a deployment.
+func (s *Store) Upload(ctx context.Context, projectId, deploymentId, dir string) (UploadStats, error) {
+	prefix := ArtifactsPrefix(projectId, deploymentId)
+
+	stats := UploadStats{Digests: make(map[string]string)}
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to access path %q: %v", filePath, err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %v", err)
+		}
+		key := prefix + filepath.ToSlash(relPath)
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %v", filePath, err)
+		}
+		defer file.Close()
+
+		// The file is read twice, S3 needs a body it can seek
+		digest, err := sha256Digest(file)
+		if err != nil {
+			return fmt.Errorf("failed to hash file %s: %v", filePath, err)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind file %s: %v", filePath, err)
+		}
+
+		contentType := detectContentType(filePath)
+		if err := s.bucket.PutObject(ctx, key, file, contentType); err != nil {
+			return err
+		}
+		fmt.Printf("Uploaded file: %s (Content-Type: %s)\n", key, contentType)
+
+		stats.Files++
+		stats.Bytes += info.Size()
+		stats.Digests[filepath.ToSlash(relPath)] = digest
+		return nil
+	})
+	return stats, err
+}
+
+// ArtifactDigests returns the hex sha256 digests of the artifacts of a
+// deployment by their path, like Upload does.
+func (s *Store) ArtifactDigests(ctx context.Context, projectId, deploymentId string) (map[string]string, error) {
+	prefix := ArtifactsPrefix(projectId, deploymentId)
+	keys, err := s.bucket.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make(map[string]string, len(keys))
+	for _, key := range keys {
+		data, err := s.bucket.GetObject(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := sha256Digest(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		digests[strings.TrimPrefix(key, prefix)] = digest
+	}
+	return digests, nil
+}
+
+// SaveProvenance writes the signed provenance of a deployment.
+func (s *Store) SaveProvenance(ctx context.Context, projectId, deploymentId string, envelope []byte) error {
+	return s.bucket.PutObject(ctx, ProvenanceKey(projectId, deploymentId), bytes.NewReader(envelope), "application/json")
+}
+
+// GetProvenance returns the signed provenance of a deployment, or ErrNotFound.
+func (s *Store) GetProvenance(ctx context.Context, projectId, deploymentId string) ([]byte, error) {
+	return s.bucket.GetObject(ctx, ProvenanceKey(projectId, deploymentId))
+}
+
+func sha256Digest(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Promote makes a deployment live by pointing the live pointer of its project
+// at its artifacts, which replaces the live deployment in a single write.
+func (s *Store) Promote(ctx context.Context, record *Record) error {
+	if record.Status != StatusSucceeded {
+		return fmt.Errorf("deployment %s has status %s and cannot be promoted", record.DeploymentId, record.Status)
+	}
+	if record.PreviewBranch != "" {
+		return fmt.Errorf("deployment %s is a preview of branch %s and cannot be promoted", record.DeploymentId, record.PreviewBranch)
+	}
+
+	if err := s.point(ctx, record, LivePointerKey(record.ProjectId)); err != nil {
+		return err
+	}
+
+	record.PromotedAt = time.Now().UTC()
+	log.Printf("Promoted deployment %s of project %s", record.DeploymentId, record.ProjectId)
+	return nil
+}
+
+// PublishPreview points the preview pointer of its branch at the artifacts of
+// a preview deployment, replacing the previous preview of the branch.
+func (s *Store) PublishPreview(ctx context.Context, record *Record) error {
+	if record.Status != StatusSucceeded {
+		return fmt.Errorf("deployment %s has status %s and cannot be published", record.DeploymentId, record.Status)
+	}
+	if record.PreviewBranch == "" {
+		return fmt.Errorf("deployment %s is not a preview", record.DeploymentId)
+	}
+
+	if err := s.point(ctx, record, PreviewPointerKey(record.ProjectId, PreviewSlug(record.PreviewBranch))); err != nil {
+		return err
+	}
+
+	log.Printf("Published preview %s of branch %s of project %s", record.DeploymentId, record.PreviewBranch, record.ProjectId)
+	return nil
+}
+
+// DeletePreview removes the pointer to the preview of a branch, so it is no
+// longer served. The records and artifacts of its deployments are kept until
+// they are pruned. It returns how many objects were removed.
+func (s *Store) DeletePreview(ctx context.Context, projectId, slug string) (int, error) {
+	if projectId == "" || slug == "" {
+		return 0, errors.New("project id and preview are required")
+	}
+	keys, err := s.bucket.ListObjects(ctx, PreviewPointerKey(projectId, slug))
+	if err != nil {
+		return 0, err
+	}
+
+	var pointers []string
+	for _, key := range keys {
+		if key == PreviewPointerKey(projectId, slug) {
+			pointers = append(pointers, key)
+		}
+	}
+	if err := s.bucket.DeleteObjects(ctx, pointers); err != nil {
+		return 0, err
+	}
+	return len(pointers), nil
+}
+
+// point writes the pointer at key to the artifacts of a deployment.
+func (s *Store) point(ctx context.Context, record *Record, key string) error {
+	artifacts, err := s.bucket.ListObjects(ctx, ArtifactsPrefix(record.ProjectId, record.ArtifactsFrom))
+	if err != nil {
+		return err
+	}
+	if len(artifacts) == 0 {
+		return fmt.Errorf("%w: %s", ErrNoArtifacts, record.ArtifactsFrom)
+	}
+
+	return s.bucket.PutObject(ctx, key, strings.NewReader(record.ArtifactsFrom), "text/plain")
+}
+
+// pointer returns the deployment a pointer points to, or ErrNotFound.
+func (s *Store) pointer(ctx context.Context, key string) (string, error) {
+	data, err := s.bucket.GetObject(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ServedDeployment returns the deployment whose artifacts a site serves, the
+// live deployment of the project when slug is empty or else the preview of
+// the branch with that slug. It returns ErrNotFound when nothing is served.
+func (s *Store) ServedDeployment(ctx context.Context, projectId, slug string) (string, error) {
+	if slug == "" {
+		return s.pointer(ctx, LivePointerKey(projectId))
+	}
+	return s.pointer(ctx, PreviewPointerKey(projectId, slug))
+}
+
+// Prune removes the artifacts no site serves or can roll back to. The
+// artifacts of the live deployment, of the branch previews, of deployments
+// still building and of the keep newest successful deployments are kept.
+// Deployments without a record yet may be uploading and are never pruned. The
+// records of deployments older than the keep newest successful ones are
+// removed too unless their artifacts are kept, so the records read stay few.
+// It returns how many artifacts were removed.
+func (s *Store) Prune(ctx context.Context, projectId string, keep int) (int, error) {
+	records, err := s.ListRecords(ctx, projectId)
+	if err != nil {
+		return 0, err
+	}
+
+	retained := make(map[string]bool)
+	pointers := []string{LivePointerKey(projectId)}
+	previews, err := s.bucket.ListObjects(ctx, sitePrefix(projectId)+"previews/")
+	if err != nil {
+		return 0, err
+	}
+	pointers = append(pointers, previews...)
+	for _, key := range pointers {
+		deploymentId, err := s.pointer(ctx, key)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		retained[deploymentId] = true
+	}
+
+	recorded := make(map[string]bool, len(records))
+	var expired []*Record
+	kept := 0
+	for _, record := range records {
+		recorded[record.DeploymentId] = true
+		switch {
+		case record.Status == StatusBuilding:
+			retained[record.ArtifactsFrom] = true
+		case record.Status == StatusSucceeded && record.PreviewBranch == "" && kept < keep:
+			retained[record.ArtifactsFrom] = true
+			kept++
+		case kept >= keep:
+			expired = append(expired, record)
+		}
+	}
+
+	prefix := sitePrefix(projectId) + "deployments/"
+	keys, err := s.bucket.ListObjects(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var stale []string
+	for _, key := range keys {
+		deploymentId, _, _ := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+		if recorded[deploymentId] && !retained[deploymentId] {
+			stale = append(stale, key)
+		}
+	}
+	if err := s.bucket.DeleteObjects(ctx, stale); err != nil {
+		return 0, err
+	}
+
+	// Records go after their artifacts, so no artifacts are left without one
+	var expiredKeys []string
+	for _, record := range expired {
+		if retained[record.ArtifactsFrom] || retained[record.DeploymentId] {
+			continue
+		}
+		keys, err := s.recordKeys(ctx, record)
+		if err != nil {
+			return 0, err
+		}
+		expiredKeys = append(expiredKeys, keys...)
+	}
+	if err := s.bucket.DeleteObjects(ctx, expiredKeys); err != nil {
+		return 0, err
+	}
+	return len(stale), nil
+}
+
+// recordKeys returns the keys of the record of a deployment, its provenance
+// and its fingerprint index unless a newer deployment took the index over.
+func (s *Store) recordKeys(ctx context.Context, record *Record) ([]string, error) {
+	keys := []string{recordKey(record.ProjectId, record.DeploymentId), ProvenanceKey(record.ProjectId, record.DeploymentId)}
+	if record.Fingerprint == "" {
+		return keys, nil
+	}
+
+	data, err := s.bucket.GetObject(ctx, fingerprintKey(record.ProjectId, record.Fingerprint))
+	if IsNotFound(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index struct {
+		DeploymentId string `json:"deploymentId"`
+	}
+	if err := json.Unmarshal(data, &index); err == nil && index.DeploymentId == record.DeploymentId {
+		keys = append(keys, fingerprintKey(record.ProjectId, record.Fingerprint))
+	}
+	return keys, nil
+}
+
+// IsNotFound reports whether err means the object does not exist.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+func detectContentType(filePath string) string {
+	ext := filepath.Ext(filePath)
+	if ext != "" {
+		mimeType := mime.TypeByExtension(ext)
+		if mimeType != "" {
+			return mimeType
+		}
+	}
+
+	// If mime type is not found, try to detect it by reading the file
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "application/octet-stream" // default to binary data
+	}
+	defer file.Close()
+
+	// Only the first 512 bytes are used to sniff the content type.
+	buffer := make([]byte, 512)
+	n, err := file.Read(buffer)
+	if err != nil {
+		return "application/octet-stream" // default to binary data
+	}
+
+	// DetectContentType always returns a valid MIME type
+	return http.DetectContentType(buffer[:n])
+}
@@ -0,0 +1,321 @@
+// Package dev runs forge, the logify API and the proxy in a single process
+// for local development. The queue and the logs are kept in memory, the
+// deployments in a directory and the leases, previews and webhooks in a local
+// Postgres, so no cloud resources are used. Builds still run in Docker.
+package dev
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forge/internal/api"
+	"forge/internal/cli"
+	"forge/internal/database"
+	"forge/internal/deployment"
+	"forge/internal/provenance"
+	"forge/internal/utils"
+	"forge/internal/webhook"
+	"forge/internal/worker"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// QueueURL is the in-memory build queue.
+const QueueURL = "dev://build-queue"
+
+// Config controls the development server.
+type Config struct {
+	// Address serves the sites, the logs and the development API.
+	Address string
+	// DataDir keeps the deployments, they outlive the process.
+	DataDir string
+	// AllowedGitHosts are the hosts builds may clone from, the defaults of
+	// the worker when empty.
+	AllowedGitHosts []string
+	// Sandbox limits the build containers, the defaults of the worker when nil.
+	Sandbox *utils.SandboxConfig
+	// Provenance signs the provenance of the deployments when it has a key.
+	Provenance *provenance.Config
+	// APIToken serves the forge API, to manage webhooks, push triggers and
+	// previews, when it is not empty.
+	APIToken string
+}
+
+// Stores are the database stores of the development server.
+type Stores struct {
+	DB       database.Service
+	Previews database.PreviewStore
+	Webhooks database.WebhookStore
+	Triggers database.TriggerStore
+}
+
+// OpenStores connects to the local Postgres and migrates it.
+func OpenStores(cfg database.Config) (*Stores, error) {
+	db := database.New(cfg)
+	if err := db.Migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return &Stores{
+		DB:       db,
+		Previews: database.NewPreviewStore(cfg),
+		Webhooks: database.NewWebhookStore(cfg),
+		Triggers: database.NewTriggerStore(cfg),
+	}, nil
+}
+
+// Server is forge running against in-memory stand-ins of its cloud services.
+type Server struct {
+	cfg        *Config
+	Queue      *Queue
+	Logs       *Logs
+	Projects   *Projects
+	Store      *deployment.Store
+	Builds     *worker.BuildQueue
+	stores     *Stores
+	dispatcher *webhook.Dispatcher
+	bucket     deployment.Bucket
+	registry   *worker.Registry
+}
+
+// New returns a development server using the stores, build logs are also
+// written to out.
+func New(cfg *Config, stores *Stores, out io.Writer) (*Server, error) {
+	s := &Server{
+		cfg:      cfg,
+		Queue:    NewQueue(),
+		Logs:     NewLogs(out),
+		Projects: NewProjects(),
+		stores:   stores,
+		bucket:   deployment.NewDirBucket(filepath.Join(cfg.DataDir, "bucket")),
+	}
+	s.Store = deployment.NewStore(s.bucket)
+	s.Builds = worker.NewBuildQueue(s.Queue, QueueURL)
+
+	// Webhook endpoints of local development run on this machine
+	webhooks := webhook.DefaultConfig()
+	webhooks.AllowPrivate = true
+	s.dispatcher = webhook.NewDispatcher(stores.Webhooks, webhooks)
+
+	// Previews are served by the development server too
+	previews := worker.DefaultPreviewConfig()
+	previews.URLTemplate = s.BaseURL() + "/{site}/"
+
+	services := &worker.Services{
+		Projects:      s.Projects,
+		Logs:          s.Logs,
+		DB:            stores.DB,
+		Webhooks:      s.dispatcher,
+		Previews:      stores.Previews,
+		Store:         s.Store,
+		Provenance:    cfg.Provenance,
+		PreviewConfig: previews,
+		Sandbox:       cfg.Sandbox,
+	}
+	if len(cfg.AllowedGitHosts) > 0 {
+		services.Repos = utils.NewRepoURLValidator(cfg.AllowedGitHosts)
+	}
+	registry, err := worker.NewRegistry(services, &worker.RegistryConfig{Unknown: worker.UnknownReject})
+	if err != nil {
+		return nil, err
+	}
+	s.registry = registry
+	return s, nil
+}
+
+// BaseURL returns the URL the server is reached at from this machine.
+func (s *Server) BaseURL() string {
+	host, port, err := net.SplitHostPort(s.cfg.Address)
+	if err != nil || host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// SiteURL returns the URL a project is served at, or the preview of branch
+// when it is not empty.
+func (s *Server) SiteURL(projectId, branch string) string {
+	site := projectId
+	if branch != "" {
+		site = deployment.PreviewSite(projectId, deployment.PreviewSlug(branch))
+	}
+	return s.BaseURL() + "/" + site + "/"
+}
+
+// Handler serves the development API, the logs like logify, and the sites
+// like the proxy:
+//
+//	POST /dev/projects/{projectId}/deploy       enqueue a build
+//	POST /dev/projects/{projectId}/rollback     enqueue a rollback
+//	GET  /dev/projects/{projectId}/deployments  list the deployments, newest first
+//	GET  /api/v1/{projectId}/logs?limit=        the latest build logs, newest first
+//	GET  /{site}/...                            the files of a site
+//
+// With an API token, the forge API is also served under /projects/ and the
+// push webhooks at /hooks/push.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /dev/projects/{projectId}/deploy", s.deploy)
+	mux.HandleFunc("POST /dev/projects/{projectId}/rollback", s.rollback)
+	mux.HandleFunc("GET /dev/projects/{projectId}/deployments", s.listDeployments)
+	mux.HandleFunc("GET /api/v1/{projectId}/logs", s.Logs.serveLogs)
+	if s.cfg.APIToken != "" {
+		services := &api.Services{
+			Webhooks: s.stores.Webhooks,
+			Triggers: s.stores.Triggers,
+			Previews: s.stores.Previews,
+			Builds:   s.Builds,
+		}
+		mux.Handle("/projects/", api.NewHandler(s.cfg.APIToken, services))
+		mux.Handle("/hooks/", api.NewHooksHandler(services))
+	}
+	mux.Handle("/", &sites{bucket: s.bucket})
+	return mux
+}
+
+// Run serves the handler and processes the queue until ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.cfg.Address)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+	log.Printf("Serving sites, logs and the development API at %s", s.BaseURL())
+
+	go s.dispatcher.Run(ctx)
+	go worker.RunPreviewExpiry(ctx, s.Store, s.stores.Previews, worker.DefaultPreviewConfig().ExpiryInterval)
+
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		worker.RunQueue(ctx, s.Queue, worker.DefaultQueueConfig(QueueURL), s.registry)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err = <-serveErr:
+	}
+	server.Shutdown(context.Background())
+	<-workerDone
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// DeployRequest is the body of POST /dev/projects/{projectId}/deploy.
+type DeployRequest struct {
+	RepoURL       string            `json:"repoURL"`
+	Ref           string            `json:"ref"`
+	CommitSHA     string            `json:"commitSha"`
+	BuildCommand  string            `json:"buildCommand"`
+	Env           map[string]string `json:"env"`
+	PreviewBranch string            `json:"previewBranch"`
+}
+
+// QueuedDeployment is a deployment enqueued on the development server.
+type QueuedDeployment struct {
+	ProjectId    string `json:"projectId"`
+	DeploymentId string `json:"deploymentId"`
+	MessageId    string `json:"messageId"`
+	URL          string `json:"url"`
+}
+
+// Deploy enqueues a build of a project.
+func (s *Server) Deploy(ctx context.Context, projectId string, req *DeployRequest) (*QueuedDeployment, error) {
+	if _, preview, ok := parseSite(projectId); !ok || preview != "" {
+		return nil, fmt.Errorf("invalid project id %q, it must be a UUID", projectId)
+	}
+	if req.RepoURL == "" {
+		return nil, errors.New("repoURL is required")
+	}
+
+	msg := &worker.BuildMessage{
+		ProjectId:     projectId,
+		DeploymentId:  uuid.NewString(),
+		RepoURL:       req.RepoURL,
+		Ref:           req.Ref,
+		CommitSHA:     req.CommitSHA,
+		BuildCommand:  req.BuildCommand,
+		Env:           req.Env,
+		PreviewBranch: req.PreviewBranch,
+	}
+	if msg.PreviewBranch != "" && msg.Ref == "" {
+		msg.Ref = msg.PreviewBranch
+	}
+	messageId, err := s.Builds.Enqueue(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	return &QueuedDeployment{
+		ProjectId:    projectId,
+		DeploymentId: msg.DeploymentId,
+		MessageId:    messageId,
+		URL:          s.SiteURL(projectId, msg.PreviewBranch),
+	}, nil
+}
+
+func (s *Server) deploy(w http.ResponseWriter, r *http.Request) {
+	var req DeployRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	queued, err := s.Deploy(r.Context(), r.PathValue("projectId"), &req)
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	api.WriteJSON(w, http.StatusAccepted, queued)
+}
+
+func (s *Server) rollback(w http.ResponseWriter, r *http.Request) {
+	var msg worker.RollbackMessage
+	// The body is optional, the deployment defaults to the one before the live one
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil && !errors.Is(err, io.EOF) {
+		api.WriteError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	msg.ProjectId = r.PathValue("projectId")
+
+	messageId, err := s.Builds.EnqueueRollback(r.Context(), &msg)
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	api.WriteJSON(w, http.StatusAccepted, map[string]string{"projectId": msg.ProjectId, "messageId": messageId})
+}
+
+func (s *Server) listDeployments(w http.ResponseWriter, r *http.Request) {
+	projectId := r.PathValue("projectId")
+	records, err := s.Store.ListRecords(r.Context(), projectId)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	liveId := ""
+	if live, err := s.Store.LiveRecord(r.Context(), projectId); err == nil {
+		liveId = live.DeploymentId
+	}
+	listed := make([]cli.ListedDeployment, len(records))
+	for i, record := range records {
+		listed[i] = cli.ListedDeployment{Record: record, Live: record.DeploymentId == liveId}
+	}
+	api.WriteJSON(w, http.StatusOK, listed)
+}
@@ -0,0 +1,87 @@
+package dev
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"forge/internal/service"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxLogLines bounds the log lines kept per project, older lines are dropped.
+const maxLogLines = 10000
+
+// LogEntry is a log line, as logify serves it.
+type LogEntry struct {
+	ProjectId string `json:"projectId"`
+	Timestamp int64  `json:"timestamp"`
+	Log       string `json:"log"`
+}
+
+// Logs keeps the build logs of the projects in memory in place of logify. It
+// implements service.ProjectLogService and serves the logs like the logify API.
+type Logs struct {
+	mu    sync.Mutex
+	lines map[string][]LogEntry
+	// Out receives every line as it is pushed, nothing is written when it is nil.
+	Out io.Writer
+}
+
+func NewLogs(out io.Writer) *Logs {
+	return &Logs{lines: make(map[string][]LogEntry), Out: out}
+}
+
+func (l *Logs) PushLogs(ctx context.Context, projectId string, logs service.LogEntry) (bool, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lines := append(l.lines[projectId], LogEntry{ProjectId: projectId, Timestamp: logs.Timestamp, Log: logs.Log})
+	if len(lines) > maxLogLines {
+		lines = lines[len(lines)-maxLogLines:]
+	}
+	l.lines[projectId] = lines
+
+	if l.Out != nil {
+		fmt.Fprintf(l.Out, "%s [%s] %s\n", time.Unix(logs.Timestamp, 0).Format(time.TimeOnly), projectId, logs.Log)
+	}
+	return true, "success"
+}
+
+func (l *Logs) PurgeLogs(ctx context.Context, projectId string) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	purged := int64(len(l.lines[projectId]))
+	delete(l.lines, projectId)
+	return purged, nil
+}
+
+// Latest returns the latest limit lines of a project, newest first.
+func (l *Logs) Latest(projectId string, limit int) []LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lines := l.lines[projectId]
+	var latest []LogEntry
+	for i := len(lines) - 1; i >= 0 && len(latest) < limit; i-- {
+		latest = append(latest, lines[i])
+	}
+	return latest
+}
+
+// serveLogs answers GET /api/v1/{projectId}/logs?limit= the way logify does,
+// so the aether CLI reads the logs of the development server.
+func (l *Logs) serveLogs(w http.ResponseWriter, r *http.Request) {
+	limit := 10
+	if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+
+	// Logify also answers null when there are no logs
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l.Latest(r.PathValue("projectId"), limit))
+}
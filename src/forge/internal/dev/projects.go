@@ -0,0 +1,41 @@
+package dev
+
+import (
+	"context"
+	pb "forge/internal/genprotobuf/project"
+	"log"
+	"sync"
+)
+
+// Projects records the project statuses forge reports, in place of launchpad.
+type Projects struct {
+	mu       sync.Mutex
+	statuses map[string]pb.ProjectStatus
+}
+
+func NewProjects() *Projects {
+	return &Projects{statuses: make(map[string]pb.ProjectStatus)}
+}
+
+func (p *Projects) UpdateProjectStatus(ctx context.Context, projectId string, status pb.ProjectStatus) error {
+	p.mu.Lock()
+	p.statuses[projectId] = status
+	p.mu.Unlock()
+
+	log.Printf("Project %s is %s", projectId, status)
+	return nil
+}
+
+func (p *Projects) ReportDeployment(ctx context.Context, report *pb.ReportDeploymentRequest) error {
+	log.Printf("Deployment %s of project %s finished: %s", report.DeploymentId, report.ProjectId, report.Status)
+	return nil
+}
+
+// Status returns the last status reported for a project, and whether one was.
+func (p *Projects) Status(projectId string) (pb.ProjectStatus, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status, ok := p.statuses[projectId]
+	return status, ok
+}
@@ -0,0 +1,176 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/uuid"
+)
+
+// defaultVisibilityTimeout is how long a received message stays invisible
+// when the receiver does not say, as in SQS.
+const defaultVisibilityTimeout = 30 * time.Second
+
+type queuedMessage struct {
+	id            string
+	body          string
+	attributes    map[string]types.MessageAttributeValue
+	sentAt        time.Time
+	receiveCount  int
+	visibleAt     time.Time
+	receiptHandle string
+}
+
+// Queue is an in-memory stand-in for the SQS queues of forge. It implements
+// worker.SQSAPI, so the worker and worker.BuildQueue run on it unchanged.
+// Messages are lost when the process exits.
+type Queue struct {
+	mu     sync.Mutex
+	queues map[string][]*queuedMessage
+	// changed is closed and replaced whenever a message may have become visible.
+	changed chan struct{}
+}
+
+func NewQueue() *Queue {
+	return &Queue{
+		queues:  make(map[string][]*queuedMessage),
+		changed: make(chan struct{}),
+	}
+}
+
+// notify wakes up the receivers, q.mu must be held.
+func (q *Queue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *Queue) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	message := &queuedMessage{
+		id:         uuid.NewString(),
+		body:       aws.ToString(params.MessageBody),
+		attributes: params.MessageAttributes,
+		sentAt:     now,
+		visibleAt:  now.Add(time.Duration(params.DelaySeconds) * time.Second),
+	}
+	queueURL := aws.ToString(params.QueueUrl)
+	q.queues[queueURL] = append(q.queues[queueURL], message)
+	q.notify()
+	return &sqs.SendMessageOutput{MessageId: aws.String(message.id)}, nil
+}
+
+// ReceiveMessage returns the visible messages of a queue, waiting up to
+// WaitTimeSeconds for one to become visible.
+func (q *Queue) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	deadline := time.Now().Add(time.Duration(params.WaitTimeSeconds) * time.Second)
+	for {
+		messages, wake, changed := q.receive(params)
+		if len(messages) > 0 || !time.Now().Before(deadline) {
+			return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+		}
+
+		if wake.IsZero() || wake.After(deadline) {
+			wake = deadline
+		}
+		timer := time.NewTimer(time.Until(wake))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// receive takes the visible messages of a queue. It also returns when the
+// next invisible message becomes visible, and a channel closed on changes.
+func (q *Queue) receive(params *sqs.ReceiveMessageInput) ([]types.Message, time.Time, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	limit := int(max(params.MaxNumberOfMessages, 1))
+	visibility := defaultVisibilityTimeout
+	if params.VisibilityTimeout > 0 {
+		visibility = time.Duration(params.VisibilityTimeout) * time.Second
+	}
+
+	now := time.Now()
+	var (
+		messages []types.Message
+		wake     time.Time
+	)
+	for _, message := range q.queues[aws.ToString(params.QueueUrl)] {
+		if message.visibleAt.After(now) {
+			if wake.IsZero() || message.visibleAt.Before(wake) {
+				wake = message.visibleAt
+			}
+			continue
+		}
+		if len(messages) == limit {
+			break
+		}
+
+		message.receiveCount++
+		message.visibleAt = now.Add(visibility)
+		message.receiptHandle = uuid.NewString()
+		messages = append(messages, types.Message{
+			MessageId:         aws.String(message.id),
+			ReceiptHandle:     aws.String(message.receiptHandle),
+			Body:              aws.String(message.body),
+			MessageAttributes: message.attributes,
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameSentTimestamp):           strconv.FormatInt(message.sentAt.UnixMilli(), 10),
+				string(types.MessageSystemAttributeNameApproximateReceiveCount): strconv.Itoa(message.receiveCount),
+			},
+		})
+	}
+	return messages, wake, q.changed
+}
+
+func (q *Queue) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queueURL := aws.ToString(params.QueueUrl)
+	messages := q.queues[queueURL]
+	for i, message := range messages {
+		if message.receiptHandle != "" && message.receiptHandle == aws.ToString(params.ReceiptHandle) {
+			q.queues[queueURL] = append(messages[:i:i], messages[i+1:]...)
+			return &sqs.DeleteMessageOutput{}, nil
+		}
+	}
+	// SQS also ignores receipt handles of messages that are gone
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (q *Queue) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, message := range q.queues[aws.ToString(params.QueueUrl)] {
+		if message.receiptHandle != "" && message.receiptHandle == aws.ToString(params.ReceiptHandle) {
+			message.visibleAt = time.Now().Add(time.Duration(params.VisibilityTimeout) * time.Second)
+			q.notify()
+			return &sqs.ChangeMessageVisibilityOutput{}, nil
+		}
+	}
+	return nil, fmt.Errorf("receipt handle %q is not valid", aws.ToString(params.ReceiptHandle))
+}
+
+// Len returns the number of messages in a queue, visible or not.
+func (q *Queue) Len(queueURL string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queues[queueURL])
+}
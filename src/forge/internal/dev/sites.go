@@ -0,0 +1,98 @@
+package dev
+
+import (
+	"bytes"
+	"forge/internal/deployment"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// siteRe matches a project id, optionally preceded by the slug of a branch
+// preview and "--", the same sites the proxy serves.
+var siteRe = regexp.MustCompile("^(?:([a-z0-9]+(?:-[a-z0-9]+)*)--)?([a-f0-9]{8}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{12})$")
+
+// parseSite returns the project id of a site, and the branch preview it
+// serves or an empty string for the live files.
+func parseSite(site string) (projectId, preview string, ok bool) {
+	match := siteRe.FindStringSubmatch(site)
+	if match == nil {
+		return "", "", false
+	}
+	return match[2], match[1], true
+}
+
+// sites serves the live files and branch previews of the projects from the
+// bucket, resolving sites like the proxy: the first host label, such as
+// <project>.localhost, then the first path segment, then the projectID cookie.
+type sites struct {
+	bucket deployment.Bucket
+}
+
+func (s *sites) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	site, filePath := "", r.URL.Path
+	pathSite, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if label, _, _ := strings.Cut(strings.ToLower(r.Host), "."); siteRe.MatchString(label) {
+		site = label
+	} else if siteRe.MatchString(pathSite) {
+		site, filePath = pathSite, "/"+rest
+		http.SetCookie(w, &http.Cookie{
+			Name:     "projectID",
+			Value:    site,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	} else if cookie, err := r.Cookie("projectID"); err == nil && siteRe.MatchString(cookie.Value) {
+		site = cookie.Value
+	} else {
+		http.Error(w, "Project ID not found", http.StatusBadRequest)
+		return
+	}
+
+	projectId, preview, _ := parseSite(site)
+	deploymentId, err := deployment.NewStore(s.bucket).ServedDeployment(r.Context(), projectId, preview)
+	if deployment.IsNotFound(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to resolve site %s: %v", site, err)
+		http.Error(w, "Proxy Error", http.StatusBadGateway)
+		return
+	}
+	prefix := deployment.ArtifactsPrefix(projectId, deploymentId)
+
+	if filePath == "" || strings.HasSuffix(filePath, "/") {
+		filePath += "index.html"
+	}
+	key := strings.TrimPrefix(path.Clean(filePath), "/")
+	data, err := s.bucket.GetObject(r.Context(), prefix+key)
+	if deployment.IsNotFound(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to serve %s of site %s: %v", key, site, err)
+		http.Error(w, "Proxy Error", http.StatusBadGateway)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	w.Header().Set("Content-Type", contentType)
+	// Every deployment replaces the files, they are never cached
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+}
@@ -0,0 +1,379 @@
+package framework
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Framework describes how a project is built and where its static output ends up.
+type Framework struct {
+	// Name identifies the framework, e.g. "nextjs". It is "static" when no
+	// framework was recognised.
+	Name string
+	// BuildCommand is the command used when the project does not set one.
+	BuildCommand string
+	// OutputDirs lists the directories the output may be written to, relative
+	// to the repository root, in the order they are tried.
+	OutputDirs []string
+	// Hint is added to the error when no output is found.
+	Hint string
+}
+
+// ErrOutputMissing is wrapped by the errors returned from CheckOutput.
+var ErrOutputMissing = errors.New("build output missing")
+
+type packageJSON struct {
+	Name            string            `json:"name"`
+	Scripts         map[string]string `json:"scripts"`
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+}
+
+func (p *packageJSON) has(dependency string) bool {
+	_, ok := p.Dependencies[dependency]
+	if !ok {
+		_, ok = p.DevDependencies[dependency]
+	}
+	return ok
+}
+
+// detector recognises a framework from package.json and the files in the repository.
+type detector struct {
+	name       string
+	dependency string
+	detect     func(repoDir string, pkg *packageJSON) (*Framework, error)
+}
+
+// detectors are tried in order, frameworks built on top of others come first.
+var detectors = []detector{
+	{name: "nextjs", dependency: "next", detect: detectNext},
+	{name: "nuxt", dependency: "nuxt", detect: detectNuxt},
+	{name: "gatsby", dependency: "gatsby", detect: fixedOutput("public")},
+	{name: "docusaurus", dependency: "@docusaurus/core", detect: fixedOutput("build")},
+	{name: "astro", dependency: "astro", detect: detectAstro},
+	{name: "sveltekit", dependency: "@sveltejs/kit", detect: detectSvelteKit},
+	{name: "angular", dependency: "@angular/core", detect: detectAngular},
+	{name: "create-react-app", dependency: "react-scripts", detect: fixedOutput("build")},
+	{name: "vue-cli", dependency: "@vue/cli-service", detect: detectVueCLI},
+	{name: "vite", dependency: "vite", detect: detectVite},
+}
+
+// Detect reads package.json and the framework config files in repoDir and
+// returns the framework the project is built with.
+func Detect(repoDir string) (*Framework, error) {
+	data, err := os.ReadFile(filepath.Join(repoDir, "package.json"))
+	if os.IsNotExist(err) {
+		return &Framework{Name: "static", OutputDirs: []string{"."}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package.json: %w", err)
+	}
+
+	var pkg packageJSON
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, fmt.Errorf("failed to parse package.json: %w", err)
+	}
+
+	for _, d := range detectors {
+		if !pkg.has(d.dependency) {
+			continue
+		}
+		fw, err := d.detect(repoDir, &pkg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect %s output: %w", d.name, err)
+		}
+		fw.Name = d.name
+		if fw.OutputDirs, err = cleanOutputDirs(fw.OutputDirs); err != nil {
+			return nil, err
+		}
+		if fw.BuildCommand == "" {
+			fw.BuildCommand = runScript(repoDir, &pkg, "build")
+		}
+		return fw, nil
+	}
+
+	return &Framework{
+		Name:         "static",
+		BuildCommand: runScript(repoDir, &pkg, "build"),
+		OutputDirs:   []string{"build", "dist", "out", "public"},
+	}, nil
+}
+
+// cleanOutputDirs normalises output directories read from config files and
+// rejects those outside of the repository.
+func cleanOutputDirs(dirs []string) ([]string, error) {
+	cleaned := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		dir = path.Clean(strings.TrimPrefix(dir, "./"))
+		if path.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+			return nil, fmt.Errorf("output directory %q is outside of the repository", dir)
+		}
+		cleaned = append(cleaned, dir)
+	}
+	return cleaned, nil
+}
+
+// runScript returns the command running a package.json script with the
+// package manager the lockfile belongs to, or "" when the script is missing.
+func runScript(repoDir string, pkg *packageJSON, script string) string {
+	if _, ok := pkg.Scripts[script]; !ok {
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(repoDir, "yarn.lock")); err == nil {
+		return "yarn " + script
+	}
+	return "npm run " + script
+}
+
+func fixedOutput(dir string) func(string, *packageJSON) (*Framework, error) {
+	return func(string, *packageJSON) (*Framework, error) {
+		return &Framework{OutputDirs: []string{dir}}, nil
+	}
+}
+
+func detectNext(repoDir string, pkg *packageJSON) (*Framework, error) {
+	config, err := readConfig(repoDir, "next.config.js", "next.config.mjs", "next.config.ts")
+	if err != nil {
+		return nil, err
+	}
+
+	fw := &Framework{
+		OutputDirs: []string{"out"},
+		Hint:       "Next.js only produces static files with output: 'export' in next.config.js",
+	}
+	// With a static export the output is written to distDir when one is set
+	if dir := configString(config, "distDir"); dir != "" && configString(config, "output") == "export" {
+		fw.OutputDirs = []string{dir}
+	}
+	return fw, nil
+}
+
+func detectNuxt(repoDir string, pkg *packageJSON) (*Framework, error) {
+	return &Framework{
+		BuildCommand: runScript(repoDir, pkg, "generate"),
+		OutputDirs:   []string{".output/public", "dist"},
+		Hint:         "Nuxt sites must be prerendered with nuxt generate",
+	}, nil
+}
+
+func detectAstro(repoDir string, pkg *packageJSON) (*Framework, error) {
+	config, err := readConfig(repoDir, "astro.config.mjs", "astro.config.js", "astro.config.ts", "astro.config.mts")
+	if err != nil {
+		return nil, err
+	}
+
+	dir := "dist"
+	if outDir := configString(config, "outDir"); outDir != "" {
+		dir = outDir
+	}
+	return &Framework{
+		OutputDirs: []string{dir},
+		Hint:       "Astro server output needs an adapter and cannot be served as static files",
+	}, nil
+}
+
+func detectSvelteKit(repoDir string, pkg *packageJSON) (*Framework, error) {
+	config, err := readConfig(repoDir, "svelte.config.js", "svelte.config.mjs", "svelte.config.ts")
+	if err != nil {
+		return nil, err
+	}
+
+	// adapter-static writes to build/ unless pages is set
+	dir := "build"
+	if pages := configString(config, "pages"); pages != "" {
+		dir = pages
+	}
+	return &Framework{
+		OutputDirs: []string{dir},
+		Hint:       "SvelteKit needs @sveltejs/adapter-static to produce static files",
+	}, nil
+}
+
+func detectVueCLI(repoDir string, pkg *packageJSON) (*Framework, error) {
+	config, err := readConfig(repoDir, "vue.config.js", "vue.config.mjs", "vue.config.ts")
+	if err != nil {
+		return nil, err
+	}
+
+	dir := "dist"
+	if outputDir := configString(config, "outputDir"); outputDir != "" {
+		dir = outputDir
+	}
+	return &Framework{OutputDirs: []string{dir}}, nil
+}
+
+func detectVite(repoDir string, pkg *packageJSON) (*Framework, error) {
+	config, err := readConfig(repoDir, "vite.config.js", "vite.config.mjs", "vite.config.ts", "vite.config.mts")
+	if err != nil {
+		return nil, err
+	}
+
+	dir := "dist"
+	if outDir := configString(config, "outDir"); outDir != "" {
+		dir = outDir
+	}
+	return &Framework{OutputDirs: []string{dir}}, nil
+}
+
+// angularWorkspace is the part of angular.json needed to find the output directory.
+type angularWorkspace struct {
+	DefaultProject string `json:"defaultProject"`
+	Projects       map[string]struct {
+		ProjectType string `json:"projectType"`
+		Architect   struct {
+			Build struct {
+				Builder string `json:"builder"`
+				Options struct {
+					OutputPath json.RawMessage `json:"outputPath"`
+				} `json:"options"`
+			} `json:"build"`
+		} `json:"architect"`
+	} `json:"projects"`
+}
+
+func detectAngular(repoDir string, pkg *packageJSON) (*Framework, error) {
+	data, err := os.ReadFile(filepath.Join(repoDir, "angular.json"))
+	if os.IsNotExist(err) {
+		return &Framework{OutputDirs: []string{path.Join("dist", pkg.Name, "browser"), path.Join("dist", pkg.Name)}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read angular.json: %w", err)
+	}
+
+	var workspace angularWorkspace
+	if err := json.Unmarshal(data, &workspace); err != nil {
+		return nil, fmt.Errorf("failed to parse angular.json: %w", err)
+	}
+
+	name := workspace.DefaultProject
+	if _, ok := workspace.Projects[name]; !ok {
+		name = ""
+		for projectName, project := range workspace.Projects {
+			if project.ProjectType == "application" && (name == "" || projectName < name) {
+				name = projectName
+			}
+		}
+	}
+	if name == "" {
+		return nil, errors.New("angular.json has no application project")
+	}
+	build := workspace.Projects[name].Architect.Build
+
+	// outputPath is either a string or, since Angular 17, an object with a
+	// base directory and a browser sub directory
+	base := path.Join("dist", name)
+	browser := "browser"
+	if len(build.Options.OutputPath) > 0 {
+		var outputPath string
+		var outputPaths struct {
+			Base    string  `json:"base"`
+			Browser *string `json:"browser"`
+		}
+		switch {
+		case json.Unmarshal(build.Options.OutputPath, &outputPath) == nil:
+			base = outputPath
+		case json.Unmarshal(build.Options.OutputPath, &outputPaths) == nil:
+			if outputPaths.Base != "" {
+				base = outputPaths.Base
+			}
+			if outputPaths.Browser != nil {
+				browser = *outputPaths.Browser
+			}
+		default:
+			return nil, errors.New("angular.json has an invalid outputPath")
+		}
+	}
+
+	// The application builder writes the browser bundle to a sub directory,
+	// the older browser builder writes it to outputPath directly
+	if strings.HasSuffix(build.Builder, ":application") {
+		return &Framework{OutputDirs: []string{path.Join(base, browser)}}, nil
+	}
+	return &Framework{OutputDirs: []string{base, path.Join(base, browser)}}, nil
+}
+
+// readConfig returns the content of the first config file that exists, or "".
+func readConfig(repoDir string, names ...string) (string, error) {
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(repoDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
+
+// configString returns the string literal assigned to key in a JavaScript
+// config file. Config files are code, so only literal values are recognised.
+func configString(config, key string) string {
+	re := regexp.MustCompile(`(?:^|[\s,{])["']?` + regexp.QuoteMeta(key) + `["']?\s*:\s*["'` + "`" + `]([^"'` + "`" + `]+)["'` + "`" + `]`)
+	match := re.FindStringSubmatch(config)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// CheckOutput verifies that a build produced a site: outputDir must exist and
+// contain an index.html.
+func CheckOutput(fw *Framework, outputDir string) error {
+	info, err := os.Stat(outputDir)
+	if err != nil || !info.IsDir() {
+		return fw.MissingOutput()
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, "index.html")); err != nil {
+		return fw.missing(fmt.Errorf("%w: no index.html in the build output", ErrOutputMissing))
+	}
+	return nil
+}
+
+// StripRepoFiles removes what a site deployed from the repository root must
+// not serve: node_modules and every dotfile, such as .git and .env, except
+// .well-known.
+func StripRepoFiles(outputDir string) error {
+	if err := os.RemoveAll(filepath.Join(outputDir, "node_modules")); err != nil {
+		return fmt.Errorf("failed to remove node_modules from build output: %w", err)
+	}
+	return filepath.WalkDir(outputDir, func(file string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := entry.Name()
+		if file == outputDir || !strings.HasPrefix(name, ".") {
+			return nil
+		}
+		if name == ".well-known" && filepath.Dir(file) == outputDir {
+			return filepath.SkipDir
+		}
+		if err := os.RemoveAll(file); err != nil {
+			return fmt.Errorf("failed to remove %s from build output: %w", name, err)
+		}
+		if entry.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// MissingOutput returns the error reported when none of the output directories exist.
+func (fw *Framework) MissingOutput() error {
+	return fw.missing(fmt.Errorf("%w: none of %s was created", ErrOutputMissing, strings.Join(fw.OutputDirs, ", ")))
+}
+
+func (fw *Framework) missing(err error) error {
+	if fw.Hint != "" {
+		return fmt.Errorf("%w (%s build, %s)", err, fw.Name, fw.Hint)
+	}
+	return fmt.Errorf("%w (%s build)", err, fw.Name)
+}
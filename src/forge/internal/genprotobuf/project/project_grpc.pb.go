@@ -23,6 +23,7 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ProjectServiceClient interface {
 	UpdateProjectStatus(ctx context.Context, in *UpdateProjectStatusRequest, opts ...grpc.CallOption) (*UpdateProjectStatusResponse, error)
+	ReportDeployment(ctx context.Context, in *ReportDeploymentRequest, opts ...grpc.CallOption) (*ReportDeploymentResponse, error)
 }
 
 type projectServiceClient struct {
@@ -42,11 +43,21 @@ func (c *projectServiceClient) UpdateProjectStatus(ctx context.Context, in *Upda
 	return out, nil
 }
 
+func (c *projectServiceClient) ReportDeployment(ctx context.Context, in *ReportDeploymentRequest, opts ...grpc.CallOption) (*ReportDeploymentResponse, error) {
+	out := new(ReportDeploymentResponse)
+	err := c.cc.Invoke(ctx, "/project.ProjectService/ReportDeployment", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ProjectServiceServer is the server API for ProjectService service.
 // All implementations must embed UnimplementedProjectServiceServer
 // for forward compatibility
 type ProjectServiceServer interface {
 	UpdateProjectStatus(context.Context, *UpdateProjectStatusRequest) (*UpdateProjectStatusResponse, error)
+	ReportDeployment(context.Context, *ReportDeploymentRequest) (*ReportDeploymentResponse, error)
 	mustEmbedUnimplementedProjectServiceServer()
 }
 
@@ -57,6 +68,9 @@ type UnimplementedProjectServiceServer struct {
 func (UnimplementedProjectServiceServer) UpdateProjectStatus(context.Context, *UpdateProjectStatusRequest) (*UpdateProjectStatusResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateProjectStatus not implemented")
 }
+func (UnimplementedProjectServiceServer) ReportDeployment(context.Context, *ReportDeploymentRequest) (*ReportDeploymentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportDeployment not implemented")
+}
 func (UnimplementedProjectServiceServer) mustEmbedUnimplementedProjectServiceServer() {}
 
 // UnsafeProjectServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -88,6 +102,24 @@ func _ProjectService_UpdateProjectStatus_Handler(srv interface{}, ctx context.Co
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ProjectService_ReportDeployment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportDeploymentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProjectServiceServer).ReportDeployment(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/project.ProjectService/ReportDeployment",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProjectServiceServer).ReportDeployment(ctx, req.(*ReportDeploymentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ProjectService_ServiceDesc is the grpc.ServiceDesc for ProjectService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -99,6 +131,10 @@ var ProjectService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateProjectStatus",
 			Handler:    _ProjectService_UpdateProjectStatus_Handler,
 		},
+		{
+			MethodName: "ReportDeployment",
+			Handler:    _ProjectService_ReportDeployment_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "project.proto",
@@ -23,6 +23,7 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ProjectLogServiceClient interface {
 	PushLogs(ctx context.Context, in *PushLogsRequest, opts ...grpc.CallOption) (*PushLogsResponse, error)
+	PurgeProjectLogs(ctx context.Context, in *PurgeProjectLogsRequest, opts ...grpc.CallOption) (*PurgeProjectLogsResponse, error)
 }
 
 type projectLogServiceClient struct {
@@ -42,11 +43,21 @@ func (c *projectLogServiceClient) PushLogs(ctx context.Context, in *PushLogsRequ
 	return out, nil
 }
 
+func (c *projectLogServiceClient) PurgeProjectLogs(ctx context.Context, in *PurgeProjectLogsRequest, opts ...grpc.CallOption) (*PurgeProjectLogsResponse, error) {
+	out := new(PurgeProjectLogsResponse)
+	err := c.cc.Invoke(ctx, "/project_log.ProjectLogService/PurgeProjectLogs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ProjectLogServiceServer is the server API for ProjectLogService service.
 // All implementations must embed UnimplementedProjectLogServiceServer
 // for forward compatibility
 type ProjectLogServiceServer interface {
 	PushLogs(context.Context, *PushLogsRequest) (*PushLogsResponse, error)
+	PurgeProjectLogs(context.Context, *PurgeProjectLogsRequest) (*PurgeProjectLogsResponse, error)
 	mustEmbedUnimplementedProjectLogServiceServer()
 }
 
@@ -57,6 +68,9 @@ type UnimplementedProjectLogServiceServer struct {
 func (UnimplementedProjectLogServiceServer) PushLogs(context.Context, *PushLogsRequest) (*PushLogsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PushLogs not implemented")
 }
+func (UnimplementedProjectLogServiceServer) PurgeProjectLogs(context.Context, *PurgeProjectLogsRequest) (*PurgeProjectLogsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeProjectLogs not implemented")
+}
 func (UnimplementedProjectLogServiceServer) mustEmbedUnimplementedProjectLogServiceServer() {}
 
 // UnsafeProjectLogServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -88,6 +102,24 @@ func _ProjectLogService_PushLogs_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ProjectLogService_PurgeProjectLogs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeProjectLogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProjectLogServiceServer).PurgeProjectLogs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/project_log.ProjectLogService/PurgeProjectLogs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProjectLogServiceServer).PurgeProjectLogs(ctx, req.(*PurgeProjectLogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ProjectLogService_ServiceDesc is the grpc.ServiceDesc for ProjectLogService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -99,6 +131,10 @@ var ProjectLogService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "PushLogs",
 			Handler:    _ProjectLogService_PushLogs_Handler,
 		},
+		{
+			MethodName: "PurgeProjectLogs",
+			Handler:    _ProjectLogService_PurgeProjectLogs_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "project_log.proto",
@@ -1,17 +1,45 @@
 package internal
 
 import (
+	"context"
+	"fmt"
 	"log"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 )
 
-func NewGrpcClient(addr string) *grpc.ClientConn {
-	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func NewGrpcClient(addr string, creds credentials.TransportCredentials) *grpc.ClientConn {
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(creds),
+		// Calls are traced and carry the trace context in their metadata
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
 
 	return conn
 }
+
+// GrpcCheck returns a health check failing while conn cannot reach its server.
+func GrpcCheck(conn *grpc.ClientConn) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		// Idle connections only connect once used
+		conn.Connect()
+		for {
+			state := conn.GetState()
+			switch state {
+			case connectivity.Ready:
+				return nil
+			case connectivity.TransientFailure, connectivity.Shutdown:
+				return fmt.Errorf("connection to %s is %s", conn.Target(), state)
+			}
+			if !conn.WaitForStateChange(ctx, state) {
+				return fmt.Errorf("connection to %s is %s: %w", conn.Target(), state, ctx.Err())
+			}
+		}
+	}
+}
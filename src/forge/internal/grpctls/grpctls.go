@@ -0,0 +1,211 @@
+package grpctls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Transport security modes of gRPC connections.
+const (
+	ModeNone = "none"
+	ModeTLS  = "tls"
+	ModeMTLS = "mtls"
+)
+
+// Config secures the gRPC connections of forge to launchpad and logify.
+type Config struct {
+	Mode string
+	// CAFile holds the certificates servers are verified with.
+	CAFile string
+	// CertFile and KeyFile hold the client certificate presented in mtls mode.
+	CertFile string
+	KeyFile  string
+	// ServerName is the identity expected from servers, the host of the
+	// address they are dialed with by default.
+	ServerName string
+}
+
+// Validate returns an error when the files the mode needs are not set.
+func (c *Config) Validate() error {
+	switch c.Mode {
+	case ModeNone:
+	case ModeTLS:
+		if c.CAFile == "" {
+			return errors.New("GRPC_TLS_CA_FILE is required when GRPC_TLS_MODE is tls")
+		}
+	case ModeMTLS:
+		if c.CAFile == "" || c.CertFile == "" || c.KeyFile == "" {
+			return errors.New("GRPC_TLS_CA_FILE, GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE are required when GRPC_TLS_MODE is mtls")
+		}
+	default:
+		return fmt.Errorf("invalid GRPC_TLS_MODE, it must be %q, %q or %q: %q", ModeNone, ModeTLS, ModeMTLS, c.Mode)
+	}
+	return nil
+}
+
+// ClientCredentials returns the transport credentials of gRPC clients.
+// Certificates are read again once their files changed, so rotated
+// certificates are used without restarting the worker.
+func ClientCredentials(cfg *Config) (credentials.TransportCredentials, error) {
+	if cfg.Mode == ModeNone {
+		return insecure.NewCredentials(), nil
+	}
+
+	roots := &certPool{file: cfg.CAFile}
+	if _, err := roots.get(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+		// The server is verified in VerifyConnection, with the current CA certificates
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			pool, err := roots.get()
+			if err != nil {
+				return err
+			}
+			return verifyServer(state, pool)
+		},
+	}
+
+	if cfg.Mode == ModeMTLS {
+		pair := &keyPair{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+		if _, err := pair.get(); err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return pair.get()
+		}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// verifyServer checks the certificate chain and identity of a server, like
+// the TLS handshake does without InsecureSkipVerify.
+func verifyServer(state tls.ConnectionState, roots *x509.CertPool) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       state.ServerName,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify server certificate: %w", err)
+	}
+	return nil
+}
+
+// keyPair is a certificate and key read from files, and read again once the
+// files changed.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// get returns the current certificate. While the files are being replaced
+// they may be missing or not match, the last certificate that loaded is kept
+// until they do.
+func (k *keyPair) get() (*tls.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	modTime, err := latestModTime(k.certFile, k.keyFile)
+	if err != nil {
+		if k.cert != nil {
+			return k.cert, nil
+		}
+		return nil, err
+	}
+	if k.cert != nil && modTime.Equal(k.modTime) {
+		return k.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		if k.cert != nil {
+			return k.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load certificate %s: %w", k.certFile, err)
+	}
+	k.cert, k.modTime = &cert, modTime
+	return k.cert, nil
+}
+
+// certPool is a set of CA certificates read from a file, and read again once
+// the file changed.
+type certPool struct {
+	file string
+
+	mu      sync.Mutex
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+// get returns the current CA certificates, or the last ones that loaded while
+// the file is being replaced.
+func (c *certPool) get() (*x509.CertPool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTime, err := latestModTime(c.file)
+	if err == nil && c.pool != nil && modTime.Equal(c.modTime) {
+		return c.pool, nil
+	}
+
+	var data []byte
+	if err == nil {
+		data, err = os.ReadFile(c.file)
+	}
+	if err != nil {
+		if c.pool != nil {
+			return c.pool, nil
+		}
+		return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		if c.pool != nil {
+			return c.pool, nil
+		}
+		return nil, fmt.Errorf("no CA certificates found in %s", c.file)
+	}
+	c.pool, c.modTime = pool, modTime
+	return c.pool, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,96 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds every check, probes must answer before kubelet gives up.
+const checkTimeout = 3 * time.Second
+
+// Check returns an error when a dependency of the worker is unusable.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checks are the checks served on /healthz and /readyz.
+type Checks struct {
+	mu        sync.Mutex
+	health    []namedCheck
+	readiness []namedCheck
+}
+
+// Health holds the checks of the worker.
+var Health = &Checks{}
+
+// AddHealth adds a check of a dependency the worker cannot work without. It
+// is run by both endpoints.
+func (c *Checks) AddHealth(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.health = append(c.health, namedCheck{name: name, check: check})
+}
+
+// AddReadiness adds a check of whether the worker takes new messages, it is
+// only run by /readyz.
+func (c *Checks) AddReadiness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness = append(c.readiness, namedCheck{name: name, check: check})
+}
+
+// Healthz serves the health checks.
+func (c *Checks) Healthz(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	checks := append([]namedCheck(nil), c.health...)
+	c.mu.Unlock()
+	serveChecks(w, r, checks)
+}
+
+// Readyz serves the health and readiness checks.
+func (c *Checks) Readyz(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	checks := append(append([]namedCheck(nil), c.health...), c.readiness...)
+	c.mu.Unlock()
+	serveChecks(w, r, checks)
+}
+
+// serveChecks runs checks concurrently and responds 503 with the failed
+// checks, or 200 when all passed.
+func serveChecks(w http.ResponseWriter, r *http.Request, checks []namedCheck) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", checks[i].name, err))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(failed) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(failed, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
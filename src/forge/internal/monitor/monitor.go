@@ -1,8 +1,10 @@
 package monitor
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -17,12 +19,159 @@ var ProcessedMessages = prometheus.NewCounterVec(
 	[]string{"status"},
 )
 
+var BuildFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "forge_build_failures_total",
+		Help: "Total number of failed builds by reason.",
+	},
+	[]string{"reason"},
+)
+
+var DepsCache = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "forge_deps_cache_total",
+		Help: "Total number of dependency cache lookups by result.",
+	},
+	[]string{"result"},
+)
+
+var BuildCache = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "forge_build_cache_total",
+		Help: "Total number of build fingerprint lookups by result.",
+	},
+	[]string{"result"},
+)
+
+var DeadLetters = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "forge_dead_letters_total",
+		Help: "Total number of messages moved to the dead-letter queue by reason.",
+	},
+	[]string{"reason"},
+)
+
+var ProjectDeletions = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "forge_project_deletions_total",
+		Help: "Total number of project deletions by result.",
+	},
+	[]string{"result"},
+)
+
+var DeletedObjects = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "forge_project_deleted_objects_total",
+		Help: "Total number of bucket objects removed with deleted projects.",
+	},
+)
+
+var PurgedLogs = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "forge_project_purged_logs_total",
+		Help: "Total number of log entries purged with deleted projects.",
+	},
+)
+
+// Phases of the build pipeline.
+const (
+	PhaseClone   = "clone"
+	PhaseInstall = "install"
+	PhaseBuild   = "build"
+	PhaseCopy    = "copy"
+	PhaseUpload  = "upload"
+)
+
+// Labels never include project or deployment ids, so series stay bounded.
+
+var QueueWait = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "forge_queue_wait_seconds",
+		Help:    "Time between a message being sent and a worker processing it, by message type.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 13),
+	},
+	[]string{"type"},
+)
+
+var PhaseDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "forge_build_phase_duration_seconds",
+		Help:    "Duration of the build pipeline phases by phase and result.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 14),
+	},
+	[]string{"phase", "result"},
+)
+
+var DeploymentDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "forge_deployment_duration_seconds",
+		Help:    "Duration of deployments from the start of processing to their final status.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 13),
+	},
+	[]string{"status"},
+)
+
+var UploadedFiles = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "forge_uploaded_files_total",
+		Help: "Total number of deployment files uploaded.",
+	},
+)
+
+var UploadedBytes = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "forge_uploaded_bytes_total",
+		Help: "Total number of deployment bytes uploaded.",
+	},
+)
+
+var InflightBuilds = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "forge_inflight_builds",
+		Help: "Number of deployments being built by this worker.",
+	},
+)
+
+// ObservePhase records the duration of a phase that started at started.
+func ObservePhase(phase string, started time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	PhaseDuration.WithLabelValues(phase, result).Observe(time.Since(started).Seconds())
+}
+
 func init() {
-	// Register the custom metric
+	// Register the custom metrics
 	prometheus.MustRegister(ProcessedMessages)
+	prometheus.MustRegister(BuildFailures)
+	prometheus.MustRegister(DepsCache)
+	prometheus.MustRegister(BuildCache)
+	prometheus.MustRegister(DeadLetters)
+	prometheus.MustRegister(ProjectDeletions)
+	prometheus.MustRegister(DeletedObjects)
+	prometheus.MustRegister(PurgedLogs)
+	prometheus.MustRegister(QueueWait)
+	prometheus.MustRegister(PhaseDuration)
+	prometheus.MustRegister(DeploymentDuration)
+	prometheus.MustRegister(UploadedFiles)
+	prometheus.MustRegister(UploadedBytes)
+	prometheus.MustRegister(InflightBuilds)
 }
 
-func StartMetricsServer() {
-	http.Handle("/metrics", promhttp.Handler())
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// StartMetricsServer serves the metrics and health checks on :8080 until the
+// returned server is shut down.
+func StartMetricsServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", Health.Healthz)
+	mux.HandleFunc("/readyz", Health.Readyz)
+
+	server := &http.Server{Addr: ":8080", Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	return server
 }
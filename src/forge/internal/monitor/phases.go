@@ -0,0 +1,55 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PhaseDurations collects how long each build phase of one deployment took.
+type PhaseDurations struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+}
+
+type phaseDurationsKey struct{}
+
+// WithPhaseDurations returns a context whose build phases are added to the
+// returned PhaseDurations.
+func WithPhaseDurations(ctx context.Context) (context.Context, *PhaseDurations) {
+	d := &PhaseDurations{durations: make(map[string]time.Duration)}
+	return context.WithValue(ctx, phaseDurationsKey{}, d), d
+}
+
+// TimePhase starts timing a build phase. The returned function records the
+// phase duration in the metric and in the PhaseDurations of ctx.
+func TimePhase(ctx context.Context, phase string) func(error) {
+	started := time.Now()
+	return func(err error) {
+		ObservePhase(phase, started, err)
+		RecordPhase(ctx, phase, time.Since(started))
+	}
+}
+
+// RecordPhase adds the duration of a phase to the PhaseDurations of ctx, if any.
+// A phase that runs more than once adds up.
+func RecordPhase(ctx context.Context, phase string, duration time.Duration) {
+	d, ok := ctx.Value(phaseDurationsKey{}).(*PhaseDurations)
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.durations[phase] += duration
+}
+
+// All returns the recorded phases and their durations.
+func (d *PhaseDurations) All() map[string]time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	durations := make(map[string]time.Duration, len(d.durations))
+	for phase, duration := range d.durations {
+		durations[phase] = duration
+	}
+	return durations
+}
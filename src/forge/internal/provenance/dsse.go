@@ -0,0 +1,160 @@
+package provenance
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// PayloadType is the DSSE payload type of in-toto statements.
+const PayloadType = "application/vnd.in-toto+json"
+
+// ErrInvalidSignature is returned when no signature of an envelope verifies.
+var ErrInvalidSignature = errors.New("provenance signature does not verify")
+
+// Envelope is a DSSE envelope, the payload and signatures are base64 encoded
+// in JSON.
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     []byte      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// pae is the DSSE pre-authentication encoding of a payload, the bytes that are signed.
+func pae(payloadType string, payload []byte) []byte {
+	return append([]byte(fmt.Sprintf("DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))), payload...)
+}
+
+// Signer signs statements with an ed25519 key.
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+func NewSigner(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key, keyID: KeyID(key.Public().(ed25519.PublicKey))}
+}
+
+// KeyID returns the id of the public key of the signer.
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Sign returns the statement in a signed envelope.
+func (s *Signer) Sign(statement *Statement) (*Envelope, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal provenance statement: %w", err)
+	}
+	return &Envelope{
+		PayloadType: PayloadType,
+		Payload:     payload,
+		Signatures:  []Signature{{KeyID: s.keyID, Sig: ed25519.Sign(s.key, pae(PayloadType, payload))}},
+	}, nil
+}
+
+// Verify returns the statement of an envelope signed with the private key of
+// publicKey, or ErrInvalidSignature.
+func Verify(envelope *Envelope, publicKey ed25519.PublicKey) (*Statement, error) {
+	if envelope.PayloadType != PayloadType {
+		return nil, fmt.Errorf("unexpected payload type %q", envelope.PayloadType)
+	}
+
+	verified := false
+	message := pae(envelope.PayloadType, envelope.Payload)
+	for _, signature := range envelope.Signatures {
+		if ed25519.Verify(publicKey, message, signature.Sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalidSignature
+	}
+
+	var statement Statement
+	if err := json.Unmarshal(envelope.Payload, &statement); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal provenance statement: %w", err)
+	}
+	if statement.Type != StatementType || statement.PredicateType != PredicateType {
+		return nil, fmt.Errorf("unexpected statement %s with predicate %s", statement.Type, statement.PredicateType)
+	}
+	return &statement, nil
+}
+
+// KeyID returns the hex sha256 digest of a public key.
+func KeyID(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS #8 ed25519 private key, such as
+// one written by `openssl genpkey -algorithm ed25519`.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no PEM PRIVATE KEY block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an ed25519 key", key)
+	}
+	return privateKey, nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX ed25519 public key, or takes the
+// public key of a private key.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if block.Type == "PRIVATE KEY" {
+		privateKey, err := ParsePrivateKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return privateKey.Public().(ed25519.PublicKey), nil
+	}
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("unexpected PEM block %s", block.Type)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an ed25519 key", key)
+	}
+	return publicKey, nil
+}
+
+// ReadPublicKey reads the public key file at path.
+func ReadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	publicKey, err := ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+	}
+	return publicKey, nil
+}
@@ -0,0 +1,295 @@
+// Package provenance describes how a deployment was built with in-toto
+// statements carrying SLSA provenance, signed as DSSE envelopes.
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"forge/internal/deployment"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// StatementType is the type of in-toto v1 statements.
+	StatementType = "https://in-toto.io/Statement/v1"
+	// PredicateType is the type of SLSA v1 provenance.
+	PredicateType = "https://slsa.dev/provenance/v1"
+	// BuildType identifies how forge builds deployments, the external
+	// parameters are read according to it.
+	BuildType = "urn:aether:forge:build:v1"
+	// DefaultBuilderID identifies the workers when PROVENANCE_BUILDER_ID is not set.
+	DefaultBuilderID = "urn:aether:forge"
+)
+
+// Config controls the provenance of deployments.
+type Config struct {
+	// KeyFile holds the PEM ed25519 private key statements are signed with,
+	// no statements are emitted when it is empty.
+	KeyFile string
+	// BuilderID identifies the workers in the statements.
+	BuilderID string
+	// Signer signs the statements, it is nil when KeyFile is empty.
+	Signer *Signer
+}
+
+// LoadConfig returns the provenance config of the workers identified by
+// builderID, loading the signing key in keyFile when it is not empty.
+func LoadConfig(keyFile, builderID string) (*Config, error) {
+	cfg := &Config{
+		KeyFile:   keyFile,
+		BuilderID: builderID,
+	}
+	if cfg.BuilderID == "" {
+		cfg.BuilderID = DefaultBuilderID
+	}
+	if cfg.KeyFile == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROVENANCE_SIGNING_KEY: %w", err)
+	}
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROVENANCE_SIGNING_KEY %s: %w", cfg.KeyFile, err)
+	}
+	cfg.Signer = NewSigner(key)
+	return cfg, nil
+}
+
+// Enabled reports whether deployments are attested.
+func (c *Config) Enabled() bool {
+	return c != nil && c.Signer != nil
+}
+
+// Statement is an in-toto statement: its subjects, the output files of a
+// deployment, were produced as its predicate describes.
+type Statement struct {
+	Type          string     `json:"_type"`
+	Subject       []Subject  `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Subject is an output file, named by its path in the deployment.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Provenance is the SLSA provenance predicate.
+type Provenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   ExternalParameters   `json:"externalParameters"`
+	InternalParameters   InternalParameters   `json:"internalParameters"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies"`
+}
+
+// ExternalParameters are the inputs of the build chosen by the project.
+// Environment variables are only named, their values may be secrets.
+type ExternalParameters struct {
+	Repository    string   `json:"repository"`
+	Ref           string   `json:"ref,omitempty"`
+	BuildCommand  string   `json:"buildCommand"`
+	Env           []string `json:"env,omitempty"`
+	PreviewBranch string   `json:"previewBranch,omitempty"`
+}
+
+// InternalParameters are the inputs of the build chosen by forge.
+type InternalParameters struct {
+	ProjectId   string `json:"projectId"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// ArtifactsFrom is the deployment that built the files, when they were
+	// reused from an earlier build of the same inputs.
+	ArtifactsFrom string `json:"artifactsFrom,omitempty"`
+	Framework     string `json:"framework,omitempty"`
+	NodeVersion   string `json:"nodeVersion,omitempty"`
+}
+
+type ResourceDescriptor struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+type RunDetails struct {
+	Builder  Builder       `json:"builder"`
+	Metadata BuildMetadata `json:"metadata"`
+}
+
+type Builder struct {
+	ID string `json:"id"`
+}
+
+type BuildMetadata struct {
+	// InvocationID is the deployment id.
+	InvocationID string    `json:"invocationId"`
+	StartedOn    time.Time `json:"startedOn"`
+	FinishedOn   time.Time `json:"finishedOn"`
+}
+
+// Build is what a statement is made of.
+type Build struct {
+	BuilderID string
+	Record    *deployment.Record
+	// Ref is the branch or tag the commit was resolved from.
+	Ref      string
+	EnvNames []string
+	// Digests are the hex sha256 digests of the output files by path.
+	Digests    map[string]string
+	FinishedOn time.Time
+}
+
+// NewStatement returns the provenance statement of a built deployment.
+func NewStatement(build *Build) *Statement {
+	record := build.Record
+
+	names := make([]string, 0, len(build.Digests))
+	for name := range build.Digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	subjects := make([]Subject, len(names))
+	for i, name := range names {
+		subjects[i] = Subject{Name: name, Digest: map[string]string{"sha256": build.Digests[name]}}
+	}
+
+	envNames := append([]string(nil), build.EnvNames...)
+	sort.Strings(envNames)
+
+	dependencies := []ResourceDescriptor{{
+		URI:    "git+" + record.RepoURL,
+		Digest: map[string]string{"gitCommit": record.CommitSHA},
+	}}
+	if record.BuilderImage != "" {
+		dependencies = append(dependencies, imageDescriptor(record.BuilderImage))
+	}
+
+	artifactsFrom := ""
+	if record.ArtifactsFrom != record.DeploymentId {
+		artifactsFrom = record.ArtifactsFrom
+	}
+
+	return &Statement{
+		Type:          StatementType,
+		Subject:       subjects,
+		PredicateType: PredicateType,
+		Predicate: Provenance{
+			BuildDefinition: BuildDefinition{
+				BuildType: BuildType,
+				ExternalParameters: ExternalParameters{
+					Repository:    record.RepoURL,
+					Ref:           build.Ref,
+					BuildCommand:  record.BuildCommand,
+					Env:           envNames,
+					PreviewBranch: record.PreviewBranch,
+				},
+				InternalParameters: InternalParameters{
+					ProjectId:     record.ProjectId,
+					Fingerprint:   record.Fingerprint,
+					ArtifactsFrom: artifactsFrom,
+					Framework:     record.Framework,
+					NodeVersion:   record.NodeVersion,
+				},
+				ResolvedDependencies: dependencies,
+			},
+			RunDetails: RunDetails{
+				Builder: Builder{ID: build.BuilderID},
+				Metadata: BuildMetadata{
+					InvocationID: record.DeploymentId,
+					StartedOn:    record.CreatedAt,
+					FinishedOn:   build.FinishedOn,
+				},
+			},
+		},
+	}
+}
+
+// imageDescriptor describes the builder image, given as name@sha256:<hex>
+// or as an image id.
+func imageDescriptor(image string) ResourceDescriptor {
+	uri, digest := "pkg:docker/"+image, image
+	if name, nameDigest, ok := strings.Cut(image, "@"); ok {
+		uri, digest = "pkg:docker/"+name, nameDigest
+	}
+	descriptor := ResourceDescriptor{URI: uri, Digest: map[string]string{}}
+	if algorithm, value, ok := strings.Cut(digest, ":"); ok {
+		descriptor.Digest[algorithm] = value
+	}
+	return descriptor
+}
+
+// CheckSubjects returns an error listing the files whose digest differs from
+// the subjects of the statement, and the files missing on either side.
+func (s *Statement) CheckSubjects(digests map[string]string) error {
+	var errs []error
+	seen := make(map[string]bool, len(s.Subject))
+	for _, subject := range s.Subject {
+		seen[subject.Name] = true
+		digest, ok := digests[subject.Name]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s is missing", subject.Name))
+		case digest != subject.Digest["sha256"]:
+			errs = append(errs, fmt.Errorf("%s has digest sha256:%s, the statement has sha256:%s", subject.Name, digest, subject.Digest["sha256"]))
+		}
+	}
+
+	var extra []string
+	for name := range digests {
+		if !seen[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		errs = append(errs, fmt.Errorf("%s is not in the statement", name))
+	}
+	return errors.Join(errs...)
+}
+
+// DirDigests returns the hex sha256 digests of the files in dir by their
+// slash-separated path, like the subjects of a statement.
+func DirDigests(dir string) (map[string]string, error) {
+	digests := make(map[string]string)
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, file); err != nil {
+			return fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+		digests[filepath.ToSlash(relPath)] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
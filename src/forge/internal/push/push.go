@@ -0,0 +1,200 @@
+package push
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Git hosts whose push webhooks are understood.
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+	ProviderGitea  = "gitea"
+)
+
+var (
+	// ErrUnknownProvider is returned for requests that are not push webhooks of a known git host.
+	ErrUnknownProvider = errors.New("not a GitHub, GitLab or Gitea webhook")
+	// ErrNotPush is returned for webhooks of other events, such as pings.
+	ErrNotPush = errors.New("not a push event")
+)
+
+// zeroSHA is the commit a deleted branch points to.
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// Push is a push webhook.
+type Push struct {
+	Provider string
+	// Delivery identifies the webhook request, when the git host sends one.
+	Delivery string
+	// RepoURLs are the URLs the repository is known by, normalized with NormalizeRepoURL.
+	RepoURLs []string
+	// Ref is the pushed ref, such as refs/heads/main.
+	Ref   string
+	After string
+}
+
+// Branch returns the pushed branch, or false when a tag was pushed.
+func (p *Push) Branch() (string, bool) {
+	branch, ok := strings.CutPrefix(p.Ref, "refs/heads/")
+	return branch, ok && branch != ""
+}
+
+// Deleted reports whether the push deleted its ref.
+func (p *Push) Deleted() bool {
+	return p.After == "" || p.After == zeroSHA
+}
+
+// payload holds the fields of GitHub, Gitea and GitLab push events used by forge.
+type payload struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Repository struct {
+		CloneURL   string `json:"clone_url"`
+		HTMLURL    string `json:"html_url"`
+		SSHURL     string `json:"ssh_url"`
+		GitHTTPURL string `json:"git_http_url"`
+		Homepage   string `json:"homepage"`
+	} `json:"repository"`
+	Project struct {
+		GitHTTPURL string `json:"git_http_url"`
+		GitSSHURL  string `json:"git_ssh_url"`
+		WebURL     string `json:"web_url"`
+	} `json:"project"`
+}
+
+// Provider returns the git host that sent a webhook. Gitea also sends GitHub
+// headers, so it is detected first.
+func Provider(header http.Header) (string, error) {
+	switch {
+	case header.Get("X-Gitea-Event") != "":
+		return ProviderGitea, nil
+	case header.Get("X-Gitlab-Event") != "":
+		return ProviderGitLab, nil
+	case header.Get("X-GitHub-Event") != "":
+		return ProviderGitHub, nil
+	}
+	return "", ErrUnknownProvider
+}
+
+// Parse decodes a push webhook. It returns ErrNotPush for other events.
+func Parse(header http.Header, body []byte) (*Push, error) {
+	provider, err := Provider(header)
+	if err != nil {
+		return nil, err
+	}
+
+	push := &Push{Provider: provider}
+	var event string
+	switch provider {
+	case ProviderGitea:
+		event, push.Delivery = header.Get("X-Gitea-Event"), header.Get("X-Gitea-Delivery")
+	case ProviderGitLab:
+		event, push.Delivery = header.Get("X-Gitlab-Event"), header.Get("X-Gitlab-Event-UUID")
+	case ProviderGitHub:
+		event, push.Delivery = header.Get("X-GitHub-Event"), header.Get("X-GitHub-Delivery")
+	}
+	if event != "push" && event != "Push Hook" {
+		return nil, fmt.Errorf("%w: %s", ErrNotPush, event)
+	}
+
+	var p payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid push payload: %w", err)
+	}
+	push.Ref, push.After = p.Ref, p.After
+
+	for _, raw := range []string{
+		p.Repository.CloneURL, p.Repository.HTMLURL, p.Repository.SSHURL, p.Repository.GitHTTPURL, p.Repository.Homepage,
+		p.Project.GitHTTPURL, p.Project.GitSSHURL, p.Project.WebURL,
+	} {
+		if repo := NormalizeRepoURL(raw); repo != "" && !slices.Contains(push.RepoURLs, repo) {
+			push.RepoURLs = append(push.RepoURLs, repo)
+		}
+	}
+	if len(push.RepoURLs) == 0 {
+		return nil, errors.New("push payload has no repository URL")
+	}
+	return push, nil
+}
+
+// Signed reports whether a webhook carries a signature, or the token of GitLab.
+func Signed(provider string, header http.Header) bool {
+	switch provider {
+	case ProviderGitHub:
+		return header.Get("X-Hub-Signature-256") != ""
+	case ProviderGitea:
+		return header.Get("X-Gitea-Signature") != ""
+	case ProviderGitLab:
+		return header.Get("X-Gitlab-Token") != ""
+	}
+	return false
+}
+
+// Digest returns the SHA-256 of a webhook body, in hex.
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify reports whether a webhook was signed with secret. GitHub and Gitea
+// send the HMAC-SHA256 of the body, GitLab sends the secret itself.
+func Verify(provider string, header http.Header, body []byte, secret string) bool {
+	switch provider {
+	case ProviderGitHub:
+		signature, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		return ok && validMAC(signature, body, secret)
+	case ProviderGitea:
+		return validMAC(header.Get("X-Gitea-Signature"), body, secret)
+	case ProviderGitLab:
+		token := header.Get("X-Gitlab-Token")
+		return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+	return false
+}
+
+func validMAC(signature string, body []byte, secret string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil || secret == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(expected, mac.Sum(nil))
+}
+
+// NormalizeRepoURL returns the lowercased host and path of a repository URL
+// without .git, so the clone, web and SSH URLs of a repository are equal. It
+// returns an empty string for URLs it cannot parse.
+func NormalizeRepoURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	// scp-like SSH URLs, such as git@github.com:owner/repo.git
+	if !strings.Contains(raw, "://") {
+		if user, rest, ok := strings.Cut(raw, "@"); ok && !strings.Contains(user, "/") {
+			raw = "ssh://" + strings.Replace(rest, ":", "/", 1)
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	path := strings.Trim(u.Path, "/")
+	path = strings.TrimSuffix(path, ".git")
+	if path == "" {
+		return ""
+	}
+	return strings.ToLower(u.Hostname() + "/" + path)
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	pb "forge/internal/genprotobuf/project"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatusUpdate is a project status update waiting to be acknowledged by launchpad.
+type StatusUpdate struct {
+	ProjectId      string           `json:"projectId"`
+	Status         pb.ProjectStatus `json:"status"`
+	IdempotencyKey string           `json:"idempotencyKey"`
+	Sequence       int64            `json:"sequence"`
+	CreatedAt      time.Time        `json:"createdAt"`
+}
+
+// Outbox keeps unacknowledged status updates on disk, one file per project, so
+// they are replayed after a restart. Only the latest update of a project is
+// kept, an older one must not overwrite it once replayed.
+//
+// Workers on the same node may share the directory, each operation holds a
+// lock on it, and a worker replays the updates the others left.
+type Outbox struct {
+	dir      string
+	lockFile *os.File
+	mu       sync.Mutex
+}
+
+// NewOutbox returns the outbox stored in dir, creating dir when needed.
+func NewOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create status outbox: %w", err)
+	}
+	lockFile, err := os.OpenFile(filepath.Join(dir, ".lock"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create status outbox: %w", err)
+	}
+	return &Outbox{dir: dir, lockFile: lockFile}, nil
+}
+
+// lock locks the outbox against the other goroutines and processes using it.
+func (o *Outbox) lock() (func(), error) {
+	o.mu.Lock()
+	if err := lockFile(o.lockFile); err != nil {
+		o.mu.Unlock()
+		return nil, fmt.Errorf("failed to lock status outbox: %w", err)
+	}
+	return func() {
+		unlockFile(o.lockFile)
+		o.mu.Unlock()
+	}, nil
+}
+
+// Put stores update, replacing the pending update of the same project unless
+// that one is newer.
+func (o *Outbox) Put(update StatusUpdate) error {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("failed to encode status update: %w", err)
+	}
+
+	unlock, err := o.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Another worker sharing the outbox may have stored a newer update
+	if pending, err := o.read(o.path(update.ProjectId)); err == nil && pending != nil && pending.Sequence > update.Sequence {
+		return nil
+	}
+
+	// Written to a temporary file first, a crash never leaves half an update
+	tmp, err := os.CreateTemp(o.dir, ".update-*")
+	if err != nil {
+		return fmt.Errorf("failed to store status update: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to store status update: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to store status update: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to store status update: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), o.path(update.ProjectId)); err != nil {
+		return fmt.Errorf("failed to store status update: %w", err)
+	}
+	return nil
+}
+
+// Get returns the pending update of a project, or nil when there is none.
+func (o *Outbox) Get(projectId string) (*StatusUpdate, error) {
+	unlock, err := o.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return o.read(o.path(projectId))
+}
+
+// Remove deletes the pending update of its project, unless it was replaced
+// by a newer update meanwhile.
+func (o *Outbox) Remove(update StatusUpdate) error {
+	unlock, err := o.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := o.path(update.ProjectId)
+	pending, err := o.read(path)
+	if err != nil || pending == nil || pending.IdempotencyKey != update.IdempotencyKey {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove status update: %w", err)
+	}
+	return nil
+}
+
+// Pending returns every pending update, oldest first.
+func (o *Outbox) Pending() ([]StatusUpdate, error) {
+	unlock, err := o.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status outbox: %w", err)
+	}
+
+	var updates []StatusUpdate
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		update, err := o.read(filepath.Join(o.dir, entry.Name()))
+		if err != nil {
+			// A corrupt update must not block the others
+			log.Printf("Skipping status update: %v", err)
+			continue
+		}
+		if update != nil {
+			updates = append(updates, *update)
+		}
+	}
+
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].CreatedAt.Before(updates[j].CreatedAt)
+	})
+	return updates, nil
+}
+
+func (o *Outbox) read(path string) (*StatusUpdate, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status update: %w", err)
+	}
+
+	var update StatusUpdate
+	if err := json.Unmarshal(data, &update); err != nil {
+		return nil, fmt.Errorf("failed to decode status update %s: %w", path, err)
+	}
+	return &update, nil
+}
+
+func (o *Outbox) path(projectId string) string {
+	// Project ids are UUIDs, Base keeps any other id inside the outbox
+	return filepath.Join(o.dir, filepath.Base(projectId)+".json")
+}
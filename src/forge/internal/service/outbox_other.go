@@ -0,0 +1,15 @@
+//go:build !unix
+
+package service
+
+import "os"
+
+// Workers only run on Unix, elsewhere an outbox is not shared between processes.
+
+func lockFile(*os.File) error {
+	return nil
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
@@ -2,41 +2,270 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	pb "forge/internal/genprotobuf/project"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type ProjectService interface {
-	UpdateProjectStatus(projectId string, status pb.ProjectStatus)
+	// UpdateProjectStatus sends a status to launchpad, retrying while it is
+	// unreachable. An update that still failed stays in the outbox and is
+	// replayed later.
+	UpdateProjectStatus(ctx context.Context, projectId string, status pb.ProjectStatus) error
+	// ReportDeployment sends the record of a finished deployment to launchpad,
+	// retrying while it is unreachable.
+	ReportDeployment(ctx context.Context, report *pb.ReportDeploymentRequest) error
 }
 
+// StatusConfig controls how status updates are delivered to launchpad.
+type StatusConfig struct {
+	OutboxDir   string
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Timeout bounds a single attempt.
+	Timeout time.Duration
+	// ReplayInterval is how often the outbox is replayed.
+	ReplayInterval time.Duration
+}
+
+// DefaultStatusConfig returns the delivery of status updates used when none
+// is configured.
+func DefaultStatusConfig() *StatusConfig {
+	return &StatusConfig{
+		OutboxDir:      "/var/lib/forge/outbox",
+		MaxAttempts:    5,
+		BaseDelay:      200 * time.Millisecond,
+		MaxDelay:       5 * time.Second,
+		Timeout:        5 * time.Second,
+		ReplayInterval: 30 * time.Second,
+	}
+}
+
+// delay returns how long to wait after the attempt-th attempt failed.
+func (c *StatusConfig) delay(attempt int) time.Duration {
+	delay := c.BaseDelay
+	for i := 1; i < attempt && delay < c.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, c.MaxDelay)
+}
+
+// errRejected is returned when launchpad refused a request, sending it again
+// would not help.
+var errRejected = errors.New("rejected by launchpad")
+
 type project struct {
-	grpc *grpc.ClientConn
+	grpc   *grpc.ClientConn
+	outbox *Outbox
+	config *StatusConfig
+
+	// Updates of a project are sent one at a time, so a replay never
+	// overtakes a newer update.
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+	// sequence is the sequence of the last update made.
+	sequence int64
 	pb.UnimplementedProjectServiceServer
 }
 
-func NewProjectServiceClient(grpcConn *grpc.ClientConn) *project {
+func NewProjectServiceClient(grpcConn *grpc.ClientConn, outbox *Outbox, config *StatusConfig) *project {
 	return &project{
-		grpc: grpcConn,
+		grpc:   grpcConn,
+		outbox: outbox,
+		config: config,
+		locks:  make(map[string]*sync.Mutex),
+	}
+}
+
+func (p *project) UpdateProjectStatus(ctx context.Context, projectId string, status pb.ProjectStatus) error {
+	now := time.Now()
+	update := StatusUpdate{
+		ProjectId:      projectId,
+		Status:         status,
+		IdempotencyKey: uuid.NewString(),
+		Sequence:       p.nextSequence(now),
+		CreatedAt:      now,
+	}
+
+	unlock := p.lock(projectId)
+	defer unlock()
+
+	if err := p.outbox.Put(update); err != nil {
+		// The update is still sent, it is only lost if this worker stops first
+		log.Printf("Failed to store status update of project %s: %v", projectId, err)
+	}
+
+	return p.deliver(ctx, update)
+}
+
+// nextSequence returns the sequence of an update made at now: its time in
+// microseconds, and always more than the previous update of this worker.
+// Launchpad applies the updates of a project in this order, whatever order
+// they arrive in.
+func (p *project) nextSequence(now time.Time) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sequence = max(now.UnixMicro(), p.sequence+1)
+	return p.sequence
+}
+
+// RunOutbox replays the outbox right away, then every ReplayInterval until ctx is done.
+func (p *project) RunOutbox(ctx context.Context) {
+	ticker := time.NewTicker(p.config.ReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		p.ReplayOutbox(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReplayOutbox sends the pending status updates again, oldest first.
+func (p *project) ReplayOutbox(ctx context.Context) {
+	updates, err := p.outbox.Pending()
+	if err != nil {
+		log.Printf("Failed to read status outbox: %v", err)
+		return
+	}
+
+	for _, update := range updates {
+		if ctx.Err() != nil {
+			return
+		}
+		p.replay(ctx, update.ProjectId)
+	}
+}
+
+func (p *project) replay(ctx context.Context, projectId string) {
+	unlock := p.lock(projectId)
+	defer unlock()
+
+	// The update may have been delivered or replaced since it was listed
+	update, err := p.outbox.Get(projectId)
+	if err != nil || update == nil {
+		return
+	}
+
+	if err := p.deliver(ctx, *update); err == nil {
+		log.Printf("Replayed %s status of project %s", update.Status, projectId)
 	}
 }
 
-func (p *project) UpdateProjectStatus(projectId string, status pb.ProjectStatus) {
+// deliver sends update with retries, and removes it from the outbox once
+// launchpad acknowledged or rejected it.
+func (p *project) deliver(ctx context.Context, update StatusUpdate) error {
+	err := p.send(ctx, update)
+	if err == nil || errors.Is(err, errRejected) {
+		if err := p.outbox.Remove(update); err != nil {
+			log.Printf("Failed to remove status update of project %s: %v", update.ProjectId, err)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not update status of project %s to %s: %w", update.ProjectId, update.Status, err)
+	}
+	return nil
+}
+
+func (p *project) send(ctx context.Context, update StatusUpdate) error {
 	c := pb.NewProjectServiceClient(p.grpc)
+	req := &pb.UpdateProjectStatusRequest{
+		ProjectId:      update.ProjectId,
+		Status:         update.Status,
+		IdempotencyKey: update.IdempotencyKey,
+		Sequence:       update.Sequence,
+	}
+	if req.Sequence == 0 {
+		// Stored by a worker that did not order updates yet
+		req.Sequence = update.CreatedAt.UnixMicro()
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
+	return p.retry(ctx, "Status update of project "+update.ProjectId, func(ctx context.Context) error {
+		r, err := c.UpdateProjectStatus(ctx, req)
+		if err != nil {
+			return err
+		}
+		if !r.GetSuccess() {
+			return fmt.Errorf("%w: %s", errRejected, r.GetMessage())
+		}
+		log.Printf("Response: %s", r.GetMessage())
+		return nil
+	})
+}
+
+func (p *project) ReportDeployment(ctx context.Context, report *pb.ReportDeploymentRequest) error {
+	c := pb.NewProjectServiceClient(p.grpc)
 
-	r, err := c.UpdateProjectStatus(ctx, &pb.UpdateProjectStatusRequest{
-		ProjectId: projectId,
-		Status:    status,
+	err := p.retry(ctx, "Report of deployment "+report.DeploymentId, func(ctx context.Context) error {
+		r, err := c.ReportDeployment(ctx, report)
+		if err != nil {
+			return err
+		}
+		if !r.GetSuccess() {
+			return fmt.Errorf("%w: %s", errRejected, r.GetMessage())
+		}
+		return nil
 	})
 	if err != nil {
-		log.Fatalf("could not update project status: %v", err)
+		return fmt.Errorf("could not report deployment %s: %w", report.DeploymentId, err)
+	}
+	return nil
+}
+
+// retry calls call until it succeeds, fails with an error that is not
+// retryable, MaxAttempts were made or ctx is done. Every attempt has its own
+// timeout.
+func (p *project) retry(ctx context.Context, what string, call func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		// Attempts are not cancelled with ctx, launchpad is told even when the
+		// deployment was stopped. Only the wait for the next attempt is.
+		attemptCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), p.config.Timeout)
+		err := call(attemptCtx)
+		cancel()
+		if err == nil || !retryable(err) || attempt >= p.config.MaxAttempts {
+			return err
+		}
+
+		delay := p.config.delay(attempt)
+		log.Printf("%s failed, retrying in %s: %v", what, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (p *project) lock(projectId string) func() {
+	p.mu.Lock()
+	l, ok := p.locks[projectId]
+	if !ok {
+		l = &sync.Mutex{}
+		p.locks[projectId] = l
 	}
+	p.mu.Unlock()
 
-	log.Printf("Response: %s", r.GetMessage())
+	l.Lock()
+	return l.Unlock
+}
+
+// retryable reports whether a failed call may succeed when sent again.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
 }
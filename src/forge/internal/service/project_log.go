@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 	pb "forge/internal/genprotobuf/project_log"
 	"log"
 	"time"
@@ -10,7 +11,9 @@ import (
 )
 
 type ProjectLogService interface {
-	PushLogs(projectId string, logs LogEntry) (bool, string)
+	PushLogs(ctx context.Context, projectId string, logs LogEntry) (bool, string)
+	// PurgeLogs deletes every log of a project and returns how many were deleted.
+	PurgeLogs(ctx context.Context, projectId string) (int64, error)
 }
 
 type LogEntry struct {
@@ -29,10 +32,11 @@ func NewProjectLogServiceClient(grpcConn *grpc.ClientConn) *projectLog {
 	}
 }
 
-func (p *projectLog) PushLogs(projectId string, logEntry LogEntry) (bool, string) {
+func (p *projectLog) PushLogs(ctx context.Context, projectId string, logEntry LogEntry) (bool, string) {
 	c := pb.NewProjectLogServiceClient(p.grpc)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	// Logs of a stopped deployment are still pushed
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
 	defer cancel()
 
 	req := &pb.PushLogsRequest{
@@ -51,3 +55,17 @@ func (p *projectLog) PushLogs(projectId string, logEntry LogEntry) (bool, string
 
 	return r.GetSuccess(), r.GetMessage()
 }
+
+func (p *projectLog) PurgeLogs(ctx context.Context, projectId string) (int64, error) {
+	c := pb.NewProjectLogServiceClient(p.grpc)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	r, err := c.PurgeProjectLogs(ctx, &pb.PurgeProjectLogsRequest{ProjectId: projectId})
+	if err != nil {
+		return 0, fmt.Errorf("could not purge logs: %w", err)
+	}
+
+	return r.GetDeleted(), nil
+}
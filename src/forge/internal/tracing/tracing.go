@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "forge"
+
+// Exporters selected with OTEL_TRACES_EXPORTER.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Setup installs the tracer provider of the exporter named by
+// OTEL_TRACES_EXPORTER. The OTLP exporter sends to endpoint, or to the default
+// collector address when it is empty. The returned function flushes and stops
+// the provider.
+func Setup(ctx context.Context, serviceName, name, endpoint string) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch name {
+	case "", ExporterNone:
+		// Trace context is still propagated, so services further down keep the trace
+		otel.SetTextMapPropagator(propagator())
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		var opts []otlptracegrpc.Option
+		if endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(endpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("invalid OTEL_TRACES_EXPORTER, it must be %q, %q or %q: %q", ExporterNone, ExporterStdout, ExporterOTLP, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", name, err)
+	}
+
+	return Install(exporter, serviceName), nil
+}
+
+// Install sends the spans of the service to exporter. Tests use it with an
+// in-memory exporter.
+func Install(exporter sdktrace.SpanExporter, serviceName string) func(context.Context) error {
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator())
+	return provider.Shutdown
+}
+
+func propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// Start starts a span of forge.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// End records err on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartPhase starts the span of a build phase. The returned function ends the span.
+func StartPhase(ctx context.Context, phase string) (context.Context, func(error)) {
+	ctx, span := Start(ctx, "build."+phase, trace.WithAttributes(attribute.String("forge.phase", phase)))
+	return ctx, func(err error) {
+		End(span, err)
+	}
+}
+
+// MessageCarrier carries trace context in SQS message attributes.
+type MessageCarrier map[string]types.MessageAttributeValue
+
+func (c MessageCarrier) Get(key string) string {
+	return aws.ToString(c[key].StringValue)
+}
+
+func (c MessageCarrier) Set(key, value string) {
+	c[key] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+}
+
+func (c MessageCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Inject adds the trace context of ctx to the attributes of a message.
+func Inject(ctx context.Context, attributes map[string]types.MessageAttributeValue) {
+	otel.GetTextMapPropagator().Inject(ctx, MessageCarrier(attributes))
+}
+
+// Extract returns ctx with the trace context sent along with a message.
+func Extract(ctx context.Context, message types.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, MessageCarrier(message.MessageAttributes))
+}
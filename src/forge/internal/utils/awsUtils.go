@@ -3,7 +3,6 @@ package utils
 import (
 	"context"
 	"fmt"
-	"os"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -12,24 +11,26 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
 
-// getConfig retrieves AWS credentials and region from environment variables and returns a session.
-func getConfig() (*aws.Config, error) {
-	accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
-	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-	sessionToken := os.Getenv("AWS_SESSION_TOKEN")
+// AWSConfig holds the region and credentials the AWS clients use.
+type AWSConfig struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
 
+// getConfig returns the SDK config of the region and credentials in awsCfg.
+func getConfig(awsCfg *AWSConfig) (*aws.Config, error) {
 	creds := credentials.NewStaticCredentialsProvider(
-		accessKeyID,
-		secretAccessKey,
-		sessionToken,
+		awsCfg.AccessKeyID,
+		awsCfg.SecretAccessKey,
+		awsCfg.SessionToken,
 	)
 
-	region := os.Getenv("AWS_REGION")
-
 	// Load the Shared AWS Config
 	cfg, err := config.LoadDefaultConfig(context.Background(),
 		config.WithCredentialsProvider(creds),
-		config.WithRegion(region),
+		config.WithRegion(awsCfg.Region),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load SDK config, %w", err)
@@ -39,8 +40,8 @@ func getConfig() (*aws.Config, error) {
 }
 
 // GetSQSService create and returns SQS client
-func GetSQSService() (*sqs.Client, error) {
-	cfg, err := getConfig()
+func GetSQSService(awsCfg *AWSConfig) (*sqs.Client, error) {
+	cfg, err := getConfig(awsCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -52,8 +53,8 @@ func GetSQSService() (*sqs.Client, error) {
 }
 
 // GetS3Service creates and returns an S3 client.
-func GetS3Service() (*s3.Client, error) {
-	cfg, err := getConfig()
+func GetS3Service(awsCfg *AWSConfig) (*s3.Client, error) {
+	cfg, err := getConfig(awsCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,544 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
+	"github.com/google/uuid"
+)
+
+// Files copied into the dependency install container. Only these files decide
+// what gets installed, so they are also what the cache key is computed from.
+var depsManifestFiles = []string{
+	"package.json",
+	"package-lock.json",
+	"npm-shrinkwrap.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	".npmrc",
+	".yarnrc",
+	".yarnrc.yml",
+}
+
+// Directories copied into the dependency install container with the manifests:
+// patches applied by patch-package and yarn, and the yarn release and plugins
+// .yarnrc.yml points to.
+var depsManifestDirs = []string{
+	"patches",
+	".yarn/patches",
+	".yarn/releases",
+	".yarn/plugins",
+}
+
+// Files marking a repository whose dependencies are installed for several packages.
+var workspaceFiles = []string{
+	"pnpm-workspace.yaml",
+	"lerna.json",
+	"rush.json",
+}
+
+// Scripts npm and yarn run while installing the dependencies of the project itself.
+var installScripts = []string{"preinstall", "install", "postinstall", "preprepare", "prepare", "postprepare"}
+
+// Dependency specs that point into the repository instead of a registry.
+var localDepPrefixes = []string{"file:", "link:", "portal:", "workspace:"}
+
+const (
+	depsImageLabel   = "aether.deps"
+	depsProjectLabel = "aether.project"
+	depsNodeLabel    = "aether.node"
+
+	builderImageLabel = "aether.builder"
+)
+
+// Results of a dependency cache lookup.
+const (
+	DepsCacheHit  = "hit"
+	DepsCacheMiss = "miss"
+	// DepsCacheSkip means the dependencies depend on more of the repository
+	// than its manifests, they are installed from the whole repository and
+	// not cached.
+	DepsCacheSkip = "skip"
+)
+
+// depsLastUsed tracks when cached dependency images were last used by this
+// worker, images missing from it fall back to their creation time.
+var depsLastUsed = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// depsFile is a file of the repository that decides what gets installed.
+type depsFile struct {
+	name    string
+	content []byte
+}
+
+// depsInputs returns the dependency manifests of the cloned repository and the
+// files of the manifest directories, in a stable order. Links are skipped, they
+// could point outside of the repository.
+func depsInputs(repoDir string) ([]depsFile, error) {
+	var files []depsFile
+	addFile := func(name string) error {
+		info, err := os.Lstat(filepath.Join(repoDir, name))
+		if os.IsNotExist(err) || (err == nil && !info.Mode().IsRegular()) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", name, err)
+		}
+
+		content, err := os.ReadFile(filepath.Join(repoDir, name))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		files = append(files, depsFile{name: filepath.ToSlash(name), content: content})
+		return nil
+	}
+
+	for _, name := range depsManifestFiles {
+		if err := addFile(name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, dir := range depsManifestDirs {
+		info, err := os.Lstat(filepath.Join(repoDir, dir))
+		if os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", dir, err)
+		}
+
+		err = filepath.WalkDir(filepath.Join(repoDir, dir), func(path string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			name, err := filepath.Rel(repoDir, path)
+			if err != nil {
+				return err
+			}
+			return addFile(name)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+		}
+	}
+
+	return files, nil
+}
+
+// uncacheableDeps returns why the dependencies of the cloned repository cannot
+// be installed from its manifests alone, or an empty string when they can.
+// Workspaces, dependencies on local paths and install scripts of the project
+// read other files of the repository.
+func uncacheableDeps(repoDir string) (string, error) {
+	for _, name := range workspaceFiles {
+		if _, err := os.Lstat(filepath.Join(repoDir, name)); err == nil {
+			return fmt.Sprintf("the repository has a %s", name), nil
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(repoDir, "package.json"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read package.json: %w", err)
+	}
+
+	var manifest struct {
+		Workspaces           json.RawMessage   `json:"workspaces"`
+		Scripts              map[string]string `json:"scripts"`
+		Dependencies         map[string]string `json:"dependencies"`
+		DevDependencies      map[string]string `json:"devDependencies"`
+		OptionalDependencies map[string]string `json:"optionalDependencies"`
+	}
+	// An invalid package.json fails the install, which reports it
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", nil
+	}
+
+	if len(manifest.Workspaces) > 0 && string(manifest.Workspaces) != "null" {
+		return "package.json declares workspaces", nil
+	}
+
+	for _, script := range installScripts {
+		command := strings.TrimSpace(manifest.Scripts[script])
+		// patch-package only reads the patches, which are part of the cache key
+		if command == "" || command == "patch-package" || command == "npx patch-package" {
+			continue
+		}
+		return fmt.Sprintf("package.json has a %s script", script), nil
+	}
+
+	for _, deps := range []map[string]string{manifest.Dependencies, manifest.DevDependencies, manifest.OptionalDependencies} {
+		for name, spec := range deps {
+			for _, prefix := range localDepPrefixes {
+				if strings.HasPrefix(spec, prefix) {
+					return fmt.Sprintf("dependency %s is installed from %s", name, spec), nil
+				}
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// depsCacheKey hashes the install recipe, the Node version and the dependency
+// inputs of the cloned repository.
+func depsCacheKey(inputs []depsFile, nodeVersion string, recipe []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "node:%s\n", nodeVersion)
+	fmt.Fprintf(h, "recipe:%x\n", sha256.Sum256(recipe))
+
+	for _, file := range inputs {
+		fmt.Fprintf(h, "%s:%x\n", file.name, sha256.Sum256(file.content))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// depsImageRepository returns the image repository the dependencies of a
+// project are stored in. The project id is hashed, it is not validated to be
+// a valid image name.
+func depsImageRepository(projectId string) string {
+	sum := sha256.Sum256([]byte(projectId))
+	return "aether-deps-" + hex.EncodeToString(sum[:])[:24]
+}
+
+// depsImageTag returns the image tag a project's dependencies are cached under.
+func depsImageTag(projectId, key string) string {
+	return fmt.Sprintf("%s:%s", depsImageRepository(projectId), key[:32])
+}
+
+// builderImageTag returns the tag of the builder image built from a recipe.
+func builderImageTag(nodeVersion string, recipe []byte) string {
+	sum := sha256.Sum256(recipe)
+	return fmt.Sprintf("aether-builder:%s-%s", nodeVersion, hex.EncodeToString(sum[:])[:12])
+}
+
+// installScript installs the dependencies of the manifests in /app/repo. It
+// runs as the build user in a sandbox container with network access.
+const installScript = `set -e
+if [ -f yarn.lock ]; then
+  yarn install --frozen-lockfile
+elif [ -f package-lock.json ]; then
+  npm ci
+else
+  npm install
+fi
+if grep -q '"react-scripts"' package.json; then
+  npm install react-scripts
+fi
+rm -rf "$HOME/.npm" "$HOME/.cache"
+`
+
+// installRecipe returns everything that decides how dependencies are
+// installed: the Dockerfile of the builder image and the install script.
+func installRecipe(dockerfile []byte) []byte {
+	return append(dockerfile[:len(dockerfile):len(dockerfile)], installScript...)
+}
+
+// ensureDepsImage returns the cached dependency image for the repository, installing
+// the dependencies when the lockfile or Node version changed. It also returns
+// the result of the cache lookup.
+func ensureDepsImage(ctx context.Context, cli *client.Client, dockerfilePath, repoDir, projectId string, sandbox *SandboxConfig, pushLogs func(string)) (string, string, error) {
+	recipe, err := os.ReadFile(dockerfilePath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read Dockerfile: %w", err)
+	}
+
+	reason, err := uncacheableDeps(repoDir)
+	if err != nil {
+		return "", "", err
+	}
+	if reason != "" {
+		pushLogs(fmt.Sprintf("Dependencies are not cached because %s, installing them from the repository", reason))
+		tag := fmt.Sprintf("%s:uncached-%s", depsImageRepository(projectId), uuid.NewString())
+		err := installDeps(ctx, cli, recipe, tag, map[string]string{depsProjectLabel: projectId}, sandbox, pushLogs, func(ctx context.Context, containerID string) error {
+			return copySourceToContainer(ctx, cli, containerID, repoDir)
+		})
+		if err != nil {
+			return "", "", err
+		}
+		return tag, DepsCacheSkip, nil
+	}
+
+	inputs, err := depsInputs(repoDir)
+	if err != nil {
+		return "", "", err
+	}
+	tag := depsImageTag(projectId, depsCacheKey(inputs, sandbox.NodeVersion, installRecipe(recipe)))
+
+	if _, _, err := cli.ImageInspectWithRaw(ctx, tag); err == nil {
+		markDepsUsed(tag)
+		pushLogs(fmt.Sprintf("Dependency cache hit (%s)", tag))
+		return tag, DepsCacheHit, nil
+	} else if !client.IsErrNotFound(err) {
+		return "", "", fmt.Errorf("failed to inspect dependency image: %w", err)
+	}
+
+	pushLogs(fmt.Sprintf("Dependency cache miss, installing dependencies (%s)", tag))
+
+	manifests, err := depsManifests(inputs)
+	if err != nil {
+		return "", "", err
+	}
+
+	labels := map[string]string{
+		depsImageLabel:   "true",
+		depsProjectLabel: projectId,
+		depsNodeLabel:    sandbox.NodeVersion,
+	}
+	err = installDeps(ctx, cli, recipe, tag, labels, sandbox, pushLogs, func(ctx context.Context, containerID string) error {
+		if err := cli.CopyToContainer(ctx, containerID, "/app/repo", manifests, container.CopyToContainerOptions{CopyUIDGID: true}); err != nil {
+			return fmt.Errorf("failed to copy dependency manifests to the container: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	markDepsUsed(tag)
+	return tag, DepsCacheMiss, nil
+}
+
+// installDeps runs the install script in a sandbox container created from the
+// builder image, after prepare put the files to install from in /app/repo, and
+// commits the container as tag.
+func installDeps(ctx context.Context, cli *client.Client, recipe []byte, tag string, labels map[string]string, sandbox *SandboxConfig, pushLogs func(string), prepare func(ctx context.Context, containerID string) error) error {
+	builder, err := ensureBuilderImage(ctx, cli, recipe, sandbox, pushLogs)
+	if err != nil {
+		return err
+	}
+
+	return runInSandbox(ctx, cli, sandboxRun{
+		Name:    "dependency install",
+		Image:   builder,
+		Cmd:     []string{"sh", "-c", installScript},
+		Network: true,
+		Prepare: prepare,
+		Done: func(ctx context.Context, containerID string) error {
+			_, err := cli.ContainerCommit(ctx, containerID, container.CommitOptions{
+				Reference: tag,
+				Config:    &container.Config{Labels: labels},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to commit dependency image: %w", err)
+			}
+			return nil
+		},
+		Failed: ReasonInstallFailed,
+	}, sandbox, pushLogs)
+}
+
+// removeUncachedDeps removes a dependency image that was installed for a
+// single build.
+func removeUncachedDeps(ctx context.Context, cli *client.Client, tag string) error {
+	if _, err := cli.ImageRemove(ctx, tag, image.RemoveOptions{Force: true, PruneChildren: true}); err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to remove dependency image %s: %w", tag, err)
+	}
+	return nil
+}
+
+// ensureBuilderImage returns the builder image of the recipe, building it when
+// it does not exist yet. It holds Node.js and the global build tools and never
+// runs code of a project while it is built.
+func ensureBuilderImage(ctx context.Context, cli *client.Client, recipe []byte, sandbox *SandboxConfig, pushLogs func(string)) (string, error) {
+	tag := builderImageTag(sandbox.NodeVersion, recipe)
+
+	if _, _, err := cli.ImageInspectWithRaw(ctx, tag); err == nil {
+		return tag, nil
+	} else if !client.IsErrNotFound(err) {
+		return "", fmt.Errorf("failed to inspect builder image: %w", err)
+	}
+
+	pushLogs(fmt.Sprintf("Building the builder image (%s)", tag))
+
+	buildContext, err := builderBuildContext(recipe)
+	if err != nil {
+		return "", err
+	}
+
+	buildResponse, err := buildImage(ctx, cli, buildContext, tag, map[string]string{builderImageLabel: "true"}, sandbox)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", &BuildError{Reason: stoppedReason(ctx), Err: err}
+		}
+		return "", fmt.Errorf("failed during image build: %w", err)
+	}
+	defer buildResponse.Close()
+
+	if err := readImageBuildOutput(ctx, buildResponse, pushLogs); err != nil {
+		return "", err
+	}
+
+	if _, _, err := cli.ImageInspectWithRaw(ctx, tag); err != nil {
+		return "", fmt.Errorf("failed to inspect builder image: %w", err)
+	}
+	return tag, nil
+}
+
+// builderBuildContext creates the build context of the builder image, it only
+// holds the Dockerfile.
+func builderBuildContext(dockerfile []byte) (io.Reader, error) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	if err := tw.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0644, Size: int64(len(dockerfile))}); err != nil {
+		return nil, fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if _, err := tw.Write(dockerfile); err != nil {
+		return nil, fmt.Errorf("failed to write Dockerfile to tar archive: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close tar archive: %w", err)
+	}
+
+	return buf, nil
+}
+
+// depsManifests archives the dependency inputs of the repository, owned by the
+// build user, to be copied into the install container.
+func depsManifests(inputs []depsFile) (io.Reader, error) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	dirs := make(map[string]bool)
+	for _, file := range inputs {
+		// Parent directories are created owned by the build user, who installs into them
+		for dir := path.Dir(file.name); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	sortedDirs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sortedDirs = append(sortedDirs, dir)
+	}
+	sort.Strings(sortedDirs)
+
+	for _, dir := range sortedDirs {
+		hdr := &tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755, Uid: buildUID, Gid: buildUID}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("failed to write tar header: %w", err)
+		}
+	}
+
+	for _, file := range inputs {
+		hdr := &tar.Header{
+			Name: file.name,
+			Mode: 0644,
+			Size: int64(len(file.content)),
+			Uid:  buildUID,
+			Gid:  buildUID,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("failed to write tar header: %w", err)
+		}
+		if _, err := tw.Write(file.content); err != nil {
+			return nil, fmt.Errorf("failed to write %s to tar archive: %w", file.name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close tar archive: %w", err)
+	}
+
+	return buf, nil
+}
+
+func markDepsUsed(tag string) {
+	depsLastUsed.Lock()
+	defer depsLastUsed.Unlock()
+	depsLastUsed.at[tag] = time.Now()
+}
+
+// EvictDepsCache removes the least recently used dependency images until the
+// cache fits in maxSize bytes. Layers shared with other images, such as the
+// Node.js base image, do not count towards the cache size.
+func EvictDepsCache(ctx context.Context, cli *client.Client, maxSize int64) error {
+	images, err := cli.ImageList(ctx, image.ListOptions{
+		Filters:    filters.NewArgs(filters.Arg("label", depsImageLabel+"=true")),
+		SharedSize: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list dependency images: %w", err)
+	}
+
+	type cachedImage struct {
+		id       string
+		tags     []string
+		size     int64
+		lastUsed time.Time
+	}
+
+	var total int64
+	cached := make([]cachedImage, 0, len(images))
+	depsLastUsed.Lock()
+	for _, img := range images {
+		size := img.Size
+		if img.SharedSize > 0 {
+			size -= img.SharedSize
+		}
+		lastUsed := time.Unix(img.Created, 0)
+		for _, tag := range img.RepoTags {
+			if at, ok := depsLastUsed.at[tag]; ok && at.After(lastUsed) {
+				lastUsed = at
+			}
+		}
+		cached = append(cached, cachedImage{id: img.ID, tags: img.RepoTags, size: size, lastUsed: lastUsed})
+		total += size
+	}
+	depsLastUsed.Unlock()
+
+	if total <= maxSize {
+		return nil
+	}
+
+	sort.Slice(cached, func(i, j int) bool {
+		return cached[i].lastUsed.Before(cached[j].lastUsed)
+	})
+
+	for _, img := range cached {
+		if total <= maxSize {
+			break
+		}
+		if _, err := cli.ImageRemove(ctx, img.id, image.RemoveOptions{Force: true, PruneChildren: true}); err != nil {
+			log.Printf("Failed to evict dependency image %s: %v", img.id, err)
+			continue
+		}
+
+		depsLastUsed.Lock()
+		for _, tag := range img.tags {
+			delete(depsLastUsed.at, tag)
+		}
+		depsLastUsed.Unlock()
+
+		total -= img.size
+		log.Printf("Evicted dependency image %v (%s)", img.tags, units.HumanSize(float64(img.size)))
+	}
+
+	return nil
+}
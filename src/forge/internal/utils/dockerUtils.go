@@ -5,87 +5,446 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"forge/internal/framework"
+	"forge/internal/monitor"
+	"forge/internal/tracing"
 	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/google/uuid"
 )
 
-// buildImage builds a Docker image using the provided Dockerfile and options.
-func buildImage(ctx context.Context, cli *client.Client, dockerfilePath, repoURL, buildCommand, imageName string) (io.ReadCloser, error) {
-	dockerfileContent, err := os.ReadFile(dockerfilePath)
+// buildImage builds an image from the given build context. It only builds the
+// trusted builder image, dependencies of projects are installed in a sandbox
+// container by installDeps.
+func buildImage(ctx context.Context, cli *client.Client, buildContext io.Reader, tag string, labels map[string]string, sandbox *SandboxConfig) (io.ReadCloser, error) {
+	imageBuildResponse, err := cli.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
+		Dockerfile:  "Dockerfile",
+		BuildArgs:   map[string]*string{"NODE_VERSION": &sandbox.NodeVersion},
+		Tags:        []string{tag},
+		Labels:      labels,
+		Remove:      true,
+		ForceRemove: true,
+		Memory:      sandbox.MemoryBytes,
+		MemorySwap:  sandbox.MemoryBytes,
+		CPUPeriod:   cpuPeriod,
+		CPUQuota:    sandbox.NanoCPUs * cpuPeriod / 1e9,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read Dockerfile: %w", err)
+		return nil, fmt.Errorf("failed to build the image: %w", err)
 	}
 
-	buf := new(bytes.Buffer)
-	tw := tar.NewWriter(buf)
-	defer tw.Close()
+	return imageBuildResponse.Body, nil
+}
 
-	hdr := &tar.Header{
-		Name: "Dockerfile",
-		Mode: 0600,
-		Size: int64(len(dockerfileContent)),
-	}
-	if err := tw.WriteHeader(hdr); err != nil {
-		return nil, fmt.Errorf("failed to write tar header: %w", err)
-	}
-	if _, err := tw.Write(dockerfileContent); err != nil {
-		return nil, fmt.Errorf("failed to write Dockerfile to tar archive: %w", err)
+// cpuPeriod is the CFS scheduler period used to express CPU limits during image builds.
+const cpuPeriod = 100000
+
+// readImageBuildOutput streams the image build output to the build logs and
+// returns the error reported by the daemon, if any.
+func readImageBuildOutput(ctx context.Context, body io.Reader, pushLogs func(string)) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		pushLogs(line)
+		fmt.Println(line) // for immediate feedback
+
+		var msg jsonmessage.JSONMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			continue
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("image build failed: %s", msg.Error.Message)
+		}
 	}
 
-	buildContext := ctx
-	imageBuildResponse, err := cli.ImageBuild(buildContext, buf, types.ImageBuildOptions{
-		Dockerfile: "Dockerfile",
-		BuildArgs:  map[string]*string{"REPO_URL": &repoURL, "BUILD_COMMAND": &buildCommand},
-		Tags:       []string{imageName},
-		Remove:     true,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to build the image: %w", err)
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return &BuildError{Reason: stoppedReason(ctx), Err: err}
+		}
+		return fmt.Errorf("error reading build output: %w", err)
 	}
 
-	return imageBuildResponse.Body, nil
+	return nil
+}
+
+// sandboxRun is a command run in a sandbox container.
+type sandboxRun struct {
+	// Name describes the command in errors.
+	Name  string
+	Image string
+	Cmd   []string
+	Env   []string
+	// Network gives the container network access, only dependency installs have it.
+	Network bool
+	// Prepare runs after the container was created, before it starts.
+	Prepare func(ctx context.Context, containerID string) error
+	// Done runs after the command succeeded, before the container is removed.
+	Done func(ctx context.Context, containerID string) error
+	// Failed is the failure reason when the command fails without hitting a sandbox limit.
+	Failed string
 }
 
-// copyBuildOutput copies the build output from the container to the host.
-func copyBuildOutput(ctx context.Context, cli *client.Client, imageName, currentDir string) error {
+// runInSandbox runs a command in a locked down container limited by sandbox,
+// streaming its output to the build logs. Failures of the command are
+// classified from the container state and returned as a *BuildError.
+func runInSandbox(ctx context.Context, cli *client.Client, run sandboxRun, sandbox *SandboxConfig, pushLogs func(string)) error {
+	hostConfig, err := sandbox.hostConfig(run.Network)
+	if err != nil {
+		return err
+	}
+
 	resp, err := cli.ContainerCreate(ctx, &container.Config{
-		Image: imageName,
-		Cmd:   []string{"sh", "-c", "cp -r /build/* /app/build-output/"},
-	}, &container.HostConfig{
-		Binds: []string{
-			currentDir + ":/app",
-		},
-	}, nil, nil, "")
+		Image:      run.Image,
+		User:       sandbox.User,
+		WorkingDir: "/app/repo",
+		Cmd:        run.Cmd,
+		Env:        run.Env,
+	}, hostConfig, nil, nil, "")
 	if err != nil {
 		return fmt.Errorf("failed to create the container: %w", err)
 	}
+	defer func() {
+		if err := cli.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true}); err != nil {
+			log.Printf("Failed to remove sandbox container %s: %v", resp.ID, err)
+		}
+	}()
+
+	if run.Prepare != nil {
+		if err := run.Prepare(ctx, resp.ID); err != nil {
+			return err
+		}
+	}
 
 	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
 		return fmt.Errorf("failed to start the container: %w", err)
 	}
+	pidsHit := watchPids(ctx, cli, resp.ID, sandbox.PidsLimit)
+
+	logs, err := cli.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		pidsHit()
+		return fmt.Errorf("failed to attach to container logs: %w", err)
+	}
+	defer logs.Close()
+
+	output := newLogWriter(pushLogs)
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		stdcopy.StdCopy(output, output, logs)
+		output.Flush()
+	}()
 
 	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
 	select {
 	case err := <-errCh:
-		if err != nil {
+		pidsHit()
+		if ctx.Err() == nil {
 			return fmt.Errorf("error waiting for container: %w", err)
 		}
+		// The wall-clock limit was hit or the build was cancelled, stop the build
+		if err := cli.ContainerKill(context.Background(), resp.ID, "KILL"); err != nil {
+			log.Printf("Failed to kill sandbox container %s: %v", resp.ID, err)
+		}
+		if reason := stoppedReason(ctx); reason == ReasonCancelled {
+			return &BuildError{Reason: reason, Err: fmt.Errorf("build was cancelled: %w", context.Cause(ctx))}
+		}
+		return &BuildError{Reason: ReasonTimeout, Err: fmt.Errorf("build exceeded %s", sandbox.Timeout)}
 	case <-statusCh:
 	}
+	exit := sandboxExit{PidsLimitHit: pidsHit()}
+	<-logsDone
+
+	inspect, _, err := cli.ContainerInspectWithRaw(ctx, resp.ID, true)
+	if err != nil {
+		return fmt.Errorf("failed to inspect the container: %w", err)
+	}
+	if inspect.State.ExitCode == 0 && !inspect.State.OOMKilled {
+		if run.Done == nil {
+			return nil
+		}
+		return run.Done(ctx, resp.ID)
+	}
+
+	exit.ExitCode = inspect.State.ExitCode
+	exit.OOMKilled = inspect.State.OOMKilled
+	if limit := sandbox.diskLimitBytes(); limit > 0 && inspect.SizeRw != nil {
+		exit.DiskFull = *inspect.SizeRw >= limit-diskLimitSlack
+	}
+	return &BuildError{
+		Reason: classifyExit(ctx, exit, run.Failed),
+		Err:    fmt.Errorf("%s exited with code %d", run.Name, exit.ExitCode),
+	}
+}
+
+// diskLimitSlack is how close to the disk limit the writable layer of a
+// container has to be for a failure to count as hitting it.
+const diskLimitSlack = 16 * 1024 * 1024
+
+// runBuild runs the build command in a sandbox container created from the
+// dependency image and copies the build output to buildDir.
+func runBuild(ctx context.Context, cli *client.Client, imageName string, spec BuildSpec, fw *framework.Framework, repoDir, buildDir string, sandbox *SandboxConfig, pushLogs func(string)) (err error) {
+	// The phase switches to copy once the build command finished
+	parent := ctx
+	ctx, endPhase := startPhase(parent, monitor.PhaseBuild)
+	defer func() { endPhase(err) }()
+
+	return runInSandbox(ctx, cli, sandboxRun{
+		Name:  "build command",
+		Image: imageName,
+		Cmd:   []string{"sh", "-c", `eval "$BUILD_COMMAND"`},
+		Env:   append(spec.envList(), "BUILD_COMMAND="+spec.BuildCommand),
+		Prepare: func(ctx context.Context, containerID string) error {
+			return copySourceToContainer(ctx, cli, containerID, repoDir)
+		},
+		Done: func(_ context.Context, containerID string) error {
+			endPhase(nil)
+			var copyCtx context.Context
+			copyCtx, endPhase = startPhase(parent, monitor.PhaseCopy)
+			return copyBuildOutput(copyCtx, cli, containerID, fw, buildDir, pushLogs)
+		},
+		Failed: ReasonBuildFailed,
+	}, sandbox, pushLogs)
+}
+
+// copySourceToContainer copies the cloned repository into the build container,
+// next to the dependencies installed in the image.
+func copySourceToContainer(ctx context.Context, cli *client.Client, containerID, repoDir string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeSourceTar(pw, repoDir))
+	}()
+	defer pr.Close()
+
+	if err := cli.CopyToContainer(ctx, containerID, "/app/repo", pr, container.CopyToContainerOptions{CopyUIDGID: true}); err != nil {
+		return fmt.Errorf("failed to copy source to the container: %w", err)
+	}
+	return nil
+}
+
+// writeSourceTar archives repoDir, owned by the build user. Installed
+// dependencies come from the image, so node_modules is left out.
+func writeSourceTar(w io.Writer, repoDir string) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.WalkDir(repoDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == repoDir {
+			return nil
+		}
+		if d.IsDir() && d.Name() == "node_modules" && filepath.Dir(path) == repoDir {
+			return filepath.SkipDir
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(repoDir, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(relPath)
+		hdr.Uid, hdr.Gid = buildUID, buildUID
+		hdr.Uname, hdr.Gname = "", ""
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive source: %w", err)
+	}
+
+	return tw.Close()
+}
+
+// buildUID is the uid of the node user in the official Node.js images.
+const buildUID = 1000
+
+// copyBuildOutput copies the first output directory of the framework that
+// exists in the container to the host, and checks that it holds a site.
+func copyBuildOutput(ctx context.Context, cli *client.Client, containerID string, fw *framework.Framework, buildDir string, pushLogs func(string)) error {
+	// An output directory without a site, such as an empty dist/, falls
+	// through to the next candidate
+	var checkErr error
+	for _, outputDir := range fw.OutputDirs {
+		reader, _, err := cli.CopyFromContainer(ctx, containerID, path.Join("/app/repo", outputDir))
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to copy %s from the container: %w", outputDir, err)
+		}
+
+		err = extractTar(reader, buildDir)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+		// Sites deployed from the repository root must not expose its history or secrets
+		if outputDir == "." {
+			if err := framework.StripRepoFiles(buildDir); err != nil {
+				return err
+			}
+		}
+		if err := framework.CheckOutput(fw, buildDir); err != nil {
+			checkErr = fmt.Errorf("%s: %w", outputDir, err)
+			if err := clearDir(buildDir); err != nil {
+				return err
+			}
+			continue
+		}
+
+		pushLogs(fmt.Sprintf("Deploying build output from %s/", outputDir))
+		return nil
+	}
 
+	if checkErr != nil {
+		return &BuildError{Reason: ReasonOutputMissing, Err: checkErr}
+	}
+	return &BuildError{Reason: ReasonOutputMissing, Err: fw.MissingOutput()}
+}
+
+// clearDir removes the content of dir.
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to clear %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", dir, err)
+		}
+	}
 	return nil
 }
 
+// extractTar extracts an archive returned by CopyFromContainer into dest,
+// stripping the top-level directory. Only regular files and directories are
+// extracted so a build cannot plant links that point outside of dest.
+func extractTar(r io.Reader, dest string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read build output archive: %w", err)
+		}
+
+		_, relPath, found := strings.Cut(filepath.ToSlash(hdr.Name), "/")
+		if !found || relPath == "" {
+			continue
+		}
+
+		target := filepath.Join(dest, relPath)
+		if !strings.HasPrefix(target, filepath.Clean(dest)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in build output: %s", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(file, tr)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("failed to extract %s: %w", hdr.Name, err)
+			}
+		default:
+			log.Printf("Skipping %s in build output: unsupported file type", hdr.Name)
+		}
+	}
+}
+
+// logWriter forwards container output to the build logs line by line.
+type logWriter struct {
+	pushLogs func(string)
+	partial  []byte
+}
+
+func newLogWriter(pushLogs func(string)) *logWriter {
+	return &logWriter{pushLogs: pushLogs}
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes out a trailing line that did not end with a newline.
+func (w *logWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.writeLine(string(w.partial))
+		w.partial = nil
+	}
+}
+
+func (w *logWriter) writeLine(line string) {
+	w.pushLogs(line)
+	fmt.Println(line) // for immediate feedback
+}
+
 func removeBuildDirectory(path string) error {
 	return os.RemoveAll(path)
 }
@@ -101,100 +460,201 @@ func pruneDockerImages(ctx context.Context, cli *client.Client) error {
 	return nil
 }
 
-func createDockerClient() (*client.Client, error) {
-	dockerHost := os.Getenv("DOCKER_HOST")
-	if dockerHost == "" {
-		dockerHost = "unix:///var/run/docker.sock" // Default value
-	}
-
-	var cli *client.Client
+// createDockerClient connects to the Docker daemon at host, retrying while it
+// starts up. It gives up when ctx is done.
+func createDockerClient(ctx context.Context, host string) (*client.Client, error) {
 	var err error
 	for attempts := 0; attempts < 30; attempts++ {
-		cli, err = client.NewClientWithOpts(
-			client.WithHost(dockerHost),
-			client.WithAPIVersionNegotiation(),
-		)
+		var cli *client.Client
+		cli, err = connectDocker(ctx, host)
 		if err == nil {
-			// Test the connection
-			_, err = cli.Ping(context.Background())
-			if err == nil {
-				return cli, nil
-			}
+			return cli, nil
 		}
 		log.Printf("Failed to connect to Docker (attempt %d): %v", attempts+1, err)
-		time.Sleep(2 * time.Second)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up connecting to Docker: %w", context.Cause(ctx))
+		case <-time.After(2 * time.Second):
+		}
 	}
 	return nil, fmt.Errorf("failed to connect to Docker after multiple attempts: %w", err)
 }
 
-// BuildProject builds a project and returns the Docker client, build directory, and image name.
-func BuildProject(ctx context.Context, repoURL, buildCommand string, pushLogs func(string)) (*client.Client, string, string, error) {
+// connectDocker returns a client of the Docker daemon at host, or an error
+// right away when the daemon does not respond.
+func connectDocker(ctx context.Context, host string) (*client.Client, error) {
+	cli, err := client.NewClientWithOpts(client.WithHost(host), client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	if _, err := cli.Ping(ctx); err != nil {
+		cli.Close()
+		return nil, fmt.Errorf("failed to connect to Docker: %w", err)
+	}
+	return cli, nil
+}
+
+// DockerCheck returns a check failing when the Docker daemon at host does not respond.
+func DockerCheck(host string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		cli, err := client.NewClientWithOpts(client.WithHost(host), client.WithAPIVersionNegotiation())
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+
+		_, err = cli.Ping(ctx)
+		return err
+	}
+}
+
+// BuildResult describes a finished build.
+type BuildResult struct {
+	// OutputDir holds the files to deploy.
+	OutputDir string
+	// WorkspaceDir holds the cloned repository.
+	WorkspaceDir string
+	// CommitSHA is the commit that was built.
+	CommitSHA string
+	// DepsImage is the image the dependencies were installed in.
+	DepsImage string
+	// DepsImageID identifies the content of DepsImage, which the build command
+	// ran in, as <repository>@sha256:<hex>. Tags of cached images are reused.
+	DepsImageID string
+	// DepsCache is the result of the dependency cache lookup, DepsCacheHit
+	// when DepsImage was reused from an earlier build.
+	DepsCache string
+	// Framework is the name of the detected framework.
+	Framework string
+	// NodeVersion is the Node.js version the project was built with.
+	NodeVersion string
+}
+
+// BuildProject builds a project and returns the Docker client and the build result.
+// Build failures caused by the project or by sandbox limits are returned as a *BuildError.
+// The spec must have been passed to PrepareBuild first.
+func BuildProject(ctx context.Context, spec BuildSpec, sandbox *SandboxConfig, pushLogs func(string)) (*client.Client, *BuildResult, error) {
 	uuid := uuid.New().String()
-	imageName := "aether-build-" + uuid
 
 	currentDir, err := os.Getwd()
 	if err != nil {
-		return nil, "", "", fmt.Errorf("failed to get current directory: %w", err)
+		return nil, nil, fmt.Errorf("failed to get current directory: %w", err)
 	}
 
-	buildDir := filepath.Join(currentDir, "build-output")
-	if err := os.MkdirAll(buildDir, 0755); err != nil {
-		return nil, "", "", fmt.Errorf("failed to create build directory: %w", err)
+	result := &BuildResult{
+		OutputDir:    filepath.Join(currentDir, "build-output", uuid),
+		WorkspaceDir: filepath.Join(currentDir, "workspaces", uuid),
+		CommitSHA:    spec.CommitSHA,
+		NodeVersion:  sandbox.NodeVersion,
+	}
+	if err := os.MkdirAll(result.OutputDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create build directory: %w", err)
 	}
 
 	dockerfilePath := filepath.Join(currentDir, "secure-build.dockerfile")
 
-	cli, err := createDockerClient()
+	cli, err := createDockerClient(ctx, sandbox.DockerHost)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("failed to create Docker client: %w", err)
+		removeBuildDirectory(result.OutputDir)
+		return nil, nil, fmt.Errorf("failed to create Docker client: %w", err)
 	}
 
-	buildResponse, err := buildImage(ctx, cli, dockerfilePath, repoURL, buildCommand, imageName)
-	if err != nil {
-		return nil, "", "", fmt.Errorf("failed during image build: %w", err)
+	// The wall-clock limit covers cloning, installing and building
+	ctx, cancel := context.WithTimeout(ctx, sandbox.Timeout)
+	defer cancel()
+
+	if err := buildInSandbox(ctx, cli, dockerfilePath, spec, result, sandbox, pushLogs); err != nil {
+		if cleanupErr := Cleanup(context.Background(), cli, result, sandbox); cleanupErr != nil {
+			log.Printf("Failed to clean up after build failure: %v", cleanupErr)
+		}
+		return nil, nil, err
 	}
-	defer buildResponse.Close()
 
-	// Read and collect build output
-	scanner := bufio.NewScanner(buildResponse)
-	for scanner.Scan() {
-		line := scanner.Text()
-		pushLogs(line)
-		fmt.Println(line) // for immediate feedback
+	return cli, result, nil
+}
+
+// startPhase starts the span of a build phase and times it. The returned
+// function ends the span and records the phase duration.
+func startPhase(ctx context.Context, phase string) (context.Context, func(error)) {
+	endTimer := monitor.TimePhase(ctx, phase)
+	ctx, endSpan := tracing.StartPhase(ctx, phase)
+	return ctx, func(err error) {
+		endTimer(err)
+		endSpan(err)
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, "", "", fmt.Errorf("error reading build output: %w", err)
+func buildInSandbox(ctx context.Context, cli *client.Client, dockerfilePath string, spec BuildSpec, result *BuildResult, sandbox *SandboxConfig, pushLogs func(string)) error {
+	repoDir := filepath.Join(result.WorkspaceDir, "repo")
+
+	cloneCtx, endPhase := startPhase(ctx, monitor.PhaseClone)
+	err := CloneRepository(cloneCtx, spec.Remote, spec.CommitSHA, repoDir, pushLogs)
+	endPhase(err)
+	if err != nil {
+		return err
 	}
 
-	// Check if the image exists
-	_, _, err = cli.ImageInspectWithRaw(ctx, imageName)
+	fw, err := framework.Detect(repoDir)
 	if err != nil {
-		if client.IsErrNotFound(err) {
-			return nil, "", "", fmt.Errorf("built image not found: %s", imageName)
+		return &BuildError{Reason: ReasonBuildFailed, Err: err}
+	}
+	result.Framework = fw.Name
+	pushLogs(fmt.Sprintf("Detected framework: %s", fw.Name))
+
+	if spec.BuildCommand == "" {
+		spec.BuildCommand = fw.BuildCommand
+		if spec.BuildCommand == "" {
+			pushLogs("No build command set, deploying the repository files as they are")
+		} else {
+			pushLogs(fmt.Sprintf("No build command set, using %q", spec.BuildCommand))
 		}
-		return nil, "", "", fmt.Errorf("failed to inspect image: %w", err)
 	}
 
-	if err := copyBuildOutput(ctx, cli, imageName, currentDir); err != nil {
-		return nil, "", "", fmt.Errorf("failed to copy build output: %w", err)
+	installCtx, endPhase := startPhase(ctx, monitor.PhaseInstall)
+	depsImage, depsCache, err := ensureDepsImage(installCtx, cli, dockerfilePath, repoDir, spec.ProjectId, sandbox, pushLogs)
+	endPhase(err)
+	if err != nil {
+		return err
+	}
+	result.DepsImage = depsImage
+	result.DepsCache = depsCache
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, depsImage)
+	if err != nil {
+		return fmt.Errorf("failed to inspect dependency image %s: %w", depsImage, err)
 	}
+	result.DepsImageID = depsImageRepository(spec.ProjectId) + "@" + inspect.ID
 
-	return cli, buildDir, imageName, nil
+	return runBuild(ctx, cli, depsImage, spec, fw, repoDir, result.OutputDir, sandbox, pushLogs)
 }
 
-// Cleanup performs cleanup actions after a build project.
-func Cleanup(ctx context.Context, cli *client.Client, buildDir string, imageName string) error {
-	// Remove the build directory
-	if err := removeBuildDirectory(buildDir); err != nil {
+// Cleanup performs cleanup actions after a build project and closes cli. Cached
+// dependency images are kept for later builds and only evicted once the cache grows too large.
+func Cleanup(ctx context.Context, cli *client.Client, result *BuildResult, sandbox *SandboxConfig) error {
+	defer cli.Close()
+
+	// Remove the build and workspace directories
+	if err := removeBuildDirectory(result.OutputDir); err != nil {
 		return fmt.Errorf("failed to remove build directory: %w", err)
 	}
+	if err := removeBuildDirectory(result.WorkspaceDir); err != nil {
+		return fmt.Errorf("failed to remove workspace directory: %w", err)
+	}
+
+	if result.DepsCache == DepsCacheSkip {
+		if err := removeUncachedDeps(ctx, cli, result.DepsImage); err != nil {
+			return err
+		}
+	}
 
 	// Prune Docker images
 	if err := pruneDockerImages(ctx, cli); err != nil {
 		return fmt.Errorf("failed to prune Docker images: %w", err)
 	}
 
+	if err := EvictDepsCache(ctx, cli, sandbox.DepsCacheMaxSize); err != nil {
+		return fmt.Errorf("failed to evict dependency cache: %w", err)
+	}
+
 	fmt.Println("Cleanup completed successfully")
 	return nil
 }
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/client"
+)
+
+// BuildSpec describes the inputs of a build.
+type BuildSpec struct {
+	ProjectId string
+	RepoURL   string
+	// Remote is RepoURL as validated by RepoURLValidator.Resolve, git only
+	// connects to its address.
+	Remote       *RepoRemote
+	Ref          string
+	CommitSHA    string
+	BuildCommand string
+	Env          map[string]string
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envList returns the build environment in a stable order.
+func (s *BuildSpec) envList() []string {
+	names := make([]string, 0, len(s.Env))
+	for name := range s.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := make([]string, 0, len(names))
+	for _, name := range names {
+		env = append(env, name+"="+s.Env[name])
+	}
+	return env
+}
+
+// PrepareBuild resolves the commit to build and returns the build fingerprint.
+// Builds with the same fingerprint produce the same output. The image a build
+// runs in only exists once its dependencies are installed, so the fingerprint
+// covers the Node.js base image and the recipe it is built from instead, and
+// BuildResult.DepsImageID records the image itself.
+func PrepareBuild(ctx context.Context, spec *BuildSpec, sandbox *SandboxConfig) (string, error) {
+	for name := range spec.Env {
+		if !envNamePattern.MatchString(name) || name == "BUILD_COMMAND" {
+			return "", &BuildError{Reason: ReasonInvalidEnv, Err: fmt.Errorf("invalid environment variable name %q", name)}
+		}
+	}
+
+	if spec.Remote == nil {
+		return "", fmt.Errorf("repository URL %s was not validated", spec.RepoURL)
+	}
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+	recipe, err := os.ReadFile(filepath.Join(currentDir, "secure-build.dockerfile"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read Dockerfile: %w", err)
+	}
+
+	// Nothing is pulled yet, builds reusing earlier artifacts never need the image
+	cli, err := connectDocker(ctx, sandbox.DockerHost)
+	if err != nil {
+		return "", err
+	}
+	defer cli.Close()
+
+	baseImage, err := baseImageDigest(ctx, cli, "node:"+sandbox.NodeVersion)
+	if err != nil {
+		return "", err
+	}
+
+	commitSHA, err := ResolveCommit(ctx, spec.Remote, spec.Ref)
+	if err != nil {
+		return "", err
+	}
+	spec.CommitSHA = commitSHA
+
+	return fingerprint(spec, baseImage, installRecipe(recipe)), nil
+}
+
+// fingerprint hashes everything that determines the build output.
+func fingerprint(spec *BuildSpec, baseImage string, recipe []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "project:%s\n", spec.ProjectId)
+	fmt.Fprintf(h, "repo:%s\n", spec.RepoURL)
+	fmt.Fprintf(h, "commit:%s\n", spec.CommitSHA)
+	fmt.Fprintf(h, "command:%q\n", spec.BuildCommand)
+	for _, env := range spec.envList() {
+		fmt.Fprintf(h, "env:%q\n", env)
+	}
+	fmt.Fprintf(h, "builder:%s\n", baseImage)
+	fmt.Fprintf(h, "recipe:%x\n", sha256.Sum256(recipe))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// baseImageDigest returns the content digest of the base image of the builder
+// image, from the local image when it is present and from the registry otherwise.
+func baseImageDigest(ctx context.Context, cli *client.Client, ref string) (string, error) {
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, ref)
+	switch {
+	case err == nil && len(inspect.RepoDigests) > 0:
+		return inspect.RepoDigests[0], nil
+	case err == nil:
+		// Images that were built or loaded locally have no registry digest
+		return inspect.ID, nil
+	case !client.IsErrNotFound(err):
+		return "", fmt.Errorf("failed to inspect builder image %s: %w", ref, err)
+	}
+
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid builder image %s: %w", ref, err)
+	}
+	dist, err := cli.DistributionInspect(ctx, ref, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to look up builder image %s: %w", ref, err)
+	}
+	return reference.FamiliarName(named) + "@" + dist.Descriptor.Digest.String(), nil
+}
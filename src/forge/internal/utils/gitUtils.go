@@ -0,0 +1,333 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidRepoURL is wrapped by every error returned from RepoURLValidator.Validate.
+var ErrInvalidRepoURL = errors.New("invalid repository URL")
+
+// scpLikeURL matches the short ssh form git accepts, e.g. git@github.com:owner/repo.git
+var scpLikeURL = regexp.MustCompile(`^[A-Za-z0-9._-]+@([A-Za-z0-9.-]+):([^/].*)$`)
+
+// Address ranges that are not covered by the net.IP classification helpers.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT, also used by cloud metadata services
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, can map onto private IPv4 ranges
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001::/32"),      // Teredo, tunnels to an IPv4 address
+	netip.MustParsePrefix("2001:db8::/32"),  // documentation
+	netip.MustParsePrefix("2002::/16"),      // 6to4, embeds any IPv4 address
+	netip.MustParsePrefix("fec0::/10"),      // deprecated site-local
+}
+
+// RepoURLValidator checks that a repository URL can be handed to git clone.
+// Only http(s) and ssh URLs to an allowed host are accepted, and the host must
+// resolve to public addresses only.
+type RepoURLValidator struct {
+	// AllowedHosts lists the git hosts builds may clone from. An entry starting
+	// with "*." also allows any subdomain.
+	AllowedHosts []string
+
+	// LookupIPAddr resolves a host name, it defaults to net.DefaultResolver.
+	LookupIPAddr func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DefaultAllowedGitHosts are the git hosts builds clone from when none are configured.
+var DefaultAllowedGitHosts = []string{"github.com", "gitlab.com", "bitbucket.org"}
+
+// NewRepoURLValidator creates a validator for the given hosts.
+func NewRepoURLValidator(allowedHosts []string) *RepoURLValidator {
+	var hosts []string
+	for _, host := range allowedHosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return &RepoURLValidator{
+		AllowedHosts: hosts,
+		LookupIPAddr: net.DefaultResolver.LookupIPAddr,
+	}
+}
+
+// RepoRemote is a repository URL accepted by RepoURLValidator. Git connects to
+// the address the host was validated with instead of resolving it again, so
+// the host cannot be rebound to another address in between.
+type RepoRemote struct {
+	URL  string
+	Host string
+	Port string
+	// Addr is the validated address of Host.
+	Addr netip.Addr
+	// SSH is set for ssh and scp-like URLs.
+	SSH bool
+}
+
+// Validate returns an error wrapping ErrInvalidRepoURL when rawURL must not be cloned.
+func (v *RepoURLValidator) Validate(ctx context.Context, rawURL string) error {
+	_, err := v.Resolve(ctx, rawURL)
+	return err
+}
+
+// Resolve validates rawURL and returns it pinned to a validated address of its
+// host. Errors wrap ErrInvalidRepoURL.
+func (v *RepoURLValidator) Resolve(ctx context.Context, rawURL string) (*RepoRemote, error) {
+	remote, err := parseRepoURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRepoURL, err)
+	}
+	host := remote.Host
+
+	if !v.isAllowedHost(host) {
+		return nil, fmt.Errorf("%w: host %q is not an allowed git host", ErrInvalidRepoURL, host)
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !IsPublicAddr(addr) {
+			return nil, fmt.Errorf("%w: %s is not a public address", ErrInvalidRepoURL, host)
+		}
+		remote.Addr = addr.Unmap()
+		return remote, nil
+	}
+
+	addrs, err := v.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to resolve %s: %v", ErrInvalidRepoURL, host, err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: %s did not resolve to any address", ErrInvalidRepoURL, host)
+	}
+
+	for _, ipAddr := range addrs {
+		addr, ok := netip.AddrFromSlice(ipAddr.IP)
+		if !ok || !IsPublicAddr(addr) {
+			return nil, fmt.Errorf("%w: %s resolves to non-public address %s", ErrInvalidRepoURL, host, ipAddr.IP)
+		}
+		if !remote.Addr.IsValid() {
+			remote.Addr = addr.Unmap()
+		}
+	}
+
+	return remote, nil
+}
+
+// gitArgs returns the options pinning git's http transport to the validated
+// address. Redirects are not followed, they could lead to another host.
+func (r *RepoRemote) gitArgs() []string {
+	if r.SSH {
+		return nil
+	}
+	addr := r.Addr.String()
+	if r.Addr.Is6() {
+		addr = "[" + addr + "]"
+	}
+	return []string{
+		"-c", fmt.Sprintf("http.curloptResolve=%s:%s:%s", r.Host, r.Port, addr),
+		"-c", "http.followRedirects=false",
+	}
+}
+
+// gitEnv returns the environment pinning git's ssh transport to the validated
+// address, host keys are still checked against the host name.
+func (r *RepoRemote) gitEnv() []string {
+	if !r.SSH {
+		return nil
+	}
+	return []string{fmt.Sprintf("GIT_SSH_COMMAND=ssh -o HostName=%s -o HostKeyAlias=%s -o BatchMode=yes", r.Addr, r.Host)}
+}
+
+func (v *RepoURLValidator) isAllowedHost(host string) bool {
+	for _, allowed := range v.AllowedHosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+			continue
+		}
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRepoURL returns the remote of a repository URL, with its host lower-cased,
+// after checking its scheme and shape.
+func parseRepoURL(rawURL string) (*RepoRemote, error) {
+	if rawURL == "" {
+		return nil, errors.New("repository URL is empty")
+	}
+	if strings.HasPrefix(rawURL, "-") {
+		return nil, errors.New("repository URL must not start with '-'")
+	}
+	if strings.ContainsFunc(rawURL, func(r rune) bool { return r <= ' ' || r == 0x7f }) {
+		return nil, errors.New("repository URL contains whitespace or control characters")
+	}
+
+	if match := scpLikeURL.FindStringSubmatch(rawURL); match != nil && !strings.Contains(rawURL, "://") {
+		return &RepoRemote{URL: rawURL, Host: strings.ToLower(match[1]), Port: "22", SSH: true}, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository URL: %v", err)
+	}
+
+	defaultPorts := map[string]string{"https": "443", "http": "80", "ssh": "22"}
+	port, ok := defaultPorts[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("scheme %q is not allowed, use https or ssh", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return nil, errors.New("repository URL has no host")
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		return nil, errors.New("repository URL has no repository path")
+	}
+
+	return &RepoRemote{URL: rawURL, Host: host, Port: port, SSH: u.Scheme == "ssh"}, nil
+}
+
+// IsPublicAddr reports whether addr is a globally routable unicast address.
+func IsPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CloneRepository fetches a single commit of remote into dir. Redirects and
+// submodules may only use network transports, and git never prompts for credentials.
+func CloneRepository(ctx context.Context, remote *RepoRemote, commitSHA, dir string, pushLogs func(string)) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	output := newLogWriter(pushLogs)
+	defer output.Flush()
+
+	err := runGit(ctx, nil, output, output, "init", "--quiet", dir)
+	if err == nil {
+		err = runGit(ctx, remote, output, output, "-C", dir, "fetch", "--depth", "1", "--no-tags", "--", remote.URL, commitSHA)
+	}
+	if err == nil {
+		err = runGit(ctx, nil, output, output, "-C", dir, "checkout", "--quiet", "--detach", "FETCH_HEAD")
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return &BuildError{Reason: stoppedReason(ctx), Err: fmt.Errorf("clone was interrupted: %w", context.Cause(ctx))}
+		}
+		return &BuildError{Reason: ReasonCloneFailed, Err: fmt.Errorf("failed to fetch commit %s: %w", commitSHA, err)}
+	}
+
+	return nil
+}
+
+// ResolveCommit returns the commit ref points to in the remote repository.
+// An empty ref resolves the default branch, full commit SHAs are returned as is.
+func ResolveCommit(ctx context.Context, remote *RepoRemote, ref string) (string, error) {
+	if commitSHAPattern.MatchString(ref) {
+		return strings.ToLower(ref), nil
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	var stdout bytes.Buffer
+	if err := runGit(ctx, remote, &stdout, nil, "ls-remote", "--", remote.URL, ref); err != nil {
+		return "", &BuildError{Reason: ReasonCloneFailed, Err: fmt.Errorf("failed to resolve %s: %w", ref, err)}
+	}
+
+	// Prefer an exact match, ls-remote also lists refs that merely end with ref
+	var commitSHA string
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if fields[1] == ref || fields[1] == "refs/heads/"+ref || fields[1] == "refs/tags/"+ref {
+			return fields[0], nil
+		}
+		if commitSHA == "" {
+			commitSHA = fields[0]
+		}
+	}
+	if commitSHA == "" {
+		return "", &BuildError{Reason: ReasonCloneFailed, Err: fmt.Errorf("ref %s not found in repository", ref)}
+	}
+
+	return commitSHA, nil
+}
+
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// gitConfig restricts git to network transports and keeps it from running
+// hooks or asking credential helpers, whatever the repository holds.
+var gitConfig = []string{
+	"-c", "protocol.allow=never",
+	"-c", "protocol.https.allow=always",
+	"-c", "protocol.http.allow=always",
+	"-c", "protocol.ssh.allow=always",
+	"-c", "core.hooksPath=/dev/null",
+	"-c", "core.fsmonitor=false",
+	"-c", "credential.helper=",
+	"-c", "init.templateDir=",
+}
+
+// gitEnv is the whole environment git runs with. The environment of the worker
+// holds credentials, so none of it is passed on apart from the path and the
+// home directory ssh reads its known hosts from.
+func gitEnv() []string {
+	return []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + os.Getenv("HOME"),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_SSH_COMMAND=ssh -o BatchMode=yes",
+	}
+}
+
+// runGit runs git, connecting to the pinned address of remote when it is set.
+func runGit(ctx context.Context, remote *RepoRemote, stdout, stderr io.Writer, args ...string) error {
+	env := gitEnv()
+	gitArgs := append([]string{}, gitConfig...)
+	if remote != nil {
+		gitArgs = append(gitArgs, remote.gitArgs()...)
+		// Later entries win, the pinned ssh command replaces the default one
+		env = append(env, remote.gitEnv()...)
+	}
+
+	cmd := exec.CommandContext(ctx, "git", append(gitArgs, args...)...)
+	cmd.Env = env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
@@ -0,0 +1,269 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
+)
+
+// Failure reasons reported when a build does not complete.
+const (
+	ReasonInvalidRepoURL = "invalid_repo_url"
+	ReasonInvalidEnv     = "invalid_env"
+	ReasonCloneFailed    = "clone_failed"
+	ReasonInstallFailed  = "install_failed"
+	ReasonBuildFailed    = "build_failed"
+	ReasonOutputMissing  = "output_missing"
+	ReasonTimeout        = "timeout"
+	ReasonCancelled      = "cancelled"
+	ReasonMemoryLimit    = "memory_limit"
+	ReasonPidsLimit      = "pids_limit"
+	ReasonDiskLimit      = "disk_limit"
+	ReasonInternal       = "internal_error"
+)
+
+// BuildError is returned by BuildProject when a build fails, carrying the reason it failed.
+type BuildError struct {
+	Reason string
+	Err    error
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// FailureReason returns the reason attached to a build error, or ReasonInternal
+// for errors that did not originate from the build sandbox.
+func FailureReason(err error) string {
+	var buildErr *BuildError
+	if errors.As(err, &buildErr) {
+		return buildErr.Reason
+	}
+	return ReasonInternal
+}
+
+// SandboxConfig holds the limits applied to untrusted build containers.
+type SandboxConfig struct {
+	// DockerHost is the Docker daemon the sandboxes run on.
+	DockerHost     string
+	NodeVersion    string
+	User           string
+	Timeout        time.Duration
+	NanoCPUs       int64
+	MemoryBytes    int64
+	PidsLimit      int64
+	DiskLimit      string
+	SeccompProfile string
+	// DepsCacheMaxSize is how many bytes the cached dependency images may take.
+	DepsCacheMaxSize int64
+}
+
+// DefaultSandboxConfig returns the limits applied when none are configured.
+func DefaultSandboxConfig() *SandboxConfig {
+	return &SandboxConfig{
+		DockerHost:       "unix:///var/run/docker.sock",
+		NodeVersion:      "20",
+		User:             "node",
+		Timeout:          15 * time.Minute,
+		NanoCPUs:         1e9,
+		MemoryBytes:      2 << 30,
+		PidsLimit:        512,
+		SeccompProfile:   "build-seccomp.json",
+		DepsCacheMaxSize: 10 << 30,
+	}
+}
+
+// hostConfig returns the host config of a sandbox container. Containers run
+// without capabilities and cannot gain new privileges, only the dependency
+// install is given network access.
+func (c *SandboxConfig) hostConfig(network bool) (*container.HostConfig, error) {
+	securityOpt := []string{"no-new-privileges"}
+
+	profile, err := os.ReadFile(c.SeccompProfile)
+	switch {
+	case err == nil:
+		securityOpt = append(securityOpt, "seccomp="+string(profile))
+	case os.IsNotExist(err):
+		log.Printf("Seccomp profile %s not found, using the Docker default profile", c.SeccompProfile)
+	default:
+		return nil, fmt.Errorf("failed to read seccomp profile: %w", err)
+	}
+
+	networkMode := container.NetworkMode("none")
+	if network {
+		networkMode = "bridge"
+	}
+
+	pidsLimit := c.PidsLimit
+	hostConfig := &container.HostConfig{
+		NetworkMode: networkMode,
+		CapDrop:     []string{"ALL"},
+		SecurityOpt: securityOpt,
+		Resources: container.Resources{
+			NanoCPUs:   c.NanoCPUs,
+			Memory:     c.MemoryBytes,
+			MemorySwap: c.MemoryBytes,
+			PidsLimit:  &pidsLimit,
+		},
+	}
+
+	// Disk quotas need overlay2 on xfs with pquota, so they are only set when configured.
+	if c.DiskLimit != "" {
+		hostConfig.StorageOpt = map[string]string{"size": c.DiskLimit}
+	}
+
+	return hostConfig, nil
+}
+
+// diskLimitBytes returns the disk limit in bytes, or 0 when there is none.
+func (c *SandboxConfig) diskLimitBytes() int64 {
+	if c.DiskLimit == "" {
+		return 0
+	}
+	limit, err := units.RAMInBytes(c.DiskLimit)
+	if err != nil {
+		return 0
+	}
+	return limit
+}
+
+// sandboxExit is the state of a sandbox container whose command failed.
+type sandboxExit struct {
+	ExitCode  int
+	OOMKilled bool
+	// PidsLimitHit reports that the cgroup of the container refused to
+	// create a process because of the pids limit.
+	PidsLimitHit bool
+	// DiskFull reports that the writable layer of the container reached the disk limit.
+	DiskFull bool
+}
+
+// classifyExit maps a failed sandbox container to a failure reason using its
+// state. failed is the reason reported when no sandbox limit was hit.
+func classifyExit(ctx context.Context, exit sandboxExit, failed string) string {
+	if ctx.Err() != nil {
+		return stoppedReason(ctx)
+	}
+
+	switch {
+	case exit.OOMKilled:
+		return ReasonMemoryLimit
+	case exit.PidsLimitHit:
+		return ReasonPidsLimit
+	case exit.DiskFull:
+		return ReasonDiskLimit
+	}
+	return failed
+}
+
+// pidsPollInterval is how often the pids cgroup of a running container is read.
+const pidsPollInterval = 250 * time.Millisecond
+
+// cgroupRoot is where the cgroup hierarchy shared with the Docker daemon is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// watchPids polls the pids cgroup of a running container until the returned
+// function is called, which reports whether the container hit its pids limit.
+// The cgroup is removed as soon as the container exits, so it has to be read
+// while the container runs.
+func watchPids(ctx context.Context, cli *client.Client, containerID string, limit int64) func() bool {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	var hit atomic.Bool
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(pidsPollInterval)
+		defer ticker.Stop()
+		for {
+			if pidsLimitHit(ctx, cli, containerID, limit) {
+				hit.Store(true)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() bool {
+		cancel()
+		<-done
+		return hit.Load()
+	}
+}
+
+// pidsLimitHit reads the max counter of the pids.events file of a container,
+// which counts the forks refused because of the limit. When the cgroup is not
+// visible from the worker, it compares the process count to the limit.
+func pidsLimitHit(ctx context.Context, cli *client.Client, containerID string, limit int64) bool {
+	for _, path := range []string{
+		// cgroup v2 with the cgroupfs and the systemd driver
+		filepath.Join(cgroupRoot, "docker", containerID, "pids.events"),
+		filepath.Join(cgroupRoot, "system.slice", "docker-"+containerID+".scope", "pids.events"),
+		// cgroup v1
+		filepath.Join(cgroupRoot, "pids", "docker", containerID, "pids.events"),
+		filepath.Join(cgroupRoot, "pids", "system.slice", "docker-"+containerID+".scope", "pids.events"),
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		return parsePidsMax(data) > 0
+	}
+
+	stats, err := cli.ContainerStatsOneShot(ctx, containerID)
+	if err != nil {
+		return false
+	}
+	defer stats.Body.Close()
+
+	var resp container.StatsResponse
+	if err := json.NewDecoder(stats.Body).Decode(&resp); err != nil {
+		return false
+	}
+	return limit > 0 && resp.PidsStats.Current >= uint64(limit)
+}
+
+// parsePidsMax returns the max counter of a pids.events file.
+func parsePidsMax(events []byte) int64 {
+	for _, line := range strings.Split(string(events), "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || name != "max" {
+			continue
+		}
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0
+		}
+		return count
+	}
+	return 0
+}
+
+// stoppedReason returns why a build was stopped through its context: it ran
+// out of time, or it was cancelled.
+func stoppedReason(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ReasonTimeout
+	}
+	return ReasonCancelled
+}
@@ -0,0 +1,231 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forge/internal/database"
+	"forge/internal/deployment"
+	"forge/internal/utils"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Config controls how webhooks are delivered.
+type Config struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Timeout bounds a single request.
+	Timeout time.Duration
+	// PollInterval is how often due deliveries are looked up when no event woke the dispatcher.
+	PollInterval time.Duration
+	// AllowPrivate lets endpoints resolve to private addresses, for local development.
+	AllowPrivate bool
+}
+
+// DefaultConfig returns the delivery of webhooks used when none is configured.
+func DefaultConfig() *Config {
+	return &Config{
+		MaxAttempts:  6,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     time.Hour,
+		Timeout:      10 * time.Second,
+		PollInterval: 5 * time.Second,
+	}
+}
+
+// delay returns how long to wait after the attempt-th attempt failed.
+func (c *Config) delay(attempt int) time.Duration {
+	delay := c.BaseDelay
+	for i := 1; i < attempt && delay < c.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, c.MaxDelay)
+}
+
+// Emitter sends the events of a deployment to the webhooks of its project.
+type Emitter interface {
+	Emit(ctx context.Context, eventType string, record *deployment.Record) error
+}
+
+// claimBatch is how many due deliveries are claimed at once.
+const claimBatch = 20
+
+// maxErrorLength bounds the error kept in the delivery log.
+const maxErrorLength = 512
+
+// Dispatcher records events in the delivery log and delivers them, retrying
+// failed deliveries with exponential backoff. Deliveries survive restarts, any
+// forge worker may send them.
+type Dispatcher struct {
+	store  database.WebhookStore
+	config *Config
+	client *http.Client
+	wake   chan struct{}
+}
+
+func NewDispatcher(store database.WebhookStore, config *Config) *Dispatcher {
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	if !config.AllowPrivate {
+		// Checked on the resolved address, so a hostname cannot point the
+		// worker at internal services
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !utils.IsPublicAddr(addr) {
+				return fmt.Errorf("%s is not a public address", host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &Dispatcher{
+		store:  store,
+		config: config,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   config.Timeout,
+			// A redirect would be sent without the endpoint checks
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Emit records an event for every endpoint of the project subscribed to it.
+func (d *Dispatcher) Emit(ctx context.Context, eventType string, record *deployment.Record) error {
+	event := NewEvent(eventType, record)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+
+	queued, err := d.store.EnqueueWebhookDeliveries(ctx, event.ProjectId, event.Id, eventType, payload)
+	if err != nil {
+		return err
+	}
+	if queued > 0 {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run delivers due deliveries until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		d.DeliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverDue sends every delivery that is due.
+func (d *Dispatcher) DeliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		// Claimed deliveries are skipped by other workers until the lease
+		// expires, long enough for a single attempt of each
+		deliveries, err := d.store.ClaimWebhookDeliveries(ctx, claimBatch, claimBatch*d.config.Timeout)
+		if err != nil {
+			log.Printf("Failed to claim webhook deliveries: %v", err)
+			return
+		}
+		for i := range deliveries {
+			d.deliver(ctx, &deliveries[i])
+		}
+		if len(deliveries) < claimBatch {
+			return
+		}
+	}
+}
+
+// deliver makes an attempt and records its outcome.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *database.WebhookDelivery) {
+	delivery.Attempts++
+	status, err := d.send(ctx, delivery)
+	delivery.ResponseStatus = status
+	delivery.LastError = ""
+
+	switch {
+	case err == nil:
+		delivery.Status = database.DeliverySucceeded
+		delivery.DeliveredAt = time.Now().UTC()
+	case delivery.Attempts >= d.config.MaxAttempts:
+		delivery.Status = database.DeliveryFailed
+		delivery.LastError = truncate(err.Error())
+		log.Printf("Webhook delivery %s to %s failed after %d attempts: %v", delivery.Id, delivery.URL, delivery.Attempts, err)
+	default:
+		delay := d.config.delay(delivery.Attempts)
+		delivery.NextAttemptAt = time.Now().Add(delay)
+		delivery.LastError = truncate(err.Error())
+		log.Printf("Webhook delivery %s to %s failed, retrying in %s: %v", delivery.Id, delivery.URL, delay, err)
+	}
+
+	// The outcome is saved even when the worker is stopping
+	if err := d.store.UpdateWebhookDelivery(context.WithoutCancel(ctx), delivery); err != nil {
+		log.Printf("Failed to record webhook delivery %s: %v", delivery.Id, err)
+	}
+}
+
+// send posts a delivery and returns the response status. Only 2xx responses
+// acknowledge it.
+func (d *Dispatcher) send(ctx context.Context, delivery *database.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	// Signed when sent, a replayed delivery is not rejected as expired
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Aether-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.Id)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, now, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New(resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func truncate(s string) string {
+	if len(s) > maxErrorLength {
+		return s[:maxErrorLength]
+	}
+	return s
+}
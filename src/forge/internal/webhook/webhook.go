@@ -0,0 +1,138 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"forge/internal/deployment"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types sent to webhook endpoints.
+const (
+	EventDeploymentStarted   = "deployment.started"
+	EventDeploymentSucceeded = "deployment.succeeded"
+	EventDeploymentFailed    = "deployment.failed"
+	EventDeploymentCancelled = "deployment.cancelled"
+)
+
+// EventTypes are the event types an endpoint can subscribe to.
+var EventTypes = []string{
+	EventDeploymentStarted,
+	EventDeploymentSucceeded,
+	EventDeploymentFailed,
+	EventDeploymentCancelled,
+}
+
+// Headers of a webhook request.
+const (
+	HeaderEvent     = "X-Aether-Event"
+	HeaderDelivery  = "X-Aether-Delivery"
+	HeaderTimestamp = "X-Aether-Timestamp"
+	HeaderSignature = "X-Aether-Signature"
+)
+
+var (
+	// ErrInvalidSignature is returned by Verify when the signature does not match the body.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrExpiredTimestamp is returned by Verify for requests signed too long ago,
+	// they may be replayed.
+	ErrExpiredTimestamp = errors.New("webhook timestamp is outside the tolerance")
+)
+
+// Event is the JSON body of a webhook request.
+type Event struct {
+	// Id is the same for every delivery of an event, receivers use it to
+	// ignore duplicates.
+	Id         string     `json:"id"`
+	Type       string     `json:"type"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ProjectId  string     `json:"projectId"`
+	Deployment Deployment `json:"deployment"`
+}
+
+// Deployment is the deployment an event is about.
+type Deployment struct {
+	Id            string    `json:"id"`
+	Status        string    `json:"status"`
+	CommitSHA     string    `json:"commitSha,omitempty"`
+	Framework     string    `json:"framework,omitempty"`
+	FailureReason string    `json:"failureReason,omitempty"`
+	CancelledBy   string    `json:"cancelledBy,omitempty"`
+	PreviewBranch string    `json:"previewBranch,omitempty"`
+	PreviewURL    string    `json:"previewUrl,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	FinishedAt    time.Time `json:"finishedAt,omitempty"`
+}
+
+// NewEvent returns the event of the given type about a deployment. A
+// deployment has a single event of each type, emitting it again after a retry
+// keeps its id.
+func NewEvent(eventType string, record *deployment.Record) Event {
+	return Event{
+		Id:        uuid.NewSHA1(uuid.NameSpaceOID, []byte(record.DeploymentId+"/"+eventType)).String(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		ProjectId: record.ProjectId,
+		Deployment: Deployment{
+			Id:            record.DeploymentId,
+			Status:        record.Status,
+			CommitSHA:     record.CommitSHA,
+			Framework:     record.Framework,
+			FailureReason: record.FailureReason,
+			CancelledBy:   record.CancelledBy,
+			PreviewBranch: record.PreviewBranch,
+			PreviewURL:    record.PreviewURL,
+			CreatedAt:     record.CreatedAt,
+			FinishedAt:    record.FinishedAt,
+		},
+	}
+}
+
+// StatusEvent returns the event type sent when a deployment reaches status,
+// or false when none is.
+func StatusEvent(status string) (string, bool) {
+	switch status {
+	case deployment.StatusSucceeded:
+		return EventDeploymentSucceeded, true
+	case deployment.StatusFailed:
+		return EventDeploymentFailed, true
+	case deployment.StatusCancelled:
+		return EventDeploymentCancelled, true
+	}
+	return "", false
+}
+
+// Sign returns the signature header of a body sent at timestamp. It is the
+// hex HMAC-SHA256 of "<unix seconds>.<body>", keyed with the endpoint secret.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp headers of a webhook request
+// received at now. Requests signed more than tolerance away from now are
+// rejected.
+func Verify(secret, signature, timestamp string, body []byte, now time.Time, tolerance time.Duration) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrExpiredTimestamp, timestamp)
+	}
+	signedAt := time.Unix(seconds, 0)
+	if now.Sub(signedAt).Abs() > tolerance {
+		return ErrExpiredTimestamp
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, signedAt, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
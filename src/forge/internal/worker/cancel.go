@@ -0,0 +1,208 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forge/internal/database"
+	"forge/internal/deployment"
+	"forge/internal/monitor"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+var (
+	// ErrCancelled matches every *CancelledError.
+	ErrCancelled = errors.New("deployment was cancelled")
+
+	// ErrNothingToCancel is returned when a project has no deployment that
+	// is queued or being built.
+	ErrNothingToCancel = errors.New("no deployment is queued or being built")
+
+	// ErrAlreadyFinished is returned when cancelling a deployment that finished.
+	ErrAlreadyFinished = errors.New("deployment already finished")
+)
+
+// CancelledError stops a deployment that was cancelled through a Cancel message.
+type CancelledError struct {
+	By string
+}
+
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("deployment was cancelled by %s", e.By)
+}
+
+func (e *CancelledError) Is(target error) bool {
+	return target == ErrCancelled
+}
+
+// CancelMessage cancels a deployment, or the deployments of the project being
+// built or queued when DeploymentId is empty.
+type CancelMessage struct {
+	ProjectId    string `json:"projectId"`
+	DeploymentId string `json:"deploymentId"`
+	// CancelledBy is the user who cancelled, it is only recorded for
+	// messages of trusted senders.
+	CancelledBy string `json:"cancelledBy"`
+}
+
+func (m *CancelMessage) Validate() error {
+	if m.ProjectId == "" {
+		return errors.New("message has no projectId")
+	}
+	return nil
+}
+
+// inflight holds the deployments built by this worker, so a Cancel message it
+// receives stops them without waiting for the next lease renewal.
+var inflight = struct {
+	sync.Mutex
+	builds map[string]inflightBuild
+}{builds: make(map[string]inflightBuild)}
+
+type inflightBuild struct {
+	projectId string
+	cancel    context.CancelCauseFunc
+}
+
+// trackBuild registers a deployment as in flight until untrack is called. The
+// returned context is canceled with the cause passed to cancelInflight.
+func trackBuild(ctx context.Context, projectId, deploymentId string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	inflight.Lock()
+	inflight.builds[deploymentId] = inflightBuild{projectId: projectId, cancel: cancel}
+	inflight.Unlock()
+	monitor.InflightBuilds.Inc()
+
+	untrack := func() {
+		inflight.Lock()
+		delete(inflight.builds, deploymentId)
+		inflight.Unlock()
+		monitor.InflightBuilds.Dec()
+		cancel(nil)
+	}
+	return ctx, untrack
+}
+
+// cancelInflight stops the given deployments of the project built by this
+// worker, or all of them when deploymentIds is nil.
+func cancelInflight(projectId string, deploymentIds []string, cause error) {
+	inflight.Lock()
+	defer inflight.Unlock()
+
+	for deploymentId, build := range inflight.builds {
+		if build.projectId == projectId && (deploymentIds == nil || slices.Contains(deploymentIds, deploymentId)) {
+			build.cancel(cause)
+		}
+	}
+}
+
+// processCancel records the cancellation of deployments. Workers building them
+// stop once they renew their build lease, deployments still queued are
+// cancelled when they are received.
+func processCancel(ctx context.Context, services *Services, message types.Message, msg *CancelMessage) error {
+	cancelledBy := canceller(message, msg.CancelledBy, services.TrustedSenders)
+
+	deploymentIds, err := cancelBuilds(ctx, services, msg.ProjectId, msg.ProjectId, msg.DeploymentId, cancelledBy)
+	if errors.Is(err, ErrNothingToCancel) || errors.Is(err, ErrAlreadyFinished) {
+		log.Printf("Nothing cancelled in project %s [cancelled by: %s]: %v", msg.ProjectId, cancelledBy, err)
+		logPusher(ctx, services.Logs, msg.ProjectId)(fmt.Sprintf("Nothing was cancelled: %v", err))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Cancelled deployments %v of project %s [cancelled by: %s]", deploymentIds, msg.ProjectId, cancelledBy)
+	cancelInflight(msg.ProjectId, deploymentIds, &CancelledError{By: cancelledBy})
+	return nil
+}
+
+// cancelBuilds records the cancellation of a deployment built under leaseKey,
+// or of the one being built and the newest one requested when deploymentId is
+// empty. Deployments that already finished are left alone. It returns the ids
+// of the cancelled deployments.
+func cancelBuilds(ctx context.Context, services *Services, projectId, leaseKey, deploymentId, cancelledBy string) ([]string, error) {
+	store, err := services.store()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []string{deploymentId}
+	if deploymentId == "" {
+		lease, err := services.DB.GetBuildLease(ctx, leaseKey)
+		if errors.Is(err, database.ErrNoBuildLease) {
+			return nil, ErrNothingToCancel
+		}
+		if err != nil {
+			return nil, err
+		}
+		candidates = []string{lease.LatestDeploymentId}
+		if lease.ActiveDeploymentId != "" && lease.ActiveDeploymentId != lease.LatestDeploymentId {
+			candidates = append(candidates, lease.ActiveDeploymentId)
+		}
+	}
+
+	var deploymentIds []string
+	for _, id := range candidates {
+		record, err := store.GetRecord(ctx, projectId, id)
+		switch {
+		case deployment.IsNotFound(err):
+			// Still queued, it is dropped once it is received
+		case err != nil:
+			return nil, fmt.Errorf("failed to look up deployment %s: %w", id, err)
+		case record.Status != deployment.StatusBuilding:
+			continue
+		}
+		if err := services.DB.CancelBuild(ctx, leaseKey, id, cancelledBy); err != nil {
+			return nil, err
+		}
+		deploymentIds = append(deploymentIds, id)
+	}
+
+	if len(deploymentIds) == 0 {
+		if deploymentId != "" {
+			return nil, fmt.Errorf("%w: %s", ErrAlreadyFinished, deploymentId)
+		}
+		return nil, ErrNothingToCancel
+	}
+	return deploymentIds, nil
+}
+
+// canceller returns who cancelled a deployment. Only trusted principals, such
+// as launchpad which authenticates its users, may name the user in the
+// message. Otherwise it is the sender SQS authenticated.
+func canceller(message types.Message, claimed string, trusted []string) string {
+	sender := message.Attributes[string(types.MessageSystemAttributeNameSenderId)]
+	if claimed != "" && trustedSender(sender, trusted) {
+		return claimed
+	}
+	if sender != "" {
+		return sender
+	}
+	return "unknown"
+}
+
+// trustedSender reports whether sender is one of the trusted principals. Roles
+// send as <role id>:<session>, so role ids match every session of the role.
+func trustedSender(sender string, trusted []string) bool {
+	if sender == "" {
+		return false
+	}
+	roleId, _, _ := strings.Cut(sender, ":")
+	return slices.Contains(trusted, sender) || slices.Contains(trusted, roleId)
+}
+
+// cancellation returns the error stopping a cancelled deployment.
+func cancellation(ctx context.Context, db database.Service, deploymentId string) error {
+	cancelled, err := db.GetCancellation(ctx, deploymentId)
+	if err != nil {
+		return fmt.Errorf("failed to look up cancellation of deployment %s: %w", deploymentId, err)
+	}
+	return &CancelledError{By: cancelled.CancelledBy}
+}
@@ -0,0 +1,301 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/uuid"
+)
+
+// Reasons a message is moved to the dead-letter queue.
+const (
+	ReasonMalformedMessage   = "malformed_message"
+	ReasonUnsupportedType    = "unsupported_message_type"
+	ReasonMaxAttemptsReached = "max_attempts_reached"
+)
+
+// Message attributes added to dead letters.
+const (
+	attrDeadLetterReason = "DeadLetterReason"
+	attrDeadLetterError  = "DeadLetterError"
+	attrDeadLetterSource = "DeadLetterSource"
+	attrDeadLetterAt     = "DeadLetterAt"
+	attrReceiveCount     = "ReceiveCount"
+	// attrRedriveId marks messages sent back by Redrive, it is new for every redrive.
+	attrRedriveId = "RedriveId"
+)
+
+// maxErrorLength keeps the error attribute well below the SQS message size limit.
+const maxErrorLength = 1024
+
+// ErrNoDeadLetterQueue is returned by DeadLetterQueue.Send when no dead-letter queue is configured.
+var ErrNoDeadLetterQueue = errors.New("no dead-letter queue configured")
+
+// PoisonError is returned by ProcessMessage for messages that can never be
+// processed. They are dead-lettered without being retried.
+type PoisonError struct {
+	Reason string
+	Err    error
+}
+
+func (e *PoisonError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+func (e *PoisonError) Unwrap() error {
+	return e.Err
+}
+
+// AttemptError is returned by handlers that count the attempts of a message
+// themselves, because some receives of the message are not attempts. Attempt
+// replaces the receive count when the message is retried or dead-lettered.
+type AttemptError struct {
+	Attempt int
+	Err     error
+}
+
+func (e *AttemptError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AttemptError) Unwrap() error {
+	return e.Err
+}
+
+// SQSAPI is the part of the SQS client used by the worker.
+type SQSAPI interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// RetryPolicy decides how often and how quickly a failed message is retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a message is received before it is dead-lettered.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it doubles with every attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy returns the retry policy used when none is configured.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   30 * time.Second,
+		MaxDelay:    15 * time.Minute,
+	}
+}
+
+// Delay returns how long a message stays invisible after its attempt-th receive failed.
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// receiveCount returns how many times SQS delivered the message, including this delivery.
+func receiveCount(message types.Message) int {
+	count, err := strconv.Atoi(message.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	if err != nil || count < 1 {
+		return 1
+	}
+	return count
+}
+
+// DeadLetterQueue moves messages that cannot be processed out of the build queue.
+type DeadLetterQueue struct {
+	client   SQSAPI
+	queueURL string
+	url      string
+}
+
+// NewDeadLetterQueue returns the dead-letter queue at dlqURL for messages from queueURL.
+func NewDeadLetterQueue(client SQSAPI, queueURL, dlqURL string) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		client:   client,
+		queueURL: queueURL,
+		url:      dlqURL,
+	}
+}
+
+// Send copies a message to the dead-letter queue with the failure attached.
+// The caller deletes the original message afterwards.
+func (q *DeadLetterQueue) Send(ctx context.Context, message types.Message, reason string, cause error) error {
+	if q.url == "" {
+		return ErrNoDeadLetterQueue
+	}
+
+	attributes := make(map[string]types.MessageAttributeValue, len(message.MessageAttributes)+5)
+	for name, value := range message.MessageAttributes {
+		attributes[name] = value
+	}
+
+	errorMessage := "unknown error"
+	if cause != nil {
+		errorMessage = cause.Error()
+	}
+	if len(errorMessage) > maxErrorLength {
+		errorMessage = errorMessage[:maxErrorLength]
+	}
+
+	attributes[attrDeadLetterReason] = stringAttribute(reason)
+	attributes[attrDeadLetterError] = stringAttribute(errorMessage)
+	if q.queueURL != "" {
+		attributes[attrDeadLetterSource] = stringAttribute(q.queueURL)
+	}
+	attributes[attrDeadLetterAt] = stringAttribute(time.Now().UTC().Format(time.RFC3339))
+	attributes[attrReceiveCount] = types.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(strconv.Itoa(receiveCount(message))),
+	}
+
+	_, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(q.url),
+		MessageBody:       message.Body,
+		MessageAttributes: attributes,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send message to the dead-letter queue: %w", err)
+	}
+	return nil
+}
+
+// DeadLetter is a message in the dead-letter queue.
+type DeadLetter struct {
+	MessageId     string
+	MessageType   string
+	Reason        string
+	Error         string
+	Source        string
+	ReceiveCount  string
+	DeadLetterAt  string
+	Body          string
+	receiptHandle string
+	attributes    map[string]types.MessageAttributeValue
+}
+
+// List returns the messages in the dead-letter queue. Listed messages stay
+// invisible for visibility, so concurrent listings do not see them twice.
+func (q *DeadLetterQueue) List(ctx context.Context, visibility time.Duration) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	seen := make(map[string]bool)
+	for {
+		result, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(q.url),
+			MaxNumberOfMessages:   10,
+			VisibilityTimeout:     int32(visibility.Seconds()),
+			WaitTimeSeconds:       1,
+			MessageAttributeNames: []string{"All"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive dead letters: %w", err)
+		}
+
+		added := 0
+		for _, message := range result.Messages {
+			id := aws.ToString(message.MessageId)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			added++
+
+			letters = append(letters, DeadLetter{
+				MessageId:     id,
+				MessageType:   messageAttribute(message, "MessageType"),
+				Reason:        messageAttribute(message, attrDeadLetterReason),
+				Error:         messageAttribute(message, attrDeadLetterError),
+				Source:        messageAttribute(message, attrDeadLetterSource),
+				ReceiveCount:  messageAttribute(message, attrReceiveCount),
+				DeadLetterAt:  messageAttribute(message, attrDeadLetterAt),
+				Body:          aws.ToString(message.Body),
+				receiptHandle: aws.ToString(message.ReceiptHandle),
+				attributes:    message.MessageAttributes,
+			})
+		}
+		if added == 0 {
+			return letters, nil
+		}
+	}
+}
+
+// Redrive sends dead letters back to the queue they came from and removes them
+// from the dead-letter queue. The letters must come from List and still be
+// invisible. Redriven messages are marked, so failed builds are built again
+// and their attempts are counted from the start.
+func (q *DeadLetterQueue) Redrive(ctx context.Context, letters []DeadLetter) (int, error) {
+	redriven := 0
+	for _, letter := range letters {
+		target := letter.Source
+		if target == "" {
+			target = q.queueURL
+		}
+		if target == "" {
+			return redriven, fmt.Errorf("dead letter %s has no source queue", letter.MessageId)
+		}
+
+		attributes := make(map[string]types.MessageAttributeValue, len(letter.attributes))
+		for name, value := range letter.attributes {
+			if !isDeadLetterAttribute(name) {
+				attributes[name] = value
+			}
+		}
+		attributes[attrRedriveId] = stringAttribute(uuid.NewString())
+
+		_, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+			QueueUrl:          aws.String(target),
+			MessageBody:       aws.String(letter.Body),
+			MessageAttributes: attributes,
+		})
+		if err != nil {
+			return redriven, fmt.Errorf("failed to redrive %s: %w", letter.MessageId, err)
+		}
+
+		_, err = q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(q.url),
+			ReceiptHandle: aws.String(letter.receiptHandle),
+		})
+		if err != nil {
+			return redriven, fmt.Errorf("redrove %s but failed to delete it from the dead-letter queue: %w", letter.MessageId, err)
+		}
+		redriven++
+	}
+	return redriven, nil
+}
+
+func isDeadLetterAttribute(name string) bool {
+	return strings.HasPrefix(name, "DeadLetter") || name == attrReceiveCount
+}
+
+// redriveId returns the redrive that sent the message back from the
+// dead-letter queue, or an empty string for messages that were not redriven.
+func redriveId(message types.Message) string {
+	return messageAttribute(message, attrRedriveId)
+}
+
+func messageAttribute(message types.Message, name string) string {
+	value, ok := message.MessageAttributes[name]
+	if !ok {
+		return ""
+	}
+	return aws.ToString(value.StringValue)
+}
+
+func stringAttribute(value string) types.MessageAttributeValue {
+	return types.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(value),
+	}
+}
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forge/internal/monitor"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// ErrBuildRunning is returned by ProcessMessage while a build of a project
+// being deleted has not stopped yet. It is retried like a failure, so the
+// deletion is dead-lettered when the build does not stop.
+var ErrBuildRunning = errors.New("a build of the project is still running")
+
+// DeleteProjectMessage removes everything forge and logify keep of a deleted project.
+type DeleteProjectMessage struct {
+	ProjectId   string `json:"projectId"`
+	RequestedBy string `json:"requestedBy"`
+}
+
+func (m *DeleteProjectMessage) Validate() error {
+	if m.ProjectId == "" {
+		return errors.New("message has no projectId")
+	}
+	return nil
+}
+
+// processDeleteProject deletes the artifacts, deployment records and logs of a
+// project. Builds of the project are stopped first, the message is retried
+// with the retry policy of builds until none is running, so no artifacts are
+// uploaded after the deletion.
+func processDeleteProject(ctx context.Context, services *Services, message types.Message, msg *DeleteProjectMessage) error {
+	building, err := services.DB.DeleteProject(ctx, msg.ProjectId)
+	if err != nil {
+		return err
+	}
+	if building {
+		// Builds of other workers stop once they renew their build lease
+		cancelInflight(msg.ProjectId, nil, ErrProjectDeleted)
+		log.Printf("Project %s is deleted once its running build stopped", msg.ProjectId)
+		return ErrBuildRunning
+	}
+
+	store, err := services.store()
+	if err != nil {
+		return err
+	}
+
+	objects, err := store.DeleteProject(ctx, msg.ProjectId)
+	monitor.DeletedObjects.Add(float64(objects))
+	if err != nil {
+		monitor.ProjectDeletions.WithLabelValues("failure").Inc()
+		return fmt.Errorf("failed to delete artifacts of project %s: %w", msg.ProjectId, err)
+	}
+
+	logs, err := services.Logs.PurgeLogs(ctx, msg.ProjectId)
+	if err != nil {
+		monitor.ProjectDeletions.WithLabelValues("failure").Inc()
+		return fmt.Errorf("failed to purge logs of project %s: %w", msg.ProjectId, err)
+	}
+	monitor.PurgedLogs.Add(float64(logs))
+
+	monitor.ProjectDeletions.WithLabelValues("success").Inc()
+	log.Printf("Deleted project %s [requested by: %s]: removed %d objects and %d logs", msg.ProjectId, msg.RequestedBy, objects, logs)
+	return nil
+}
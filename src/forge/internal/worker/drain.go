@@ -0,0 +1,49 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// ErrShuttingDown stops the messages still processed when the drain timeout
+// is reached. They are released back to the queue for other workers.
+var ErrShuttingDown = errors.New("worker is shutting down")
+
+// draining is set once the worker stops receiving messages.
+var draining atomic.Bool
+
+// DefaultDrainTimeout is how long in-flight messages may take to finish once
+// the worker is asked to stop, when no drain timeout is configured.
+const DefaultDrainTimeout = 5 * time.Minute
+
+// drainContext returns the context messages are processed with. Once ctx is
+// done the worker is draining, and the returned context is canceled with
+// ErrShuttingDown after timeout. stop must be called once processing ended.
+func drainContext(ctx context.Context, timeout time.Duration) (context.Context, func()) {
+	processCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-processCtx.Done():
+			return
+		}
+
+		draining.Store(true)
+		log.Printf("Draining, in-flight messages have %s to finish", timeout)
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			log.Printf("Drain timeout reached, stopping in-flight messages")
+			cancel(ErrShuttingDown)
+		case <-processCtx.Done():
+		}
+	}()
+
+	return processCtx, func() { cancel(nil) }
+}
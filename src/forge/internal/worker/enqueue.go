@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"forge/internal/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// BuildQueue sends Build, Rollback and DeletePreview messages to the build
+// queue, the same way launchpad does.
+type BuildQueue struct {
+	client   SQSAPI
+	queueURL string
+}
+
+func NewBuildQueue(client SQSAPI, queueURL string) *BuildQueue {
+	return &BuildQueue{client: client, queueURL: queueURL}
+}
+
+// Enqueue sends a Build message and returns its message id.
+func (q *BuildQueue) Enqueue(ctx context.Context, msg *BuildMessage) (string, error) {
+	messageId, err := q.send(ctx, MessageTypeBuild, msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to enqueue build of project %s: %w", msg.ProjectId, err)
+	}
+	return messageId, nil
+}
+
+// EnqueueDeletePreview sends a DeletePreview message and returns its message id.
+func (q *BuildQueue) EnqueueDeletePreview(ctx context.Context, msg *DeletePreviewMessage) (string, error) {
+	messageId, err := q.send(ctx, MessageTypeDeletePreview, msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to enqueue deletion of preview %s of project %s: %w", msg.Branch, msg.ProjectId, err)
+	}
+	return messageId, nil
+}
+
+// EnqueueRollback sends a Rollback message and returns its message id.
+func (q *BuildQueue) EnqueueRollback(ctx context.Context, msg *RollbackMessage) (string, error) {
+	messageId, err := q.send(ctx, MessageTypeRollback, msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to enqueue rollback of project %s: %w", msg.ProjectId, err)
+	}
+	return messageId, nil
+}
+
+func (q *BuildQueue) send(ctx context.Context, messageType string, msg Payload) (string, error) {
+	if err := msg.Validate(); err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s message: %w", messageType, err)
+	}
+
+	attributes := map[string]types.MessageAttributeValue{
+		"MessageType": stringAttribute(messageType),
+	}
+	// The worker continues the trace of the request that queued the message
+	tracing.Inject(ctx, attributes)
+
+	out, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(q.queueURL),
+		MessageBody:       aws.String(string(body)),
+		MessageAttributes: attributes,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.MessageId), nil
+}
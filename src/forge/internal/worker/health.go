@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// buildSlots is how many builds a worker runs at once, Run receives one
+// build message at a time.
+const buildSlots = 1
+
+var (
+	// ErrDraining is reported by the readiness check while the worker stops.
+	ErrDraining = errors.New("worker is draining")
+	// ErrNoBuildSlots is reported by the readiness check while every build slot is taken.
+	ErrNoBuildSlots = errors.New("worker has no free build slots")
+)
+
+// QueueAttributesAPI reads queue attributes, which checks the queue is reachable.
+type QueueAttributesAPI interface {
+	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+}
+
+// QueueCheck returns a health check failing while the queue cannot be read.
+func QueueCheck(sqsSvc QueueAttributesAPI, queueURL string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := sqsSvc.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+			QueueUrl:       &queueURL,
+			AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameApproximateNumberOfMessages},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read queue %s: %w", queueURL, err)
+		}
+		return nil
+	}
+}
+
+// ReadinessCheck fails while the worker drains or has no free build slot.
+func ReadinessCheck(ctx context.Context) error {
+	if draining.Load() {
+		return ErrDraining
+	}
+
+	inflight.Lock()
+	builds := len(inflight.builds)
+	inflight.Unlock()
+	if builds >= buildSlots {
+		return ErrNoBuildSlots
+	}
+	return nil
+}
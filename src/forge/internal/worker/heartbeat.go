@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+var (
+	// ErrLeaseLost cancels processing when the message visibility could not be
+	// extended. SQS delivers the message again, so it must not be reported as failed.
+	ErrLeaseLost = errors.New("lost the lease on the message")
+
+	// ErrVisibilityCeiling cancels processing that ran longer than the visibility ceiling.
+	ErrVisibilityCeiling = errors.New("message was processed for longer than the visibility ceiling")
+)
+
+// HeartbeatConfig controls how long messages stay invisible while they are processed.
+type HeartbeatConfig struct {
+	// VisibilityTimeout is how long a message stays invisible after it was
+	// received or its visibility was extended.
+	VisibilityTimeout time.Duration
+	// Interval is how often the visibility is extended.
+	Interval time.Duration
+	// Ceiling is the longest a message is processed, processing is canceled
+	// once it is reached.
+	Ceiling time.Duration
+}
+
+// NewHeartbeatConfig returns the heartbeat config of a visibility timeout,
+// the visibility is extended three times per timeout.
+func NewHeartbeatConfig(visibilityTimeout, ceiling time.Duration) *HeartbeatConfig {
+	return &HeartbeatConfig{
+		VisibilityTimeout: visibilityTimeout,
+		Interval:          visibilityTimeout / 3,
+		Ceiling:           ceiling,
+	}
+}
+
+// DefaultHeartbeatConfig returns the heartbeat config used when none is configured.
+func DefaultHeartbeatConfig() *HeartbeatConfig {
+	return NewHeartbeatConfig(2*time.Minute, time.Hour)
+}
+
+// KeepVisible extends the visibility of a message until stop is called. The
+// returned context is canceled with ErrLeaseLost when the visibility cannot
+// be extended in time, or with ErrVisibilityCeiling once the ceiling is reached.
+func KeepVisible(
+	ctx context.Context,
+	sqsSvc SQSAPI,
+	queueURL string,
+	message types.Message,
+	cfg *HeartbeatConfig,
+) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		received := time.Now()
+		expires := received.Add(cfg.VisibilityTimeout)
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		ceiling := time.NewTimer(cfg.Ceiling)
+		defer ceiling.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ceiling.C:
+				// The message stays invisible a while longer so the failure can be reported
+				cancel(fmt.Errorf("%w (%s)", ErrVisibilityCeiling, cfg.Ceiling))
+			case <-ticker.C:
+			}
+
+			renewed := time.Now()
+			_, err := sqsSvc.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          aws.String(queueURL),
+				ReceiptHandle:     message.ReceiptHandle,
+				VisibilityTimeout: int32(cfg.VisibilityTimeout.Seconds()),
+			})
+			if err == nil {
+				expires = renewed.Add(cfg.VisibilityTimeout)
+				if ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+
+			var invalidReceipt *types.ReceiptHandleIsInvalid
+			var notInflight *types.MessageNotInflight
+			if errors.As(err, &invalidReceipt) || errors.As(err, &notInflight) || time.Until(expires) <= cfg.Interval {
+				log.Printf("Failed to extend visibility of message %s, giving it up: %v", aws.ToString(message.MessageId), err)
+				cancel(fmt.Errorf("%w: %v", ErrLeaseLost, err))
+				return
+			}
+			log.Printf("Failed to extend visibility of message %s, retrying: %v", aws.ToString(message.MessageId), err)
+		}
+	}()
+
+	stop := func() {
+		close(done)
+		wg.Wait()
+		cancel(nil)
+	}
+	return ctx, stop
+}
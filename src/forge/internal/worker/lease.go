@@ -0,0 +1,175 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forge/internal/database"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrSuperseded stops a deployment once a newer deployment of the same project was requested.
+	ErrSuperseded = errors.New("superseded by a newer deployment")
+
+	// ErrProjectBusy is returned by ProcessMessage while an older deployment of
+	// the project is still being built. The message is retried shortly.
+	ErrProjectBusy = errors.New("another deployment of the project is being built")
+
+	// ErrBuildLeaseLost stops a deployment whose build lease could not be renewed.
+	ErrBuildLeaseLost = errors.New("lost the build lease of the project")
+
+	// ErrProjectDeleted stops a deployment of a deleted project.
+	ErrProjectDeleted = errors.New("project was deleted")
+)
+
+// busyRetryDelay is how long a message waits for an older deployment to stop.
+const busyRetryDelay = 15 * time.Second
+
+// leaseHolder identifies this worker in the build lease table.
+var leaseHolder = func() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "forge"
+	}
+	return hostname + "-" + uuid.New().String()[:8]
+}()
+
+// buildLease is the lease a deployment holds while it is the only active build of its project.
+type buildLease struct {
+	db           database.Service
+	projectId    string
+	deploymentId string
+	ttl          time.Duration
+}
+
+// DefaultLeaseTTL is how long a build lease is valid without being renewed,
+// when no lease TTL is configured.
+const DefaultLeaseTTL = 60 * time.Second
+
+// acquireBuildLease takes the build lease of the project for a deployment. It
+// returns ErrProjectDeleted when the project was deleted, a *CancelledError
+// when the deployment was cancelled, ErrSuperseded
+// when a newer deployment was requested already, and ErrProjectBusy while an
+// older deployment still holds the lease.
+func acquireBuildLease(ctx context.Context, db database.Service, projectId, deploymentId string, requestedAt time.Time, ttl time.Duration) (*buildLease, error) {
+	state, err := db.AcquireBuildLease(ctx, projectId, deploymentId, requestedAt, leaseHolder, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch state {
+	case database.LeaseDeleted:
+		return nil, ErrProjectDeleted
+	case database.LeaseCancelled:
+		return nil, cancellation(ctx, db, deploymentId)
+	case database.LeaseSuperseded:
+		return nil, ErrSuperseded
+	case database.LeaseBusy:
+		return nil, ErrProjectBusy
+	}
+
+	return &buildLease{
+		db:           db,
+		projectId:    projectId,
+		deploymentId: deploymentId,
+		ttl:          ttl,
+	}, nil
+}
+
+// Check renews the lease and returns an error when the deployment must stop.
+func (l *buildLease) Check(ctx context.Context) error {
+	state, err := l.db.RenewBuildLease(ctx, l.projectId, l.deploymentId, leaseHolder, l.ttl)
+	if errors.Is(err, database.ErrLeaseNotHeld) {
+		return ErrBuildLeaseLost
+	}
+	if err != nil {
+		return err
+	}
+	switch state {
+	case database.LeaseDeleted:
+		return ErrProjectDeleted
+	case database.LeaseCancelled:
+		return cancellation(ctx, l.db, l.deploymentId)
+	case database.LeaseSuperseded:
+		return ErrSuperseded
+	}
+	return nil
+}
+
+// Keep renews the lease until stop is called. The returned context is
+// canceled with ErrProjectDeleted once the project is deleted, with a
+// *CancelledError once the deployment is cancelled, with
+// ErrSuperseded once a newer deployment is requested, or with
+// ErrBuildLeaseLost when the lease could not be renewed before it expired.
+func (l *buildLease) Keep(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		expires := time.Now().Add(l.ttl)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			renewed := time.Now()
+			err := l.Check(ctx)
+			switch {
+			case err == nil:
+				expires = renewed.Add(l.ttl)
+			case errors.Is(err, ErrProjectDeleted), errors.Is(err, ErrCancelled), errors.Is(err, ErrSuperseded), errors.Is(err, ErrBuildLeaseLost):
+				cancel(err)
+				return
+			case time.Until(expires) <= l.ttl/3:
+				cancel(fmt.Errorf("%w: %v", ErrBuildLeaseLost, err))
+				return
+			default:
+				log.Printf("Failed to renew build lease of deployment %s, retrying: %v", l.deploymentId, err)
+			}
+		}
+	}()
+
+	stop := func() {
+		close(done)
+		wg.Wait()
+		cancel(nil)
+	}
+	return ctx, stop
+}
+
+// Release gives up the lease so the next deployment of the project can start.
+func (l *buildLease) Release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := l.db.ReleaseBuildLease(ctx, l.projectId, l.deploymentId, leaseHolder); err != nil {
+		log.Printf("Failed to release build lease of deployment %s: %v", l.deploymentId, err)
+	}
+}
+
+// sentTimestamp returns when the message was sent, deployments are ordered by it.
+func sentTimestamp(message types.Message) time.Time {
+	millis, err := strconv.ParseInt(message.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.UnixMilli(millis)
+}
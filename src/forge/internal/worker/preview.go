@@ -0,0 +1,189 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forge/internal/database"
+	"forge/internal/deployment"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// PreviewConfig controls branch preview deployments.
+type PreviewConfig struct {
+	// TTL is how long a preview is kept after the last build of its branch.
+	TTL time.Duration
+	// URLTemplate is the URL previews are served at, "{site}" is replaced by
+	// <branch>--<project>. Previews have no URL when it is empty.
+	URLTemplate string
+	// ExpiryInterval is how often expired previews are deleted.
+	ExpiryInterval time.Duration
+}
+
+// DefaultPreviewConfig returns the preview settings used when none are
+// configured, previews have no URL.
+func DefaultPreviewConfig() *PreviewConfig {
+	return &PreviewConfig{
+		TTL:            7 * 24 * time.Hour,
+		ExpiryInterval: 10 * time.Minute,
+	}
+}
+
+// URL returns the URL the preview of a branch is served at, or an empty string.
+func (c *PreviewConfig) URL(projectId, slug string) string {
+	if c.URLTemplate == "" {
+		return ""
+	}
+	return strings.ReplaceAll(c.URLTemplate, "{site}", deployment.PreviewSite(projectId, slug))
+}
+
+// DeletePreviewMessage removes the preview of a branch, such as when the
+// branch was deleted.
+type DeletePreviewMessage struct {
+	ProjectId   string `json:"projectId"`
+	Branch      string `json:"branch"`
+	RequestedBy string `json:"requestedBy"`
+}
+
+func (m *DeletePreviewMessage) Validate() error {
+	if m.ProjectId == "" {
+		return errors.New("message has no projectId")
+	}
+	if m.Branch == "" {
+		return errors.New("message has no branch")
+	}
+	return nil
+}
+
+// finishPreview publishes a successful preview deployment under the preview
+// prefix of its branch and extends its expiry.
+func finishPreview(ctx context.Context, store *deployment.Store, previews database.PreviewStore, cfg *PreviewConfig, record *deployment.Record, pushLogs func(string)) error {
+	slug := deployment.PreviewSlug(record.PreviewBranch)
+
+	record.Status = deployment.StatusSucceeded
+	if err := store.PublishPreview(ctx, record); err != nil {
+		record.Status = deployment.StatusBuilding
+		return fmt.Errorf("failed to publish preview: %w", err)
+	}
+
+	record.PreviewURL = cfg.URL(record.ProjectId, slug)
+	record.FinishedAt = time.Now().UTC()
+	if err := store.SaveRecord(ctx, record); err != nil {
+		log.Printf("Failed to save deployment record %s: %v", record.DeploymentId, err)
+	}
+
+	if previews != nil {
+		err := previews.SavePreview(ctx, &database.Preview{
+			ProjectId:    record.ProjectId,
+			Slug:         slug,
+			Branch:       record.PreviewBranch,
+			DeploymentId: record.DeploymentId,
+			URL:          record.PreviewURL,
+			ExpiresAt:    record.FinishedAt.Add(cfg.TTL),
+		})
+		if err != nil {
+			// The files are served, the preview only does not expire
+			log.Printf("Failed to record preview of deployment %s: %v", record.DeploymentId, err)
+		}
+	}
+
+	if record.PreviewURL != "" {
+		pushLogs(fmt.Sprintf("Preview %s of branch %s is available at %s", record.DeploymentId, record.PreviewBranch, record.PreviewURL))
+	} else {
+		pushLogs(fmt.Sprintf("Preview %s of branch %s is published", record.DeploymentId, record.PreviewBranch))
+	}
+	return nil
+}
+
+// processDeletePreview cancels the pending builds of a branch preview and
+// removes its files. The deployment records and artifacts are kept.
+func processDeletePreview(ctx context.Context, services *Services, message types.Message, msg *DeletePreviewMessage) error {
+	if msg.RequestedBy == "" {
+		msg.RequestedBy = "unknown"
+	}
+	slug := deployment.PreviewSlug(msg.Branch)
+
+	// Builds still running would publish the preview again
+	deploymentIds, err := cancelBuilds(ctx, services, msg.ProjectId, database.PreviewLeaseKey(msg.ProjectId, slug), "", msg.RequestedBy)
+	if err != nil && !errors.Is(err, ErrNothingToCancel) {
+		return err
+	}
+	if len(deploymentIds) > 0 {
+		cancelInflight(msg.ProjectId, deploymentIds, &CancelledError{By: msg.RequestedBy})
+	}
+
+	store, err := services.store()
+	if err != nil {
+		return err
+	}
+	objects, err := deletePreview(ctx, store, services.Previews, msg.ProjectId, slug, "")
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deleted preview of branch %s of project %s [requested by: %s]: removed %d objects", msg.Branch, msg.ProjectId, msg.RequestedBy, objects)
+	logPusher(ctx, services.Logs, msg.ProjectId)(fmt.Sprintf("Preview of branch %s was deleted", msg.Branch))
+	return nil
+}
+
+// deletePreview removes the files of a preview and forgets it. When
+// deploymentId is not empty, the preview is only forgotten while it still
+// serves that deployment.
+func deletePreview(ctx context.Context, store *deployment.Store, previews database.PreviewStore, projectId, slug, deploymentId string) (int, error) {
+	// The files go first, a preview that is forgotten is never deleted again
+	objects, err := store.DeletePreview(ctx, projectId, slug)
+	if err != nil {
+		return objects, fmt.Errorf("failed to delete preview %s of project %s: %w", slug, projectId, err)
+	}
+	if previews == nil {
+		return objects, nil
+	}
+	if _, err := previews.DeletePreview(ctx, projectId, slug, deploymentId); err != nil {
+		return objects, err
+	}
+	return objects, nil
+}
+
+// maxExpiredPreviews bounds the previews deleted at once.
+const maxExpiredPreviews = 100
+
+// ExpirePreviews deletes the previews that expired before now and returns how
+// many were deleted.
+func ExpirePreviews(ctx context.Context, store *deployment.Store, previews database.PreviewStore, now time.Time) (int, error) {
+	expired, err := previews.ExpiredPreviews(ctx, now, maxExpiredPreviews)
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	deleted := 0
+	for _, preview := range expired {
+		if _, err := deletePreview(ctx, store, previews, preview.ProjectId, preview.Slug, preview.DeploymentId); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("Preview of branch %s of project %s expired", preview.Branch, preview.ProjectId)
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// RunPreviewExpiry deletes expired previews every interval until ctx is done.
+func RunPreviewExpiry(ctx context.Context, store *deployment.Store, previews database.PreviewStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := ExpirePreviews(ctx, store, previews, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to delete expired previews: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forge/internal/deployment"
+	pb "forge/internal/genprotobuf/project"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// PromoteMessage makes an earlier successful deployment live again.
+type PromoteMessage struct {
+	ProjectId    string `json:"projectId"`
+	DeploymentId string `json:"deploymentId"`
+}
+
+func (m *PromoteMessage) Validate() error {
+	if m.ProjectId == "" {
+		return errors.New("message has no projectId")
+	}
+	if m.DeploymentId == "" {
+		return errors.New("message has no deploymentId")
+	}
+	return nil
+}
+
+// RollbackMessage makes the successful deployment before the live one live
+// again, or the given deployment when DeploymentId is set.
+type RollbackMessage struct {
+	ProjectId    string `json:"projectId"`
+	DeploymentId string `json:"deploymentId"`
+}
+
+func (m *RollbackMessage) Validate() error {
+	if m.ProjectId == "" {
+		return errors.New("message has no projectId")
+	}
+	return nil
+}
+
+func processPromote(ctx context.Context, services *Services, message types.Message, msg *PromoteMessage) error {
+	store, err := services.store()
+	if err != nil {
+		return err
+	}
+	pushLogs := logPusher(ctx, services.Logs, msg.ProjectId)
+
+	record, err := store.GetRecord(ctx, msg.ProjectId, msg.DeploymentId)
+	if deployment.IsNotFound(err) {
+		log.Printf("Cannot promote deployment %s of project %s, it does not exist", msg.DeploymentId, msg.ProjectId)
+		pushLogs(fmt.Sprintf("Deployment %s does not exist and cannot be promoted", msg.DeploymentId))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get deployment record: %w", err)
+	}
+
+	return promoteDeployment(ctx, store, record, pushLogs, services)
+}
+
+func processRollback(ctx context.Context, services *Services, message types.Message, msg *RollbackMessage) error {
+	if msg.DeploymentId != "" {
+		return processPromote(ctx, services, message, &PromoteMessage{ProjectId: msg.ProjectId, DeploymentId: msg.DeploymentId})
+	}
+
+	store, err := services.store()
+	if err != nil {
+		return err
+	}
+	pushLogs := logPusher(ctx, services.Logs, msg.ProjectId)
+
+	target, err := rollbackTarget(ctx, store, msg.ProjectId)
+	if deployment.IsNotFound(err) {
+		log.Printf("Cannot roll back project %s, there is no earlier successful deployment", msg.ProjectId)
+		pushLogs("There is no earlier successful deployment to roll back to")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	pushLogs(fmt.Sprintf("Rolling back to deployment %s", target.DeploymentId))
+	return promoteDeployment(ctx, store, target, pushLogs, services)
+}
+
+// rollbackTarget returns the newest successful deployment created before the
+// live deployment, or ErrNotFound. Previews are never rolled back to.
+func rollbackTarget(ctx context.Context, store *deployment.Store, projectId string) (*deployment.Record, error) {
+	live, err := store.LiveRecord(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := store.ListRecords(ctx, projectId)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Status == deployment.StatusSucceeded && record.PreviewBranch == "" && record.CreatedAt.Before(live.CreatedAt) {
+			return record, nil
+		}
+	}
+	return nil, deployment.ErrNotFound
+}
+
+// promoteDeployment makes an existing successful deployment live.
+func promoteDeployment(ctx context.Context, store *deployment.Store, record *deployment.Record, pushLogs func(string), services *Services) error {
+	if record.Status != deployment.StatusSucceeded {
+		log.Printf("Cannot promote deployment %s of project %s with status %s", record.DeploymentId, record.ProjectId, record.Status)
+		pushLogs(fmt.Sprintf("Deployment %s has status %s and cannot be promoted", record.DeploymentId, record.Status))
+		return nil
+	}
+	if record.PreviewBranch != "" {
+		log.Printf("Cannot promote deployment %s of project %s, it is a preview of branch %s", record.DeploymentId, record.ProjectId, record.PreviewBranch)
+		pushLogs(fmt.Sprintf("Deployment %s is a preview of branch %s and cannot be promoted", record.DeploymentId, record.PreviewBranch))
+		return nil
+	}
+
+	err := store.Promote(ctx, record)
+	if errors.Is(err, deployment.ErrNoArtifacts) {
+		log.Printf("Cannot promote deployment %s of project %s, its artifacts were pruned", record.DeploymentId, record.ProjectId)
+		pushLogs(fmt.Sprintf("The artifacts of deployment %s were pruned, it cannot be promoted", record.DeploymentId))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to promote deployment: %w", err)
+	}
+	if err := store.SaveRecord(ctx, record); err != nil {
+		log.Printf("Failed to save deployment record %s: %v", record.DeploymentId, err)
+	}
+
+	pushLogs(fmt.Sprintf("Deployment %s is live", record.DeploymentId))
+	if err := services.Projects.UpdateProjectStatus(ctx, record.ProjectId, pb.ProjectStatus_LIVE); err != nil {
+		log.Printf("Failed to report status: %v", err)
+	}
+	pruneArtifacts(ctx, store, record.ProjectId, services.retention())
+	return nil
+}
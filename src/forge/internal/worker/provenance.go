@@ -0,0 +1,55 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"forge/internal/deployment"
+	"forge/internal/provenance"
+	"time"
+)
+
+// attestDeployment signs the provenance of a built deployment and stores it
+// next to its artifacts. digests are the digests of the uploaded files, they
+// are read from the bucket when nil. Nothing is done when provenance is disabled.
+func attestDeployment(ctx context.Context, cfg *provenance.Config, store *deployment.Store, msg BuildMessage, record *deployment.Record, digests map[string]string, pushLogs func(string)) error {
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	if digests == nil {
+		var err error
+		digests, err = store.ArtifactDigests(ctx, record.ProjectId, record.ArtifactsFrom)
+		if err != nil {
+			return fmt.Errorf("failed to hash artifacts of deployment %s: %w", record.ArtifactsFrom, err)
+		}
+	}
+
+	envNames := make([]string, 0, len(msg.Env))
+	for name := range msg.Env {
+		envNames = append(envNames, name)
+	}
+	statement := provenance.NewStatement(&provenance.Build{
+		BuilderID:  cfg.BuilderID,
+		Record:     record,
+		Ref:        msg.Ref,
+		EnvNames:   envNames,
+		Digests:    digests,
+		FinishedOn: time.Now().UTC(),
+	})
+
+	envelope, err := cfg.Signer.Sign(statement)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal provenance envelope: %w", err)
+	}
+	if err := store.SaveProvenance(ctx, record.ProjectId, record.DeploymentId, data); err != nil {
+		return fmt.Errorf("failed to save provenance: %w", err)
+	}
+
+	pushLogs(fmt.Sprintf("Signed the provenance of %d files of deployment %s with key %s", len(digests), record.DeploymentId, cfg.Signer.KeyID()))
+	return nil
+}
@@ -0,0 +1,268 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forge/internal/database"
+	"forge/internal/deployment"
+	"forge/internal/monitor"
+	"forge/internal/provenance"
+	"forge/internal/service"
+	"forge/internal/tracing"
+	"forge/internal/utils"
+	"forge/internal/webhook"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Message types handled by forge.
+const (
+	MessageTypeBuild    = "Build"
+	MessageTypeCancel   = "Cancel"
+	MessageTypePromote  = "Promote"
+	MessageTypeRollback = "Rollback"
+	MessageTypeDelete   = "DeleteProject"
+	// MessageTypeDeletePreview removes the preview of a deleted branch.
+	MessageTypeDeletePreview = "DeletePreview"
+)
+
+// Policies for messages whose type or version is not handled by the worker.
+const (
+	// UnknownReject dead-letters the message.
+	UnknownReject = "reject"
+	// UnknownRelease leaves the message in the queue for workers that handle it.
+	UnknownRelease = "release"
+)
+
+// ErrReleased is returned by ProcessMessage for messages left in the queue
+// because the worker does not handle them.
+var ErrReleased = errors.New("message was released for other workers")
+
+// releaseDelay makes a released message visible to other workers again soon,
+// without this worker receiving it over and over.
+const releaseDelay = 10 * time.Second
+
+// Services are used by message handlers.
+type Services struct {
+	Projects service.ProjectService
+	Logs     service.ProjectLogService
+	DB       database.Service
+	// Webhooks receive deployment events, none are sent when it is nil.
+	Webhooks webhook.Emitter
+	// Previews records the served branch previews, they are not recorded and
+	// never expire when it is nil.
+	Previews database.PreviewStore
+	// Store keeps the deployments.
+	Store *deployment.Store
+	// Provenance signs the provenance of deployments, none is recorded when
+	// it is nil or has no signing key.
+	Provenance *provenance.Config
+	// Retention is how many successful deployments of a project keep their
+	// artifacts, deployment.DefaultRetention when zero.
+	Retention int
+	// Repos validates the repositories builds clone from, it allows the
+	// utils.DefaultAllowedGitHosts when nil.
+	Repos *utils.RepoURLValidator
+	// Retry decides how often a failed build is attempted, DefaultRetryPolicy
+	// when nil.
+	Retry *RetryPolicy
+	// LeaseTTL is how long a build lease is valid without being renewed,
+	// DefaultLeaseTTL when zero.
+	LeaseTTL time.Duration
+	// PreviewConfig controls the branch previews, DefaultPreviewConfig when nil.
+	PreviewConfig *PreviewConfig
+	// Sandbox limits the build containers, utils.DefaultSandboxConfig when nil.
+	Sandbox *utils.SandboxConfig
+	// TrustedSenders are the SQS principals that may name who cancelled a
+	// deployment in Cancel messages.
+	TrustedSenders []string
+}
+
+// Payload is the typed body of a message.
+type Payload interface {
+	// Validate returns an error when required fields are missing.
+	Validate() error
+}
+
+// HandlerFunc processes a message with a decoded payload. It returns errors
+// the same way ProcessMessage does.
+type HandlerFunc[P Payload] func(ctx context.Context, services *Services, message types.Message, payload P) error
+
+type handler func(ctx context.Context, services *Services, message types.Message) error
+
+// RegistryConfig controls which messages a worker handles.
+type RegistryConfig struct {
+	// Types are the message types handled, every registered type when empty.
+	Types []string
+	// Unknown is the policy for messages of other types or versions. Messages
+	// of registered types that are not enabled are always released, they are
+	// handled by other workers.
+	Unknown string
+}
+
+// Registry maps message types and payload versions to their handlers. The
+// version is read from the MessageVersion attribute and defaults to 1.
+type Registry struct {
+	services *Services
+	handlers map[string]map[int]handler
+	enabled  map[string]bool
+	unknown  string
+	// disabled is the policy for registered types that are not enabled.
+	disabled string
+}
+
+// NewRegistry returns a registry with the message types handled by forge,
+// limited to the types enabled in cfg.
+func NewRegistry(services *Services, cfg *RegistryConfig) (*Registry, error) {
+	r := &Registry{
+		services: services,
+		handlers: make(map[string]map[int]handler),
+		unknown:  cfg.Unknown,
+		disabled: UnknownRelease,
+	}
+
+	Register(r, MessageTypeBuild, 1, processBuild)
+	Register(r, MessageTypeCancel, 1, processCancel)
+	Register(r, MessageTypePromote, 1, processPromote)
+	Register(r, MessageTypeRollback, 1, processRollback)
+	Register(r, MessageTypeDelete, 1, processDeleteProject)
+	Register(r, MessageTypeDeletePreview, 1, processDeletePreview)
+
+	if err := r.Enable(cfg.Types...); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Register adds the handler of a version of a message type. Message bodies
+// are decoded into P and validated before handle is called, bodies that do
+// not match are dead-lettered.
+func Register[T any, P interface {
+	*T
+	Payload
+}](r *Registry, messageType string, version int, handle HandlerFunc[P]) {
+	if r.handlers[messageType] == nil {
+		r.handlers[messageType] = make(map[int]handler)
+	}
+
+	r.handlers[messageType][version] = func(ctx context.Context, services *Services, message types.Message) error {
+		payload := P(new(T))
+		if err := json.Unmarshal([]byte(aws.ToString(message.Body)), payload); err != nil {
+			return &PoisonError{Reason: ReasonMalformedMessage, Err: fmt.Errorf("failed to unmarshal %s v%d message body: %w", messageType, version, err)}
+		}
+		if err := payload.Validate(); err != nil {
+			return &PoisonError{Reason: ReasonMalformedMessage, Err: fmt.Errorf("invalid %s v%d message: %w", messageType, version, err)}
+		}
+		return handle(ctx, services, message, payload)
+	}
+}
+
+// Enable limits the registry to the given message types, or enables every
+// registered type when none are given.
+func (r *Registry) Enable(messageTypes ...string) error {
+	if len(messageTypes) == 0 {
+		r.enabled = nil
+		return nil
+	}
+
+	enabled := make(map[string]bool, len(messageTypes))
+	for _, messageType := range messageTypes {
+		if r.handlers[messageType] == nil {
+			return fmt.Errorf("no handler registered for message type %q", messageType)
+		}
+		enabled[messageType] = true
+	}
+	r.enabled = enabled
+	return nil
+}
+
+// Only returns a copy of the registry that handles the given message types and
+// rejects every other message.
+func (r *Registry) Only(messageTypes ...string) (*Registry, error) {
+	only := &Registry{
+		services: r.services,
+		handlers: r.handlers,
+		unknown:  UnknownReject,
+		disabled: UnknownReject,
+	}
+	if err := only.Enable(messageTypes...); err != nil {
+		return nil, err
+	}
+	return only, nil
+}
+
+// Types returns the message types handled by the registry.
+func (r *Registry) Types() []string {
+	var messageTypes []string
+	for messageType := range r.handlers {
+		if r.enabled == nil || r.enabled[messageType] {
+			messageTypes = append(messageTypes, messageType)
+		}
+	}
+	sort.Strings(messageTypes)
+	return messageTypes
+}
+
+// ProcessMessage hands a message to the handler of its type and version.
+// Messages that can never be processed return a *PoisonError, messages left for
+// other workers return ErrReleased, other errors mean the message should be
+// retried. Processing stops when ctx is canceled.
+func (r *Registry) ProcessMessage(ctx context.Context, message types.Message) error {
+	if message.Body == nil {
+		return &PoisonError{Reason: ReasonMalformedMessage, Err: errors.New("message has no body")}
+	}
+
+	messageType := messageAttribute(message, "MessageType")
+	if messageType == "" {
+		return &PoisonError{Reason: ReasonMalformedMessage, Err: errors.New("message has no MessageType attribute")}
+	}
+
+	version := 1
+	if value := messageAttribute(message, "MessageVersion"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return &PoisonError{Reason: ReasonMalformedMessage, Err: fmt.Errorf("invalid MessageVersion attribute: %q", value)}
+		}
+		version = parsed
+	}
+
+	handle, ok := r.handlers[messageType][version]
+	policy := r.unknown
+	if ok && r.enabled != nil && !r.enabled[messageType] {
+		policy = r.disabled
+		ok = false
+	}
+	if !ok {
+		if policy == UnknownRelease {
+			return ErrReleased
+		}
+		return &PoisonError{Reason: ReasonUnsupportedType, Err: fmt.Errorf("message type %q v%d is not handled by this worker (handles %s)", messageType, version, strings.Join(r.Types(), ", "))}
+	}
+
+	// Only registered types are used as labels
+	if _, ok := message.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)]; ok {
+		monitor.QueueWait.WithLabelValues(messageType).Observe(time.Since(sentTimestamp(message)).Seconds())
+	}
+
+	// The trace continues the one of the service that sent the message
+	ctx, span := tracing.Start(tracing.Extract(ctx, message), "process "+messageType,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_sqs"),
+			attribute.String("messaging.message.id", aws.ToString(message.MessageId)),
+			attribute.Int("forge.message.version", version),
+		),
+	)
+	err := handle(ctx, r.services, message)
+	tracing.End(span, err)
+	return err
+}
@@ -2,136 +2,781 @@ package worker
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"forge/internal/database"
+	"forge/internal/deployment"
 	pb "forge/internal/genprotobuf/project"
 	"forge/internal/monitor"
 	"forge/internal/service"
+	"forge/internal/tracing"
 	"forge/internal/utils"
+	"forge/internal/webhook"
 	"log"
-	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/google/uuid"
 )
 
-type Message struct {
-	ProjectId    string `json:"projectId"`
-	RepoURL      string `json:"repoURL"`
-	BuildCommand string `json:"buildCommand"`
+// BuildMessage builds and deploys a project.
+type BuildMessage struct {
+	ProjectId    string            `json:"projectId"`
+	DeploymentId string            `json:"deploymentId"`
+	RepoURL      string            `json:"repoURL"`
+	Ref          string            `json:"ref"`
+	CommitSHA    string            `json:"commitSha"`
+	BuildCommand string            `json:"buildCommand"`
+	Env          map[string]string `json:"env"`
+	// PreviewBranch builds a preview of the branch instead of a deployment
+	// that is made live.
+	PreviewBranch string `json:"previewBranch,omitempty"`
 }
 
-// ProcessMessage takes a message and performs the necessary actions based on the message content.
-func ProcessMessage(
-	message types.Message,
-	workerType string,
-	projectService service.ProjectService,
-	logService service.ProjectLogService,
-) bool {
-	if message.Body == nil || message.MessageAttributes == nil {
-		log.Println("Invalid message received")
-		return false
+func (m *BuildMessage) Validate() error {
+	if m.ProjectId == "" {
+		return errors.New("message has no projectId")
 	}
+	return nil
+}
 
-	messageBody := *message.Body
-	messageType := *message.MessageAttributes["MessageType"].StringValue
+// processBuild builds a deployment, unless it was cancelled or superseded.
+func processBuild(ctx context.Context, services *Services, message types.Message, msg *BuildMessage) error {
+	projectService, db := services.Projects, services.DB
+	policy := services.retryPolicy()
 
-	if messageType != workerType {
-		return false
-	}
+	projectId := msg.ProjectId
+	pushLogs := logPusher(ctx, services.Logs, projectId)
 
-	var msg Message
-	err := json.Unmarshal([]byte(messageBody), &msg)
+	store, err := services.store()
 	if err != nil {
-		log.Printf("Error unmarshaling message body: %v\n", err)
-		return false
+		return err
 	}
 
-	projectId := msg.ProjectId
-	repoURL := msg.RepoURL
-	buildCommand := msg.BuildCommand
+	deploymentId := msg.DeploymentId
+	if deploymentId == "" {
+		// Retries must keep the deployment, so fall back to an id derived from the message
+		deploymentId = uuid.NewSHA1(uuid.NameSpaceOID, []byte(aws.ToString(message.MessageId))).String()
+	}
+
+	record := &deployment.Record{
+		DeploymentId:  deploymentId,
+		ProjectId:     projectId,
+		Status:        deployment.StatusBuilding,
+		RepoURL:       msg.RepoURL,
+		BuildCommand:  msg.BuildCommand,
+		ArtifactsFrom: deploymentId,
+		CreatedAt:     time.Now().UTC(),
+		PreviewBranch: msg.PreviewBranch,
+	}
+	// Previews of a branch are built one at a time, apart from the live deployments
+	leaseKey := projectId
+	finish := func(ctx context.Context, record *deployment.Record) error {
+		if err := finishDeployment(ctx, store, record, pushLogs, projectService); err != nil {
+			return err
+		}
+		pruneArtifacts(ctx, store, projectId, services.retention())
+		return nil
+	}
+	if record.PreviewBranch != "" {
+		leaseKey = database.PreviewLeaseKey(projectId, deployment.PreviewSlug(record.PreviewBranch))
+		finish = func(ctx context.Context, record *deployment.Record) error {
+			if err := finishPreview(ctx, store, services.Previews, services.previewConfig(), record, pushLogs); err != nil {
+				return err
+			}
+			pruneArtifacts(ctx, store, projectId, services.retention())
+			return nil
+		}
+	}
+	ctx, phases := monitor.WithPhaseDurations(ctx)
+	defer func() {
+		// Deployments that are retried are observed and reported once they reach a final status
+		if record.Status != deployment.StatusBuilding {
+			monitor.DeploymentDuration.WithLabelValues(strings.ToLower(record.Status)).Observe(time.Since(record.CreatedAt).Seconds())
+			if err := projectService.ReportDeployment(context.WithoutCancel(ctx), deploymentReport(record, phases)); err != nil {
+				log.Printf("Failed to report deployment: %v", err)
+			}
+			if eventType, ok := webhook.StatusEvent(record.Status); ok {
+				emitEvent(ctx, services.Webhooks, eventType, record)
+			}
+		}
+	}()
+
+	// Only the newest deployment of a project is built, one at a time
+	lease, err := acquireBuildLease(ctx, db, leaseKey, deploymentId, sentTimestamp(message), services.leaseTTL())
+	var cancelled *CancelledError
+	switch {
+	case errors.Is(err, ErrProjectDeleted):
+		log.Printf("Dropped deployment %s of deleted project %s", deploymentId, projectId)
+		return nil
+	case errors.As(err, &cancelled):
+		cancelDeployment(ctx, store, record, cancelled.By, pushLogs, projectService)
+		return nil
+	case errors.Is(err, ErrSuperseded):
+		supersedeDeployment(ctx, store, record, pushLogs)
+		return nil
+	case errors.Is(err, ErrProjectBusy):
+		log.Printf("Deployment %s waits for an older deployment of project %s", deploymentId, projectId)
+		return err
+	case err != nil:
+		return fmt.Errorf("failed to acquire build lease: %w", err)
+	}
+	defer lease.Release()
 
-	ctx := context.Background()
+	// Pushes redelivered after a failure enqueue their deployments again. Failed
+	// deployments are only built again when they are redriven from the dead-letter queue
+	existing, err := store.GetRecord(ctx, projectId, deploymentId)
+	switch {
+	case err == nil && existing.Status == deployment.StatusFailed && redriveId(message) != "":
+		log.Printf("Building failed deployment %s of project %s again, it was redriven", deploymentId, projectId)
+	case err == nil && existing.Status != deployment.StatusBuilding:
+		log.Printf("Dropped deployment %s of project %s, it is %s already", deploymentId, projectId, strings.ToLower(existing.Status))
+		return nil
+	case err != nil && !errors.Is(err, deployment.ErrNotFound):
+		log.Printf("Failed to look up deployment record %s: %v", deploymentId, err)
+	}
+
+	// Previews leave the status of the project alone
+	if record.PreviewBranch == "" {
+		if err := projectService.UpdateProjectStatus(ctx, projectId, pb.ProjectStatus_DEPLOYING); err != nil {
+			log.Printf("Failed to report status: %v", err)
+		}
+	}
+	emitEvent(ctx, services.Webhooks, webhook.EventDeploymentStarted, record)
+
+	buildCtx, stopLease := lease.Keep(ctx)
+	buildCtx, untrack := trackBuild(buildCtx, projectId, deploymentId)
+	err = deploy(buildCtx, services, *msg, record, store, lease, pushLogs, finish)
+	cause := context.Cause(buildCtx)
+	untrack()
+	stopLease()
+	if err == nil {
+		return nil
+	}
 
-	// Push log entry
-	pushLogs := func(logMessage string) {
+	switch {
+	case errors.Is(cause, ErrProjectDeleted), errors.Is(err, ErrProjectDeleted):
+		// Nothing is recorded, the artifacts of the project are being deleted
+		log.Printf("Stopped deployment %s of deleted project %s", record.DeploymentId, projectId)
+		return nil
+	case errors.As(cause, &cancelled), errors.As(err, &cancelled):
+		// Cleaning up the cancelled build already happened in deploy
+		cancelDeployment(context.WithoutCancel(ctx), store, record, cancelled.By, pushLogs, projectService)
+		return nil
+	case errors.Is(cause, ErrLeaseLost):
+		// Another worker receives the message and builds it
+		log.Printf("Stopped deployment %s: %v", record.DeploymentId, cause)
+		return cause
+	case errors.Is(cause, ErrSuperseded), errors.Is(err, ErrSuperseded):
+		supersedeDeployment(context.WithoutCancel(ctx), store, record, pushLogs)
+		return nil
+	case errors.Is(cause, ErrShuttingDown):
+		// Released back to the queue, the deployment stays pending and the
+		// interrupted build does not count as a failed attempt
+		log.Printf("Stopped deployment %s: %v", record.DeploymentId, cause)
+		pushLogs("The worker building this deployment is shutting down, another worker builds it again")
+		return cause
+	case errors.Is(cause, ErrBuildLeaseLost):
+		// Retried like any other internal error
+		err = cause
+	case errors.Is(cause, ErrVisibilityCeiling):
+		err = &utils.BuildError{Reason: utils.ReasonTimeout, Err: cause}
+	}
+	// The deployment is recorded and reported even when processing was canceled
+	ctx = context.WithoutCancel(ctx)
+
+	// Failures caused by the project are final, retrying would fail the same way
+	if utils.FailureReason(err) != utils.ReasonInternal {
+		failDeployment(ctx, store, record, err, pushLogs, projectService)
+		return nil
+	}
+
+	// Receives of the message also count the times it waited for the build lease
+	attempt, countErr := db.RecordBuildFailure(ctx, projectId, record.DeploymentId, redriveId(message))
+	if countErr != nil {
+		log.Printf("Failed to record failed attempt of deployment %s: %v", record.DeploymentId, countErr)
+		attempt = receiveCount(message)
+	}
+	if attempt >= policy.MaxAttempts {
+		failDeployment(ctx, store, record, err, pushLogs, projectService)
+		return &AttemptError{Attempt: attempt, Err: err}
+	}
+
+	log.Printf("Deployment %s failed on attempt %d of %d, retrying: %v", record.DeploymentId, attempt, policy.MaxAttempts, err)
+	pushLogs(fmt.Sprintf("Deployment failed with an internal error, retrying (attempt %d of %d)", attempt, policy.MaxAttempts))
+	return &AttemptError{Attempt: attempt, Err: err}
+}
+
+// deploymentReport returns what launchpad records of a finished deployment.
+func deploymentReport(record *deployment.Record, phases *monitor.PhaseDurations) *pb.ReportDeploymentRequest {
+	report := &pb.ReportDeploymentRequest{
+		ProjectId:     record.ProjectId,
+		DeploymentId:  record.DeploymentId,
+		Status:        record.Status,
+		CommitSha:     record.CommitSHA,
+		OutputBytes:   record.OutputBytes,
+		FileCount:     int64(record.OutputFiles),
+		Framework:     record.Framework,
+		NodeVersion:   record.NodeVersion,
+		FailureReason: record.FailureReason,
+		CreatedAt:     record.CreatedAt.UnixMilli(),
+		PreviewUrl:    record.PreviewURL,
+	}
+	if !record.FinishedAt.IsZero() {
+		report.FinishedAt = record.FinishedAt.UnixMilli()
+	}
+
+	durations := phases.All()
+	for _, phase := range []string{monitor.PhaseClone, monitor.PhaseInstall, monitor.PhaseBuild, monitor.PhaseCopy, monitor.PhaseUpload} {
+		if duration, ok := durations[phase]; ok {
+			report.PhaseDurations = append(report.PhaseDurations, &pb.PhaseDuration{Phase: phase, DurationMs: duration.Milliseconds()})
+		}
+	}
+	return report
+}
+
+// emitEvent sends a deployment event to the webhooks of its project.
+func emitEvent(ctx context.Context, webhooks webhook.Emitter, eventType string, record *deployment.Record) {
+	if webhooks == nil {
+		return
+	}
+	if err := webhooks.Emit(context.WithoutCancel(ctx), eventType, record); err != nil {
+		log.Printf("Failed to emit %s event of deployment %s: %v", eventType, record.DeploymentId, err)
+	}
+}
+
+// logPusher returns a function pushing log entries of a project.
+func logPusher(ctx context.Context, logService service.ProjectLogService, projectId string) func(string) {
+	return func(logMessage string) {
 		logEntry := service.LogEntry{
 			Log:       logMessage,
 			Timestamp: time.Now().Unix(),
 		}
-		logService.PushLogs(projectId, logEntry)
+		logService.PushLogs(ctx, projectId, logEntry)
+	}
+}
+
+// store returns the deployment store of the services.
+func (s *Services) store() (*deployment.Store, error) {
+	if s.Store == nil {
+		return nil, errors.New("no deployment store")
+	}
+	return s.Store, nil
+}
+
+// retention returns how many successful deployments keep their artifacts.
+func (s *Services) retention() int {
+	if s.Retention > 0 {
+		return s.Retention
+	}
+	return deployment.DefaultRetention
+}
+
+// repos returns the repository URL validator of the services, or one for the
+// default hosts.
+func (s *Services) repos() *utils.RepoURLValidator {
+	if s.Repos != nil {
+		return s.Repos
+	}
+	return utils.NewRepoURLValidator(utils.DefaultAllowedGitHosts)
+}
+
+// retryPolicy returns the retry policy of builds.
+func (s *Services) retryPolicy() *RetryPolicy {
+	if s.Retry != nil {
+		return s.Retry
+	}
+	return DefaultRetryPolicy()
+}
+
+// leaseTTL returns how long a build lease is valid without being renewed.
+func (s *Services) leaseTTL() time.Duration {
+	if s.LeaseTTL > 0 {
+		return s.LeaseTTL
+	}
+	return DefaultLeaseTTL
+}
+
+// previewConfig returns the settings of branch previews.
+func (s *Services) previewConfig() *PreviewConfig {
+	if s.PreviewConfig != nil {
+		return s.PreviewConfig
+	}
+	return DefaultPreviewConfig()
+}
+
+// sandbox returns the limits of the build containers.
+func (s *Services) sandbox() *utils.SandboxConfig {
+	if s.Sandbox != nil {
+		return s.Sandbox
+	}
+	return utils.DefaultSandboxConfig()
+}
+
+// deploy builds a deployment, or reuses the artifacts of an earlier deployment
+// built from the same inputs, and hands it to finish to be published.
+func deploy(
+	ctx context.Context,
+	services *Services,
+	msg BuildMessage,
+	record *deployment.Record,
+	store *deployment.Store,
+	lease *buildLease,
+	pushLogs func(string),
+	finish func(context.Context, *deployment.Record) error,
+) error {
+	attestation, sandbox := services.Provenance, services.sandbox()
+
+	// Reject repositories we must not clone before anything reaches Docker
+	remote, err := services.repos().Resolve(ctx, msg.RepoURL)
+	if err != nil {
+		return &utils.BuildError{Reason: utils.ReasonInvalidRepoURL, Err: err}
+	}
+
+	spec := utils.BuildSpec{
+		ProjectId:    msg.ProjectId,
+		RepoURL:      msg.RepoURL,
+		Remote:       remote,
+		Ref:          msg.Ref,
+		BuildCommand: msg.BuildCommand,
+		Env:          msg.Env,
+	}
+	if msg.CommitSHA != "" {
+		spec.Ref = msg.CommitSHA
+	}
+
+	fingerprint, err := utils.PrepareBuild(ctx, &spec, sandbox)
+	if err != nil {
+		return err
+	}
+	record.Fingerprint = fingerprint
+	record.CommitSHA = spec.CommitSHA
+
+	cached, err := store.FindByFingerprint(ctx, record.ProjectId, fingerprint)
+	switch {
+	case err == nil:
+		monitor.BuildCache.WithLabelValues("hit").Inc()
+		pushLogs(fmt.Sprintf("Deployment %s was built from the same inputs, promoting it instead of building", cached.DeploymentId))
+		record.ArtifactsFrom = cached.ArtifactsFrom
+		record.BuilderImage = cached.BuilderImage
+		record.Framework = cached.Framework
+		record.NodeVersion = cached.NodeVersion
+		record.OutputFiles = cached.OutputFiles
+		record.OutputBytes = cached.OutputBytes
+		if err := attestDeployment(ctx, attestation, store, msg, record, nil, pushLogs); err != nil {
+			return err
+		}
+		if err := lease.Check(ctx); err != nil {
+			return err
+		}
+		return finish(context.WithoutCancel(ctx), record)
+	case deployment.IsNotFound(err):
+		monitor.BuildCache.WithLabelValues("miss").Inc()
+	default:
+		log.Printf("Failed to look up build fingerprint, building: %v", err)
 	}
 
-	cli, buildDir, imageName, err := utils.BuildProject(ctx, repoURL, buildCommand, pushLogs)
+	if err := store.SaveRecord(ctx, record); err != nil {
+		log.Printf("Failed to save deployment record %s: %v", record.DeploymentId, err)
+	}
+
+	cli, result, err := utils.BuildProject(ctx, spec, sandbox, pushLogs)
 	if err != nil {
-		log.Fatalf("Failed to build project: %v", err)
+		return err
 	}
+	defer func() {
+		if err := utils.Cleanup(context.Background(), cli, result, sandbox); err != nil {
+			log.Printf("Cleanup failed: %v", err)
+		}
+	}()
 
-	// Deploying to S3
-	bucketName := os.Getenv("AWS_BUCKET_NAME")
-	prefix := fmt.Sprintf("projects/%s/build/", projectId)
+	record.BuilderImage = result.DepsImageID
+	record.Framework = result.Framework
+	record.NodeVersion = result.NodeVersion
+
+	monitor.DepsCache.WithLabelValues(result.DepsCache).Inc()
 
-	s3Client, err := utils.GetS3Service()
+	// Deploying to S3
+	endTimer := monitor.TimePhase(ctx, monitor.PhaseUpload)
+	uploadCtx, endSpan := tracing.StartPhase(ctx, monitor.PhaseUpload)
+	stats, err := store.Upload(uploadCtx, record.ProjectId, record.DeploymentId, result.OutputDir)
+	endSpan(err)
+	endTimer(err)
+	monitor.UploadedFiles.Add(float64(stats.Files))
+	monitor.UploadedBytes.Add(float64(stats.Bytes))
 	if err != nil {
-		log.Fatalf("Failed to create S3 client: %v", err)
+		return fmt.Errorf("failed to upload build output: %w", err)
 	}
+	record.OutputFiles = stats.Files
+	record.OutputBytes = stats.Bytes
 
-	if err := utils.UploadToS3(ctx, buildDir, bucketName, prefix, s3Client); err != nil {
-		log.Fatalf("Failed to upload files to S3: %v", err)
+	// Deployments are only published with their provenance
+	if err := attestDeployment(ctx, attestation, store, msg, record, stats.Digests, pushLogs); err != nil {
+		return err
 	}
 
-	utils.Cleanup(ctx, cli, buildDir, imageName)
+	// A newer deployment must not be replaced by this one, and promoting is not interrupted halfway
+	if err := lease.Check(ctx); err != nil {
+		return err
+	}
+	return finish(context.WithoutCancel(ctx), record)
+}
+
+// finishDeployment promotes a successful deployment and marks the project live.
+func finishDeployment(ctx context.Context, store *deployment.Store, record *deployment.Record, pushLogs func(string), projectService service.ProjectService) error {
+	record.Status = deployment.StatusSucceeded
+	if err := store.Promote(ctx, record); err != nil {
+		record.Status = deployment.StatusBuilding
+		return fmt.Errorf("failed to promote deployment: %w", err)
+	}
+
+	record.FinishedAt = time.Now().UTC()
+	if err := store.SaveRecord(ctx, record); err != nil {
+		log.Printf("Failed to save deployment record %s: %v", record.DeploymentId, err)
+	}
+
+	pushLogs(fmt.Sprintf("Deployment %s is live", record.DeploymentId))
 
 	// Update launchpad as the project is deployed
-	projectService.UpdateProjectStatus(projectId, pb.ProjectStatus_LIVE)
-	return true
+	if err := projectService.UpdateProjectStatus(ctx, record.ProjectId, pb.ProjectStatus_LIVE); err != nil {
+		log.Printf("Failed to report status: %v", err)
+	}
+	return nil
 }
 
-// Run listens to an SQS queue and processes messages.
-func Run(
-	queueURL string,
-	workerType string,
-	projectService service.ProjectService,
-	logService service.ProjectLogService,
-) {
-	sqsSvc, err := utils.GetSQSService()
+// pruneArtifacts removes the artifacts of a project that are no longer served
+// and too old to roll back to. Failing to prune only leaves them for later.
+func pruneArtifacts(ctx context.Context, store *deployment.Store, projectId string, keep int) {
+	removed, err := store.Prune(ctx, projectId, keep)
 	if err != nil {
-		log.Fatalf("Failed to get SQS service %v", err)
+		log.Printf("Failed to prune artifacts of project %s: %v", projectId, err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("Pruned %d artifacts of old deployments of project %s", removed, projectId)
+	}
+}
+
+// supersedeDeployment records a deployment that was skipped or stopped because
+// a newer deployment of the project was requested.
+func supersedeDeployment(ctx context.Context, store *deployment.Store, record *deployment.Record, pushLogs func(string)) {
+	record.Status = deployment.StatusSuperseded
+	record.FinishedAt = time.Now().UTC()
+	if err := store.SaveRecord(ctx, record); err != nil {
+		log.Printf("Failed to save deployment record %s: %v", record.DeploymentId, err)
+	}
+
+	log.Printf("Deployment %s of project %s was superseded", record.DeploymentId, record.ProjectId)
+	pushLogs(fmt.Sprintf("Deployment %s was superseded by a newer deployment", record.DeploymentId))
+}
+
+// cancelDeployment records a deployment that was cancelled before it was
+// promoted and reports who cancelled it.
+func cancelDeployment(ctx context.Context, store *deployment.Store, record *deployment.Record, cancelledBy string, pushLogs func(string), projectService service.ProjectService) {
+	record.Status = deployment.StatusCancelled
+	record.CancelledBy = cancelledBy
+	record.FinishedAt = time.Now().UTC()
+	if err := store.SaveRecord(ctx, record); err != nil {
+		log.Printf("Failed to save deployment record %s: %v", record.DeploymentId, err)
+	}
+
+	log.Printf("Deployment %s of project %s was cancelled by %s", record.DeploymentId, record.ProjectId, cancelledBy)
+	pushLogs(fmt.Sprintf("Deployment %s was cancelled by %s", record.DeploymentId, cancelledBy))
+	if record.PreviewBranch != "" {
+		// Previews leave the status of the project alone
+		return
+	}
+	if err := projectService.UpdateProjectStatus(ctx, record.ProjectId, pb.ProjectStatus_CANCELLED); err != nil {
+		log.Printf("Failed to report status: %v", err)
+	}
+}
+
+// failDeployment records a failed deployment and reports it.
+func failDeployment(ctx context.Context, store *deployment.Store, record *deployment.Record, err error, pushLogs func(string), projectService service.ProjectService) {
+	record.Status = deployment.StatusFailed
+	record.FailureReason = utils.FailureReason(err)
+	record.FinishedAt = time.Now().UTC()
+	if err := store.SaveRecord(ctx, record); err != nil {
+		log.Printf("Failed to save deployment record %s: %v", record.DeploymentId, err)
+	}
+
+	if record.PreviewBranch != "" {
+		// Previews leave the status of the project alone
+		projectService = nil
+	}
+	reportBuildFailure(ctx, record.ProjectId, err, pushLogs, projectService)
+}
+
+// reportBuildFailure reports a failed build to the build logs, and to
+// launchpad unless projectService is nil.
+func reportBuildFailure(ctx context.Context, projectId string, err error, pushLogs func(string), projectService service.ProjectService) {
+	reason := utils.FailureReason(err)
+	log.Printf("Failed to build project %s [reason: %s]: %v", projectId, reason, err)
+	pushLogs(fmt.Sprintf("Build failed (%s): %v", reason, err))
+	monitor.BuildFailures.WithLabelValues(reason).Inc()
+	if projectService == nil {
+		return
+	}
+	if err := projectService.UpdateProjectStatus(ctx, projectId, pb.ProjectStatus_NOT_LIVE); err != nil {
+		log.Printf("Failed to report status: %v", err)
+	}
+}
+
+// QueueConfig locates the queues a worker receives messages from and controls
+// how they are processed.
+type QueueConfig struct {
+	URL string
+	// ControlURL is the queue Cancel messages are also received from while a
+	// build runs, there is none when it is empty.
+	ControlURL string
+	// DeadLetterURL keeps the messages that failed for good, they are deleted
+	// when it is empty.
+	DeadLetterURL string
+	Retry         *RetryPolicy
+	Heartbeat     *HeartbeatConfig
+	DrainTimeout  time.Duration
+}
+
+// DefaultQueueConfig returns the config of the queue at url, with the default
+// retry policy, heartbeat and drain timeout.
+func DefaultQueueConfig(url string) *QueueConfig {
+	return &QueueConfig{
+		URL:          url,
+		Retry:        DefaultRetryPolicy(),
+		Heartbeat:    DefaultHeartbeatConfig(),
+		DrainTimeout: DefaultDrainTimeout,
+	}
+}
+
+// Run listens to an SQS queue and processes messages until ctx is done. It
+// then stops receiving messages and returns once the in-flight messages
+// finished, or were released back to the queue after the drain timeout.
+func Run(ctx context.Context, sqsSvc *sqs.Client, cfg *QueueConfig, registry *Registry) {
+	monitor.Health.AddHealth("queue", QueueCheck(sqsSvc, cfg.URL))
+	monitor.Health.AddReadiness("worker", ReadinessCheck)
+
+	RunQueue(ctx, sqsSvc, cfg, registry)
+}
+
+// RunQueue processes the messages of a queue read through sqsSvc until ctx is
+// done, the way Run does.
+func RunQueue(ctx context.Context, sqsSvc SQSAPI, cfg *QueueConfig, registry *Registry) {
+	queueURL, policy, heartbeat := cfg.URL, cfg.Retry, cfg.Heartbeat
+	deadLetters := NewDeadLetterQueue(sqsSvc, queueURL, cfg.DeadLetterURL)
+
+	processCtx, stopProcessing := drainContext(ctx, cfg.DrainTimeout)
+	defer stopProcessing()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// Builds keep the build queue busy, so Cancel messages are also received from
+	// a control queue while a build runs
+	if controlURL := cfg.ControlURL; controlURL != "" {
+		control, err := registry.Only(MessageTypeCancel)
+		if err != nil {
+			log.Fatalf("Failed to set up control queue: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runControl(ctx, processCtx, sqsSvc, controlURL, policy, control)
+		}()
+	}
+
+	fmt.Printf("[Types: %s] Listening to SQS: %v\n", strings.Join(registry.Types(), ", "), queueURL)
+
+	for ctx.Err() == nil {
+		result, err := sqsSvc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl: &queueURL,
+			// Messages are built one at a time, others would wait invisible until their turn
+			MaxNumberOfMessages:   buildSlots,
+			VisibilityTimeout:     int32(heartbeat.VisibilityTimeout.Seconds()),
+			WaitTimeSeconds:       20,
+			MessageAttributeNames: []string{"All"},
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+				types.MessageSystemAttributeNameApproximateReceiveCount,
+				types.MessageSystemAttributeNameSentTimestamp,
+				types.MessageSystemAttributeNameSenderId,
+			},
+		})
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Fatalf("ReceiveMessage failed %v", err)
+		}
+
+		for _, message := range result.Messages {
+			messageCtx, stop := KeepVisible(processCtx, sqsSvc, queueURL, message, heartbeat)
+			err := registry.ProcessMessage(messageCtx, message)
+			stop()
+			settleMessage(context.TODO(), sqsSvc, queueURL, deadLetters, policy, message, err)
+		}
 	}
 
-	fmt.Printf("[Type: %s] Listening to SQS: %v\n", workerType, queueURL)
+	log.Printf("Stopped receiving messages from %s", queueURL)
+}
+
+// runControl listens to the control queue and processes Cancel messages until
+// ctx is done.
+func runControl(ctx, processCtx context.Context, sqsSvc SQSAPI, queueURL string, policy *RetryPolicy, registry *Registry) {
+	// Control messages are never worth keeping once they fail for good
+	deadLetters := NewDeadLetterQueue(sqsSvc, queueURL, "")
 
-	for {
-		result, err := sqsSvc.ReceiveMessage(context.TODO(), &sqs.ReceiveMessageInput{
+	fmt.Printf("[Type: %s] Listening to SQS: %v\n", MessageTypeCancel, queueURL)
+
+	for ctx.Err() == nil {
+		result, err := sqsSvc.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
 			QueueUrl:              &queueURL,
 			MaxNumberOfMessages:   10,
-			VisibilityTimeout:     300,
 			WaitTimeSeconds:       20,
 			MessageAttributeNames: []string{"All"},
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{
+				types.MessageSystemAttributeNameApproximateReceiveCount,
+				types.MessageSystemAttributeNameSentTimestamp,
+				types.MessageSystemAttributeNameSenderId,
+			},
 		})
+		if ctx.Err() != nil {
+			break
+		}
 		if err != nil {
 			log.Fatalf("ReceiveMessage failed %v", err)
 		}
 
 		for _, message := range result.Messages {
-			messageStatus := ProcessMessage(message, workerType, projectService, logService)
-			if !messageStatus {
-				log.Printf("Failed to process message [message id: %s]\n", *message.MessageId)
-				break
-			}
+			err := registry.ProcessMessage(processCtx, message)
+			settleMessage(context.TODO(), sqsSvc, queueURL, deadLetters, policy, message, err)
+		}
+	}
+}
 
-			// Delete the message from the queue after processing
-			_, err = sqsSvc.DeleteMessage(context.TODO(), &sqs.DeleteMessageInput{
-				QueueUrl:      &queueURL,
-				ReceiptHandle: message.ReceiptHandle,
-			})
-			if err != nil {
-				log.Printf("DeleteMessage Failed %v", err)
-			}
+// settleMessage deletes a processed message, dead-letters a message that
+// cannot be processed and schedules a retry for the others.
+func settleMessage(
+	ctx context.Context,
+	sqsSvc SQSAPI,
+	queueURL string,
+	deadLetters *DeadLetterQueue,
+	policy *RetryPolicy,
+	message types.Message,
+	err error,
+) {
+	messageId := aws.ToString(message.MessageId)
+	attempt := receiveCount(message)
+	var attemptErr *AttemptError
+	if errors.As(err, &attemptErr) {
+		attempt = attemptErr.Attempt
+	}
+
+	if errors.Is(err, ErrLeaseLost) {
+		// The receipt handle is no longer valid, the message is already visible again
+		monitor.ProcessedMessages.WithLabelValues("lease_lost").Inc()
+		return
+	}
 
-			// Increment the metric when a message is processed
-			monitor.ProcessedMessages.WithLabelValues("success").Inc()
+	if errors.Is(err, ErrReleased) {
+		_, err := sqsSvc.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          &queueURL,
+			ReceiptHandle:     message.ReceiptHandle,
+			VisibilityTimeout: int32(releaseDelay.Seconds()),
+		})
+		if err != nil {
+			log.Printf("ChangeMessageVisibility failed %v", err)
 		}
+		monitor.ProcessedMessages.WithLabelValues("released").Inc()
+		return
+	}
+
+	if errors.Is(err, ErrShuttingDown) {
+		// Visible again right away, so another worker takes over the message
+		_, err := sqsSvc.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          &queueURL,
+			ReceiptHandle:     message.ReceiptHandle,
+			VisibilityTimeout: 0,
+		})
+		if err != nil {
+			log.Printf("ChangeMessageVisibility failed %v", err)
+		}
+		monitor.ProcessedMessages.WithLabelValues("shutdown").Inc()
+		return
+	}
+
+	if errors.Is(err, ErrProjectBusy) {
+		// Not a failure, the message is retried once the older deployment stopped
+		_, err := sqsSvc.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          &queueURL,
+			ReceiptHandle:     message.ReceiptHandle,
+			VisibilityTimeout: int32(busyRetryDelay.Seconds()),
+		})
+		if err != nil {
+			log.Printf("ChangeMessageVisibility failed %v", err)
+		}
+		monitor.ProcessedMessages.WithLabelValues("busy").Inc()
+		return
+	}
+
+	if err == nil {
+		deleteMessage(ctx, sqsSvc, queueURL, message)
+		// Increment the metric when a message is processed
+		monitor.ProcessedMessages.WithLabelValues("success").Inc()
+		return
+	}
+
+	var poisonErr *PoisonError
+	reason := ""
+	switch {
+	case errors.As(err, &poisonErr):
+		reason = poisonErr.Reason
+	case attempt >= policy.MaxAttempts:
+		reason = ReasonMaxAttemptsReached
+	}
+
+	if reason == "" {
+		delay := policy.Delay(attempt)
+		log.Printf("Failed to process message [message id: %s, attempt %d of %d], retrying in %s: %v\n", messageId, attempt, policy.MaxAttempts, delay, err)
+		_, err := sqsSvc.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          &queueURL,
+			ReceiptHandle:     message.ReceiptHandle,
+			VisibilityTimeout: int32(delay.Seconds()),
+		})
+		if err != nil {
+			log.Printf("ChangeMessageVisibility failed %v", err)
+		}
+		monitor.ProcessedMessages.WithLabelValues("retry").Inc()
+		return
+	}
+
+	log.Printf("Dead-lettering message [message id: %s, reason: %s, attempt %d]: %v\n", messageId, reason, attempt, err)
+	dlqErr := deadLetters.Send(ctx, message, reason, err)
+	if errors.Is(dlqErr, ErrNoDeadLetterQueue) {
+		log.Printf("No dead-letter queue configured, dropping message %s", messageId)
+		deleteMessage(ctx, sqsSvc, queueURL, message)
+		monitor.ProcessedMessages.WithLabelValues("failure").Inc()
+		return
+	}
+	if dlqErr != nil {
+		// Keep the message, SQS redelivers it once the visibility timeout expires
+		log.Printf("Failed to dead-letter message %s: %v", messageId, dlqErr)
+		monitor.ProcessedMessages.WithLabelValues("failure").Inc()
+		return
+	}
+	deleteMessage(ctx, sqsSvc, queueURL, message)
+	monitor.DeadLetters.WithLabelValues(reason).Inc()
+	monitor.ProcessedMessages.WithLabelValues("dead_letter").Inc()
+}
+
+func deleteMessage(ctx context.Context, sqsSvc SQSAPI, queueURL string, message types.Message) {
+	_, err := sqsSvc.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      &queueURL,
+		ReceiptHandle: message.ReceiptHandle,
+	})
+	if err != nil {
+		log.Printf("DeleteMessage Failed %v", err)
 	}
 }
@@ -0,0 +1,10 @@
+CREATE TABLE build_leases (
+    project_id TEXT PRIMARY KEY,
+    -- The newest deployment requested for the project
+    latest_deployment_id TEXT NOT NULL,
+    latest_requested_at TIMESTAMPTZ NOT NULL,
+    -- The deployment currently being built, if any
+    active_deployment_id TEXT,
+    holder TEXT,
+    expires_at TIMESTAMPTZ
+);
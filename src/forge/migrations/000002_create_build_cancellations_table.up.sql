@@ -0,0 +1,7 @@
+CREATE TABLE build_cancellations (
+    deployment_id TEXT PRIMARY KEY,
+    project_id TEXT NOT NULL,
+    -- Who asked for the cancellation, as sent in the Cancel message
+    cancelled_by TEXT NOT NULL,
+    cancelled_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
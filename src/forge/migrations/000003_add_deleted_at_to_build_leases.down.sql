@@ -0,0 +1 @@
+ALTER TABLE build_leases DROP COLUMN IF EXISTS deleted_at;
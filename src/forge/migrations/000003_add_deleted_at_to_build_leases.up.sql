@@ -0,0 +1,2 @@
+-- Set once the project is deleted, builds of the project are dropped afterwards
+ALTER TABLE build_leases ADD COLUMN deleted_at TIMESTAMPTZ;
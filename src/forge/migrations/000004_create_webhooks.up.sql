@@ -0,0 +1,34 @@
+CREATE TABLE webhook_endpoints (
+    id TEXT PRIMARY KEY,
+    project_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    -- Key of the HMAC-SHA256 signatures, only shown when the endpoint is created
+    secret TEXT NOT NULL,
+    -- Event types sent to the endpoint, every type when empty
+    events JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX webhook_endpoints_project_id_idx ON webhook_endpoints (project_id);
+
+CREATE TABLE webhook_deliveries (
+    id TEXT PRIMARY KEY,
+    endpoint_id TEXT NOT NULL REFERENCES webhook_endpoints (id) ON DELETE CASCADE,
+    project_id TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    -- pending until delivered, failed once every attempt failed
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    response_status INT,
+    last_error TEXT,
+    -- The delivery this one sends again, if it is a replay
+    replay_of TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at TIMESTAMPTZ
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_project_id_idx ON webhook_deliveries (project_id, created_at DESC);
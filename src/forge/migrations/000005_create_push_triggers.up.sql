@@ -0,0 +1,15 @@
+CREATE TABLE push_triggers (
+    project_id TEXT PRIMARY KEY,
+    -- Host and path of the repository, lowercased and without .git
+    repo TEXT NOT NULL,
+    repo_url TEXT NOT NULL,
+    -- Pushes to this branch are deployed
+    branch TEXT NOT NULL,
+    build_command TEXT NOT NULL DEFAULT '',
+    -- Verifies the signature of push webhooks, only shown when the trigger is created
+    secret TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX push_triggers_repo_idx ON push_triggers (repo);
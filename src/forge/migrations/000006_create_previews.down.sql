@@ -0,0 +1,2 @@
+ALTER TABLE push_triggers DROP COLUMN IF EXISTS previews;
+DROP TABLE IF EXISTS previews;
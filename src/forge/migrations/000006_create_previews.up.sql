@@ -0,0 +1,19 @@
+CREATE TABLE previews (
+    project_id TEXT NOT NULL,
+    -- Slug of the branch, the preview is served at <slug>--<project_id>
+    slug TEXT NOT NULL,
+    branch TEXT NOT NULL,
+    -- Deployment whose files are served
+    deployment_id TEXT NOT NULL,
+    url TEXT NOT NULL DEFAULT '',
+    -- Previews are deleted once they expire, every build of the branch extends it
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (project_id, slug)
+);
+
+CREATE INDEX previews_expires_at_idx ON previews (expires_at);
+
+-- Pushes to other branches than the deployed one build previews
+ALTER TABLE push_triggers ADD COLUMN previews BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS build_attempts;
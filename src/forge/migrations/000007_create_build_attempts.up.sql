@@ -0,0 +1,10 @@
+CREATE TABLE build_attempts (
+    deployment_id TEXT PRIMARY KEY,
+    project_id TEXT NOT NULL,
+    -- Builds that failed with an internal error, waiting for the build lease
+    -- of the project is not counted
+    failures INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX build_attempts_project_id_idx ON build_attempts (project_id);
@@ -0,0 +1,9 @@
+CREATE TABLE push_deliveries (
+    -- SHA-256 of the webhook body, a replayed body is ignored whatever its headers
+    digest TEXT PRIMARY KEY,
+    -- Delivery id sent by the git host, a redelivery keeps it
+    delivery TEXT UNIQUE,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX push_deliveries_received_at_idx ON push_deliveries (received_at);
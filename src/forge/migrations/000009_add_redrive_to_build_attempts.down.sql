@@ -0,0 +1 @@
+ALTER TABLE build_attempts DROP COLUMN IF EXISTS redrive_id;
@@ -0,0 +1,3 @@
+-- The redrive the failures were counted for, the count starts again when a
+-- deployment is redriven from the dead-letter queue
+ALTER TABLE build_attempts ADD COLUMN redrive_id TEXT NOT NULL DEFAULT '';
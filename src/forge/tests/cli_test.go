@@ -0,0 +1,173 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"forge/internal/cli"
+	"forge/internal/deployment"
+	"forge/internal/worker"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCLIQueue struct {
+	builds    []*worker.BuildMessage
+	rollbacks []*worker.RollbackMessage
+}
+
+func (f *fakeCLIQueue) Enqueue(ctx context.Context, msg *worker.BuildMessage) (string, error) {
+	f.builds = append(f.builds, msg)
+	return "build-message", nil
+}
+
+func (f *fakeCLIQueue) EnqueueRollback(ctx context.Context, msg *worker.RollbackMessage) (string, error) {
+	f.rollbacks = append(f.rollbacks, msg)
+	return "rollback-message", nil
+}
+
+func writeProfiles(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadProfile(t *testing.T) {
+	t.Setenv("AETHER_PROFILE", "")
+	path := writeProfiles(t, `
+default_profile: staging
+profiles:
+  staging:
+    logify_url: http://logify.staging
+  prod:
+    logify_url: http://logify.prod
+    output: json
+`)
+
+	profile, err := cli.LoadProfile(path, "")
+	require.NoError(t, err)
+	assert.Equal(t, "staging", profile.Name)
+	assert.Equal(t, "text", profile.Output)
+
+	t.Setenv("AETHER_PROFILE", "prod")
+	profile, err = cli.LoadProfile(path, "")
+	require.NoError(t, err)
+	assert.Equal(t, "http://logify.prod", profile.LogifyURL)
+	assert.Equal(t, "json", profile.Output)
+
+	_, err = cli.LoadProfile(path, "missing")
+	assert.ErrorContains(t, err, `profile "missing" not found`)
+}
+
+func TestLogTail(t *testing.T) {
+	var tail cli.LogTail
+	// Logify serves the newest lines first
+	first := tail.New([]cli.LogEntry{{Timestamp: 2, Log: "b"}, {Timestamp: 2, Log: "b"}, {Timestamp: 1, Log: "a"}})
+	assert.Equal(t, []cli.LogEntry{{Timestamp: 1, Log: "a"}, {Timestamp: 2, Log: "b"}, {Timestamp: 2, Log: "b"}}, first)
+
+	// Only lines not seen before are returned, even within the same second
+	next := tail.New([]cli.LogEntry{{Timestamp: 3, Log: "c"}, {Timestamp: 2, Log: "b"}, {Timestamp: 2, Log: "b"}, {Timestamp: 2, Log: "b"}, {Timestamp: 1, Log: "a"}})
+	assert.Equal(t, []cli.LogEntry{{Timestamp: 2, Log: "b"}, {Timestamp: 3, Log: "c"}}, next)
+	assert.Empty(t, tail.New(nil))
+}
+
+func TestCLILogs(t *testing.T) {
+	logify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/p1/logs", r.URL.Path)
+		assert.Equal(t, "2", r.URL.Query().Get("limit"))
+		w.Write([]byte(`[{"projectId":"p1","timestamp":20,"log":"done"},{"projectId":"p1","timestamp":10,"log":"building"}]`))
+	}))
+	defer logify.Close()
+
+	var out bytes.Buffer
+	c := &cli.CLI{Profile: &cli.Profile{LogifyURL: logify.URL}, Out: &out, JSON: true}
+	require.NoError(t, c.Logs(context.Background(), "p1", 2, false, time.Second))
+
+	decoder := json.NewDecoder(&out)
+	var entry cli.LogEntry
+	require.NoError(t, decoder.Decode(&entry))
+	assert.Equal(t, "building", entry.Log)
+	require.NoError(t, decoder.Decode(&entry))
+	assert.Equal(t, "done", entry.Log)
+
+	c.Profile.LogifyURL = ""
+	assert.ErrorContains(t, c.Logs(context.Background(), "p1", 2, false, time.Second), "logify_url is not set")
+}
+
+func TestCLIDeploy(t *testing.T) {
+	forgeAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/projects/p1/push-trigger", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"projectId":"p1","repoURL":"https://github.com/acme/site","branch":"main","buildCommand":"npm run build"}`))
+	}))
+	defer forgeAPI.Close()
+
+	queue := &fakeCLIQueue{}
+	var out bytes.Buffer
+	c := &cli.CLI{Profile: &cli.Profile{APIURL: forgeAPI.URL, APIToken: "token"}, Out: &out, JSON: true, Queue: queue}
+
+	// The repository and build command come from the push trigger
+	require.NoError(t, c.Deploy(context.Background(), cli.DeployOptions{ProjectId: "p1", CommitSHA: "abc"}))
+	require.Len(t, queue.builds, 1)
+	msg := queue.builds[0]
+	assert.Equal(t, "https://github.com/acme/site", msg.RepoURL)
+	assert.Equal(t, "main", msg.Ref)
+	assert.Equal(t, "abc", msg.CommitSHA)
+	assert.Equal(t, "npm run build", msg.BuildCommand)
+
+	var job cli.QueuedJob
+	require.NoError(t, json.Unmarshal(out.Bytes(), &job))
+	assert.Equal(t, cli.QueuedJob{ProjectId: "p1", DeploymentId: msg.DeploymentId, MessageId: "build-message"}, job)
+
+	out.Reset()
+	c.JSON = false
+	require.NoError(t, c.Rollback(context.Background(), "p1", ""))
+	assert.Equal(t, []*worker.RollbackMessage{{ProjectId: "p1"}}, queue.rollbacks)
+	assert.Contains(t, out.String(), "Queued rollback of project p1")
+}
+
+func TestCLIListDeploymentsAndOpen(t *testing.T) {
+	ctx := context.Background()
+	bucket := newMemBucket()
+	store := deployment.NewStore(bucket)
+
+	now := time.Now()
+	old := &deployment.Record{DeploymentId: "d1", ProjectId: "p1", Status: deployment.StatusSucceeded, ArtifactsFrom: "d1", CreatedAt: now.Add(-time.Hour)}
+	live := &deployment.Record{DeploymentId: "d2", ProjectId: "p1", Status: deployment.StatusSucceeded, ArtifactsFrom: "d2", CreatedAt: now, PromotedAt: now}
+	require.NoError(t, store.SaveRecord(ctx, old))
+	require.NoError(t, store.SaveRecord(ctx, live))
+
+	var out bytes.Buffer
+	c := &cli.CLI{Profile: &cli.Profile{ProxyURL: "http://proxy.example.com/"}, Out: &out, JSON: true, Deployments: store}
+	require.NoError(t, c.ListDeployments(ctx, "p1", 0))
+
+	var listed []struct {
+		DeploymentId string `json:"deploymentId"`
+		Live         bool   `json:"live"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &listed))
+	require.Len(t, listed, 2)
+	assert.Equal(t, "d2", listed[0].DeploymentId)
+	assert.True(t, listed[0].Live)
+	assert.False(t, listed[1].Live)
+
+	liveURL, err := c.LiveURL("p1", "")
+	require.NoError(t, err)
+	assert.Equal(t, "http://proxy.example.com/p1/", liveURL)
+	previewURL, err := c.LiveURL("p1", "feature/x")
+	require.NoError(t, err)
+	assert.Equal(t, "http://proxy.example.com/feature-x-452392--p1/", previewURL)
+
+	var opened string
+	c.JSON = false
+	c.Browse = func(url string) error { opened = url; return nil }
+	require.NoError(t, c.Open("p1", "", true))
+	assert.Equal(t, "http://proxy.example.com/p1/", opened)
+}
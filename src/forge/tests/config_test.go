@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"bytes"
+	"forge/internal/config"
+	"forge/internal/deployment"
+	"forge/internal/grpctls"
+	"forge/internal/utils"
+	"forge/internal/worker"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "forge.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// loadConfig loads the config of a worker with the required settings and the
+// settings in env, and no others.
+func loadConfig(t *testing.T, env map[string]string) (*config.Config, error) {
+	for _, key := range config.Keys {
+		t.Setenv(key, "")
+	}
+	for key, value := range map[string]string{
+		"GRPC_SERVER_ADDRESS":      "launchpad:50051",
+		"LOGS_GRPC_SERVER_ADDRESS": "logify:50051",
+		"AWS_REGION":               "us-east-1",
+		"AWS_SQS_URL":              "https://sqs.example.com/queue",
+		"AWS_BUCKET_NAME":          "bucket",
+		"DB_HOST":                  "db",
+		"DB_PORT":                  "5432",
+		"DB_USERNAME":              "forge",
+		"DB_DATABASE":              "forge",
+	} {
+		t.Setenv(key, value)
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	return config.Load("")
+}
+
+func TestLoadConfig(t *testing.T) {
+	// Settings the file may set are restored once the test finished
+	for _, key := range config.Keys {
+		t.Setenv(key, "")
+	}
+	t.Setenv("AWS_SQS_URL", "https://sqs.example.com/env")
+
+	path := writeConfig(t, `
+GRPC_SERVER_ADDRESS: launchpad:50051
+LOGS_GRPC_SERVER_ADDRESS: logify:50051
+AWS_REGION: us-east-1
+AWS_SQS_URL: https://sqs.example.com/file
+AWS_BUCKET_NAME: bucket
+DB_HOST: db
+DB_PORT: 5432
+DB_USERNAME: forge
+DB_PASSWORD: secret
+DB_DATABASE: forge
+WORKER_TYPES: [Build, Cancel]
+BUILD_TIMEOUT: 20m
+`)
+
+	cfg, err := config.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://sqs.example.com/env", cfg.Queue.URL, "Expected the environment to override the file")
+	assert.Equal(t, "5432", cfg.Database.Port)
+	assert.Equal(t, "us-east-1", cfg.AWS.Region)
+	assert.Empty(t, os.Getenv("AWS_REGION"), "Expected the file to leave the environment unchanged")
+	assert.Equal(t, []string{"Build", "Cancel"}, cfg.Registry.Types)
+	assert.Equal(t, 20*time.Minute, cfg.Sandbox.Timeout)
+	assert.Equal(t, "none", cfg.TracesExporter)
+
+	var printed bytes.Buffer
+	cfg.Print(&printed)
+	assert.Contains(t, printed.String(), "forge@db:5432/forge (password: [redacted])")
+	assert.NotContains(t, printed.String(), "secret")
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, key := range config.Keys {
+		t.Setenv(key, "")
+	}
+
+	_, err := config.Load(writeConfig(t, "AWS_SQS_URLS: https://sqs.example.com\n"))
+	assert.ErrorContains(t, err, "unknown setting AWS_SQS_URLS")
+
+	// Every invalid setting is reported at once
+	t.Setenv("BUILD_MAX_ATTEMPTS", "0")
+	_, err = config.Load("")
+	assert.ErrorContains(t, err, "GRPC_SERVER_ADDRESS is required")
+	assert.ErrorContains(t, err, "DB_DATABASE is required")
+	assert.ErrorContains(t, err, "invalid BUILD_MAX_ATTEMPTS")
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(t, nil)
+	require.NoError(t, err)
+	assert.Equal(t, worker.DefaultRetryPolicy(), cfg.Queue.Retry)
+	assert.Equal(t, worker.DefaultHeartbeatConfig(), cfg.Queue.Heartbeat)
+	assert.Equal(t, worker.DefaultDrainTimeout, cfg.Queue.DrainTimeout)
+	assert.Equal(t, worker.DefaultLeaseTTL, cfg.LeaseTTL)
+	assert.Equal(t, utils.DefaultSandboxConfig(), cfg.Sandbox)
+	assert.Equal(t, utils.DefaultAllowedGitHosts, cfg.AllowedGitHosts)
+	assert.Equal(t, grpctls.ModeNone, cfg.GRPCTLS.Mode)
+	assert.Equal(t, worker.UnknownReject, cfg.Registry.Unknown)
+	assert.Empty(t, cfg.Registry.Types)
+	assert.Empty(t, cfg.TrustedSenders)
+	assert.Equal(t, deployment.DefaultRetention, cfg.Retention)
+}
+
+func TestLoadConfigSections(t *testing.T) {
+	cfg, err := loadConfig(t, map[string]string{
+		"WORKER_TYPE":                "Build",
+		"TRUSTED_SENDER_IDS":         "AROALAUNCHPAD, AIDAOPERATOR",
+		"MESSAGE_VISIBILITY_TIMEOUT": "90s",
+		"BUILD_MEMORY":               "512m",
+		"BUILD_CPUS":                 "0.5",
+		"DEPS_CACHE_MAX_SIZE":        "1g",
+		"DOCKER_HOST":                "tcp://docker:2375",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Build"}, cfg.Registry.Types)
+	assert.Equal(t, []string{"AROALAUNCHPAD", "AIDAOPERATOR"}, cfg.TrustedSenders)
+	assert.Equal(t, 90*time.Second, cfg.Queue.Heartbeat.VisibilityTimeout)
+	assert.Equal(t, 30*time.Second, cfg.Queue.Heartbeat.Interval)
+	assert.Equal(t, int64(512*1024*1024), cfg.Sandbox.MemoryBytes)
+	assert.Equal(t, int64(5e8), cfg.Sandbox.NanoCPUs)
+	assert.Equal(t, int64(1024*1024*1024), cfg.Sandbox.DepsCacheMaxSize)
+	assert.Equal(t, "tcp://docker:2375", cfg.Sandbox.DockerHost)
+
+	cfg, err = loadConfig(t, map[string]string{
+		"WORKER_TYPE":           "Build",
+		"WORKER_TYPES":          "Build, Cancel",
+		"UNKNOWN_MESSAGE_TYPES": worker.UnknownRelease,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Build", "Cancel"}, cfg.Registry.Types)
+	assert.Equal(t, worker.UnknownRelease, cfg.Registry.Unknown)
+
+	for key, value := range map[string]string{
+		"UNKNOWN_MESSAGE_TYPES":      "ignore",
+		"MESSAGE_VISIBILITY_CEILING": "13h",
+		"DRAIN_TIMEOUT":              "-1s",
+		"BUILD_PIDS_LIMIT":           "-1",
+		"GRPC_TLS_MODE":              grpctls.ModeMTLS,
+		"PREVIEW_URL_TEMPLATE":       "https://preview.example.com",
+		"DEPLOYMENT_RETENTION":       "0",
+	} {
+		_, err := loadConfig(t, map[string]string{key: value})
+		assert.ErrorContains(t, err, key, "Expected %s=%s to be rejected", key, value)
+	}
+}
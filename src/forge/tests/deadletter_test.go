@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forge/internal/worker"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSQS keeps messages per queue URL, received messages are not hidden.
+type fakeSQS struct {
+	queues  map[string][]types.Message
+	deleted []string
+	nextId  int
+
+	mu            sync.Mutex
+	extended      int
+	visibilityErr error
+}
+
+func (f *fakeSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	return &sqs.ReceiveMessageOutput{Messages: f.queues[aws.ToString(params.QueueUrl)]}, nil
+}
+
+func (f *fakeSQS) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.nextId++
+	id := fmt.Sprintf("msg-%d", f.nextId)
+	url := aws.ToString(params.QueueUrl)
+	f.queues[url] = append(f.queues[url], types.Message{
+		MessageId:         aws.String(id),
+		ReceiptHandle:     aws.String("receipt-" + id),
+		Body:              params.MessageBody,
+		MessageAttributes: params.MessageAttributes,
+	})
+	return &sqs.SendMessageOutput{MessageId: aws.String(id)}, nil
+}
+
+func (f *fakeSQS) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.deleted = append(f.deleted, aws.ToString(params.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.visibilityErr != nil {
+		return nil, f.visibilityErr
+	}
+	f.extended++
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func TestDeadLetterQueue(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeSQS{queues: make(map[string][]types.Message)}
+	dlq := worker.NewDeadLetterQueue(client, "build-queue", "build-dlq")
+
+	message := types.Message{
+		MessageId: aws.String("original"),
+		Body:      aws.String("Invalid JSON"),
+		Attributes: map[string]string{
+			"ApproximateReceiveCount": "3",
+		},
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"MessageType": {DataType: aws.String("String"), StringValue: aws.String("Build")},
+		},
+	}
+	assert.NoError(t, dlq.Send(ctx, message, worker.ReasonMalformedMessage, errors.New("bad body")))
+
+	letters, err := dlq.List(ctx, time.Minute)
+	assert.NoError(t, err)
+	if assert.Len(t, letters, 1) {
+		assert.Equal(t, "Build", letters[0].MessageType)
+		assert.Equal(t, worker.ReasonMalformedMessage, letters[0].Reason)
+		assert.Equal(t, "bad body", letters[0].Error)
+		assert.Equal(t, "3", letters[0].ReceiveCount)
+		assert.Equal(t, "build-queue", letters[0].Source)
+	}
+
+	redriven, err := dlq.Redrive(ctx, letters)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, redriven)
+	assert.Len(t, client.deleted, 1)
+
+	// The redriven message looks like the original again, marked as redriven
+	if assert.Len(t, client.queues["build-queue"], 1) {
+		redrivenMessage := client.queues["build-queue"][0]
+		assert.Equal(t, "Invalid JSON", aws.ToString(redrivenMessage.Body))
+		assert.NotEmpty(t, aws.ToString(redrivenMessage.MessageAttributes["RedriveId"].StringValue))
+		delete(redrivenMessage.MessageAttributes, "RedriveId")
+		assert.Equal(t, message.MessageAttributes, redrivenMessage.MessageAttributes)
+	}
+
+	assert.ErrorIs(t, worker.NewDeadLetterQueue(client, "build-queue", "").Send(ctx, message, "reason", nil), worker.ErrNoDeadLetterQueue)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := worker.DefaultRetryPolicy()
+	assert.Equal(t, 3, policy.MaxAttempts)
+	assert.Equal(t, 30*time.Second, policy.Delay(1))
+	assert.Equal(t, 60*time.Second, policy.Delay(2))
+	assert.Equal(t, 15*time.Minute, policy.Delay(20))
+}
@@ -0,0 +1,277 @@
+package worker
+
+import (
+	"context"
+	"forge/internal/deployment"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemBucket() *memBucket {
+	return &memBucket{objects: make(map[string][]byte)}
+}
+
+func (b *memBucket) PutObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = data
+	return nil
+}
+
+func (b *memBucket) GetObject(ctx context.Context, key string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.objects[key]
+	if !ok {
+		return nil, deployment.ErrNotFound
+	}
+	return data, nil
+}
+
+func (b *memBucket) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []string
+	for key := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (b *memBucket) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.objects[srcKey]
+	if !ok {
+		return deployment.ErrNotFound
+	}
+	b.objects[dstKey] = data
+	return nil
+}
+
+func (b *memBucket) DeleteObjects(ctx context.Context, keys []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		delete(b.objects, key)
+	}
+	return nil
+}
+
+func TestStoreFindByFingerprintAndPromote(t *testing.T) {
+	ctx := context.Background()
+	bucket := newMemBucket()
+	store := deployment.NewStore(bucket)
+
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "assets"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "app.js"), []byte("console.log(1)"), 0644))
+
+	// An older deployment is live
+	bucket.objects[deployment.LivePointerKey("p1")] = []byte("d0")
+
+	_, err := store.FindByFingerprint(ctx, "p1", "fp")
+	assert.True(t, deployment.IsNotFound(err))
+
+	record := &deployment.Record{
+		DeploymentId:  "d1",
+		ProjectId:     "p1",
+		Status:        deployment.StatusSucceeded,
+		Fingerprint:   "fp",
+		ArtifactsFrom: "d1",
+		CreatedAt:     time.Now(),
+	}
+	stats, err := store.Upload(ctx, "p1", "d1", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Files)
+	assert.Equal(t, int64(27), stats.Bytes)
+	// The digests of the uploaded files are read back from the bucket
+	digests, err := store.ArtifactDigests(ctx, "p1", "d1")
+	assert.NoError(t, err)
+	assert.Equal(t, stats.Digests, digests)
+	assert.Len(t, digests, 2)
+	assert.NoError(t, store.Promote(ctx, record))
+	assert.NoError(t, store.SaveRecord(ctx, record))
+
+	served, err := store.ServedDeployment(ctx, "p1", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "d1", served, "Expected the live pointer to be flipped to the deployment")
+	assert.Equal(t, []byte("d1"), bucket.objects["sites/p1/live"])
+	assert.Contains(t, bucket.objects, "sites/p1/deployments/d1/index.html")
+	assert.Contains(t, bucket.objects, "projects/p1/deployments/d1.json", "Expected records outside of the public prefix")
+	assert.Contains(t, bucket.objects, "projects/p1/fingerprints/fp.json")
+
+	found, err := store.FindByFingerprint(ctx, "p1", "fp")
+	assert.NoError(t, err)
+	assert.Equal(t, "d1", found.DeploymentId)
+
+	// Failed deployments are never served from the cache
+	failed := &deployment.Record{DeploymentId: "d2", ProjectId: "p1", Status: deployment.StatusFailed, Fingerprint: "other"}
+	assert.NoError(t, store.SaveRecord(ctx, failed))
+	_, err = store.FindByFingerprint(ctx, "p1", "other")
+	assert.True(t, deployment.IsNotFound(err))
+	assert.Error(t, store.Promote(ctx, failed))
+
+	liveRecord, err := store.LiveRecord(ctx, "p1")
+	assert.NoError(t, err)
+	assert.Equal(t, "d1", liveRecord.DeploymentId)
+	_, err = store.LiveRecord(ctx, "p2")
+	assert.True(t, deployment.IsNotFound(err))
+
+	// Deleting a project leaves other projects alone
+	bucket.objects["sites/p10/live"] = []byte("d9")
+	bucket.objects["projects/p10/deployments/d9.json"] = []byte("{}")
+	deleted, err := store.DeleteProject(ctx, "p1")
+	assert.NoError(t, err)
+	assert.Positive(t, deleted)
+	remaining, err := bucket.ListObjects(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"projects/p10/deployments/d9.json", "sites/p10/live"}, remaining)
+}
+
+func TestStorePrune(t *testing.T) {
+	ctx := context.Background()
+	bucket := newMemBucket()
+	store := deployment.NewStore(bucket)
+
+	now := time.Now()
+	records := []*deployment.Record{
+		{DeploymentId: "d1", Status: deployment.StatusSucceeded},
+		{DeploymentId: "d2", Status: deployment.StatusSucceeded},
+		{DeploymentId: "d3", Status: deployment.StatusFailed},
+		{DeploymentId: "d4", Status: deployment.StatusSucceeded},
+		{DeploymentId: "d5", Status: deployment.StatusSucceeded, PreviewBranch: "feature/x"},
+		{DeploymentId: "d6", Status: deployment.StatusSucceeded, PreviewBranch: "feature/x"},
+		{DeploymentId: "d7", Status: deployment.StatusSucceeded},
+		{DeploymentId: "d8", Status: deployment.StatusBuilding},
+	}
+	for i, record := range records {
+		record.ProjectId = "p1"
+		record.ArtifactsFrom = record.DeploymentId
+		record.CreatedAt = now.Add(time.Duration(i) * time.Minute)
+		assert.NoError(t, store.SaveRecord(ctx, record))
+		bucket.objects[deployment.ArtifactsPrefix("p1", record.DeploymentId)+"index.html"] = []byte(record.DeploymentId)
+	}
+	// A deployment whose record is not written yet
+	bucket.objects[deployment.ArtifactsPrefix("p1", "d9")+"index.html"] = []byte("d9")
+
+	assert.NoError(t, store.Promote(ctx, records[0]))
+	assert.NoError(t, store.PublishPreview(ctx, records[5]))
+
+	removed, err := store.Prune(ctx, "p1", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, removed)
+
+	for id, kept := range map[string]bool{
+		"d1": true,  // live
+		"d2": false, // older than the two newest
+		"d3": false, // failed
+		"d4": true,  // second newest
+		"d5": false, // replaced preview
+		"d6": true,  // preview of the branch
+		"d7": true,  // newest
+		"d8": true,  // building
+		"d9": true,  // no record yet
+	} {
+		_, err := bucket.GetObject(ctx, deployment.ArtifactsPrefix("p1", id)+"index.html")
+		assert.Equal(t, kept, err == nil, id)
+	}
+
+	// Pruned artifacts are neither promoted nor served from the build cache
+	assert.Error(t, store.Promote(ctx, records[1]))
+
+	// Records older than the kept deployments go too, unless they are live
+	for id, kept := range map[string]bool{
+		"d1": true,
+		"d2": false,
+		"d3": false,
+		"d5": true,
+		"d8": true,
+	} {
+		_, err := store.GetRecord(ctx, "p1", id)
+		assert.Equal(t, kept, err == nil, id)
+	}
+	listed, err := store.ListRecords(ctx, "p1")
+	assert.NoError(t, err)
+	assert.Len(t, listed, 6)
+}
+
+func TestPreviewSlug(t *testing.T) {
+	assert.Equal(t, "main", deployment.PreviewSlug("main"))
+	assert.Equal(t, "feature-login", deployment.PreviewSlug("feature-login"))
+
+	// Changed branches get a hash, so they don't collide with the branch they became
+	slug := deployment.PreviewSlug("Feature/Login")
+	assert.Regexp(t, "^feature-login-[0-9a-f]{6}$", slug)
+	assert.NotEqual(t, slug, deployment.PreviewSlug("feature/login"))
+	assert.Regexp(t, "^fix-1234-[0-9a-f]{6}$", deployment.PreviewSlug("--fix//1234--"))
+
+	// Long branches are shortened and stay distinct
+	long := deployment.PreviewSlug("feature/a-very-long-branch-name-one")
+	other := deployment.PreviewSlug("feature/a-very-long-branch-name-two")
+	assert.LessOrEqual(t, len(long), 24)
+	assert.NotEqual(t, long, other)
+	assert.True(t, strings.HasPrefix(long, "feature-a-very-"))
+
+	assert.Len(t, deployment.PreviewSlug("🚀"), 6)
+	assert.Equal(t, "main--p1", deployment.PreviewSite("p1", "main"))
+}
+
+func TestStorePublishPreview(t *testing.T) {
+	ctx := context.Background()
+	bucket := newMemBucket()
+	store := deployment.NewStore(bucket)
+
+	bucket.objects[deployment.ArtifactsPrefix("p1", "d1")+"index.html"] = []byte("preview")
+	bucket.objects[deployment.PreviewPointerKey("p1", "feature-x")] = []byte("d0")
+	bucket.objects[deployment.LivePointerKey("p1")] = []byte("d0")
+
+	record := &deployment.Record{
+		DeploymentId:  "d1",
+		ProjectId:     "p1",
+		Status:        deployment.StatusSucceeded,
+		ArtifactsFrom: "d1",
+		PreviewBranch: "feature-x",
+	}
+	assert.Error(t, store.Promote(ctx, record), "Expected previews not to be promoted")
+	assert.NoError(t, store.PublishPreview(ctx, record))
+	assert.True(t, record.PromotedAt.IsZero())
+
+	served, err := store.ServedDeployment(ctx, "p1", "feature-x")
+	assert.NoError(t, err)
+	assert.Equal(t, "d1", served)
+	assert.Equal(t, []byte("d0"), bucket.objects[deployment.LivePointerKey("p1")], "Expected the live deployment to be left alone")
+
+	// Previews of branches whose slug starts with the same characters are separate
+	bucket.objects[deployment.PreviewPointerKey("p1", "feature-x2")] = []byte("d0")
+	deleted, err := store.DeletePreview(ctx, "p1", "feature-x")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = store.ServedDeployment(ctx, "p1", "feature-x")
+	assert.True(t, deployment.IsNotFound(err))
+	_, err = store.ServedDeployment(ctx, "p1", "feature-x2")
+	assert.NoError(t, err)
+	_, err = bucket.GetObject(ctx, deployment.ArtifactsPrefix("p1", "d1")+"index.html")
+	assert.NoError(t, err, "Expected the artifacts to be kept")
+}
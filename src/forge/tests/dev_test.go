@@ -0,0 +1,208 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"forge/internal/database"
+	"forge/internal/deployment"
+	"forge/internal/dev"
+	"forge/internal/service"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const devProject = "3f1c2a4e-1111-4222-8333-123456789abc"
+
+func TestDirBucket(t *testing.T) {
+	ctx := context.Background()
+	bucket := deployment.NewDirBucket(t.TempDir())
+
+	require.NoError(t, bucket.PutObject(ctx, "projects/p1/build/index.html", strings.NewReader("<h1>hi</h1>"), "text/html"))
+	require.NoError(t, bucket.PutObject(ctx, "projects/p1/build/assets/app.js", strings.NewReader("app()"), "text/javascript"))
+	require.NoError(t, bucket.PutObject(ctx, "projects/p10/build/index.html", strings.NewReader("other"), "text/html"))
+
+	data, err := bucket.GetObject(ctx, "projects/p1/build/index.html")
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>hi</h1>", string(data))
+	_, err = bucket.GetObject(ctx, "projects/p1/build/missing.html")
+	assert.ErrorIs(t, err, deployment.ErrNotFound)
+	_, err = bucket.GetObject(ctx, "projects/p1/build/assets")
+	assert.ErrorIs(t, err, deployment.ErrNotFound)
+
+	keys, err := bucket.ListObjects(ctx, "projects/p1/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"projects/p1/build/assets/app.js", "projects/p1/build/index.html"}, keys)
+	keys, err = bucket.ListObjects(ctx, "projects/p1")
+	require.NoError(t, err)
+	assert.Len(t, keys, 3)
+	keys, err = bucket.ListObjects(ctx, "projects/missing/")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	require.NoError(t, bucket.CopyObject(ctx, "projects/p1/build/index.html", "projects/p2/build/index.html"))
+	require.NoError(t, bucket.DeleteObjects(ctx, []string{"projects/p1/build/index.html", "projects/p1/build/gone.html"}))
+	_, err = bucket.GetObject(ctx, "projects/p1/build/index.html")
+	assert.ErrorIs(t, err, deployment.ErrNotFound)
+	data, err = bucket.GetObject(ctx, "projects/p2/build/index.html")
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>hi</h1>", string(data))
+
+	// Keys never leave the directory
+	assert.Error(t, bucket.PutObject(ctx, "../escape", strings.NewReader("x"), ""))
+	_, err = bucket.GetObject(ctx, "/etc/passwd")
+	assert.Error(t, err)
+}
+
+func TestDevQueue(t *testing.T) {
+	ctx := context.Background()
+	queue := dev.NewQueue()
+	queueURL := aws.String(dev.QueueURL)
+
+	_, err := queue.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: queueURL, MessageBody: aws.String("first")})
+	require.NoError(t, err)
+
+	received, err := queue.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: queueURL, MaxNumberOfMessages: 1, VisibilityTimeout: 60})
+	require.NoError(t, err)
+	require.Len(t, received.Messages, 1)
+	message := received.Messages[0]
+	assert.Equal(t, "first", aws.ToString(message.Body))
+	assert.Equal(t, "1", message.Attributes["ApproximateReceiveCount"])
+	assert.NotEmpty(t, message.Attributes["SentTimestamp"])
+
+	// The message is invisible until its visibility timeout is changed
+	received, err = queue.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: queueURL})
+	require.NoError(t, err)
+	assert.Empty(t, received.Messages)
+
+	// A waiting receiver gets the message as soon as it is visible again
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		queue.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{QueueUrl: queueURL, ReceiptHandle: message.ReceiptHandle})
+	}()
+	received, err = queue.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: queueURL, WaitTimeSeconds: 5})
+	require.NoError(t, err)
+	require.Len(t, received.Messages, 1)
+	assert.Equal(t, "2", received.Messages[0].Attributes["ApproximateReceiveCount"])
+
+	// Only the latest receipt handle is valid
+	_, err = queue.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{QueueUrl: queueURL, ReceiptHandle: message.ReceiptHandle})
+	assert.Error(t, err)
+	_, err = queue.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: queueURL, ReceiptHandle: received.Messages[0].ReceiptHandle})
+	require.NoError(t, err)
+	assert.Equal(t, 0, queue.Len(dev.QueueURL))
+}
+
+// fakePreviewStore keeps the previews of the development server in memory.
+type fakePreviewStore struct {
+	previews []database.Preview
+}
+
+func (f *fakePreviewStore) SavePreview(ctx context.Context, preview *database.Preview) error {
+	f.previews = append(f.previews, *preview)
+	return nil
+}
+
+func (f *fakePreviewStore) ListPreviews(ctx context.Context, projectId string) ([]database.Preview, error) {
+	var found []database.Preview
+	for _, preview := range f.previews {
+		if preview.ProjectId == projectId {
+			found = append(found, preview)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakePreviewStore) ExpiredPreviews(ctx context.Context, now time.Time, limit int) ([]database.Preview, error) {
+	return nil, nil
+}
+
+func (f *fakePreviewStore) DeletePreview(ctx context.Context, projectId, slug, deploymentId string) (bool, error) {
+	return false, nil
+}
+
+func TestDevServer(t *testing.T) {
+	ctx := context.Background()
+	stores := &dev.Stores{
+		DB:       &MockLeaseDB{state: database.LeaseHeld},
+		Previews: &fakePreviewStore{previews: []database.Preview{{ProjectId: devProject, Slug: "main", Branch: "main", DeploymentId: "d0"}}},
+		Webhooks: &fakeWebhookStore{},
+		Triggers: &fakeTriggerStore{},
+	}
+	server, err := dev.New(&dev.Config{Address: "localhost:0", DataDir: t.TempDir(), APIToken: "dev-token"}, stores, io.Discard)
+	require.NoError(t, err)
+	handler := server.Handler()
+
+	// The live files are served like the proxy does
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>live</h1>"), 0o644))
+	_, err = server.Store.Upload(ctx, devProject, "d1", dir)
+	require.NoError(t, err)
+	live := &deployment.Record{DeploymentId: "d1", ProjectId: devProject, Status: deployment.StatusSucceeded, ArtifactsFrom: "d1"}
+	require.NoError(t, server.Store.Promote(ctx, live))
+	require.NoError(t, server.Store.SaveRecord(ctx, live))
+
+	get := func(target string, host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if host != "" {
+			req.Host = host
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := get("/"+devProject+"/", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "<h1>live</h1>", rec.Body.String())
+	assert.Contains(t, rec.Header().Get("Set-Cookie"), "projectID="+devProject)
+	rec = get("/", devProject+".localhost:8080")
+	assert.Equal(t, "<h1>live</h1>", rec.Body.String())
+	rec = get("/", strings.ToUpper(devProject)+".localhost:8080")
+	assert.Equal(t, "<h1>live</h1>", rec.Body.String())
+	assert.Equal(t, http.StatusNotFound, get("/"+devProject+"/missing.js", "").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/nothing", "").Code)
+
+	// Logs are served like logify, newest first
+	server.Logs.PushLogs(ctx, devProject, service.LogEntry{Log: "building", Timestamp: 1})
+	server.Logs.PushLogs(ctx, devProject, service.LogEntry{Log: "done", Timestamp: 2})
+	rec = get("/api/v1/"+devProject+"/logs?limit=1", "")
+	assert.JSONEq(t, `[{"projectId":"`+devProject+`","timestamp":2,"log":"done"}]`, rec.Body.String())
+
+	// Deploying enqueues a build on the in-memory queue
+	req := httptest.NewRequest(http.MethodPost, "/dev/projects/"+devProject+"/deploy", strings.NewReader(`{"repoURL":"https://github.com/acme/site","previewBranch":"feature/x"}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var queued dev.QueuedDeployment
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &queued))
+	assert.Equal(t, "http://localhost:0/feature-x-452392--"+devProject+"/", queued.URL)
+	assert.Equal(t, 1, server.Queue.Len(dev.QueueURL))
+
+	// The forge API is served with the token, on the same stores
+	req = httptest.NewRequest(http.MethodGet, "/projects/"+devProject+"/previews", nil)
+	req.Header.Set("Authorization", "Bearer dev-token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"deploymentId":"d0"`)
+	assert.Equal(t, http.StatusUnauthorized, get("/projects/"+devProject+"/previews", "").Code)
+
+	// Deployments are listed like the CLI does
+	rec = get("/dev/projects/"+devProject+"/deployments", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"deploymentId":"d1"`)
+	assert.Contains(t, rec.Body.String(), `"live":true`)
+
+	_, err = server.Deploy(ctx, "not-a-uuid", &dev.DeployRequest{RepoURL: "https://github.com/acme/site"})
+	assert.ErrorContains(t, err, "must be a UUID")
+}
@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"forge/internal/framework"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeRepoFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestDetectFramework(t *testing.T) {
+	tests := []struct {
+		name         string
+		files        map[string]string
+		framework    string
+		buildCommand string
+		outputDirs   []string
+	}{
+		{
+			name: "next static export with distDir",
+			files: map[string]string{
+				"package.json":   `{"scripts":{"build":"next build"},"dependencies":{"next":"14.2.0","react":"18.3.0"}}`,
+				"next.config.js": "module.exports = {\n  output: 'export',\n  distDir: 'site',\n}\n",
+			},
+			framework:    "nextjs",
+			buildCommand: "npm run build",
+			outputDirs:   []string{"site"},
+		},
+		{
+			name: "gatsby with yarn",
+			files: map[string]string{
+				"package.json": `{"scripts":{"build":"gatsby build"},"dependencies":{"gatsby":"5.0.0"}}`,
+				"yarn.lock":    "",
+			},
+			framework:    "gatsby",
+			buildCommand: "yarn build",
+			outputDirs:   []string{"public"},
+		},
+		{
+			name: "angular application builder",
+			files: map[string]string{
+				"package.json": `{"name":"shop","scripts":{"build":"ng build"},"dependencies":{"@angular/core":"17.0.0"}}`,
+				"angular.json": `{"projects":{"shop":{"projectType":"application","architect":{"build":{"builder":"@angular-devkit/build-angular:application","options":{"outputPath":"dist/shop"}}}}}}`,
+			},
+			framework:    "angular",
+			buildCommand: "npm run build",
+			outputDirs:   []string{"dist/shop/browser"},
+		},
+		{
+			name: "vite with custom outDir",
+			files: map[string]string{
+				"package.json":   `{"scripts":{"build":"vite build"},"devDependencies":{"vite":"5.0.0"}}`,
+				"vite.config.ts": "export default defineConfig({ build: { outDir: './public-build' } })",
+			},
+			framework:    "vite",
+			buildCommand: "npm run build",
+			outputDirs:   []string{"public-build"},
+		},
+		{
+			name:       "plain html",
+			files:      map[string]string{"index.html": "<html></html>"},
+			framework:  "static",
+			outputDirs: []string{"."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fw, err := framework.Detect(writeRepoFiles(t, tt.files))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.framework, fw.Name)
+			assert.Equal(t, tt.buildCommand, fw.BuildCommand)
+			assert.Equal(t, tt.outputDirs, fw.OutputDirs)
+		})
+	}
+
+	_, err := framework.Detect(writeRepoFiles(t, map[string]string{
+		"package.json":   `{"devDependencies":{"vite":"5.0.0"}}`,
+		"vite.config.js": "export default { build: { outDir: '../../etc' } }",
+	}))
+	assert.Error(t, err)
+}
+
+func TestCheckOutput(t *testing.T) {
+	fw := &framework.Framework{Name: "nextjs", OutputDirs: []string{"out"}}
+
+	err := framework.CheckOutput(fw, filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, framework.ErrOutputMissing)
+
+	err = framework.CheckOutput(fw, writeRepoFiles(t, map[string]string{"app.js": ""}))
+	assert.ErrorIs(t, err, framework.ErrOutputMissing)
+	assert.Contains(t, err.Error(), "index.html")
+
+	assert.NoError(t, framework.CheckOutput(fw, writeRepoFiles(t, map[string]string{"index.html": ""})))
+}
+
+func TestStripRepoFiles(t *testing.T) {
+	dir := writeRepoFiles(t, map[string]string{
+		"index.html":                   "",
+		".env":                         "SECRET=1",
+		".git/config":                  "",
+		".github/workflows/ci.yml":     "",
+		"node_modules/react/index.js":  "",
+		"assets/.DS_Store":             "",
+		"assets/app.js":                "",
+		".well-known/security.txt":     "",
+		".well-known/.hidden/file.txt": "",
+	})
+
+	assert.NoError(t, framework.StripRepoFiles(dir))
+
+	var files []string
+	assert.NoError(t, filepath.WalkDir(dir, func(file string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			rel, _ := filepath.Rel(dir, file)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	}))
+	assert.ElementsMatch(t, []string{"index.html", "assets/app.js", ".well-known/security.txt", ".well-known/.hidden/file.txt"}, files)
+}
@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"forge/internal/utils"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestValidator(addrs map[string]string) *utils.RepoURLValidator {
+	return &utils.RepoURLValidator{
+		AllowedHosts: []string{"github.com", "*.example.com", "internal.example.org"},
+		LookupIPAddr: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			addr, ok := addrs[host]
+			if !ok {
+				return nil, errors.New("no such host")
+			}
+			return []net.IPAddr{{IP: net.ParseIP(addr)}}, nil
+		},
+	}
+}
+
+func TestValidateRepoURL(t *testing.T) {
+	validator := newTestValidator(map[string]string{
+		"github.com":           "140.82.112.3",
+		"git.example.com":      "93.184.216.34",
+		"internal.example.org": "10.0.0.12",
+		"meta.example.com":     "169.254.169.254",
+		"mapped.example.com":   "::ffff:127.0.0.1",
+		"6to4.example.com":     "2002:a00:1::1",
+		"teredo.example.com":   "2001:0:4136:e378:8000:63bf:f5ff:fffe",
+	})
+
+	valid := []string{
+		"https://github.com/example/repo",
+		"http://github.com/example/repo.git",
+		"ssh://git@github.com/example/repo.git",
+		"git@github.com:example/repo.git",
+		"https://git.example.com/team/site",
+	}
+	for _, repoURL := range valid {
+		assert.NoError(t, validator.Validate(context.Background(), repoURL), repoURL)
+	}
+
+	invalid := []string{
+		"",
+		"file:///etc/passwd",
+		"ext::sh -c touch% /tmp/pwned",
+		"git://github.com/example/repo",
+		"--upload-pack=touch /tmp/pwned",
+		"/srv/repos/site.git",
+		"https://github.com",
+		"https://gitlab.internal/example/repo",
+		"https://internal.example.org/example/repo",
+		"https://meta.example.com/latest/meta-data",
+		"https://mapped.example.com/example/repo",
+		"https://6to4.example.com/example/repo",
+		"https://teredo.example.com/example/repo",
+		"https://unknown.example.com/example/repo",
+		"https://example.com/example/repo",
+	}
+	for _, repoURL := range invalid {
+		err := validator.Validate(context.Background(), repoURL)
+		assert.ErrorIs(t, err, utils.ErrInvalidRepoURL, repoURL)
+	}
+}
+
+func TestResolveRepoURL(t *testing.T) {
+	validator := newTestValidator(map[string]string{
+		"github.com":      "140.82.112.3",
+		"git.example.com": "2606:2800:220:1:248:1893:25c8:1946",
+	})
+
+	remote, err := validator.Resolve(context.Background(), "https://github.com/example/repo")
+	assert.NoError(t, err)
+	assert.Equal(t, "github.com", remote.Host)
+	assert.Equal(t, "443", remote.Port)
+	assert.Equal(t, netip.MustParseAddr("140.82.112.3"), remote.Addr, "Expected git to be pinned to the validated address")
+	assert.False(t, remote.SSH)
+
+	remote, err = validator.Resolve(context.Background(), "ssh://git@git.example.com:2222/team/site.git")
+	assert.NoError(t, err)
+	assert.Equal(t, "2222", remote.Port)
+	assert.Equal(t, netip.MustParseAddr("2606:2800:220:1:248:1893:25c8:1946"), remote.Addr)
+	assert.True(t, remote.SSH)
+
+	remote, err = validator.Resolve(context.Background(), "git@github.com:example/repo.git")
+	assert.NoError(t, err)
+	assert.Equal(t, "22", remote.Port)
+	assert.True(t, remote.SSH)
+}
@@ -0,0 +1,152 @@
+package worker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"forge/internal/grpctls"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate for name signed by the CA, in PEM.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+// handshake connects the client credentials to a TLS server presenting
+// serverCert, which requires a client certificate signed by clientCA.
+func handshake(creds credentials.TransportCredentials, serverCert tls.Certificate, clientCA *x509.CertPool) error {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		server := tls.Server(serverConn, &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCA,
+			NextProtos:   []string{"h2"},
+		})
+		server.Handshake()
+		server.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err := creds.ClientHandshake(ctx, "logify:50051", clientConn)
+	return err
+}
+
+func TestClientCredentials(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	cfg := &grpctls.Config{
+		Mode:     grpctls.ModeMTLS,
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "forge.pem"),
+		KeyFile:  filepath.Join(dir, "forge-key.pem"),
+	}
+
+	modTime := time.Now().Add(-time.Minute)
+	writeFile(t, cfg.CAFile, ca.pem, modTime)
+	certPEM, keyPEM := ca.issue(t, "forge", x509.ExtKeyUsageClientAuth)
+	writeFile(t, cfg.CertFile, certPEM, modTime)
+	writeFile(t, cfg.KeyFile, keyPEM, modTime)
+
+	creds, err := grpctls.ClientCredentials(cfg)
+	require.NoError(t, err)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	serverCert, err := tls.X509KeyPair(ca.issue(t, "logify", x509.ExtKeyUsageServerAuth))
+	require.NoError(t, err)
+	assert.NoError(t, handshake(creds, serverCert, clientCAs))
+
+	// The server identity is checked
+	otherCert, err := tls.X509KeyPair(ca.issue(t, "launchpad", x509.ExtKeyUsageServerAuth))
+	require.NoError(t, err)
+	assert.ErrorContains(t, handshake(creds, otherCert, clientCAs), "failed to verify server certificate")
+
+	// Rotated certificates are used without new credentials
+	rotated := newTestCA(t)
+	rotatedServer, err := tls.X509KeyPair(rotated.issue(t, "logify", x509.ExtKeyUsageServerAuth))
+	require.NoError(t, err)
+	modTime = time.Now()
+	writeFile(t, cfg.CAFile, rotated.pem, modTime)
+	certPEM, keyPEM = rotated.issue(t, "forge", x509.ExtKeyUsageClientAuth)
+	writeFile(t, cfg.CertFile, certPEM, modTime)
+	writeFile(t, cfg.KeyFile, keyPEM, modTime)
+
+	rotatedCAs := x509.NewCertPool()
+	rotatedCAs.AddCert(rotated.cert)
+	assert.NoError(t, handshake(creds, rotatedServer, rotatedCAs))
+
+	// While the files are being replaced the last certificates are kept
+	require.NoError(t, os.Remove(cfg.CertFile))
+	require.NoError(t, os.Remove(cfg.CAFile))
+	assert.NoError(t, handshake(creds, rotatedServer, rotatedCAs))
+}
+
+func TestGrpcTLSConfigValidate(t *testing.T) {
+	assert.NoError(t, (&grpctls.Config{Mode: grpctls.ModeNone}).Validate())
+	assert.NoError(t, (&grpctls.Config{Mode: grpctls.ModeTLS, CAFile: "ca.pem"}).Validate())
+	assert.Error(t, (&grpctls.Config{Mode: grpctls.ModeMTLS, CAFile: "ca.pem"}).Validate())
+	assert.Error(t, (&grpctls.Config{Mode: "plaintext"}).Validate())
+}
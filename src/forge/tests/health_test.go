@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"forge/internal/monitor"
+	"forge/internal/worker"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockQueueAttributes struct {
+	err error
+}
+
+func (q *MockQueueAttributes) GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	return &sqs.GetQueueAttributesOutput{}, q.err
+}
+
+func probe(handler http.HandlerFunc) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder
+}
+
+func TestHealthChecks(t *testing.T) {
+	queue := &MockQueueAttributes{}
+	ready := error(nil)
+
+	checks := &monitor.Checks{}
+	checks.AddHealth("queue", worker.QueueCheck(queue, "queue-url"))
+	checks.AddReadiness("worker", func(ctx context.Context) error { return ready })
+
+	assert.Equal(t, http.StatusOK, probe(checks.Healthz).Code)
+	assert.Equal(t, http.StatusOK, probe(checks.Readyz).Code)
+
+	// Readiness checks do not fail the health check
+	ready = worker.ErrNoBuildSlots
+	assert.Equal(t, http.StatusOK, probe(checks.Healthz).Code)
+	response := probe(checks.Readyz)
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Contains(t, response.Body.String(), "worker: worker has no free build slots")
+
+	// Health checks fail both endpoints
+	ready = nil
+	queue.err = errors.New("access denied")
+	response = probe(checks.Healthz)
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Contains(t, response.Body.String(), "queue: failed to read queue queue-url: access denied")
+	assert.Equal(t, http.StatusServiceUnavailable, probe(checks.Readyz).Code)
+}
+
+func TestReadinessCheck(t *testing.T) {
+	// No build is running outside of processing a message
+	assert.NoError(t, worker.ReadinessCheck(context.Background()))
+}
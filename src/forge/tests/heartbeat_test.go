@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"forge/internal/worker"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepVisible(t *testing.T) {
+	message := types.Message{MessageId: aws.String("m1"), ReceiptHandle: aws.String("r1")}
+	cfg := &worker.HeartbeatConfig{
+		VisibilityTimeout: 30 * time.Millisecond,
+		Interval:          10 * time.Millisecond,
+		Ceiling:           time.Hour,
+	}
+
+	client := &fakeSQS{}
+	ctx, stop := worker.KeepVisible(context.Background(), client, "queue", message, cfg)
+	time.Sleep(55 * time.Millisecond)
+	assert.NoError(t, ctx.Err())
+	stop()
+	client.mu.Lock()
+	assert.GreaterOrEqual(t, client.extended, 3)
+	client.mu.Unlock()
+
+	// Processing is canceled once the visibility can no longer be extended
+	client = &fakeSQS{visibilityErr: errors.New("throttled")}
+	ctx, stop = worker.KeepVisible(context.Background(), client, "queue", message, cfg)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the lease to be lost")
+	}
+	stop()
+	assert.ErrorIs(t, context.Cause(ctx), worker.ErrLeaseLost)
+
+	cfg.Ceiling = 20 * time.Millisecond
+	ctx, stop = worker.KeepVisible(context.Background(), &fakeSQS{}, "queue", message, cfg)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the ceiling to cancel processing")
+	}
+	stop()
+	assert.ErrorIs(t, context.Cause(ctx), worker.ErrVisibilityCeiling)
+}
+
+func TestDefaultHeartbeatConfig(t *testing.T) {
+	cfg := worker.DefaultHeartbeatConfig()
+	assert.Equal(t, 2*time.Minute, cfg.VisibilityTimeout)
+	assert.Equal(t, 40*time.Second, cfg.Interval)
+	assert.Equal(t, time.Hour, cfg.Ceiling)
+}
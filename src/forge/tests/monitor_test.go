@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"forge/internal/monitor"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObservePhase(t *testing.T) {
+	monitor.ObservePhase(monitor.PhaseClone, time.Now().Add(-time.Second), nil)
+	monitor.ObservePhase(monitor.PhaseClone, time.Now(), errors.New("clone failed"))
+
+	// One series per phase and result
+	assert.GreaterOrEqual(t, testutil.CollectAndCount(monitor.PhaseDuration), 2)
+
+	// Builds are untracked once processed
+	assert.Equal(t, 0.0, testutil.ToFloat64(monitor.InflightBuilds))
+}
+
+func TestPhaseDurations(t *testing.T) {
+	ctx, phases := monitor.WithPhaseDurations(context.Background())
+
+	endPhase := monitor.TimePhase(ctx, monitor.PhaseClone)
+	endPhase(nil)
+	monitor.RecordPhase(ctx, monitor.PhaseBuild, time.Second)
+	monitor.RecordPhase(ctx, monitor.PhaseBuild, time.Second)
+	// Phases outside a deployment are not recorded
+	monitor.RecordPhase(context.Background(), monitor.PhaseUpload, time.Second)
+
+	durations := phases.All()
+	assert.Contains(t, durations, monitor.PhaseClone)
+	assert.Equal(t, 2*time.Second, durations[monitor.PhaseBuild])
+	assert.NotContains(t, durations, monitor.PhaseUpload)
+}
@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"forge/internal/cli"
+	"forge/internal/deployment"
+	"forge/internal/provenance"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func provenanceRecord() *deployment.Record {
+	return &deployment.Record{
+		DeploymentId:  "d2",
+		ProjectId:     "p1",
+		Status:        deployment.StatusSucceeded,
+		RepoURL:       "https://github.com/acme/site",
+		CommitSHA:     "0123456789abcdef0123456789abcdef01234567",
+		BuildCommand:  "npm run build",
+		BuilderImage:  "node@sha256:" + string(bytes.Repeat([]byte("a"), 64)),
+		ArtifactsFrom: "d2",
+		CreatedAt:     time.Now().Add(-time.Minute).UTC(),
+	}
+}
+
+func TestProvenanceSignAndVerify(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer := provenance.NewSigner(privateKey)
+	assert.Equal(t, provenance.KeyID(publicKey), signer.KeyID())
+
+	statement := provenance.NewStatement(&provenance.Build{
+		BuilderID:  provenance.DefaultBuilderID,
+		Record:     provenanceRecord(),
+		Ref:        "main",
+		EnvNames:   []string{"NODE_ENV", "API_KEY"},
+		Digests:    map[string]string{"index.html": "11", "assets/app.js": "22"},
+		FinishedOn: time.Now().UTC(),
+	})
+	assert.Equal(t, []provenance.Subject{
+		{Name: "assets/app.js", Digest: map[string]string{"sha256": "22"}},
+		{Name: "index.html", Digest: map[string]string{"sha256": "11"}},
+	}, statement.Subject)
+	assert.Equal(t, []string{"API_KEY", "NODE_ENV"}, statement.Predicate.BuildDefinition.ExternalParameters.Env)
+	assert.Empty(t, statement.Predicate.BuildDefinition.InternalParameters.ArtifactsFrom)
+	assert.Equal(t, []provenance.ResourceDescriptor{
+		{URI: "git+https://github.com/acme/site", Digest: map[string]string{"gitCommit": "0123456789abcdef0123456789abcdef01234567"}},
+		{URI: "pkg:docker/node", Digest: map[string]string{"sha256": string(bytes.Repeat([]byte("a"), 64))}},
+	}, statement.Predicate.BuildDefinition.ResolvedDependencies)
+
+	envelope, err := signer.Sign(statement)
+	require.NoError(t, err)
+	data, err := json.Marshal(envelope)
+	require.NoError(t, err)
+
+	var decoded provenance.Envelope
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	verified, err := provenance.Verify(&decoded, publicKey)
+	require.NoError(t, err)
+	assert.Equal(t, "d2", verified.Predicate.RunDetails.Metadata.InvocationID)
+	require.NoError(t, verified.CheckSubjects(map[string]string{"index.html": "11", "assets/app.js": "22"}))
+
+	// Another key or a changed payload does not verify
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = provenance.Verify(&decoded, otherKey)
+	assert.ErrorIs(t, err, provenance.ErrInvalidSignature)
+	decoded.Payload = bytes.Replace(decoded.Payload, []byte("acme"), []byte("evil"), 1)
+	_, err = provenance.Verify(&decoded, publicKey)
+	assert.ErrorIs(t, err, provenance.ErrInvalidSignature)
+
+	err = verified.CheckSubjects(map[string]string{"index.html": "12", "extra.js": "33"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "index.html has digest sha256:12")
+	assert.Contains(t, err.Error(), "assets/app.js is missing")
+	assert.Contains(t, err.Error(), "extra.js is not in the statement")
+}
+
+// writeProvenanceKey writes a PEM PKCS #8 key like openssl genpkey does.
+func writeProvenanceKey(t *testing.T, privateKey ed25519.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	return path
+}
+
+func TestProvenanceLoadConfig(t *testing.T) {
+	cfg, err := provenance.LoadConfig("", "")
+	require.NoError(t, err)
+	assert.False(t, cfg.Enabled())
+	assert.Equal(t, provenance.DefaultBuilderID, cfg.BuilderID)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keyFile := writeProvenanceKey(t, privateKey)
+	cfg, err = provenance.LoadConfig(keyFile, "https://forge.example.com/workers")
+	require.NoError(t, err)
+	assert.True(t, cfg.Enabled())
+	assert.Equal(t, provenance.KeyID(publicKey), cfg.Signer.KeyID())
+	assert.Equal(t, "https://forge.example.com/workers", cfg.BuilderID)
+
+	// The public key is read from the private key as well
+	readKey, err := provenance.ReadPublicKey(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, readKey)
+
+	notAKey := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(notAKey, []byte("not a key"), 0o600))
+	_, err = provenance.LoadConfig(notAKey, "")
+	assert.ErrorContains(t, err, "invalid PROVENANCE_SIGNING_KEY")
+}
+
+func TestCLIVerify(t *testing.T) {
+	ctx := context.Background()
+	store := deployment.NewStore(newMemBucket())
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>signed</h1>"), 0o644))
+	stats, err := store.Upload(ctx, "p1", "d2", dir)
+	require.NoError(t, err)
+	record := provenanceRecord()
+	require.NoError(t, store.Promote(ctx, record))
+	require.NoError(t, store.SaveRecord(ctx, record))
+
+	statement := provenance.NewStatement(&provenance.Build{Record: record, Digests: stats.Digests, FinishedOn: time.Now()})
+	envelope, err := provenance.NewSigner(privateKey).Sign(statement)
+	require.NoError(t, err)
+	data, err := json.Marshal(envelope)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveProvenance(ctx, "p1", "d2", data))
+
+	var out bytes.Buffer
+	c := &cli.CLI{Out: &out, JSON: true, Deployments: store}
+	require.NoError(t, c.Verify(ctx, cli.VerifyOptions{ProjectId: "p1", PublicKey: publicKey}))
+	var verified cli.VerifiedProvenance
+	require.NoError(t, json.Unmarshal(out.Bytes(), &verified))
+	assert.Equal(t, "d2", verified.DeploymentId)
+	assert.Equal(t, record.CommitSHA, verified.CommitSHA)
+	assert.Equal(t, 1, verified.Files)
+	assert.True(t, verified.FilesChecked)
+
+	// The files in the bucket changed after the build
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>tampered</h1>"), 0o644))
+	_, err = store.Upload(ctx, "p1", "d2", dir)
+	require.NoError(t, err)
+	err = c.Verify(ctx, cli.VerifyOptions{ProjectId: "p1", DeploymentId: "d2", PublicKey: publicKey})
+	assert.ErrorContains(t, err, "index.html has digest")
+
+	// A downloaded envelope is checked against a local directory
+	envelopeFile := filepath.Join(t.TempDir(), "provenance.json")
+	require.NoError(t, os.WriteFile(envelopeFile, data, 0o644))
+	err = c.Verify(ctx, cli.VerifyOptions{PublicKey: publicKey, EnvelopeFile: envelopeFile, Dir: dir})
+	assert.ErrorContains(t, err, "do not match the provenance")
+	out.Reset()
+	require.NoError(t, c.Verify(ctx, cli.VerifyOptions{PublicKey: publicKey, EnvelopeFile: envelopeFile}))
+	assert.Contains(t, out.String(), `"filesChecked": false`)
+
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	err = c.Verify(ctx, cli.VerifyOptions{PublicKey: otherKey, EnvelopeFile: envelopeFile})
+	assert.ErrorIs(t, err, provenance.ErrInvalidSignature)
+
+	_, err = store.Upload(ctx, "p1", "d3", dir)
+	require.NoError(t, err)
+	unsigned := &deployment.Record{DeploymentId: "d3", ProjectId: "p1", Status: deployment.StatusSucceeded, ArtifactsFrom: "d3"}
+	require.NoError(t, store.SaveRecord(ctx, unsigned))
+	err = c.Verify(ctx, cli.VerifyOptions{ProjectId: "p1", DeploymentId: "d3", PublicKey: publicKey})
+	assert.ErrorContains(t, err, "has no provenance")
+}
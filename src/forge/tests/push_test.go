@@ -0,0 +1,300 @@
+package worker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"forge/internal/api"
+	"forge/internal/database"
+	"forge/internal/push"
+	"forge/internal/worker"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeTriggerStore struct {
+	triggers   []database.PushTrigger
+	lookups    int
+	deliveries map[string]string
+}
+
+func (f *fakeTriggerStore) PutPushTrigger(ctx context.Context, trigger *database.PushTrigger) (bool, error) {
+	f.triggers = append(f.triggers, *trigger)
+	return true, nil
+}
+
+func (f *fakeTriggerStore) GetPushTrigger(ctx context.Context, projectId string) (*database.PushTrigger, error) {
+	return nil, database.ErrTriggerNotFound
+}
+
+func (f *fakeTriggerStore) DeletePushTrigger(ctx context.Context, projectId string) error {
+	return database.ErrTriggerNotFound
+}
+
+func (f *fakeTriggerStore) FindPushTriggers(ctx context.Context, repo string) ([]database.PushTrigger, error) {
+	f.lookups++
+	var found []database.PushTrigger
+	for _, trigger := range f.triggers {
+		if trigger.Repo == repo {
+			found = append(found, trigger)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeTriggerStore) ClaimPushDelivery(ctx context.Context, digest, delivery string) (bool, error) {
+	if f.deliveries == nil {
+		f.deliveries = make(map[string]string)
+	}
+	for claimed, claimedDelivery := range f.deliveries {
+		if claimed == digest || (delivery != "" && claimedDelivery == delivery) {
+			return false, nil
+		}
+	}
+	f.deliveries[digest] = delivery
+	return true, nil
+}
+
+func (f *fakeTriggerStore) ReleasePushDelivery(ctx context.Context, digest string) error {
+	delete(f.deliveries, digest)
+	return nil
+}
+
+type fakeBuildQueue struct {
+	mu        sync.Mutex
+	messages  []*worker.BuildMessage
+	deletions []*worker.DeletePreviewMessage
+	// failing is a project whose builds fail to enqueue.
+	failing string
+}
+
+func (f *fakeBuildQueue) Enqueue(ctx context.Context, msg *worker.BuildMessage) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if msg.ProjectId == f.failing {
+		return "", errors.New("queue is unavailable")
+	}
+	f.messages = append(f.messages, msg)
+	return "message", nil
+}
+
+func (f *fakeBuildQueue) EnqueueDeletePreview(ctx context.Context, msg *worker.DeletePreviewMessage) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletions = append(f.deletions, msg)
+	return "deletion", nil
+}
+
+func hmacHex(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestNormalizeRepoURL(t *testing.T) {
+	for _, raw := range []string{
+		"https://github.com/Acme/Site.git",
+		"https://github.com/acme/site",
+		"git@github.com:acme/site.git",
+		"ssh://git@github.com/acme/site.git",
+		"https://github.com/acme/site/",
+	} {
+		assert.Equal(t, "github.com/acme/site", push.NormalizeRepoURL(raw), raw)
+	}
+	assert.Empty(t, push.NormalizeRepoURL("not a url"))
+}
+
+func TestParsePush(t *testing.T) {
+	github := http.Header{}
+	github.Set("X-GitHub-Event", "push")
+	p, err := push.Parse(github, []byte(`{"ref":"refs/heads/main","after":"abc","repository":{"clone_url":"https://github.com/acme/site.git","ssh_url":"git@github.com:acme/site.git"}}`))
+	require.NoError(t, err)
+	branch, ok := p.Branch()
+	assert.True(t, ok)
+	assert.Equal(t, "main", branch)
+	assert.Equal(t, []string{"github.com/acme/site"}, p.RepoURLs)
+	assert.False(t, p.Deleted())
+
+	gitlab := http.Header{}
+	gitlab.Set("X-Gitlab-Event", "Push Hook")
+	p, err = push.Parse(gitlab, []byte(`{"ref":"refs/heads/dev","after":"0000000000000000000000000000000000000000","project":{"git_http_url":"https://gitlab.com/acme/site.git"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, push.ProviderGitLab, p.Provider)
+	assert.True(t, p.Deleted())
+
+	// Gitea also sends the GitHub headers
+	gitea := http.Header{}
+	gitea.Set("X-Gitea-Event", "push")
+	gitea.Set("X-GitHub-Event", "push")
+	p, err = push.Parse(gitea, []byte(`{"ref":"refs/tags/v1","after":"abc","repository":{"clone_url":"https://gitea.example.com/acme/site.git"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, push.ProviderGitea, p.Provider)
+	_, ok = p.Branch()
+	assert.False(t, ok)
+
+	ping := http.Header{}
+	ping.Set("X-GitHub-Event", "ping")
+	_, err = push.Parse(ping, []byte(`{}`))
+	assert.ErrorIs(t, err, push.ErrNotPush)
+}
+
+func TestVerifyPush(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+
+	github := http.Header{}
+	github.Set("X-Hub-Signature-256", "sha256="+hmacHex("secret", string(body)))
+	assert.True(t, push.Verify(push.ProviderGitHub, github, body, "secret"))
+	assert.False(t, push.Verify(push.ProviderGitHub, github, body, "other"))
+	assert.False(t, push.Verify(push.ProviderGitHub, github, []byte(`{}`), "secret"))
+
+	gitea := http.Header{}
+	gitea.Set("X-Gitea-Signature", hmacHex("secret", string(body)))
+	assert.True(t, push.Verify(push.ProviderGitea, gitea, body, "secret"))
+
+	gitlab := http.Header{}
+	gitlab.Set("X-Gitlab-Token", "secret")
+	assert.True(t, push.Verify(push.ProviderGitLab, gitlab, body, "secret"))
+	assert.False(t, push.Verify(push.ProviderGitLab, http.Header{}, body, ""))
+}
+
+func TestReceivePush(t *testing.T) {
+	triggers := &fakeTriggerStore{triggers: []database.PushTrigger{
+		{ProjectId: "site", Repo: "github.com/acme/site", RepoURL: "https://github.com/acme/site", Branch: "main", BuildCommand: "npm run build", Secret: "site-secret"},
+		{ProjectId: "docs", Repo: "github.com/acme/site", RepoURL: "https://github.com/acme/site", Branch: "main", Secret: "docs-secret"},
+		{ProjectId: "staging", Repo: "github.com/acme/site", RepoURL: "https://github.com/acme/site", Branch: "staging", Secret: "site-secret"},
+	}}
+	builds := &fakeBuildQueue{}
+	server := httptest.NewServer(api.NewHooksHandler(&api.Services{Triggers: triggers, Builds: builds}))
+	defer server.Close()
+
+	send := func(body, secret string) *http.Response {
+		req, err := http.NewRequest("POST", server.URL+"/hooks/push", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-GitHub-Event", "push")
+		if secret != "" {
+			req.Header.Set("X-Hub-Signature-256", "sha256="+hmacHex(secret, body))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	body := `{"ref":"refs/heads/main","after":"abc123","repository":{"clone_url":"https://github.com/acme/site.git"}}`
+	// Unsigned webhooks are refused before the repository is looked up
+	assert.Equal(t, http.StatusUnauthorized, send(body, "").StatusCode)
+	assert.Zero(t, triggers.lookups)
+	assert.Equal(t, http.StatusUnauthorized, send(body, "wrong").StatusCode)
+	assert.Empty(t, builds.messages)
+
+	// Only the projects signed for and deployed from the pushed branch are built
+	resp := send(body, "site-secret")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var result struct {
+		Builds []api.QueuedBuild `json:"builds"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.Len(t, result.Builds, 1)
+	require.Len(t, builds.messages, 1)
+	msg := builds.messages[0]
+	assert.Equal(t, "site", msg.ProjectId)
+	assert.Equal(t, "abc123", msg.CommitSHA)
+	assert.Equal(t, "main", msg.Ref)
+	assert.Equal(t, "npm run build", msg.BuildCommand)
+	assert.Equal(t, result.Builds[0].DeploymentId, msg.DeploymentId)
+
+	// A replay is ignored
+	assert.Equal(t, http.StatusOK, send(body, "site-secret").StatusCode)
+	assert.Len(t, builds.messages, 1)
+
+	// Unknown repositories look like wrong signatures
+	other := `{"ref":"refs/heads/main","after":"abc123","repository":{"clone_url":"https://github.com/acme/other.git"}}`
+	assert.Equal(t, http.StatusUnauthorized, send(other, "site-secret").StatusCode)
+}
+
+func TestReceivePushRedelivery(t *testing.T) {
+	triggers := &fakeTriggerStore{triggers: []database.PushTrigger{
+		{ProjectId: "site", Repo: "github.com/acme/site", RepoURL: "https://github.com/acme/site", Branch: "main", Secret: "secret"},
+		{ProjectId: "docs", Repo: "github.com/acme/site", RepoURL: "https://github.com/acme/site", Branch: "main", Secret: "secret"},
+	}}
+	builds := &fakeBuildQueue{failing: "docs"}
+	server := httptest.NewServer(api.NewHooksHandler(&api.Services{Triggers: triggers, Builds: builds}))
+	defer server.Close()
+
+	body := `{"ref":"refs/heads/main","after":"abc123","repository":{"clone_url":"https://github.com/acme/site.git"}}`
+	send := func(delivery string) int {
+		req, err := http.NewRequest("POST", server.URL+"/hooks/push", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-GitHub-Delivery", delivery)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hmacHex("secret", body))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, send("delivery-1"))
+	require.Len(t, builds.messages, 1)
+
+	// The redelivery enqueues the build of site again under the same deployment
+	builds.failing = ""
+	assert.Equal(t, http.StatusAccepted, send("delivery-1"))
+	require.Len(t, builds.messages, 3)
+	assert.Equal(t, builds.messages[0].DeploymentId, builds.messages[1].DeploymentId)
+	assert.Equal(t, "docs", builds.messages[2].ProjectId)
+
+	// Once processed, the delivery and its body are not processed again
+	assert.Equal(t, http.StatusOK, send("delivery-1"))
+	assert.Equal(t, http.StatusOK, send("delivery-2"))
+	assert.Len(t, builds.messages, 3)
+}
+
+func TestReceivePushPreviews(t *testing.T) {
+	triggers := &fakeTriggerStore{triggers: []database.PushTrigger{
+		{ProjectId: "site", Repo: "github.com/acme/site", RepoURL: "https://github.com/acme/site", Branch: "main", Previews: true, Secret: "secret"},
+		{ProjectId: "docs", Repo: "github.com/acme/site", RepoURL: "https://github.com/acme/site", Branch: "main", Secret: "secret"},
+	}}
+	builds := &fakeBuildQueue{}
+	server := httptest.NewServer(api.NewHooksHandler(&api.Services{Triggers: triggers, Builds: builds}))
+	defer server.Close()
+
+	send := func(body string) {
+		req, err := http.NewRequest("POST", server.URL+"/hooks/push", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-GitHub-Event", "push")
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hmacHex("secret", body))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	}
+
+	// Only the project with previews builds the other branch, as a preview
+	send(`{"ref":"refs/heads/feature/x","after":"abc123","repository":{"clone_url":"https://github.com/acme/site.git"}}`)
+	require.Len(t, builds.messages, 1)
+	assert.Equal(t, "site", builds.messages[0].ProjectId)
+	assert.Equal(t, "feature/x", builds.messages[0].Ref)
+	assert.Equal(t, "feature/x", builds.messages[0].PreviewBranch)
+
+	// Deleting the branch deletes its preview
+	send(`{"ref":"refs/heads/feature/x","after":"0000000000000000000000000000000000000000","repository":{"clone_url":"https://github.com/acme/site.git"}}`)
+	assert.Len(t, builds.messages, 1)
+	require.Len(t, builds.deletions, 1)
+	assert.Equal(t, &worker.DeletePreviewMessage{ProjectId: "site", Branch: "feature/x", RequestedBy: "push"}, builds.deletions[0])
+
+	// Pushes to the deployed branch are no previews
+	send(`{"ref":"refs/heads/main","after":"def456","repository":{"clone_url":"https://github.com/acme/site.git"}}`)
+	require.Len(t, builds.messages, 3)
+	assert.Empty(t, builds.messages[1].PreviewBranch)
+	assert.Empty(t, builds.messages[2].PreviewBranch)
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"forge/internal/worker"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type echoPayload struct {
+	Text string `json:"text"`
+}
+
+func (p *echoPayload) Validate() error {
+	if p.Text == "" {
+		return errors.New("message has no text")
+	}
+	return nil
+}
+
+func echoMessage(body, version string) types.Message {
+	message := types.Message{
+		Body: aws.String(body),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"MessageType": {DataType: aws.String("String"), StringValue: aws.String("Echo")},
+		},
+	}
+	if version != "" {
+		message.MessageAttributes["MessageVersion"] = types.MessageAttributeValue{DataType: aws.String("Number"), StringValue: aws.String(version)}
+	}
+	return message
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	registry, err := worker.NewRegistry(&worker.Services{}, &worker.RegistryConfig{Unknown: worker.UnknownReject})
+	assert.NoError(t, err)
+
+	var handled []string
+	worker.Register(registry, "Echo", 1, func(ctx context.Context, services *worker.Services, message types.Message, payload *echoPayload) error {
+		handled = append(handled, "v1:"+payload.Text)
+		return nil
+	})
+	worker.Register(registry, "Echo", 2, func(ctx context.Context, services *worker.Services, message types.Message, payload *echoPayload) error {
+		handled = append(handled, "v2:"+payload.Text)
+		return nil
+	})
+	assert.Contains(t, registry.Types(), "Echo")
+
+	// Messages without a version are version 1
+	assert.NoError(t, registry.ProcessMessage(ctx, echoMessage(`{"text": "a"}`, "")))
+	assert.NoError(t, registry.ProcessMessage(ctx, echoMessage(`{"text": "b"}`, "2")))
+	assert.Equal(t, []string{"v1:a", "v2:b"}, handled)
+
+	assertPoison(t, registry.ProcessMessage(ctx, echoMessage(`{}`, "1")), worker.ReasonMalformedMessage, "Expected invalid payload to be rejected")
+	assertPoison(t, registry.ProcessMessage(ctx, echoMessage(`{"text": "c"}`, "two")), worker.ReasonMalformedMessage, "Expected invalid version to be rejected")
+	assertPoison(t, registry.ProcessMessage(ctx, echoMessage(`{"text": "c"}`, "3")), worker.ReasonUnsupportedType, "Expected unknown version to be rejected")
+
+	// Messages of types other workers handle are left for them
+	promoting, err := worker.NewRegistry(&worker.Services{}, &worker.RegistryConfig{Types: []string{"Promote"}, Unknown: worker.UnknownReject})
+	assert.NoError(t, err)
+	worker.Register(promoting, "Echo", 1, func(ctx context.Context, services *worker.Services, message types.Message, payload *echoPayload) error {
+		return nil
+	})
+	assert.ErrorIs(t, promoting.ProcessMessage(ctx, echoMessage(`{"text": "d"}`, "")), worker.ErrReleased)
+	assertPoison(t, promoting.ProcessMessage(ctx, echoMessage(`{"text": "d"}`, "3")), worker.ReasonUnsupportedType, "Expected unknown version to be rejected")
+
+	// Other workers may handle what this one does not
+	releasing, err := worker.NewRegistry(&worker.Services{}, &worker.RegistryConfig{Types: []string{"Promote"}, Unknown: worker.UnknownRelease})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, releasing.ProcessMessage(ctx, echoMessage(`{"text": "d"}`, "")), worker.ErrReleased)
+
+	assert.Error(t, registry.Enable("Unknown"))
+
+	control, err := registry.Only(worker.MessageTypeCancel)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{worker.MessageTypeCancel}, control.Types())
+	assertPoison(t, control.ProcessMessage(ctx, echoMessage(`{"text": "e"}`, "")), worker.ReasonUnsupportedType, "Expected other types on the control queue to be rejected")
+}
@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forge/internal/utils"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultSandboxConfig(t *testing.T) {
+	cfg := utils.DefaultSandboxConfig()
+	assert.Equal(t, "node", cfg.User)
+	assert.Equal(t, 15*time.Minute, cfg.Timeout)
+	assert.Equal(t, int64(1e9), cfg.NanoCPUs)
+	assert.Equal(t, int64(2*1024*1024*1024), cfg.MemoryBytes)
+	assert.Equal(t, int64(512), cfg.PidsLimit)
+	assert.Equal(t, int64(10*1024*1024*1024), cfg.DepsCacheMaxSize)
+}
+
+func TestFailureReason(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &utils.BuildError{Reason: utils.ReasonMemoryLimit, Err: errors.New("killed")})
+	assert.Equal(t, utils.ReasonMemoryLimit, utils.FailureReason(err))
+	assert.Equal(t, utils.ReasonInternal, utils.FailureReason(errors.New("docker unavailable")))
+}
+
+func TestSeccompProfile(t *testing.T) {
+	data, err := os.ReadFile("../build-seccomp.json")
+	assert.NoError(t, err)
+
+	var profile struct {
+		DefaultAction string `json:"defaultAction"`
+		Syscalls      []struct {
+			Names    []string        `json:"names"`
+			Action   string          `json:"action"`
+			Args     json.RawMessage `json:"args"`
+			Includes struct {
+				Caps []string `json:"caps"`
+			} `json:"includes"`
+		} `json:"syscalls"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &profile))
+	assert.Equal(t, "SCMP_ACT_ERRNO", profile.DefaultAction, "Expected syscalls to be denied unless allowed")
+
+	allowed := make(map[string]bool)
+	for _, rule := range profile.Syscalls {
+		assert.Empty(t, rule.Includes.Caps, "Expected no rules for capabilities the sandbox drops")
+		if rule.Action == "SCMP_ACT_ALLOW" && len(rule.Args) == 0 {
+			for _, name := range rule.Names {
+				allowed[name] = true
+			}
+		}
+	}
+
+	assert.True(t, allowed["read"])
+	for _, name := range []string{"clone", "unshare", "setns", "mount", "ptrace", "process_vm_readv", "bpf", "keyctl", "io_uring_setup", "userfaultfd"} {
+		assert.False(t, allowed[name], "Expected %s not to be allowed unconditionally", name)
+	}
+}
@@ -1,10 +1,15 @@
 package worker
 
 import (
+	"context"
+	"errors"
+	"forge/internal/database"
+	"forge/internal/deployment"
 	"forge/internal/service"
 	"forge/internal/worker"
 	"log"
 	"testing"
+	"time"
 
 	pbProject "forge/internal/genprotobuf/project"
 
@@ -18,21 +23,116 @@ type MockSQSService struct {
 }
 
 type MockGrpcClient1 struct {
-	conn string
+	conn    string
+	status  pbProject.ProjectStatus
+	reports []*pbProject.ReportDeploymentRequest
 }
 
-func (g *MockGrpcClient1) UpdateProjectStatus(projectId string, status pbProject.ProjectStatus) {
+func (g *MockGrpcClient1) UpdateProjectStatus(ctx context.Context, projectId string, status pbProject.ProjectStatus) error {
 	log.Println("projectId: ", projectId, " status", status)
+	g.status = status
+	return nil
+}
+
+func (g *MockGrpcClient1) ReportDeployment(ctx context.Context, report *pbProject.ReportDeploymentRequest) error {
+	g.reports = append(g.reports, report)
+	return nil
 }
 
 type MockGrpcClient2 struct {
 	conn string
 }
 
-func (g *MockGrpcClient2) PushLogs(projectId string, buildLogs service.LogEntry) (bool, string) {
+func (g *MockGrpcClient2) PushLogs(ctx context.Context, projectId string, buildLogs service.LogEntry) (bool, string) {
 	log.Println("projectId: ", projectId)
 	return true, "success"
 }
+
+func (g *MockGrpcClient2) PurgeLogs(ctx context.Context, projectId string) (int64, error) {
+	return 0, nil
+}
+
+type MockLeaseDB struct {
+	state         database.LeaseState
+	cancellations map[string]string
+	building      bool
+	deleted       []string
+	failures      map[string]int
+	redrives      map[string]string
+	lease         *database.BuildLease
+}
+
+func (d *MockLeaseDB) Migrate() error { return nil }
+
+func (d *MockLeaseDB) Close() error { return nil }
+
+func (d *MockLeaseDB) AcquireBuildLease(ctx context.Context, projectId, deploymentId string, requestedAt time.Time, holder string, ttl time.Duration) (database.LeaseState, error) {
+	return d.state, nil
+}
+
+func (d *MockLeaseDB) RenewBuildLease(ctx context.Context, projectId, deploymentId, holder string, ttl time.Duration) (database.LeaseState, error) {
+	return d.state, nil
+}
+
+func (d *MockLeaseDB) ReleaseBuildLease(ctx context.Context, projectId, deploymentId, holder string) error {
+	return nil
+}
+
+func (d *MockLeaseDB) RecordBuildFailure(ctx context.Context, projectId, deploymentId, redriveId string) (int, error) {
+	if d.failures == nil {
+		d.failures = make(map[string]int)
+		d.redrives = make(map[string]string)
+	}
+	if d.redrives[deploymentId] != redriveId {
+		d.failures[deploymentId] = 0
+		d.redrives[deploymentId] = redriveId
+	}
+	d.failures[deploymentId]++
+	return d.failures[deploymentId], nil
+}
+
+func (d *MockLeaseDB) GetBuildLease(ctx context.Context, projectId string) (*database.BuildLease, error) {
+	if d.lease == nil {
+		return nil, database.ErrNoBuildLease
+	}
+	return d.lease, nil
+}
+
+func (d *MockLeaseDB) CancelBuild(ctx context.Context, projectId, deploymentId, cancelledBy string) error {
+	d.cancellations[deploymentId] = cancelledBy
+	return nil
+}
+
+func (d *MockLeaseDB) GetCancellation(ctx context.Context, deploymentId string) (*database.Cancellation, error) {
+	cancelledBy, ok := d.cancellations[deploymentId]
+	if !ok {
+		return nil, database.ErrNotCancelled
+	}
+	return &database.Cancellation{DeploymentId: deploymentId, CancelledBy: cancelledBy}, nil
+}
+
+func (d *MockLeaseDB) DeleteProject(ctx context.Context, projectId string) (bool, error) {
+	d.deleted = append(d.deleted, projectId)
+	return d.building, nil
+}
+
+func newRegistry(t *testing.T, projectService service.ProjectService, logService service.ProjectLogService, db database.Service, cfg *worker.RegistryConfig) *worker.Registry {
+	registry, err := worker.NewRegistry(&worker.Services{
+		Projects: projectService,
+		Logs:     logService,
+		DB:       db,
+		Store:    deployment.NewStore(newMemBucket()),
+		// Repositories resolve to a private address, so builds fail without reaching the network
+		Repos: newTestValidator(map[string]string{"github.com": "10.0.0.1"}),
+		// Only launchpad names the user who cancelled
+		TrustedSenders: []string{"AROALAUNCHPAD"},
+	}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
 func TestProcessMessage(t *testing.T) {
 	// mock grpc client
 	mockClient1 := &MockGrpcClient1{
@@ -42,9 +142,11 @@ func TestProcessMessage(t *testing.T) {
 	mockClient2 := &MockGrpcClient2{
 		conn: "logs-test",
 	}
+
+	mockDB := &MockLeaseDB{state: database.LeaseHeld}
 	// Mock SQS message
 	mockMessage := types.Message{
-		Body: aws.String(`{"repoURL": "https://github.com/example/repo", "buildCommand": "go build"}`),
+		Body: aws.String(`{"projectId": "project-test", "repoURL": "https://github.com/example/repo", "buildCommand": "go build"}`),
 		MessageAttributes: map[string]types.MessageAttributeValue{
 			"MessageType": {
 				DataType:    aws.String("String"),
@@ -53,11 +155,40 @@ func TestProcessMessage(t *testing.T) {
 		},
 	}
 
-	isProcessed := worker.ProcessMessage(mockMessage, "Build", mockClient1, mockClient2)
-	assert.True(t, isProcessed, "Expected message to be processed")
+	registry := newRegistry(t, mockClient1, mockClient2, mockDB, &worker.RegistryConfig{Types: []string{"Build"}, Unknown: worker.UnknownReject})
 
-	isProcessed = worker.ProcessMessage(mockMessage, "invalid-type", mockClient1, mockClient2)
-	assert.False(t, isProcessed, "Expected message to be rejected due to invalid type")
+	// The Build handler receives the decoded payload
+	var dispatched []*worker.BuildMessage
+	spy := newRegistry(t, mockClient1, mockClient2, mockDB, &worker.RegistryConfig{Types: []string{"Build"}, Unknown: worker.UnknownReject})
+	worker.Register(spy, worker.MessageTypeBuild, 1, func(ctx context.Context, services *worker.Services, message types.Message, payload *worker.BuildMessage) error {
+		dispatched = append(dispatched, payload)
+		return nil
+	})
+	assert.NoError(t, spy.ProcessMessage(context.Background(), mockMessage))
+	if assert.Len(t, dispatched, 1) {
+		assert.Equal(t, "project-test", dispatched[0].ProjectId)
+		assert.Equal(t, "https://github.com/example/repo", dispatched[0].RepoURL)
+		assert.Equal(t, "go build", dispatched[0].BuildCommand)
+	}
+
+	// The build handler fails the deployment before cloning, the stub resolves
+	// the repository host to a private address
+	err := registry.ProcessMessage(context.Background(), mockMessage)
+	assert.NoError(t, err, "Expected message to be processed")
+	assert.Equal(t, pbProject.ProjectStatus_NOT_LIVE, mockClient1.status)
+	if assert.Len(t, mockClient1.reports, 1) {
+		assert.Equal(t, deployment.StatusFailed, mockClient1.reports[0].Status)
+		assert.Equal(t, "invalid_repo_url", mockClient1.reports[0].FailureReason)
+	}
+
+	// An older deployment of the project is still being built
+	busyRegistry := newRegistry(t, mockClient1, mockClient2, &MockLeaseDB{state: database.LeaseBusy}, &worker.RegistryConfig{Unknown: worker.UnknownReject})
+	err = busyRegistry.ProcessMessage(context.Background(), mockMessage)
+	assert.ErrorIs(t, err, worker.ErrProjectBusy)
+
+	promoteOnly := newRegistry(t, mockClient1, mockClient2, mockDB, &worker.RegistryConfig{Types: []string{"Promote"}, Unknown: worker.UnknownReject})
+	err = promoteOnly.ProcessMessage(context.Background(), mockMessage)
+	assert.ErrorIs(t, err, worker.ErrReleased, "Expected message to be left for the build workers")
 
 	// Test invalid JSON message body
 	invalidMessage := types.Message{
@@ -70,15 +201,169 @@ func TestProcessMessage(t *testing.T) {
 		},
 	}
 
-	isProcessed = worker.ProcessMessage(invalidMessage, "Build", mockClient1, mockClient2)
-	assert.False(t, isProcessed, "Expected message with invalid JSON to be rejected")
+	err = registry.ProcessMessage(context.Background(), invalidMessage)
+	assertPoison(t, err, worker.ReasonMalformedMessage, "Expected message with invalid JSON to be rejected")
 
 	// Test missing message attributes
 	missingAttributes := types.Message{
 		Body: aws.String(`{"repoURL": "https://github.com/example/repo", "buildCommand": "go build"}`),
 	}
 
-	isProcessed = worker.ProcessMessage(missingAttributes, "Build", mockClient1, mockClient2)
+	err = registry.ProcessMessage(context.Background(), missingAttributes)
+
+	assertPoison(t, err, worker.ReasonMalformedMessage, "Expected message with missing attributes to be rejected")
+}
+
+func TestProcessRedeliveredBuild(t *testing.T) {
+	projectClient := &MockGrpcClient1{conn: "project-test"}
+	store := deployment.NewStore(newMemBucket())
+	registry, err := worker.NewRegistry(&worker.Services{
+		Projects: projectClient,
+		Logs:     &MockGrpcClient2{conn: "logs-test"},
+		DB:       &MockLeaseDB{state: database.LeaseHeld},
+		Store:    store,
+		Repos:    newTestValidator(map[string]string{"github.com": "10.0.0.1"}),
+	}, &worker.RegistryConfig{Types: []string{"Build"}, Unknown: worker.UnknownReject})
+	assert.NoError(t, err)
+
+	// The deployment was built before the push was delivered again
+	assert.NoError(t, store.SaveRecord(context.Background(), &deployment.Record{DeploymentId: "deployment-test", ProjectId: "project-test", Status: deployment.StatusSucceeded}))
+	message := types.Message{
+		Body: aws.String(`{"projectId": "project-test", "deploymentId": "deployment-test", "repoURL": "https://github.com/example/repo", "buildCommand": "go build"}`),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"MessageType": {DataType: aws.String("String"), StringValue: aws.String("Build")},
+		},
+	}
+	assert.NoError(t, registry.ProcessMessage(context.Background(), message))
+	assert.Empty(t, projectClient.reports)
+	assert.Equal(t, pbProject.ProjectStatus(0), projectClient.status, "Expected the status to be left alone")
+
+	record, err := store.GetRecord(context.Background(), "project-test", "deployment-test")
+	if assert.NoError(t, err) {
+		assert.Equal(t, deployment.StatusSucceeded, record.Status)
+	}
+
+	// Failed deployments are dropped too, unless they were redriven from the dead-letter queue
+	assert.NoError(t, store.SaveRecord(context.Background(), &deployment.Record{DeploymentId: "deployment-test", ProjectId: "project-test", Status: deployment.StatusFailed}))
+	assert.NoError(t, registry.ProcessMessage(context.Background(), message))
+	assert.Empty(t, projectClient.reports)
+
+	message.MessageAttributes["RedriveId"] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String("redrive-test")}
+	assert.NoError(t, registry.ProcessMessage(context.Background(), message))
+	if assert.Len(t, projectClient.reports, 1, "Expected the redriven deployment to be built again") {
+		assert.Equal(t, "invalid_repo_url", projectClient.reports[0].FailureReason)
+	}
+}
+
+func assertPoison(t *testing.T, err error, reason string, msg string) {
+	var poisonErr *worker.PoisonError
+	if assert.True(t, errors.As(err, &poisonErr), msg) {
+		assert.Equal(t, reason, poisonErr.Reason, msg)
+	}
+}
+
+func TestProcessCancelMessage(t *testing.T) {
+	projectClient := &MockGrpcClient1{conn: "project-test"}
+	logClient := &MockGrpcClient2{conn: "logs-test"}
+	mockDB := &MockLeaseDB{state: database.LeaseCancelled, cancellations: make(map[string]string)}
+	// Cancel messages are handled by Build workers too
+	registry := newRegistry(t, projectClient, logClient, mockDB, &worker.RegistryConfig{Types: []string{"Build", worker.MessageTypeCancel}, Unknown: worker.UnknownReject})
+
+	// Only trusted senders name the user who cancelled
+	cancelMessage := types.Message{
+		Body:       aws.String(`{"projectId": "project-test", "deploymentId": "deployment-test", "cancelledBy": "user-test"}`),
+		Attributes: map[string]string{"SenderId": "AROALAUNCHPAD:launchpad-1"},
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"MessageType": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(worker.MessageTypeCancel),
+			},
+		},
+	}
+
+	err := registry.ProcessMessage(context.Background(), cancelMessage)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-test", mockDB.cancellations["deployment-test"])
 
-	assert.False(t, isProcessed, "Expected message with missing attributes to be rejected")
+	untrusted := types.Message{
+		Body:              aws.String(`{"projectId": "project-test", "deploymentId": "deployment-other", "cancelledBy": "user-test"}`),
+		Attributes:        map[string]string{"SenderId": "AIDAOTHER"},
+		MessageAttributes: cancelMessage.MessageAttributes,
+	}
+	assert.NoError(t, registry.ProcessMessage(context.Background(), untrusted))
+	assert.Equal(t, "AIDAOTHER", mockDB.cancellations["deployment-other"])
+
+	// The cancelled deployment is not built once its message is received
+	buildMessage := types.Message{
+		Body: aws.String(`{"projectId": "project-test", "deploymentId": "deployment-test", "repoURL": "https://github.com/example/repo"}`),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"MessageType": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String("Build"),
+			},
+		},
+	}
+	err = registry.ProcessMessage(context.Background(), buildMessage)
+	assert.NoError(t, err)
+	assert.Equal(t, pbProject.ProjectStatus_CANCELLED, projectClient.status)
+	// The cancelled deployment is part of the deployment history
+	if assert.Len(t, projectClient.reports, 1) {
+		assert.Equal(t, "deployment-test", projectClient.reports[0].DeploymentId)
+		assert.Equal(t, "CANCELLED", projectClient.reports[0].Status)
+	}
+
+	// Finished deployments are not cancelled again
+	finished := types.Message{
+		Body:              aws.String(`{"projectId": "project-test", "deploymentId": "deployment-test", "cancelledBy": "user-later"}`),
+		Attributes:        cancelMessage.Attributes,
+		MessageAttributes: cancelMessage.MessageAttributes,
+	}
+	assert.NoError(t, registry.ProcessMessage(context.Background(), finished))
+	assert.Equal(t, "user-test", mockDB.cancellations["deployment-test"])
+
+	// Projects that never built have nothing to cancel
+	nothing := types.Message{
+		Body:              aws.String(`{"projectId": "project-idle", "cancelledBy": "user-test"}`),
+		Attributes:        cancelMessage.Attributes,
+		MessageAttributes: cancelMessage.MessageAttributes,
+	}
+	assert.NoError(t, registry.ProcessMessage(context.Background(), nothing))
+	assert.Len(t, mockDB.cancellations, 2)
+
+	missingProject := types.Message{
+		Body:              aws.String(`{"deploymentId": "deployment-test"}`),
+		MessageAttributes: cancelMessage.MessageAttributes,
+	}
+	err = registry.ProcessMessage(context.Background(), missingProject)
+	assertPoison(t, err, worker.ReasonMalformedMessage, "Expected cancel message without a project to be rejected")
+
+	assert.ErrorIs(t, &worker.CancelledError{By: "user-test"}, worker.ErrCancelled)
+}
+
+func TestProcessDeleteProjectMessage(t *testing.T) {
+	mockDB := &MockLeaseDB{state: database.LeaseDeleted, building: true}
+	registry := newRegistry(t, &MockGrpcClient1{}, &MockGrpcClient2{}, mockDB, &worker.RegistryConfig{Unknown: worker.UnknownReject})
+
+	deleteMessage := types.Message{
+		Body: aws.String(`{"projectId": "project-test", "requestedBy": "user-test"}`),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"MessageType": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(worker.MessageTypeDelete),
+			},
+		},
+	}
+
+	// The project is deleted once its running build stopped
+	err := registry.ProcessMessage(context.Background(), deleteMessage)
+	assert.ErrorIs(t, err, worker.ErrBuildRunning)
+	assert.NotErrorIs(t, err, worker.ErrProjectBusy, "Expected the deletion to count as a failed attempt")
+	assert.Equal(t, []string{"project-test"}, mockDB.deleted)
+
+	// Builds of a deleted project are dropped without being recorded
+	buildMessage := types.Message{
+		Body:              aws.String(`{"projectId": "project-test", "repoURL": "https://github.com/example/repo"}`),
+		MessageAttributes: map[string]types.MessageAttributeValue{"MessageType": {DataType: aws.String("String"), StringValue: aws.String("Build")}},
+	}
+	assert.NoError(t, registry.ProcessMessage(context.Background(), buildMessage))
 }
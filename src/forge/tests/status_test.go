@@ -0,0 +1,194 @@
+package worker
+
+import (
+	"context"
+	pb "forge/internal/genprotobuf/project"
+	"forge/internal/service"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeLaunchpad fails status updates while unavailable and records the others.
+type fakeLaunchpad struct {
+	pb.UnimplementedProjectServiceServer
+
+	mu          sync.Mutex
+	unavailable int
+	keys        []string
+	sequences   []int64
+	statuses    map[string]pb.ProjectStatus
+}
+
+func (f *fakeLaunchpad) UpdateProjectStatus(ctx context.Context, req *pb.UpdateProjectStatusRequest) (*pb.UpdateProjectStatusResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.unavailable > 0 {
+		f.unavailable--
+		return nil, status.Error(codes.Unavailable, "launchpad is restarting")
+	}
+	if req.ProjectId == "deleted" {
+		return &pb.UpdateProjectStatusResponse{Success: false, Message: "Project not found"}, nil
+	}
+	f.keys = append(f.keys, req.IdempotencyKey)
+	f.sequences = append(f.sequences, req.Sequence)
+	f.statuses[req.ProjectId] = req.Status
+	return &pb.UpdateProjectStatusResponse{Success: true}, nil
+}
+
+func (f *fakeLaunchpad) setUnavailable(calls int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unavailable = calls
+}
+
+func newStatusClient(t *testing.T) (*fakeLaunchpad, *grpc.ClientConn) {
+	launchpad := &fakeLaunchpad{statuses: map[string]pb.ProjectStatus{}}
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterProjectServiceServer(server, launchpad)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///launchpad",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return launchpad, conn
+}
+
+func TestUpdateProjectStatusRetries(t *testing.T) {
+	launchpad, conn := newStatusClient(t)
+	outbox, err := service.NewOutbox(t.TempDir())
+	require.NoError(t, err)
+	config := &service.StatusConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Timeout: time.Second}
+	client := service.NewProjectServiceClient(conn, outbox, config)
+
+	launchpad.setUnavailable(2)
+	require.NoError(t, client.UpdateProjectStatus(context.Background(), "project", pb.ProjectStatus_LIVE))
+	assert.Equal(t, pb.ProjectStatus_LIVE, launchpad.statuses["project"])
+
+	pending, err := outbox.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// Rejected updates are not kept
+	assert.Error(t, client.UpdateProjectStatus(context.Background(), "deleted", pb.ProjectStatus_LIVE))
+	pending, err = outbox.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestStatusOutboxReplay(t *testing.T) {
+	launchpad, conn := newStatusClient(t)
+	dir := t.TempDir()
+	outbox, err := service.NewOutbox(dir)
+	require.NoError(t, err)
+	config := &service.StatusConfig{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Timeout: time.Second}
+	client := service.NewProjectServiceClient(conn, outbox, config)
+
+	launchpad.setUnavailable(4)
+	assert.Error(t, client.UpdateProjectStatus(context.Background(), "project", pb.ProjectStatus_DEPLOYING))
+	assert.Error(t, client.UpdateProjectStatus(context.Background(), "project", pb.ProjectStatus_LIVE))
+
+	// Only the latest update of a project is replayed
+	pending, err := outbox.Pending()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, pb.ProjectStatus_LIVE, pending[0].Status)
+
+	// A new worker replays what the previous one left
+	outbox, err = service.NewOutbox(dir)
+	require.NoError(t, err)
+	service.NewProjectServiceClient(conn, outbox, config).ReplayOutbox(context.Background())
+
+	assert.Equal(t, pb.ProjectStatus_LIVE, launchpad.statuses["project"])
+	assert.Equal(t, []string{pending[0].IdempotencyKey}, launchpad.keys)
+	pending, err = outbox.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestStatusUpdateSequence(t *testing.T) {
+	launchpad, conn := newStatusClient(t)
+	outbox, err := service.NewOutbox(t.TempDir())
+	require.NoError(t, err)
+	config := &service.StatusConfig{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Timeout: time.Second}
+	client := service.NewProjectServiceClient(conn, outbox, config)
+
+	require.NoError(t, client.UpdateProjectStatus(context.Background(), "project", pb.ProjectStatus_DEPLOYING))
+	require.NoError(t, client.UpdateProjectStatus(context.Background(), "project", pb.ProjectStatus_LIVE))
+	launchpad.setUnavailable(1)
+	assert.Error(t, client.UpdateProjectStatus(context.Background(), "project", pb.ProjectStatus_NOT_LIVE))
+	pending, err := outbox.Pending()
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	client.ReplayOutbox(context.Background())
+
+	// Sequences increase with every update, a replay keeps its own
+	require.Len(t, launchpad.sequences, 3)
+	assert.Less(t, launchpad.sequences[0], launchpad.sequences[1])
+	assert.Less(t, launchpad.sequences[1], launchpad.sequences[2])
+	assert.Equal(t, pending[0].Sequence, launchpad.sequences[2])
+	assert.InDelta(t, time.Now().UnixMicro(), launchpad.sequences[2], float64(time.Minute/time.Microsecond))
+}
+
+func TestStatusOutboxShared(t *testing.T) {
+	dir := t.TempDir()
+	first, err := service.NewOutbox(dir)
+	require.NoError(t, err)
+	second, err := service.NewOutbox(dir)
+	require.NoError(t, err)
+
+	newer := service.StatusUpdate{ProjectId: "project", Status: pb.ProjectStatus_LIVE, IdempotencyKey: "newer", Sequence: 2}
+	older := service.StatusUpdate{ProjectId: "project", Status: pb.ProjectStatus_DEPLOYING, IdempotencyKey: "older", Sequence: 1}
+	require.NoError(t, first.Put(newer))
+	// An older update stored by another worker does not replace a newer one
+	require.NoError(t, second.Put(older))
+
+	pending, err := first.Get("project")
+	require.NoError(t, err)
+	require.NotNil(t, pending)
+	assert.Equal(t, "newer", pending.IdempotencyKey)
+
+	// Acknowledging the older update leaves the newer one pending
+	require.NoError(t, second.Remove(older))
+	pending, err = second.Get("project")
+	require.NoError(t, err)
+	require.NotNil(t, pending)
+	assert.Equal(t, "newer", pending.IdempotencyKey)
+}
+
+func TestStatusRetryStopsWithContext(t *testing.T) {
+	launchpad, conn := newStatusClient(t)
+	outbox, err := service.NewOutbox(t.TempDir())
+	require.NoError(t, err)
+	config := &service.StatusConfig{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour, Timeout: time.Second}
+	client := service.NewProjectServiceClient(conn, outbox, config)
+
+	launchpad.setUnavailable(5)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.Error(t, client.UpdateProjectStatus(ctx, "project", pb.ProjectStatus_LIVE))
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// The update is replayed later
+	pending, err := outbox.Pending()
+	require.NoError(t, err)
+	assert.Len(t, pending, 1)
+}
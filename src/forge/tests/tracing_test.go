@@ -0,0 +1,87 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"forge/internal/monitor"
+	"forge/internal/tracing"
+	"forge/internal/worker"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown := tracing.Install(exporter, "forge-test")
+	defer shutdown(ctx)
+
+	registry, err := worker.NewRegistry(&worker.Services{}, &worker.RegistryConfig{Unknown: worker.UnknownReject})
+	assert.NoError(t, err)
+	worker.Register(registry, "Echo", 1, func(ctx context.Context, services *worker.Services, message types.Message, payload *echoPayload) error {
+		_, endPhase := tracing.StartPhase(ctx, monitor.PhaseClone)
+		endPhase(errors.New("clone failed"))
+		return nil
+	})
+
+	// The trace of the sender is continued
+	message := echoMessage(`{"text": "a"}`, "")
+	message.MessageId = aws.String("message-1")
+	message.MessageAttributes["traceparent"] = types.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"),
+	}
+	assert.NoError(t, registry.ProcessMessage(ctx, message))
+
+	assert.NoError(t, otel.GetTracerProvider().(*sdktrace.TracerProvider).ForceFlush(ctx))
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+
+	phase, process := spans[0], spans[1]
+	assert.Equal(t, "build.clone", phase.Name)
+	assert.Equal(t, codes.Error, phase.Status.Code)
+	assert.Equal(t, process.SpanContext.SpanID(), phase.Parent.SpanID())
+
+	assert.Equal(t, "process Echo", process.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", process.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", process.Parent.SpanID().String())
+}
+
+func TestTracingSetup(t *testing.T) {
+	_, err := tracing.Setup(context.Background(), "forge-test", "jaeger", "")
+	assert.Error(t, err)
+
+	shutdown, err := tracing.Setup(context.Background(), "forge-test", tracing.ExporterStdout, "")
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}
+
+func TestBuildQueueInjectsTrace(t *testing.T) {
+	ctx := context.Background()
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown := tracing.Install(exporter, "forge-test")
+	defer shutdown(ctx)
+
+	client := &fakeSQS{queues: make(map[string][]types.Message)}
+	queue := worker.NewBuildQueue(client, "build-queue")
+
+	ctx, span := tracing.Start(ctx, "push")
+	_, err := queue.Enqueue(ctx, &worker.BuildMessage{ProjectId: "p1"})
+	span.End()
+	assert.NoError(t, err)
+
+	// The worker receiving the message continues the trace of the sender
+	if assert.Len(t, client.queues["build-queue"], 1) {
+		received := tracing.Extract(context.Background(), client.queues["build-queue"][0])
+		assert.Equal(t, span.SpanContext().TraceID(), trace.SpanContextFromContext(received).TraceID())
+		assert.Equal(t, span.SpanContext().SpanID(), trace.SpanContextFromContext(received).SpanID())
+	}
+}
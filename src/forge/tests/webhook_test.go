@@ -0,0 +1,275 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"forge/internal/api"
+	"forge/internal/database"
+	"forge/internal/deployment"
+	"forge/internal/webhook"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWebhookStore keeps webhook endpoints and deliveries in memory.
+type fakeWebhookStore struct {
+	mu         sync.Mutex
+	endpoints  []database.WebhookEndpoint
+	deliveries []database.WebhookDelivery
+}
+
+func (f *fakeWebhookStore) CreateWebhookEndpoint(ctx context.Context, endpoint *database.WebhookEndpoint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	endpoint.CreatedAt = time.Now()
+	f.endpoints = append(f.endpoints, *endpoint)
+	return nil
+}
+
+func (f *fakeWebhookStore) ListWebhookEndpoints(ctx context.Context, projectId string) ([]database.WebhookEndpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	endpoints := []database.WebhookEndpoint{}
+	for _, endpoint := range f.endpoints {
+		if endpoint.ProjectId == projectId {
+			endpoint.Secret = ""
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints, nil
+}
+
+func (f *fakeWebhookStore) DeleteWebhookEndpoint(ctx context.Context, projectId, endpointId string) error {
+	return database.ErrWebhookNotFound
+}
+
+func (f *fakeWebhookStore) EnqueueWebhookDeliveries(ctx context.Context, projectId, eventId, eventType string, payload []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	queued := 0
+	for _, endpoint := range f.endpoints {
+		if endpoint.ProjectId != projectId {
+			continue
+		}
+		if len(endpoint.Events) > 0 && !strings.Contains(strings.Join(endpoint.Events, ","), eventType) {
+			continue
+		}
+		f.deliveries = append(f.deliveries, database.WebhookDelivery{
+			Id: uuid.NewString(), EndpointId: endpoint.Id, ProjectId: projectId, EventId: eventId,
+			EventType: eventType, Payload: payload, Status: database.DeliveryPending, NextAttemptAt: time.Now(),
+		})
+		queued++
+	}
+	return queued, nil
+}
+
+func (f *fakeWebhookStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]database.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var claimed []database.WebhookDelivery
+	for i := range f.deliveries {
+		d := &f.deliveries[i]
+		if d.Status != database.DeliveryPending || d.NextAttemptAt.After(time.Now()) || len(claimed) == limit {
+			continue
+		}
+		d.NextAttemptAt = time.Now().Add(lease)
+		claim := *d
+		for _, endpoint := range f.endpoints {
+			if endpoint.Id == d.EndpointId {
+				claim.URL, claim.Secret = endpoint.URL, endpoint.Secret
+			}
+		}
+		claimed = append(claimed, claim)
+	}
+	return claimed, nil
+}
+
+func (f *fakeWebhookStore) UpdateWebhookDelivery(ctx context.Context, delivery *database.WebhookDelivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.deliveries {
+		if f.deliveries[i].Id == delivery.Id {
+			d := *delivery
+			d.URL, d.Secret = "", ""
+			f.deliveries[i] = d
+		}
+	}
+	return nil
+}
+
+func (f *fakeWebhookStore) ListWebhookDeliveries(ctx context.Context, projectId, status string, limit int) ([]database.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	deliveries := []database.WebhookDelivery{}
+	for _, d := range f.deliveries {
+		if d.ProjectId == projectId && (status == "" || d.Status == status) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+func (f *fakeWebhookStore) ReplayWebhookDelivery(ctx context.Context, projectId, deliveryId string) (*database.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, d := range f.deliveries {
+		if d.ProjectId == projectId && d.Id == deliveryId {
+			replay := database.WebhookDelivery{
+				Id: uuid.NewString(), EndpointId: d.EndpointId, ProjectId: projectId, EventId: d.EventId,
+				EventType: d.EventType, Payload: d.Payload, Status: database.DeliveryPending, NextAttemptAt: time.Now(), ReplayOf: d.Id,
+			}
+			f.deliveries = append(f.deliveries, replay)
+			return &replay, nil
+		}
+	}
+	return nil, database.ErrWebhookNotFound
+}
+
+func (f *fakeWebhookStore) delivery(i int) database.WebhookDelivery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.deliveries[i]
+}
+
+func TestWebhookSignature(t *testing.T) {
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"type":"deployment.succeeded"}`)
+	signature := webhook.Sign("secret", now, body)
+
+	assert.NoError(t, webhook.Verify("secret", signature, timestamp, body, now, 5*time.Minute))
+	assert.ErrorIs(t, webhook.Verify("other", signature, timestamp, body, now, 5*time.Minute), webhook.ErrInvalidSignature)
+	assert.ErrorIs(t, webhook.Verify("secret", signature, timestamp, []byte(`{}`), now, 5*time.Minute), webhook.ErrInvalidSignature)
+	// A captured request cannot be replayed later
+	assert.ErrorIs(t, webhook.Verify("secret", signature, timestamp, body, now.Add(10*time.Minute), 5*time.Minute), webhook.ErrExpiredTimestamp)
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	var mu sync.Mutex
+	failures := 1
+	var received []webhook.Event
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		err := webhook.Verify("whsec_test", r.Header.Get(webhook.HeaderSignature), r.Header.Get(webhook.HeaderTimestamp), body, time.Now(), time.Minute)
+		if !assert.NoError(t, err) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var event webhook.Event
+		require.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, event.Type, r.Header.Get(webhook.HeaderEvent))
+		received = append(received, event)
+	}))
+	defer endpoint.Close()
+
+	store := &fakeWebhookStore{}
+	store.CreateWebhookEndpoint(context.Background(), &database.WebhookEndpoint{
+		Id: "endpoint", ProjectId: "project", URL: endpoint.URL, Secret: "whsec_test",
+		Events: []string{webhook.EventDeploymentSucceeded},
+	})
+	config := &webhook.Config{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Timeout: time.Second, AllowPrivate: true}
+	dispatcher := webhook.NewDispatcher(store, config)
+
+	record := &deployment.Record{DeploymentId: "deployment", ProjectId: "project", Status: deployment.StatusSucceeded}
+	require.NoError(t, dispatcher.Emit(context.Background(), webhook.EventDeploymentStarted, record))
+	require.NoError(t, dispatcher.Emit(context.Background(), webhook.EventDeploymentSucceeded, record))
+	require.Len(t, store.deliveries, 1, "the endpoint is not subscribed to started events")
+
+	// The first attempt fails, the retry is due after the backoff
+	dispatcher.DeliverDue(context.Background())
+	assert.Equal(t, database.DeliveryPending, store.delivery(0).Status)
+	assert.Equal(t, http.StatusBadGateway, store.delivery(0).ResponseStatus)
+	time.Sleep(5 * time.Millisecond)
+	dispatcher.DeliverDue(context.Background())
+
+	delivery := store.delivery(0)
+	assert.Equal(t, database.DeliverySucceeded, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+	require.Len(t, received, 1)
+	assert.Equal(t, "deployment", received[0].Deployment.Id)
+	assert.Equal(t, delivery.EventId, received[0].Id)
+}
+
+func TestWebhookDeliveryBlocksPrivateAddresses(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a private address was called")
+	}))
+	defer endpoint.Close()
+
+	store := &fakeWebhookStore{}
+	store.CreateWebhookEndpoint(context.Background(), &database.WebhookEndpoint{Id: "endpoint", ProjectId: "project", URL: endpoint.URL})
+	config := &webhook.Config{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Timeout: time.Second}
+	dispatcher := webhook.NewDispatcher(store, config)
+
+	record := &deployment.Record{DeploymentId: "deployment", ProjectId: "project", Status: deployment.StatusFailed}
+	require.NoError(t, dispatcher.Emit(context.Background(), webhook.EventDeploymentFailed, record))
+	dispatcher.DeliverDue(context.Background())
+
+	delivery := store.delivery(0)
+	assert.Equal(t, database.DeliveryFailed, delivery.Status)
+	assert.Contains(t, delivery.LastError, "not a public address")
+}
+
+func TestWebhookAPI(t *testing.T) {
+	store := &fakeWebhookStore{}
+	server := httptest.NewServer(api.NewHandler("token", &api.Services{Webhooks: store}))
+	defer server.Close()
+
+	request := func(method, path, body, token string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/projects/project/webhooks", "", "wrong").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/projects/project/webhooks", `{"url":"ftp://example.com"}`, "token").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/projects/project/webhooks", `{"url":"https://example.com","events":["deployment.deleted"]}`, "token").StatusCode)
+
+	resp := request("POST", "/projects/project/webhooks", `{"url":"https://example.com/hook"}`, "token")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var endpoint database.WebhookEndpoint
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&endpoint))
+	assert.True(t, strings.HasPrefix(endpoint.Secret, "whsec_"))
+
+	// The secret is only shown once
+	resp = request("GET", "/projects/project/webhooks", "", "token")
+	var endpoints []database.WebhookEndpoint
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&endpoints))
+	require.Len(t, endpoints, 1)
+	assert.Empty(t, endpoints[0].Secret)
+
+	_, err := store.EnqueueWebhookDeliveries(context.Background(), "project", "event", webhook.EventDeploymentCancelled, []byte(`{}`))
+	require.NoError(t, err)
+
+	resp = request("POST", "/projects/project/webhooks/deliveries/"+store.delivery(0).Id+"/replay", "", "token")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, http.StatusNotFound, request("POST", "/projects/other/webhooks/deliveries/"+store.delivery(0).Id+"/replay", "", "token").StatusCode)
+
+	resp = request("GET", "/projects/project/webhooks/deliveries?status=pending", "", "token")
+	var deliveries []database.WebhookDelivery
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&deliveries))
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, deliveries[0].Id, deliveries[1].ReplayOf)
+}
@@ -120,6 +120,12 @@ const ProjectDetailPage: React.FC = () => {
             Not Deployed
           </Chip>
         );
+      case "CANCELLED":
+        return (
+          <Chip color="warning" variant="flat">
+            Cancelled
+          </Chip>
+        );
       case "DEPLOYING":
         return (
           <Chip
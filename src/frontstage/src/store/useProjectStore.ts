@@ -11,7 +11,7 @@ export interface Project {
   createdAt: string;
   updatedAt: string;
   userId: string;
-  status: "LIVE" | "NOT_LIVE" | "DEPLOYING";
+  status: "LIVE" | "NOT_LIVE" | "DEPLOYING" | "CANCELLED";
 }
 
 interface ProjectStore {
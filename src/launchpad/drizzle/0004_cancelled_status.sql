@@ -0,0 +1 @@
+ALTER TYPE "public"."project_status" ADD VALUE 'CANCELLED';
\ No newline at end of file
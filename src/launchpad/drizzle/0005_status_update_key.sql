@@ -0,0 +1 @@
+ALTER TABLE "projects" ADD COLUMN "status_update_key" varchar;
\ No newline at end of file
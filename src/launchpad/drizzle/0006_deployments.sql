@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS "deployments" (
+	"id" uuid PRIMARY KEY NOT NULL,
+	"project_id" uuid NOT NULL,
+	"status" varchar NOT NULL,
+	"commit_sha" varchar,
+	"phase_durations" jsonb,
+	"output_bytes" bigint,
+	"file_count" bigint,
+	"framework" varchar,
+	"node_version" varchar,
+	"failure_reason" varchar,
+	"preview_url" varchar,
+	"created_at" timestamp,
+	"finished_at" timestamp
+);
+--> statement-breakpoint
+DO $$ BEGIN
+ ALTER TABLE "deployments" ADD CONSTRAINT "deployments_project_id_projects_id_fk" FOREIGN KEY ("project_id") REFERENCES "public"."projects"("id") ON DELETE cascade ON UPDATE no action;
+EXCEPTION
+ WHEN duplicate_object THEN null;
+END $$;
+--> statement-breakpoint
+CREATE INDEX IF NOT EXISTS "deployments_project_id_created_at_idx" ON "deployments" USING btree ("project_id","created_at");
\ No newline at end of file
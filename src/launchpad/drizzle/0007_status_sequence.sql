@@ -0,0 +1 @@
+ALTER TABLE "projects" ADD COLUMN "status_sequence" bigint;
\ No newline at end of file
@@ -1,10 +1,20 @@
-import { pgTable, uuid, varchar, timestamp, pgEnum } from "drizzle-orm/pg-core";
+import {
+  pgTable,
+  uuid,
+  varchar,
+  timestamp,
+  pgEnum,
+  bigint,
+  jsonb,
+  index,
+} from "drizzle-orm/pg-core";
 import { sql } from "drizzle-orm";
 
 export const projectStatusEnum = pgEnum("project_status", [
   "NOT_LIVE",
   "LIVE",
   "DEPLOYING",
+  "CANCELLED",
 ]);
 
 export const Project = pgTable("projects", {
@@ -27,4 +37,37 @@ export const Project = pgTable("projects", {
     .$onUpdateFn(() => sql`now()`),
   userId: varchar("clerk_user_id"),
   status: projectStatusEnum("status").default("NOT_LIVE"),
+  // Idempotency key of the last status update from forge, replays are skipped
+  statusUpdateKey: varchar("status_update_key"),
+  // Sequence of the last status update, older updates arriving late are skipped
+  statusSequence: bigint("status_sequence", { mode: "number" }),
 });
+
+// Deployment history reported by forge once a deployment reaches a final status.
+export const Deployment = pgTable(
+  "deployments",
+  {
+    id: uuid("id").primaryKey(),
+    projectId: uuid("project_id")
+      .notNull()
+      .references(() => Project.id, { onDelete: "cascade" }),
+    status: varchar("status").notNull(),
+    commitSha: varchar("commit_sha"),
+    // Milliseconds per build phase, such as { "clone": 1200 }
+    phaseDurations: jsonb("phase_durations").$type<Record<string, number>>(),
+    outputBytes: bigint("output_bytes", { mode: "number" }),
+    fileCount: bigint("file_count", { mode: "number" }),
+    framework: varchar("framework"),
+    nodeVersion: varchar("node_version"),
+    failureReason: varchar("failure_reason"),
+    previewUrl: varchar("preview_url"),
+    createdAt: timestamp("created_at"),
+    finishedAt: timestamp("finished_at"),
+  },
+  (table) => ({
+    projectCreatedAtIdx: index("deployments_project_id_created_at_idx").on(
+      table.projectId,
+      table.createdAt
+    ),
+  })
+);
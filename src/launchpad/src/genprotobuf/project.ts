@@ -17,6 +17,7 @@ import {
   type ServiceError,
   type UntypedServiceImplementation,
 } from "@grpc/grpc-js";
+import Long from "long";
 import _m0 from "protobufjs/minimal";
 
 export const protobufPackage = "project";
@@ -25,6 +26,7 @@ export enum ProjectStatus {
   NOT_LIVE = 0,
   LIVE = 1,
   DEPLOYING = 2,
+  CANCELLED = 3,
   UNRECOGNIZED = -1,
 }
 
@@ -39,6 +41,9 @@ export function projectStatusFromJSON(object: any): ProjectStatus {
     case 2:
     case "DEPLOYING":
       return ProjectStatus.DEPLOYING;
+    case 3:
+    case "CANCELLED":
+      return ProjectStatus.CANCELLED;
     case -1:
     case "UNRECOGNIZED":
     default:
@@ -54,6 +59,8 @@ export function projectStatusToJSON(object: ProjectStatus): string {
       return "LIVE";
     case ProjectStatus.DEPLOYING:
       return "DEPLOYING";
+    case ProjectStatus.CANCELLED:
+      return "CANCELLED";
     case ProjectStatus.UNRECOGNIZED:
     default:
       return "UNRECOGNIZED";
@@ -63,6 +70,13 @@ export function projectStatusToJSON(object: ProjectStatus): string {
 export interface UpdateProjectStatusRequest {
   projectId: string;
   status: ProjectStatus;
+  /** Identifies an update across retries, so a replayed update is applied once. */
+  idempotencyKey: string;
+  /**
+   * Orders the updates of a project, launchpad ignores an update older than
+   * the last one it applied. Microseconds since the Unix epoch.
+   */
+  sequence: number;
 }
 
 export interface UpdateProjectStatusResponse {
@@ -70,8 +84,41 @@ export interface UpdateProjectStatusResponse {
   message: string;
 }
 
+/** PhaseDuration is how long a build phase took. */
+export interface PhaseDuration {
+  phase: string;
+  durationMs: number;
+}
+
+/**
+ * ReportDeploymentRequest describes a deployment that reached a final status.
+ * A deployment may be reported more than once, the last report wins.
+ */
+export interface ReportDeploymentRequest {
+  projectId: string;
+  deploymentId: string;
+  /** SUCCEEDED, FAILED, SUPERSEDED or CANCELLED. */
+  status: string;
+  commitSha: string;
+  phaseDurations: PhaseDuration[];
+  outputBytes: number;
+  fileCount: number;
+  framework: string;
+  nodeVersion: string;
+  failureReason: string;
+  previewUrl: string;
+  /** Unix milliseconds. */
+  createdAt: number;
+  finishedAt: number;
+}
+
+export interface ReportDeploymentResponse {
+  success: boolean;
+  message: string;
+}
+
 function createBaseUpdateProjectStatusRequest(): UpdateProjectStatusRequest {
-  return { projectId: "", status: 0 };
+  return { projectId: "", status: 0, idempotencyKey: "", sequence: 0 };
 }
 
 export const UpdateProjectStatusRequest = {
@@ -82,6 +129,12 @@ export const UpdateProjectStatusRequest = {
     if (message.status !== 0) {
       writer.uint32(16).int32(message.status);
     }
+    if (message.idempotencyKey !== "") {
+      writer.uint32(26).string(message.idempotencyKey);
+    }
+    if (message.sequence !== 0) {
+      writer.uint32(32).int64(message.sequence);
+    }
     return writer;
   },
 
@@ -106,6 +159,20 @@ export const UpdateProjectStatusRequest = {
 
           message.status = reader.int32() as any;
           continue;
+        case 3:
+          if (tag !== 26) {
+            break;
+          }
+
+          message.idempotencyKey = reader.string();
+          continue;
+        case 4:
+          if (tag !== 32) {
+            break;
+          }
+
+          message.sequence = longToNumber(reader.int64() as Long);
+          continue;
       }
       if ((tag & 7) === 4 || tag === 0) {
         break;
@@ -119,6 +186,8 @@ export const UpdateProjectStatusRequest = {
     return {
       projectId: isSet(object.projectId) ? globalThis.String(object.projectId) : "",
       status: isSet(object.status) ? projectStatusFromJSON(object.status) : 0,
+      idempotencyKey: isSet(object.idempotencyKey) ? globalThis.String(object.idempotencyKey) : "",
+      sequence: isSet(object.sequence) ? globalThis.Number(object.sequence) : 0,
     };
   },
 
@@ -130,6 +199,12 @@ export const UpdateProjectStatusRequest = {
     if (message.status !== 0) {
       obj.status = projectStatusToJSON(message.status);
     }
+    if (message.idempotencyKey !== "") {
+      obj.idempotencyKey = message.idempotencyKey;
+    }
+    if (message.sequence !== 0) {
+      obj.sequence = Math.round(message.sequence);
+    }
     return obj;
   },
 
@@ -140,6 +215,8 @@ export const UpdateProjectStatusRequest = {
     const message = createBaseUpdateProjectStatusRequest();
     message.projectId = object.projectId ?? "";
     message.status = object.status ?? 0;
+    message.idempotencyKey = object.idempotencyKey ?? "";
+    message.sequence = object.sequence ?? 0;
     return message;
   },
 };
@@ -218,6 +295,409 @@ export const UpdateProjectStatusResponse = {
   },
 };
 
+function createBasePhaseDuration(): PhaseDuration {
+  return { phase: "", durationMs: 0 };
+}
+
+export const PhaseDuration = {
+  encode(message: PhaseDuration, writer: _m0.Writer = _m0.Writer.create()): _m0.Writer {
+    if (message.phase !== "") {
+      writer.uint32(10).string(message.phase);
+    }
+    if (message.durationMs !== 0) {
+      writer.uint32(16).int64(message.durationMs);
+    }
+    return writer;
+  },
+
+  decode(input: _m0.Reader | Uint8Array, length?: number): PhaseDuration {
+    const reader = input instanceof _m0.Reader ? input : _m0.Reader.create(input);
+    let end = length === undefined ? reader.len : reader.pos + length;
+    const message = createBasePhaseDuration();
+    while (reader.pos < end) {
+      const tag = reader.uint32();
+      switch (tag >>> 3) {
+        case 1:
+          if (tag !== 10) {
+            break;
+          }
+
+          message.phase = reader.string();
+          continue;
+        case 2:
+          if (tag !== 16) {
+            break;
+          }
+
+          message.durationMs = longToNumber(reader.int64() as Long);
+          continue;
+      }
+      if ((tag & 7) === 4 || tag === 0) {
+        break;
+      }
+      reader.skipType(tag & 7);
+    }
+    return message;
+  },
+
+  fromJSON(object: any): PhaseDuration {
+    return {
+      phase: isSet(object.phase) ? globalThis.String(object.phase) : "",
+      durationMs: isSet(object.durationMs) ? globalThis.Number(object.durationMs) : 0,
+    };
+  },
+
+  toJSON(message: PhaseDuration): unknown {
+    const obj: any = {};
+    if (message.phase !== "") {
+      obj.phase = message.phase;
+    }
+    if (message.durationMs !== 0) {
+      obj.durationMs = Math.round(message.durationMs);
+    }
+    return obj;
+  },
+
+  create<I extends Exact<DeepPartial<PhaseDuration>, I>>(base?: I): PhaseDuration {
+    return PhaseDuration.fromPartial(base ?? ({} as any));
+  },
+  fromPartial<I extends Exact<DeepPartial<PhaseDuration>, I>>(object: I): PhaseDuration {
+    const message = createBasePhaseDuration();
+    message.phase = object.phase ?? "";
+    message.durationMs = object.durationMs ?? 0;
+    return message;
+  },
+};
+
+function createBaseReportDeploymentRequest(): ReportDeploymentRequest {
+  return {
+    projectId: "",
+    deploymentId: "",
+    status: "",
+    commitSha: "",
+    phaseDurations: [],
+    outputBytes: 0,
+    fileCount: 0,
+    framework: "",
+    nodeVersion: "",
+    failureReason: "",
+    previewUrl: "",
+    createdAt: 0,
+    finishedAt: 0,
+  };
+}
+
+export const ReportDeploymentRequest = {
+  encode(message: ReportDeploymentRequest, writer: _m0.Writer = _m0.Writer.create()): _m0.Writer {
+    if (message.projectId !== "") {
+      writer.uint32(10).string(message.projectId);
+    }
+    if (message.deploymentId !== "") {
+      writer.uint32(18).string(message.deploymentId);
+    }
+    if (message.status !== "") {
+      writer.uint32(26).string(message.status);
+    }
+    if (message.commitSha !== "") {
+      writer.uint32(34).string(message.commitSha);
+    }
+    for (const v of message.phaseDurations) {
+      PhaseDuration.encode(v!, writer.uint32(42).fork()).ldelim();
+    }
+    if (message.outputBytes !== 0) {
+      writer.uint32(48).int64(message.outputBytes);
+    }
+    if (message.fileCount !== 0) {
+      writer.uint32(56).int64(message.fileCount);
+    }
+    if (message.framework !== "") {
+      writer.uint32(66).string(message.framework);
+    }
+    if (message.nodeVersion !== "") {
+      writer.uint32(74).string(message.nodeVersion);
+    }
+    if (message.failureReason !== "") {
+      writer.uint32(82).string(message.failureReason);
+    }
+    if (message.previewUrl !== "") {
+      writer.uint32(90).string(message.previewUrl);
+    }
+    if (message.createdAt !== 0) {
+      writer.uint32(96).int64(message.createdAt);
+    }
+    if (message.finishedAt !== 0) {
+      writer.uint32(104).int64(message.finishedAt);
+    }
+    return writer;
+  },
+
+  decode(input: _m0.Reader | Uint8Array, length?: number): ReportDeploymentRequest {
+    const reader = input instanceof _m0.Reader ? input : _m0.Reader.create(input);
+    let end = length === undefined ? reader.len : reader.pos + length;
+    const message = createBaseReportDeploymentRequest();
+    while (reader.pos < end) {
+      const tag = reader.uint32();
+      switch (tag >>> 3) {
+        case 1:
+          if (tag !== 10) {
+            break;
+          }
+
+          message.projectId = reader.string();
+          continue;
+        case 2:
+          if (tag !== 18) {
+            break;
+          }
+
+          message.deploymentId = reader.string();
+          continue;
+        case 3:
+          if (tag !== 26) {
+            break;
+          }
+
+          message.status = reader.string();
+          continue;
+        case 4:
+          if (tag !== 34) {
+            break;
+          }
+
+          message.commitSha = reader.string();
+          continue;
+        case 5:
+          if (tag !== 42) {
+            break;
+          }
+
+          message.phaseDurations.push(PhaseDuration.decode(reader, reader.uint32()));
+          continue;
+        case 6:
+          if (tag !== 48) {
+            break;
+          }
+
+          message.outputBytes = longToNumber(reader.int64() as Long);
+          continue;
+        case 7:
+          if (tag !== 56) {
+            break;
+          }
+
+          message.fileCount = longToNumber(reader.int64() as Long);
+          continue;
+        case 8:
+          if (tag !== 66) {
+            break;
+          }
+
+          message.framework = reader.string();
+          continue;
+        case 9:
+          if (tag !== 74) {
+            break;
+          }
+
+          message.nodeVersion = reader.string();
+          continue;
+        case 10:
+          if (tag !== 82) {
+            break;
+          }
+
+          message.failureReason = reader.string();
+          continue;
+        case 11:
+          if (tag !== 90) {
+            break;
+          }
+
+          message.previewUrl = reader.string();
+          continue;
+        case 12:
+          if (tag !== 96) {
+            break;
+          }
+
+          message.createdAt = longToNumber(reader.int64() as Long);
+          continue;
+        case 13:
+          if (tag !== 104) {
+            break;
+          }
+
+          message.finishedAt = longToNumber(reader.int64() as Long);
+          continue;
+      }
+      if ((tag & 7) === 4 || tag === 0) {
+        break;
+      }
+      reader.skipType(tag & 7);
+    }
+    return message;
+  },
+
+  fromJSON(object: any): ReportDeploymentRequest {
+    return {
+      projectId: isSet(object.projectId) ? globalThis.String(object.projectId) : "",
+      deploymentId: isSet(object.deploymentId) ? globalThis.String(object.deploymentId) : "",
+      status: isSet(object.status) ? globalThis.String(object.status) : "",
+      commitSha: isSet(object.commitSha) ? globalThis.String(object.commitSha) : "",
+      phaseDurations: globalThis.Array.isArray(object?.phaseDurations)
+        ? object.phaseDurations.map((e: any) => PhaseDuration.fromJSON(e))
+        : [],
+      outputBytes: isSet(object.outputBytes) ? globalThis.Number(object.outputBytes) : 0,
+      fileCount: isSet(object.fileCount) ? globalThis.Number(object.fileCount) : 0,
+      framework: isSet(object.framework) ? globalThis.String(object.framework) : "",
+      nodeVersion: isSet(object.nodeVersion) ? globalThis.String(object.nodeVersion) : "",
+      failureReason: isSet(object.failureReason) ? globalThis.String(object.failureReason) : "",
+      previewUrl: isSet(object.previewUrl) ? globalThis.String(object.previewUrl) : "",
+      createdAt: isSet(object.createdAt) ? globalThis.Number(object.createdAt) : 0,
+      finishedAt: isSet(object.finishedAt) ? globalThis.Number(object.finishedAt) : 0,
+    };
+  },
+
+  toJSON(message: ReportDeploymentRequest): unknown {
+    const obj: any = {};
+    if (message.projectId !== "") {
+      obj.projectId = message.projectId;
+    }
+    if (message.deploymentId !== "") {
+      obj.deploymentId = message.deploymentId;
+    }
+    if (message.status !== "") {
+      obj.status = message.status;
+    }
+    if (message.commitSha !== "") {
+      obj.commitSha = message.commitSha;
+    }
+    if (message.phaseDurations?.length) {
+      obj.phaseDurations = message.phaseDurations.map((e) => PhaseDuration.toJSON(e));
+    }
+    if (message.outputBytes !== 0) {
+      obj.outputBytes = Math.round(message.outputBytes);
+    }
+    if (message.fileCount !== 0) {
+      obj.fileCount = Math.round(message.fileCount);
+    }
+    if (message.framework !== "") {
+      obj.framework = message.framework;
+    }
+    if (message.nodeVersion !== "") {
+      obj.nodeVersion = message.nodeVersion;
+    }
+    if (message.failureReason !== "") {
+      obj.failureReason = message.failureReason;
+    }
+    if (message.previewUrl !== "") {
+      obj.previewUrl = message.previewUrl;
+    }
+    if (message.createdAt !== 0) {
+      obj.createdAt = Math.round(message.createdAt);
+    }
+    if (message.finishedAt !== 0) {
+      obj.finishedAt = Math.round(message.finishedAt);
+    }
+    return obj;
+  },
+
+  create<I extends Exact<DeepPartial<ReportDeploymentRequest>, I>>(base?: I): ReportDeploymentRequest {
+    return ReportDeploymentRequest.fromPartial(base ?? ({} as any));
+  },
+  fromPartial<I extends Exact<DeepPartial<ReportDeploymentRequest>, I>>(object: I): ReportDeploymentRequest {
+    const message = createBaseReportDeploymentRequest();
+    message.projectId = object.projectId ?? "";
+    message.deploymentId = object.deploymentId ?? "";
+    message.status = object.status ?? "";
+    message.commitSha = object.commitSha ?? "";
+    message.phaseDurations = object.phaseDurations?.map((e) => PhaseDuration.fromPartial(e)) || [];
+    message.outputBytes = object.outputBytes ?? 0;
+    message.fileCount = object.fileCount ?? 0;
+    message.framework = object.framework ?? "";
+    message.nodeVersion = object.nodeVersion ?? "";
+    message.failureReason = object.failureReason ?? "";
+    message.previewUrl = object.previewUrl ?? "";
+    message.createdAt = object.createdAt ?? 0;
+    message.finishedAt = object.finishedAt ?? 0;
+    return message;
+  },
+};
+
+function createBaseReportDeploymentResponse(): ReportDeploymentResponse {
+  return { success: false, message: "" };
+}
+
+export const ReportDeploymentResponse = {
+  encode(message: ReportDeploymentResponse, writer: _m0.Writer = _m0.Writer.create()): _m0.Writer {
+    if (message.success !== false) {
+      writer.uint32(8).bool(message.success);
+    }
+    if (message.message !== "") {
+      writer.uint32(18).string(message.message);
+    }
+    return writer;
+  },
+
+  decode(input: _m0.Reader | Uint8Array, length?: number): ReportDeploymentResponse {
+    const reader = input instanceof _m0.Reader ? input : _m0.Reader.create(input);
+    let end = length === undefined ? reader.len : reader.pos + length;
+    const message = createBaseReportDeploymentResponse();
+    while (reader.pos < end) {
+      const tag = reader.uint32();
+      switch (tag >>> 3) {
+        case 1:
+          if (tag !== 8) {
+            break;
+          }
+
+          message.success = reader.bool();
+          continue;
+        case 2:
+          if (tag !== 18) {
+            break;
+          }
+
+          message.message = reader.string();
+          continue;
+      }
+      if ((tag & 7) === 4 || tag === 0) {
+        break;
+      }
+      reader.skipType(tag & 7);
+    }
+    return message;
+  },
+
+  fromJSON(object: any): ReportDeploymentResponse {
+    return {
+      success: isSet(object.success) ? globalThis.Boolean(object.success) : false,
+      message: isSet(object.message) ? globalThis.String(object.message) : "",
+    };
+  },
+
+  toJSON(message: ReportDeploymentResponse): unknown {
+    const obj: any = {};
+    if (message.success !== false) {
+      obj.success = message.success;
+    }
+    if (message.message !== "") {
+      obj.message = message.message;
+    }
+    return obj;
+  },
+
+  create<I extends Exact<DeepPartial<ReportDeploymentResponse>, I>>(base?: I): ReportDeploymentResponse {
+    return ReportDeploymentResponse.fromPartial(base ?? ({} as any));
+  },
+  fromPartial<I extends Exact<DeepPartial<ReportDeploymentResponse>, I>>(object: I): ReportDeploymentResponse {
+    const message = createBaseReportDeploymentResponse();
+    message.success = object.success ?? false;
+    message.message = object.message ?? "";
+    return message;
+  },
+};
+
 export type ProjectServiceService = typeof ProjectServiceService;
 export const ProjectServiceService = {
   updateProjectStatus: {
@@ -231,10 +711,22 @@ export const ProjectServiceService = {
       Buffer.from(UpdateProjectStatusResponse.encode(value).finish()),
     responseDeserialize: (value: Buffer) => UpdateProjectStatusResponse.decode(value),
   },
+  reportDeployment: {
+    path: "/project.ProjectService/ReportDeployment",
+    requestStream: false,
+    responseStream: false,
+    requestSerialize: (value: ReportDeploymentRequest) =>
+      Buffer.from(ReportDeploymentRequest.encode(value).finish()),
+    requestDeserialize: (value: Buffer) => ReportDeploymentRequest.decode(value),
+    responseSerialize: (value: ReportDeploymentResponse) =>
+      Buffer.from(ReportDeploymentResponse.encode(value).finish()),
+    responseDeserialize: (value: Buffer) => ReportDeploymentResponse.decode(value),
+  },
 } as const;
 
 export interface ProjectServiceServer extends UntypedServiceImplementation {
   updateProjectStatus: handleUnaryCall<UpdateProjectStatusRequest, UpdateProjectStatusResponse>;
+  reportDeployment: handleUnaryCall<ReportDeploymentRequest, ReportDeploymentResponse>;
 }
 
 export interface ProjectServiceClient extends Client {
@@ -253,6 +745,21 @@ export interface ProjectServiceClient extends Client {
     options: Partial<CallOptions>,
     callback: (error: ServiceError | null, response: UpdateProjectStatusResponse) => void,
   ): ClientUnaryCall;
+  reportDeployment(
+    request: ReportDeploymentRequest,
+    callback: (error: ServiceError | null, response: ReportDeploymentResponse) => void,
+  ): ClientUnaryCall;
+  reportDeployment(
+    request: ReportDeploymentRequest,
+    metadata: Metadata,
+    callback: (error: ServiceError | null, response: ReportDeploymentResponse) => void,
+  ): ClientUnaryCall;
+  reportDeployment(
+    request: ReportDeploymentRequest,
+    metadata: Metadata,
+    options: Partial<CallOptions>,
+    callback: (error: ServiceError | null, response: ReportDeploymentResponse) => void,
+  ): ClientUnaryCall;
 }
 
 export const ProjectServiceClient = makeGenericClientConstructor(
@@ -279,3 +786,18 @@ export type Exact<P, I extends P> = P extends Builtin ? P
 function isSet(value: any): boolean {
   return value !== null && value !== undefined;
 }
+
+function longToNumber(long: Long): number {
+  if (long.gt(globalThis.Number.MAX_SAFE_INTEGER)) {
+    throw new globalThis.Error("Value is larger than Number.MAX_SAFE_INTEGER");
+  }
+  if (long.lt(globalThis.Number.MIN_SAFE_INTEGER)) {
+    throw new globalThis.Error("Value is smaller than Number.MIN_SAFE_INTEGER");
+  }
+  return long.toNumber();
+}
+
+if (_m0.util.Long !== Long) {
+  _m0.util.Long = Long as any;
+  _m0.configure();
+}
@@ -5,13 +5,23 @@ import {
   UpdateProjectStatusRequest,
   UpdateProjectStatusResponse,
   ProjectStatus,
+  ReportDeploymentRequest,
+  ReportDeploymentResponse,
 } from "./genprotobuf/project";
-import { DBProjectStatus, updateStatusForProject } from "./repository/project";
+import {
+  DBProjectStatus,
+  ProjectNotFoundError,
+  updateStatusForProject,
+} from "./repository/project";
+import { saveDeployment } from "./repository/deployment";
 
 const fastify = Fastify({ logger: true });
 
 const server = new grpc.Server();
 
+// Postgres error code of an insert referencing a missing row
+const FOREIGN_KEY_VIOLATION = "23503";
+
 server.addService(ProjectServiceService, {
   updateProjectStatus: async (
     call: grpc.ServerUnaryCall<
@@ -20,7 +30,7 @@ server.addService(ProjectServiceService, {
     >,
     callback: grpc.sendUnaryData<UpdateProjectStatusResponse>
   ) => {
-    const { projectId, status } = call.request;
+    const { projectId, status, idempotencyKey, sequence } = call.request;
     console.log(
       `Updating project status: ${projectId} - ${ProjectStatus[status]}`
     );
@@ -38,14 +48,26 @@ server.addService(ProjectServiceService, {
           case 2:
             statusToSet = "DEPLOYING";
             break;
+          case 3:
+            statusToSet = "CANCELLED";
+            break;
           default:
-            throw new Error("Invalid status value");
+            callback(null, {
+              success: false,
+              message: `Invalid status ${status} for project ${projectId}`,
+            });
+            return;
         }
       } else {
         statusToSet = status;
       }
 
-      await updateStatusForProject(projectId, statusToSet);
+      await updateStatusForProject(
+        projectId,
+        statusToSet,
+        idempotencyKey,
+        sequence
+      );
 
       callback(null, {
         success: true,
@@ -53,20 +75,126 @@ server.addService(ProjectServiceService, {
       });
     } catch (error) {
       console.error(error);
+      // Forge retries unavailable errors, other failures are final
+      if (!(error instanceof ProjectNotFoundError)) {
+        callback({
+          code: grpc.status.UNAVAILABLE,
+          details: `Failed to update project ${projectId}: ${error}`,
+        });
+        return;
+      }
       callback(null, {
         success: false,
         message: `Failed to update project ${projectId} - expected status ${ProjectStatus[status]}`,
       });
     }
   },
+  reportDeployment: async (
+    call: grpc.ServerUnaryCall<
+      ReportDeploymentRequest,
+      ReportDeploymentResponse
+    >,
+    callback: grpc.sendUnaryData<ReportDeploymentResponse>
+  ) => {
+    const report = call.request;
+    console.log(
+      `Deployment ${report.deploymentId} of project ${report.projectId}: ${report.status}`
+    );
+
+    try {
+      await saveDeployment({
+        id: report.deploymentId,
+        projectId: report.projectId,
+        status: report.status,
+        commitSha: report.commitSha || null,
+        phaseDurations: Object.fromEntries(
+          report.phaseDurations.map(({ phase, durationMs }) => [
+            phase,
+            durationMs,
+          ])
+        ),
+        outputBytes: report.outputBytes,
+        fileCount: report.fileCount,
+        framework: report.framework || null,
+        nodeVersion: report.nodeVersion || null,
+        failureReason: report.failureReason || null,
+        previewUrl: report.previewUrl || null,
+        createdAt: report.createdAt ? new Date(report.createdAt) : null,
+        finishedAt: report.finishedAt ? new Date(report.finishedAt) : null,
+      });
+
+      callback(null, {
+        success: true,
+        message: `Deployment ${report.deploymentId} recorded`,
+      });
+    } catch (error) {
+      console.error(error);
+      // The project was deleted meanwhile, reporting again would not help
+      if ((error as { code?: string }).code === FOREIGN_KEY_VIOLATION) {
+        callback(null, {
+          success: false,
+          message: `Project ${report.projectId} not found`,
+        });
+        return;
+      }
+      // Forge retries unavailable errors
+      callback({
+        code: grpc.status.UNAVAILABLE,
+        details: `Failed to record deployment ${report.deploymentId}: ${error}`,
+      });
+    }
+  },
 });
 
+// GRPC_TLS_MODE is none, tls or mtls. Certificates are read again from disk
+// when they change, so they can be rotated without a restart.
+const serverCredentials = (): grpc.ServerCredentials => {
+  const mode = process.env.GRPC_TLS_MODE || "none";
+  if (mode === "none") {
+    return grpc.ServerCredentials.createInsecure();
+  }
+  if (mode !== "tls" && mode !== "mtls") {
+    throw new Error(`Invalid GRPC_TLS_MODE, it must be none, tls or mtls: ${mode}`);
+  }
+
+  const certificateFile = process.env.GRPC_TLS_CERT_FILE;
+  const privateKeyFile = process.env.GRPC_TLS_KEY_FILE;
+  const caCertificateFile = process.env.GRPC_TLS_CA_FILE;
+  if (!certificateFile || !privateKeyFile) {
+    throw new Error(
+      `GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE are required when GRPC_TLS_MODE is ${mode}`
+    );
+  }
+  if (mode === "mtls" && !caCertificateFile) {
+    throw new Error("GRPC_TLS_CA_FILE is required when GRPC_TLS_MODE is mtls");
+  }
+
+  const refreshIntervalMs = 60_000;
+  const identity = new grpc.experimental.FileWatcherCertificateProvider({
+    certificateFile,
+    privateKeyFile,
+    refreshIntervalMs,
+  });
+  const clientCAs =
+    mode === "mtls"
+      ? new grpc.experimental.FileWatcherCertificateProvider({
+          caCertificateFile,
+          refreshIntervalMs,
+        })
+      : null;
+  return grpc.experimental.createCertificateProviderServerCredentials(
+    identity,
+    clientCAs,
+    mode === "mtls"
+  );
+};
+
 export const startGrpcServer = async () => {
   const GRPC_SERVER_ADDRESS = process.env.GRPC_PORT ?? "0.0.0.0:50051";
   await fastify.ready();
   server.bindAsync(
     GRPC_SERVER_ADDRESS,
-    grpc.ServerCredentials.createInsecure(),
+    serverCredentials(),
     (err, port) => {
       if (err) {
         console.error("Failed to bind server:", err);
@@ -0,0 +1,26 @@
+import { desc, eq } from "drizzle-orm";
+import { db } from "../db";
+import { Deployment } from "../db/schema";
+
+export type DeploymentRecord = typeof Deployment.$inferInsert;
+
+// Forge may report a deployment again, the last report replaces the earlier one
+export async function saveDeployment(record: DeploymentRecord) {
+  const { id, ...fields } = record;
+  const saved = await db
+    .insert(Deployment)
+    .values(record)
+    .onConflictDoUpdate({ target: Deployment.id, set: fields })
+    .returning();
+
+  return saved[0];
+}
+
+export async function readDeployments(projectId: string, limit = 50) {
+  return db
+    .select()
+    .from(Deployment)
+    .where(eq(Deployment.projectId, projectId))
+    .orderBy(desc(Deployment.createdAt))
+    .limit(limit);
+}
@@ -1,9 +1,15 @@
-import { and, eq, sql } from "drizzle-orm";
+import { and, eq, isNull, lt, or, sql } from "drizzle-orm";
 import slugify from "slugify";
 import { db } from "../db";
 import { Project } from "../db/schema";
 const { nanoid } = require("nanoid");
 
+export class ProjectNotFoundError extends Error {
+  constructor(projectId: string) {
+    super(`Project ${projectId} not found`);
+  }
+}
+
 async function generateUniqueSlug(baseName: string): Promise<string> {
   const maxAttempts = 10;
   let attempts = 0;
@@ -98,10 +104,28 @@ export async function readAllProjects(userId: string) {
   return projects;
 }
 
-export type DBProjectStatus = "NOT_LIVE" | "LIVE" | "DEPLOYING";
+export async function deleteProject(userId: string, projectId: string) {
+  const deleted = await db
+    .delete(Project)
+    .where(and(eq(Project.userId, userId), eq(Project.id, projectId)))
+    .returning({ projectId: Project.id });
+
+  if (deleted.length === 0) {
+    throw new Error("Project: 404");
+  }
+
+  return deleted[0];
+}
+
+export type DBProjectStatus = "NOT_LIVE" | "LIVE" | "DEPLOYING" | "CANCELLED";
+// Updates are ordered by sequence, in microseconds since the Unix epoch. Forge
+// sends the time each update was made, updates made by launchpad are ordered
+// by the time they are applied.
 export async function updateStatusForProject(
   projectId: string,
-  newStatus: DBProjectStatus
+  newStatus: DBProjectStatus,
+  idempotencyKey?: string,
+  sequence?: number
 ) {
   // First, check if the project exists and belongs to the user
   const existingProject = await db
@@ -110,18 +134,42 @@ export async function updateStatusForProject(
     .where(eq(Project.id, projectId));
 
   if (existingProject.length === 0) {
-    throw new Error("Project not found");
+    throw new ProjectNotFoundError(projectId);
   }
 
-  // Update the project status
+  // Forge retries and replays updates, each one is only applied once
+  if (idempotencyKey && existingProject[0].statusUpdateKey === idempotencyKey) {
+    return existingProject[0];
+  }
+
+  const statusSequence = sequence || Date.now() * 1000;
+
+  // Update the project status, unless a newer update was applied already
   const updatedProject = await db
     .update(Project)
-    .set({ status: newStatus, updatedAt: sql`now()` })
-    .where(eq(Project.id, projectId))
+    .set({
+      status: newStatus,
+      statusUpdateKey: idempotencyKey ?? null,
+      statusSequence,
+      updatedAt: sql`now()`,
+    })
+    .where(
+      and(
+        eq(Project.id, projectId),
+        or(
+          isNull(Project.statusSequence),
+          lt(Project.statusSequence, statusSequence)
+        )
+      )
+    )
     .returning();
 
   if (updatedProject.length === 0) {
-    throw new Error("Failed to update project status");
+    // A replay or a late update, the project keeps its newer status
+    console.log(
+      `Skipped ${newStatus} status of project ${projectId}, a newer update was applied`
+    );
+    return existingProject[0];
   }
 
   return updatedProject[0];
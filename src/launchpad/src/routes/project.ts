@@ -1,10 +1,17 @@
+import { randomUUID } from "crypto";
 import { FastifyInstance, FastifyReply, FastifyRequest } from "fastify";
 // @ts-ignore
 import { z } from "zod";
 import { projectStatusEnum } from "../db/schema";
 import * as repository from "../repository/project";
-import { pushMessageToDeployQueue } from "../utils/awsSqs";
+import { readDeployments } from "../repository/deployment";
+import {
+  pushMessageToControlQueue,
+  pushMessageToDeployQueue,
+} from "../utils/awsSqs";
+import { forgeRequest } from "../utils/forgeApi";
 import { ERROR_MESSAGES, HTTP_CODES } from "../utils/httpCodes";
+import { childTraceparent } from "../utils/traceContext";
 
 const createProjectSchema = z.object({
   name: z.string().min(1).max(100),
@@ -130,13 +137,21 @@ async function deployProjectHandler(
       return;
     }
 
+    // The id is known before forge receives the build, so it can be
+    // cancelled while it is still queued
+    const deploymentId = randomUUID();
     const message = {
       projectId: project.id,
+      deploymentId,
       repoURL: project.repositoryUrl,
       buildCommand: project.buildCommand,
     };
 
-    const messageId = await pushMessageToDeployQueue(message);
+    const messageId = await pushMessageToDeployQueue(
+      message,
+      "Build",
+      childTraceparent(request.headers.traceparent)
+    );
 
     // Update status a deploying
     await repository.updateStatusForProject(
@@ -144,10 +159,280 @@ async function deployProjectHandler(
       projectStatusEnum.enumValues[2]
     );
 
+    reply.code(HTTP_CODES.OK).send({
+      success: true,
+      messageId: messageId,
+      deploymentId,
+    });
+  } catch (error) {
+    if ((error as Error).message === "Project: 404") {
+      reply.code(HTTP_CODES.NOT_FOUND).send({
+        error: ERROR_MESSAGES.PROJECT_NOT_FOUND,
+      });
+    } else {
+      reply.log.error(error);
+      reply.code(HTTP_CODES.INTERNAL_SERVER_ERROR).send({
+        error: ERROR_MESSAGES.INTERNAL_SERVER_ERROR,
+      });
+    }
+  }
+}
+
+const cancelDeploymentSchema = z.object({
+  deploymentId: z.string().optional(),
+});
+
+async function cancelDeploymentHandler(
+  request: FastifyRequest,
+  reply: FastifyReply
+) {
+  try {
+    const { id } = request.params as { id: string };
+    const { deploymentId } = cancelDeploymentSchema.parse(request.body ?? {});
+    const userId = request.userId;
+
+    const project = await repository.readProject(userId, id);
+
+    // Without a deployment id the deployment being built and the newest one
+    // queued are cancelled. Forge only records the user as who cancelled
+    // because launchpad is one of its trusted senders.
+    const message = {
+      projectId: project.id,
+      deploymentId,
+      cancelledBy: userId,
+    };
+
+    const messageId = await pushMessageToControlQueue(
+      message,
+      "Cancel",
+      childTraceparent(request.headers.traceparent)
+    );
+
     reply.code(HTTP_CODES.OK).send({
       success: true,
       messageId: messageId,
     });
+  } catch (error) {
+    if (error instanceof z.ZodError) {
+      reply.code(HTTP_CODES.BAD_REQUEST).send({
+        error: ERROR_MESSAGES.INVALID_INPUT,
+        //@ts-ignore
+        details: error.errors,
+      });
+    } else if ((error as Error).message === "Project: 404") {
+      reply.code(HTTP_CODES.NOT_FOUND).send({
+        error: ERROR_MESSAGES.PROJECT_NOT_FOUND,
+      });
+    } else {
+      reply.log.error(error);
+      reply.code(HTTP_CODES.INTERNAL_SERVER_ERROR).send({
+        error: ERROR_MESSAGES.INTERNAL_SERVER_ERROR,
+      });
+    }
+  }
+}
+
+async function deleteProjectHandler(
+  request: FastifyRequest,
+  reply: FastifyReply
+) {
+  try {
+    const { id } = request.params as { id: string };
+    const userId = request.userId;
+
+    const project = await repository.readProject(userId, id);
+
+    // Forge removes the artifacts and logs, the job is queued before the
+    // project row is gone so a failure leaves nothing behind
+    const message = {
+      projectId: project.id,
+      requestedBy: userId,
+    };
+    const messageId = await pushMessageToDeployQueue(
+      message,
+      "DeleteProject",
+      childTraceparent(request.headers.traceparent)
+    );
+
+    await repository.deleteProject(userId, project.id);
+
+    reply.code(HTTP_CODES.OK).send({
+      success: true,
+      messageId: messageId,
+    });
+  } catch (error) {
+    if ((error as Error).message === "Project: 404") {
+      reply.code(HTTP_CODES.NOT_FOUND).send({
+        error: ERROR_MESSAGES.PROJECT_NOT_FOUND,
+      });
+    } else {
+      reply.log.error(error);
+      reply.code(HTTP_CODES.INTERNAL_SERVER_ERROR).send({
+        error: ERROR_MESSAGES.INTERNAL_SERVER_ERROR,
+      });
+    }
+  }
+}
+
+async function readDeploymentsHandler(
+  request: FastifyRequest,
+  reply: FastifyReply
+) {
+  try {
+    const { id } = request.params as { id: string };
+    const userId = request.userId;
+
+    const project = await repository.readProject(userId, id);
+    const deployments = await readDeployments(project.id);
+
+    reply.code(HTTP_CODES.OK).send(deployments);
+  } catch (error) {
+    if ((error as Error).message === "Project: 404") {
+      reply.code(HTTP_CODES.NOT_FOUND).send({
+        error: ERROR_MESSAGES.PROJECT_NOT_FOUND,
+      });
+    } else {
+      reply.log.error(error);
+      reply.code(HTTP_CODES.INTERNAL_SERVER_ERROR).send({
+        error: ERROR_MESSAGES.INTERNAL_SERVER_ERROR,
+      });
+    }
+  }
+}
+
+// Webhooks are kept by forge, the request is forwarded once the project is
+// known to belong to the user
+async function webhooksHandler(request: FastifyRequest, reply: FastifyReply) {
+  try {
+    const { id, webhookId, deliveryId } = request.params as {
+      id: string;
+      webhookId?: string;
+      deliveryId?: string;
+    };
+    const userId = request.userId;
+
+    const project = await repository.readProject(userId, id);
+
+    let path = `/projects/${project.id}/webhooks`;
+    if (webhookId) {
+      path += `/${encodeURIComponent(webhookId)}`;
+    } else if (deliveryId) {
+      path += `/deliveries/${encodeURIComponent(deliveryId)}/replay`;
+    } else if (request.url.split("?")[0].endsWith("/deliveries")) {
+      path += "/deliveries";
+    }
+    const query = request.url.indexOf("?");
+    if (query !== -1) {
+      path += request.url.slice(query);
+    }
+
+    const response = await forgeRequest(
+      request.method,
+      path,
+      request.method === "POST" ? request.body ?? {} : undefined
+    );
+    reply.code(response.status).send(response.body);
+  } catch (error) {
+    if ((error as Error).message === "Project: 404") {
+      reply.code(HTTP_CODES.NOT_FOUND).send({
+        error: ERROR_MESSAGES.PROJECT_NOT_FOUND,
+      });
+    } else {
+      reply.log.error(error);
+      reply.code(HTTP_CODES.INTERNAL_SERVER_ERROR).send({
+        error: ERROR_MESSAGES.INTERNAL_SERVER_ERROR,
+      });
+    }
+  }
+}
+
+const pushTriggerSchema = z.object({
+  branch: z.string().min(1).max(255).optional(),
+  previews: z.boolean().optional(),
+});
+
+// The trigger builds the repository and build command of the project, only
+// the branch and whether other branches get previews are chosen by the user
+async function putPushTriggerHandler(
+  request: FastifyRequest,
+  reply: FastifyReply
+) {
+  try {
+    const { id } = request.params as { id: string };
+    const { branch, previews } = pushTriggerSchema.parse(request.body ?? {});
+    const userId = request.userId;
+
+    const project = await repository.readProject(userId, id);
+
+    const response = await forgeRequest(
+      "PUT",
+      `/projects/${project.id}/push-trigger`,
+      {
+        repoURL: project.repositoryUrl,
+        branch: branch ?? "main",
+        buildCommand: project.buildCommand ?? "",
+        previews: previews ?? true,
+      }
+    );
+    reply.code(response.status).send(response.body);
+  } catch (error) {
+    if (error instanceof z.ZodError) {
+      reply.code(HTTP_CODES.BAD_REQUEST).send({
+        error: ERROR_MESSAGES.INVALID_INPUT,
+        //@ts-ignore
+        details: error.errors,
+      });
+    } else if ((error as Error).message === "Project: 404") {
+      reply.code(HTTP_CODES.NOT_FOUND).send({
+        error: ERROR_MESSAGES.PROJECT_NOT_FOUND,
+      });
+    } else {
+      reply.log.error(error);
+      reply.code(HTTP_CODES.INTERNAL_SERVER_ERROR).send({
+        error: ERROR_MESSAGES.INTERNAL_SERVER_ERROR,
+      });
+    }
+  }
+}
+
+async function pushTriggerHandler(
+  request: FastifyRequest,
+  reply: FastifyReply
+) {
+  try {
+    const { id } = request.params as { id: string };
+    const userId = request.userId;
+
+    const project = await repository.readProject(userId, id);
+
+    const response = await forgeRequest(
+      request.method,
+      `/projects/${project.id}/push-trigger`
+    );
+    reply.code(response.status).send(response.body);
+  } catch (error) {
+    if ((error as Error).message === "Project: 404") {
+      reply.code(HTTP_CODES.NOT_FOUND).send({
+        error: ERROR_MESSAGES.PROJECT_NOT_FOUND,
+      });
+    } else {
+      reply.log.error(error);
+      reply.code(HTTP_CODES.INTERNAL_SERVER_ERROR).send({
+        error: ERROR_MESSAGES.INTERNAL_SERVER_ERROR,
+      });
+    }
+  }
+}
+
+async function previewsHandler(request: FastifyRequest, reply: FastifyReply) {
+  try {
+    const { id } = request.params as { id: string };
+    const userId = request.userId;
+
+    const project = await repository.readProject(userId, id);
+
+    const response = await forgeRequest("GET", `/projects/${project.id}/previews`);
+    reply.code(response.status).send(response.body);
   } catch (error) {
     if ((error as Error).message === "Project: 404") {
       reply.code(HTTP_CODES.NOT_FOUND).send({
@@ -165,6 +450,21 @@ async function deployProjectHandler(
 export default async function registerRoutes(fastify: FastifyInstance) {
   fastify.post<{ Body: CreateProjectBody }>("/project", createProjectHandler);
   fastify.post("/project/:id/deploy", deployProjectHandler);
+  fastify.post("/project/:id/cancel", cancelDeploymentHandler);
   fastify.get("/project/:id", readProjectHandler);
+  fastify.get("/project/:id/deployments", readDeploymentsHandler);
+  fastify.post("/project/:id/webhooks", webhooksHandler);
+  fastify.get("/project/:id/webhooks", webhooksHandler);
+  fastify.delete("/project/:id/webhooks/:webhookId", webhooksHandler);
+  fastify.get("/project/:id/webhooks/deliveries", webhooksHandler);
+  fastify.post(
+    "/project/:id/webhooks/deliveries/:deliveryId/replay",
+    webhooksHandler
+  );
+  fastify.put("/project/:id/push-trigger", putPushTriggerHandler);
+  fastify.get("/project/:id/push-trigger", pushTriggerHandler);
+  fastify.delete("/project/:id/push-trigger", pushTriggerHandler);
+  fastify.get("/project/:id/previews", previewsHandler);
+  fastify.delete("/project/:id", deleteProjectHandler);
   fastify.get("/project", readAllProjectHandler);
 }
@@ -6,6 +6,8 @@ import {
 
 const awsRegion = process.env.AWS_REGION;
 const queueUrl = process.env.AWS_QUEUE_URL;
+// Control messages such as Cancel must not wait behind queued builds
+const controlQueueUrl = process.env.AWS_CONTROL_QUEUE_URL ?? queueUrl;
 
 // Configure the SQS client
 const sqsClient = new SQSClient({ region: awsRegion });
@@ -16,7 +18,8 @@ interface MessageAttributes {
 
 export async function pushMessageToDeployQueue(
   message: unknown,
-  messageType: string = "Build"
+  messageType: string = "Build",
+  traceparent?: string
 ) {
   if (!queueUrl) {
     throw new Error(
@@ -24,16 +27,46 @@ export async function pushMessageToDeployQueue(
     );
   }
 
+  return pushMessage(queueUrl, message, messageType, traceparent);
+}
+
+export async function pushMessageToControlQueue(
+  message: unknown,
+  messageType: string,
+  traceparent?: string
+) {
+  if (!controlQueueUrl) {
+    throw new Error(
+      "AWS_CONTROL_QUEUE_URL is not defined in the environment variables"
+    );
+  }
+
+  return pushMessage(controlQueueUrl, message, messageType, traceparent);
+}
+
+async function pushMessage(
+  url: string,
+  message: unknown,
+  messageType: string,
+  traceparent?: string
+) {
   const messageAttributes: MessageAttributes = {
     MessageType: {
       DataType: "String",
       StringValue: messageType,
     },
   };
+  // Forge continues the trace of the request that queued the message
+  if (traceparent) {
+    messageAttributes.traceparent = {
+      DataType: "String",
+      StringValue: traceparent,
+    };
+  }
 
   try {
     const command = new SendMessageCommand({
-      QueueUrl: queueUrl,
+      QueueUrl: url,
       MessageBody: JSON.stringify(message),
       MessageAttributes: messageAttributes,
     });
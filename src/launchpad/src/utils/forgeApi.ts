@@ -0,0 +1,36 @@
+const forgeApiUrl = process.env.FORGE_API_URL;
+const forgeApiToken = process.env.FORGE_API_TOKEN;
+
+export interface ForgeResponse {
+  status: number;
+  body: unknown;
+}
+
+// Sends a request to the forge API and returns its status and JSON body
+export async function forgeRequest(
+  method: string,
+  path: string,
+  body?: unknown
+): Promise<ForgeResponse> {
+  if (!forgeApiUrl || !forgeApiToken) {
+    throw new Error(
+      "FORGE_API_URL and FORGE_API_TOKEN must be defined in the environment variables"
+    );
+  }
+
+  const response = await fetch(`${forgeApiUrl}${path}`, {
+    method,
+    headers: {
+      Authorization: `Bearer ${forgeApiToken}`,
+      "Content-Type": "application/json",
+    },
+    body: body === undefined ? undefined : JSON.stringify(body),
+    signal: AbortSignal.timeout(10_000),
+  });
+
+  const text = await response.text();
+  return {
+    status: response.status,
+    body: text ? JSON.parse(text) : undefined,
+  };
+}
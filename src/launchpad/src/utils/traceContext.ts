@@ -0,0 +1,17 @@
+import { randomBytes } from "crypto";
+
+// W3C trace context, https://www.w3.org/TR/trace-context/#traceparent-header
+const traceparentRe = /^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$/;
+
+// Returns the traceparent of a message sent while handling a request. The
+// message continues the trace of the request when it carries one, and starts
+// a new sampled trace otherwise, so forge traces the job either way.
+export function childTraceparent(incoming?: string | string[]) {
+  const spanId = randomBytes(8).toString("hex");
+  const header = Array.isArray(incoming) ? incoming[0] : incoming;
+  const match = header ? traceparentRe.exec(header.trim().toLowerCase()) : null;
+  if (match && !/^0+$/.test(match[1]) && !/^0+$/.test(match[2])) {
+    return `00-${match[1]}-${spanId}-${match[3]}`;
+  }
+  return `00-${randomBytes(16).toString("hex")}-${spanId}-01`;
+}
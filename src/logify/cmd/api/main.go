@@ -1,24 +1,55 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"logify/internal/config"
+	"logify/internal/database"
 	"logify/internal/server"
+	"logify/internal/tracing"
+	"logify/internal/utils"
 	"net"
 	"os"
 )
 
 func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML file with settings that are not set in the environment")
+	checkConfig := flag.Bool("check-config", false, "validate the config, print it and exit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("invalid config:\n%v", err)
+	}
+	if *checkConfig {
+		cfg.Print(os.Stdout)
+		fmt.Println("config is valid")
+		return
+	}
+	cfg.Print(log.Writer())
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "logify", cfg.TracesExporter, cfg.OTLPEndpoint)
+	if err != nil {
+		log.Fatalf("failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	db := database.New(cfg.Database)
+
 	// Start gRPC server
-	grpcServer := server.NewGRPCServer()
-	GRPC_SERVER_ADDRESS := os.Getenv("GRPC_SERVER_ADDRESS")
+	grpcServer, err := server.NewGRPCServer(db, utils.NewKinesisStream(cfg.Kinesis), cfg.GRPCTLS, cfg.LogWriters)
+	if err != nil {
+		log.Fatalf("failed to create gRPC server: %v", err)
+	}
 
-	listener, err := net.Listen("tcp", GRPC_SERVER_ADDRESS)
+	listener, err := net.Listen("tcp", cfg.GRPCServerAddress)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	log.Println("GRPC server running at", GRPC_SERVER_ADDRESS)
+	log.Println("GRPC server running at", cfg.GRPCServerAddress)
 	go func() {
 		if err := grpcServer.Serve(listener); err != nil {
 			log.Fatalf("failed to serve gRPC server: %v", err)
@@ -26,7 +57,7 @@ func main() {
 	}()
 
 	// Start HTTP server
-	server := server.NewServer()
+	server := server.NewServer(cfg.Port, db)
 
 	err = server.ListenAndServe()
 	if err != nil {
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"logify/internal/database"
+	"logify/internal/grpctls"
+	"logify/internal/utils"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Keys are the settings of logify. Environment variables take precedence over
+// the config file.
+var Keys = []string{
+	"APP_ENV",
+	"PORT",
+	"GRPC_SERVER_ADDRESS",
+	"GRPC_TLS_MODE",
+	"GRPC_TLS_CERT_FILE",
+	"GRPC_TLS_KEY_FILE",
+	"GRPC_TLS_CA_FILE",
+	"GRPC_LOG_WRITERS",
+	"AWS_REGION",
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_KINESIS_STREAM",
+	"AWS_KINESIS_STREAM_PARTITION_KEY",
+	"DB_HOST",
+	"DB_PORT",
+	"DB_USERNAME",
+	"DB_PASSWORD",
+	"DB_DATABASE",
+	"MAIN_DB_DATABASE",
+	"OTEL_TRACES_EXPORTER",
+	"OTEL_EXPORTER_OTLP_ENDPOINT",
+}
+
+var required = []string{
+	"PORT",
+	"GRPC_SERVER_ADDRESS",
+	"AWS_REGION",
+	"AWS_KINESIS_STREAM",
+	"AWS_KINESIS_STREAM_PARTITION_KEY",
+	"DB_HOST",
+	"DB_PORT",
+	"DB_USERNAME",
+	"DB_DATABASE",
+	"MAIN_DB_DATABASE",
+}
+
+// Config is the effective configuration of logify.
+type Config struct {
+	AppEnv            string
+	Port              int
+	GRPCServerAddress string
+	GRPCTLS           grpctls.Config
+	// LogWriters are the client identities allowed to push and purge logs with mtls.
+	LogWriters     []string
+	TracesExporter string
+	OTLPEndpoint   string
+	Kinesis        utils.KinesisConfig
+	Database       database.Config
+}
+
+// Load reads the config from the environment and the YAML file at path, when
+// path is not empty, and reports every missing or invalid setting.
+func Load(path string) (*Config, error) {
+	var file settings
+	if path != "" {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, key := range required {
+		if file.lookup(key) == "" {
+			errs = append(errs, fmt.Errorf("%s is required", key))
+		}
+	}
+
+	cfg := &Config{
+		AppEnv:            file.lookup("APP_ENV"),
+		GRPCServerAddress: file.lookup("GRPC_SERVER_ADDRESS"),
+		TracesExporter:    file.lookup("OTEL_TRACES_EXPORTER"),
+		OTLPEndpoint:      file.lookup("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		GRPCTLS: grpctls.Config{
+			Mode:     file.lookup("GRPC_TLS_MODE"),
+			CertFile: file.lookup("GRPC_TLS_CERT_FILE"),
+			KeyFile:  file.lookup("GRPC_TLS_KEY_FILE"),
+			CAFile:   file.lookup("GRPC_TLS_CA_FILE"),
+		},
+		Kinesis: utils.KinesisConfig{
+			Region:       file.lookup("AWS_REGION"),
+			Stream:       file.lookup("AWS_KINESIS_STREAM"),
+			PartitionKey: file.lookup("AWS_KINESIS_STREAM_PARTITION_KEY"),
+		},
+		Database: database.Config{
+			Host:         file.lookup("DB_HOST"),
+			Port:         file.lookup("DB_PORT"),
+			Username:     file.lookup("DB_USERNAME"),
+			Password:     file.lookup("DB_PASSWORD"),
+			Database:     file.lookup("DB_DATABASE"),
+			MainDatabase: file.lookup("MAIN_DB_DATABASE"),
+		},
+	}
+
+	if value := file.lookup("PORT"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("invalid PORT: %q", value))
+		}
+		cfg.Port = port
+	}
+
+	if cfg.GRPCTLS.Mode == "" {
+		cfg.GRPCTLS.Mode = grpctls.ModeNone
+	}
+	switch cfg.GRPCTLS.Mode {
+	case grpctls.ModeNone:
+	case grpctls.ModeTLS, grpctls.ModeMTLS:
+		if cfg.GRPCTLS.CertFile == "" || cfg.GRPCTLS.KeyFile == "" {
+			errs = append(errs, fmt.Errorf("GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE are required when GRPC_TLS_MODE is %s", cfg.GRPCTLS.Mode))
+		}
+		if cfg.GRPCTLS.Mode == grpctls.ModeMTLS && cfg.GRPCTLS.CAFile == "" {
+			errs = append(errs, errors.New("GRPC_TLS_CA_FILE is required when GRPC_TLS_MODE is mtls"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid GRPC_TLS_MODE, it must be none, tls or mtls: %q", cfg.GRPCTLS.Mode))
+	}
+
+	writers := file.lookup("GRPC_LOG_WRITERS")
+	if writers == "" {
+		writers = "forge"
+	}
+	for _, writer := range strings.Split(writers, ",") {
+		if writer = strings.TrimSpace(writer); writer != "" {
+			cfg.LogWriters = append(cfg.LogWriters, writer)
+		}
+	}
+
+	if cfg.TracesExporter == "" {
+		cfg.TracesExporter = "none"
+	}
+	if !slices.Contains([]string{"none", "stdout", "otlp"}, cfg.TracesExporter) {
+		errs = append(errs, fmt.Errorf("invalid OTEL_TRACES_EXPORTER, it must be none, stdout or otlp: %q", cfg.TracesExporter))
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// settings are the values of a config file.
+type settings map[string]string
+
+// lookup returns the environment variable key, or the value of the config file
+// when it is not set.
+func (s settings) lookup(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return s[key]
+}
+
+// readFile returns the settings of a YAML file. The environment is left
+// untouched.
+func readFile(path string) (settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	file := make(settings, len(values))
+	var errs []error
+	for key, value := range values {
+		switch value.(type) {
+		case nil:
+			continue
+		case []any, map[string]any:
+			errs = append(errs, fmt.Errorf("setting %s in config file %s must be a single value", key, path))
+			continue
+		}
+		if !slices.Contains(Keys, key) {
+			errs = append(errs, fmt.Errorf("unknown setting %s in config file %s", key, path))
+			continue
+		}
+		file[key] = fmt.Sprint(value)
+	}
+	return file, errors.Join(errs...)
+}
+
+// Print writes the config with the database password left out.
+func (c *Config) Print(w io.Writer) {
+	password := ""
+	if c.Database.Password != "" {
+		password = "[redacted]"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "app env:\t%s\n", c.AppEnv)
+	fmt.Fprintf(tw, "http port:\t%d\n", c.Port)
+	fmt.Fprintf(tw, "grpc address:\t%s\n", c.GRPCServerAddress)
+	fmt.Fprintf(tw, "grpc tls:\t%s\n", c.GRPCTLS.Mode)
+	fmt.Fprintf(tw, "log writers:\t%s\n", strings.Join(c.LogWriters, ","))
+	fmt.Fprintf(tw, "kinesis stream:\t%s (%s, partition key %s)\n", c.Kinesis.Stream, c.Kinesis.Region, c.Kinesis.PartitionKey)
+	fmt.Fprintf(tw, "database:\t%s@%s:%s/%s (password: %s)\n", c.Database.Username, c.Database.Host, c.Database.Port, c.Database.Database, password)
+	fmt.Fprintf(tw, "main database:\t%s\n", c.Database.MainDatabase)
+	fmt.Fprintf(tw, "traces exporter:\t%s\n", c.TracesExporter)
+	fmt.Fprintf(tw, "otlp endpoint:\t%s\n", c.OTLPEndpoint)
+	tw.Flush()
+}
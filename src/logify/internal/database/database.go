@@ -5,7 +5,8 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
+	"net"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -31,6 +32,8 @@ type Service interface {
 
 	BulkInsertLogs(ctx context.Context, logs []Log) error
 	GetProjectLogs(ctx context.Context, projectID string, limit int) ([]Log, error)
+	// PurgeProjectLogs deletes every log of a project and returns how many were deleted.
+	PurgeProjectLogs(ctx context.Context, projectID string) (int64, error)
 }
 
 type Log struct {
@@ -41,40 +44,56 @@ type Log struct {
 }
 
 type service struct {
-	db *sql.DB
+	db       *sql.DB
+	database string
 }
 
-var (
-	aetherMainDb = os.Getenv("MAIN_DB_DATABASE")
-	database     = os.Getenv("DB_DATABASE")
-	password     = os.Getenv("DB_PASSWORD")
-	username     = os.Getenv("DB_USERNAME")
-	port         = os.Getenv("DB_PORT")
-	host         = os.Getenv("DB_HOST")
-	dbInstance   *service
-)
+// Config locates the logs database, and the main database it is created from
+// when it does not exist yet.
+type Config struct {
+	Host         string
+	Port         string
+	Username     string
+	Password     string
+	Database     string
+	MainDatabase string
+}
+
+// url returns the connection string of a database on the server.
+func (c Config) url(database string) string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.Username, c.Password),
+		Host:     net.JoinHostPort(c.Host, c.Port),
+		Path:     database,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
+var dbInstance *service
 
-func New() Service {
+func New(cfg Config) Service {
 	// Reuse Connection
 	if dbInstance != nil {
 		return dbInstance
 	}
 
-	db, err := connectToDatabase()
+	db, err := connectToDatabase(cfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
 	dbInstance = &service{
-		db: db,
+		db:       db,
+		database: cfg.Database,
 	}
 	return dbInstance
 }
 
-func connectToDatabase() (*sql.DB, error) {
+func connectToDatabase(cfg Config) (*sql.DB, error) {
 	// Attempt to connect to the aether-logs database
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", username, password, host, port, database)
-	db, err := sql.Open("pgx", connStr)
+	db, err := sql.Open("pgx", cfg.url(cfg.Database))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
@@ -87,8 +106,7 @@ func connectToDatabase() (*sql.DB, error) {
 
 	// If the aether-logs database does not exist, connect to the aether database and create it
 	db.Close()
-	connStr = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", username, password, host, port, aetherMainDb)
-	db, err = sql.Open("pgx", connStr)
+	db, err = sql.Open("pgx", cfg.url(cfg.MainDatabase))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open connection to aether database: %w", err)
 	}
@@ -103,8 +121,7 @@ func connectToDatabase() (*sql.DB, error) {
 	db.Close()
 
 	// Reconnect to the aether-logs database
-	connStr = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", username, password, host, port, database)
-	db, err = sql.Open("pgx", connStr)
+	db, err = sql.Open("pgx", cfg.url(cfg.Database))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open connection to aether-logs database: %w", err)
 	}
@@ -168,7 +185,7 @@ func (s *service) Health() map[string]string {
 // If the connection is successfully closed, it returns nil.
 // If an error occurs while closing the connection, it returns the error.
 func (s *service) Close() error {
-	log.Printf("Disconnected from database: %s", database)
+	log.Printf("Disconnected from database: %s", s.database)
 	return s.db.Close()
 }
 
@@ -244,3 +261,15 @@ func (s *service) GetProjectLogs(ctx context.Context, projectID string, limit in
 
 	return logs, nil
 }
+
+func (s *service) PurgeProjectLogs(ctx context.Context, projectID string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `
+        DELETE FROM logs
+        WHERE projectId = $1
+    `, projectID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
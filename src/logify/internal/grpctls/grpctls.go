@@ -0,0 +1,212 @@
+package grpctls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Transport security modes of the gRPC server.
+const (
+	ModeNone = "none"
+	ModeTLS  = "tls"
+	ModeMTLS = "mtls"
+)
+
+// Config secures the gRPC server of logify.
+type Config struct {
+	Mode string
+	// CertFile and KeyFile hold the server certificate.
+	CertFile string
+	KeyFile  string
+	// CAFile holds the certificates clients are verified with in mtls mode.
+	CAFile string
+}
+
+// ServerCredentials returns the transport credentials of the gRPC server.
+// Certificates are read again once their files changed, so they can be
+// rotated without a restart.
+func ServerCredentials(cfg Config) (credentials.TransportCredentials, error) {
+	if cfg.Mode == ModeNone {
+		return insecure.NewCredentials(), nil
+	}
+
+	pair := &keyPair{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if _, err := pair.get(); err != nil {
+		return nil, err
+	}
+
+	var clientCAs *certPool
+	if cfg.Mode == ModeMTLS {
+		clientCAs = &certPool{file: cfg.CAFile}
+		if _, err := clientCAs.get(); err != nil {
+			return nil, err
+		}
+	}
+
+	return credentials.NewTLS(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Every handshake uses the current certificates
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, err := pair.get()
+			if err != nil {
+				return nil, err
+			}
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				NextProtos:   []string{"h2"},
+			}
+			if clientCAs != nil {
+				pool, err := clientCAs.get()
+				if err != nil {
+					return nil, err
+				}
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = pool
+			}
+			return config, nil
+		},
+	}), nil
+}
+
+// PeerIdentities returns the names in the verified client certificate of a
+// request: its common name and DNS names.
+func PeerIdentities(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := info.State.VerifiedChains[0][0]
+	return append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+}
+
+// AuthorizePeers returns an interceptor allowing the methods in allowed only
+// to clients whose certificate names one of the listed identities. Other
+// methods are open to every client.
+func AuthorizePeers(allowed map[string][]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		identities, restricted := allowed[info.FullMethod]
+		if !restricted {
+			return handler(ctx, req)
+		}
+
+		for _, identity := range PeerIdentities(ctx) {
+			if slices.Contains(identities, identity) {
+				return handler(ctx, req)
+			}
+		}
+		return nil, status.Errorf(codes.PermissionDenied, "%s is only allowed to %v", info.FullMethod, identities)
+	}
+}
+
+// keyPair is a certificate and key read again once their files changed.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// get returns the current certificate. While the files are being replaced
+// they may be missing or not match, the last certificate that loaded is kept
+// until they do.
+func (k *keyPair) get() (*tls.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	modTime, err := latestModTime(k.certFile, k.keyFile)
+	if err != nil {
+		if k.cert != nil {
+			return k.cert, nil
+		}
+		return nil, err
+	}
+	if k.cert != nil && modTime.Equal(k.modTime) {
+		return k.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		if k.cert != nil {
+			return k.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load certificate %s: %w", k.certFile, err)
+	}
+	k.cert, k.modTime = &cert, modTime
+	return k.cert, nil
+}
+
+// certPool is a set of CA certificates read again once their file changed.
+type certPool struct {
+	file string
+
+	mu      sync.Mutex
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+// get returns the current CA certificates, or the last ones that loaded while
+// the file is being replaced.
+func (c *certPool) get() (*x509.CertPool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTime, err := latestModTime(c.file)
+	if err == nil && c.pool != nil && modTime.Equal(c.modTime) {
+		return c.pool, nil
+	}
+
+	var data []byte
+	if err == nil {
+		data, err = os.ReadFile(c.file)
+	}
+	if err != nil {
+		if c.pool != nil {
+			return c.pool, nil
+		}
+		return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		if c.pool != nil {
+			return c.pool, nil
+		}
+		return nil, errors.New("no CA certificates found in " + c.file)
+	}
+	c.pool, c.modTime = pool, modTime
+	return c.pool, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
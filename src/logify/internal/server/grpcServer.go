@@ -4,14 +4,21 @@ import (
 	"context"
 	"log"
 
+	"logify/internal/database"
 	pb "logify/internal/genprotobuf/project_log"
+	"logify/internal/grpctls"
 	"logify/internal/utils"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type grpcServer struct {
 	pb.UnimplementedProjectLogServiceServer
+	db   database.Service
+	logs *utils.KinesisStream
 }
 
 func (s *grpcServer) PushLogs(ctx context.Context, req *pb.PushLogsRequest) (*pb.PushLogsResponse, error) {
@@ -21,7 +28,7 @@ func (s *grpcServer) PushLogs(ctx context.Context, req *pb.PushLogsRequest) (*pb
 		"timestamp": req.LogEntry.Timestamp,
 	}
 
-	err := utils.PushDataToKinesisStream(data)
+	err := s.logs.Push(ctx, data)
 	if err != nil {
 		log.Printf("Failed to push log to Kinesis stream: %v", err)
 		return &pb.PushLogsResponse{
@@ -36,8 +43,56 @@ func (s *grpcServer) PushLogs(ctx context.Context, req *pb.PushLogsRequest) (*pb
 	}, nil
 }
 
-func NewGRPCServer() *grpc.Server {
-	s := grpc.NewServer()
-	pb.RegisterProjectLogServiceServer(s, &grpcServer{})
-	return s
+// PurgeProjectLogs deletes every stored log of a project, when the project is deleted.
+func (s *grpcServer) PurgeProjectLogs(ctx context.Context, req *pb.PurgeProjectLogsRequest) (*pb.PurgeProjectLogsResponse, error) {
+	if req.ProjectId == "" {
+		return nil, status.Error(codes.InvalidArgument, "project_id is required")
+	}
+
+	deleted, err := s.db.PurgeProjectLogs(ctx, req.ProjectId)
+	if err != nil {
+		log.Printf("Failed to purge logs of project %s: %v", req.ProjectId, err)
+		return nil, status.Errorf(codes.Internal, "failed to purge logs: %v", err)
+	}
+
+	log.Printf("Purged %d logs of project %s", deleted, req.ProjectId)
+	return &pb.PurgeProjectLogsResponse{
+		Success: true,
+		Message: "Logs purged successfully",
+		Deleted: deleted,
+	}, nil
+}
+
+// Methods that change the logs of a project, only forge may call them.
+const (
+	pushLogsMethod         = "/project_log.ProjectLogService/PushLogs"
+	purgeProjectLogsMethod = "/project_log.ProjectLogService/PurgeProjectLogs"
+)
+
+// NewGRPCServer returns the gRPC server of logify. With mtls, only clients
+// whose certificate names one of writers may push and purge logs. Without a
+// client certificate there is no identity to check, so every client may.
+func NewGRPCServer(db database.Service, logs *utils.KinesisStream, tlsConfig grpctls.Config, writers []string) (*grpc.Server, error) {
+	creds, err := grpctls.ServerCredentials(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.Creds(creds),
+		// Requests continue the trace of the caller
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	}
+	if tlsConfig.Mode == grpctls.ModeMTLS {
+		opts = append(opts, grpc.UnaryInterceptor(grpctls.AuthorizePeers(map[string][]string{
+			pushLogsMethod:         writers,
+			purgeProjectLogsMethod: writers,
+		})))
+	} else {
+		log.Printf("GRPC_TLS_MODE is %s, every client may push and purge logs, use mtls to restrict them to %v", tlsConfig.Mode, writers)
+	}
+
+	s := grpc.NewServer(opts...)
+	pb.RegisterProjectLogServiceServer(s, &grpcServer{db: db, logs: logs})
+	return s, nil
 }
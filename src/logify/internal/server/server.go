@@ -5,8 +5,6 @@ import (
 	"log"
 	"logify/internal/database"
 	"net/http"
-	"os"
-	"strconv"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
@@ -17,11 +15,10 @@ type Server struct {
 	db   database.Service
 }
 
-func NewServer() *http.Server {
-	port, _ := strconv.Atoi(os.Getenv("PORT"))
+func NewServer(port int, db database.Service) *http.Server {
 	NewServer := &Server{
 		port: port,
-		db:   database.New(),
+		db:   db,
 	}
 
 	if err := NewServer.db.Migrate(); err != nil {
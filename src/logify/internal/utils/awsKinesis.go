@@ -5,17 +5,29 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/kinesis"
 )
 
-func GetAWSConfig() aws.Config {
-	AWS_REGION := os.Getenv("AWS_REGION")
+// KinesisConfig locates the stream build logs are pushed to.
+type KinesisConfig struct {
+	Region       string
+	Stream       string
+	PartitionKey string
+}
+
+// KinesisStream pushes records to a Kinesis stream.
+type KinesisStream struct {
+	client       *kinesis.Client
+	stream       string
+	partitionKey string
+}
+
+func GetAWSConfig(region string) aws.Config {
 	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(AWS_REGION),
+		config.WithRegion(region),
 	)
 	if err != nil {
 		log.Fatalf("unable to load SDK config, %v", err)
@@ -23,33 +35,29 @@ func GetAWSConfig() aws.Config {
 	return cfg
 }
 
-func GetKinesisClient() *kinesis.Client {
-	cfg := GetAWSConfig()
-	client := kinesis.NewFromConfig(cfg)
-	return client
+func NewKinesisStream(cfg KinesisConfig) *KinesisStream {
+	return &KinesisStream{
+		client:       kinesis.NewFromConfig(GetAWSConfig(cfg.Region)),
+		stream:       cfg.Stream,
+		partitionKey: cfg.PartitionKey,
+	}
 }
 
-func PushDataToKinesisStream(data map[string]any) error {
-	client := GetKinesisClient()
-
-	streamName := os.Getenv("AWS_KINESIS_STREAM")
+func (k *KinesisStream) Push(ctx context.Context, data map[string]any) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		log.Fatalf("failed to marshal data: %v", err)
-		return err
+		return fmt.Errorf("failed to marshal data: %w", err)
 	}
 
-	partitionKey := os.Getenv("AWS_KINESIS_STREAM_PARTITION_KEY")
 	input := &kinesis.PutRecordInput{
 		Data:         jsonData,
-		PartitionKey: aws.String(partitionKey),
-		StreamName:   aws.String(streamName),
+		PartitionKey: aws.String(k.partitionKey),
+		StreamName:   aws.String(k.stream),
 	}
 
-	result, err := client.PutRecord(context.TODO(), input)
+	result, err := k.client.PutRecord(ctx, input)
 	if err != nil {
-		log.Fatalf("failed to put record to Kinesis: %v", err)
-		return err
+		return fmt.Errorf("failed to put record to Kinesis: %w", err)
 	}
 
 	fmt.Printf("Successfully put logs record to Kinesis. Shard ID: %s, Sequence number: %s\n",
@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"logify/internal/config"
+	"logify/internal/grpctls"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// setRequired sets the settings logify requires and unsets those in skip.
+func setRequired(t *testing.T, skip ...string) {
+	values := map[string]string{
+		"PORT":                             "8080",
+		"GRPC_SERVER_ADDRESS":              "0.0.0.0:50051",
+		"AWS_REGION":                       "us-east-1",
+		"AWS_KINESIS_STREAM":               "logs",
+		"AWS_KINESIS_STREAM_PARTITION_KEY": "builds",
+		"DB_HOST":                          "localhost",
+		"DB_PORT":                          "5432",
+		"DB_USERNAME":                      "aether",
+		"DB_DATABASE":                      "aether-logs",
+		"MAIN_DB_DATABASE":                 "aether",
+	}
+	for key, value := range values {
+		if slices.Contains(skip, key) {
+			value = ""
+		}
+		t.Setenv(key, value)
+	}
+	// Unset the optional settings the tests check
+	for _, key := range []string{"GRPC_TLS_MODE", "GRPC_TLS_CERT_FILE", "GRPC_TLS_KEY_FILE", "GRPC_TLS_CA_FILE", "GRPC_LOG_WRITERS", "OTEL_TRACES_EXPORTER"} {
+		t.Setenv(key, "")
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "logify.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	setRequired(t)
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GRPCTLS.Mode != grpctls.ModeNone {
+		t.Errorf("got tls mode %q", cfg.GRPCTLS.Mode)
+	}
+	if !slices.Equal(cfg.LogWriters, []string{"forge"}) {
+		t.Errorf("got log writers %v", cfg.LogWriters)
+	}
+	if cfg.TracesExporter != "none" {
+		t.Errorf("got traces exporter %q", cfg.TracesExporter)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	setRequired(t, "DB_HOST")
+	t.Setenv("GRPC_LOG_WRITERS", "forge, forge-canary")
+	path := writeConfig(t, `
+DB_HOST: db.internal
+GRPC_TLS_MODE: mtls
+GRPC_TLS_CERT_FILE: /etc/aether/grpc/tls.crt
+GRPC_TLS_KEY_FILE: /etc/aether/grpc/tls.key
+GRPC_TLS_CA_FILE: /etc/aether/grpc/ca.crt
+GRPC_LOG_WRITERS: launchpad
+`)
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Host != "db.internal" || cfg.GRPCTLS.Mode != grpctls.ModeMTLS || cfg.GRPCTLS.CAFile != "/etc/aether/grpc/ca.crt" {
+		t.Errorf("file settings not read: %+v", cfg)
+	}
+	// The environment overrides the file
+	if !slices.Equal(cfg.LogWriters, []string{"forge", "forge-canary"}) {
+		t.Errorf("got log writers %v", cfg.LogWriters)
+	}
+	// The file is not copied into the environment
+	if value := os.Getenv("DB_HOST"); value != "" {
+		t.Errorf("DB_HOST was set to %q", value)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		skip    []string
+		env     map[string]string
+		content string
+		errors  []string
+	}{
+		{
+			name:   "missing settings",
+			skip:   []string{"PORT", "DB_HOST"},
+			errors: []string{"PORT is required", "DB_HOST is required"},
+		},
+		{
+			name:   "invalid port",
+			env:    map[string]string{"PORT": "80000"},
+			errors: []string{"invalid PORT"},
+		},
+		{
+			name:   "invalid tls mode",
+			env:    map[string]string{"GRPC_TLS_MODE": "plaintext"},
+			errors: []string{"invalid GRPC_TLS_MODE"},
+		},
+		{
+			name:   "mtls without certificates",
+			env:    map[string]string{"GRPC_TLS_MODE": "mtls"},
+			errors: []string{"GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE are required", "GRPC_TLS_CA_FILE is required"},
+		},
+		{
+			name:    "unknown file setting",
+			content: "GRPC_TLS_MOD: mtls\n",
+			errors:  []string{"unknown setting GRPC_TLS_MOD"},
+		},
+		{
+			name:    "list in file",
+			content: "GRPC_LOG_WRITERS: [forge]\n",
+			errors:  []string{"must be a single value"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequired(t, tt.skip...)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			path := ""
+			if tt.content != "" {
+				path = writeConfig(t, tt.content)
+			}
+
+			_, err := config.Load(path)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			for _, want := range tt.errors {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
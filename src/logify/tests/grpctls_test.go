@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"logify/internal/grpctls"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// withPeer returns a context of a request from a client whose verified
+// certificate has the common name and DNS names, or without a certificate
+// when cert is nil.
+func withPeer(cert *x509.Certificate) context.Context {
+	info := credentials.TLSInfo{}
+	if cert != nil {
+		info.State = tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: info})
+}
+
+func TestAuthorizePeers(t *testing.T) {
+	const pushLogs = "/project_log.ProjectLogService/PushLogs"
+	interceptor := grpctls.AuthorizePeers(map[string][]string{pushLogs: {"forge"}})
+	handler := func(context.Context, any) (any, error) { return "handled", nil }
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		method  string
+		allowed bool
+	}{
+		{"common name", withPeer(&x509.Certificate{Subject: pkix.Name{CommonName: "forge"}}), pushLogs, true},
+		{"dns name", withPeer(&x509.Certificate{Subject: pkix.Name{CommonName: "worker"}, DNSNames: []string{"forge"}}), pushLogs, true},
+		{"other identity", withPeer(&x509.Certificate{Subject: pkix.Name{CommonName: "launchpad"}}), pushLogs, false},
+		{"no certificate", withPeer(nil), pushLogs, false},
+		{"no peer", context.Background(), pushLogs, false},
+		{"open method", withPeer(nil), "/project_log.ProjectLogService/GetLogs", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := interceptor(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			if tt.allowed {
+				if err != nil || resp != "handled" {
+					t.Fatalf("got %v, %v, want the handler to run", resp, err)
+				}
+				return
+			}
+			if status.Code(err) != codes.PermissionDenied {
+				t.Fatalf("got %v, want PermissionDenied", err)
+			}
+		})
+	}
+}
+
+func TestPeerIdentities(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "forge"}, DNSNames: []string{"forge.aether.svc"}}
+	identities := grpctls.PeerIdentities(withPeer(cert))
+	if len(identities) != 2 || identities[0] != "forge" || identities[1] != "forge.aether.svc" {
+		t.Fatalf("got %v", identities)
+	}
+	if identities := grpctls.PeerIdentities(withPeer(nil)); identities != nil {
+		t.Fatalf("got %v for a client without a certificate", identities)
+	}
+}
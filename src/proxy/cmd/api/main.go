@@ -1,15 +1,41 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log"
+	"os"
+	"proxy/internal/config"
 	"proxy/internal/server"
+	"proxy/internal/tracing"
 )
 
 func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML file with settings that are not set in the environment")
+	checkConfig := flag.Bool("check-config", false, "validate the config, print it and exit")
+	flag.Parse()
 
-	server := server.NewServer()
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Invalid config:\n%v", err)
+	}
+	if *checkConfig {
+		cfg.Print(os.Stdout)
+		fmt.Println("Config is valid")
+		return
+	}
+	cfg.Print(log.Writer())
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "proxy", cfg.TracesExporter, cfg.OTLPEndpoint)
+	if err != nil {
+		panic(fmt.Sprintf("cannot set up tracing: %s", err))
+	}
+	defer shutdownTracing(context.Background())
+
+	server := server.NewServer(cfg)
 
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if err != nil {
 		panic(fmt.Sprintf("cannot start server: %s", err))
 	}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Keys are the settings of the proxy. Environment variables take precedence
+// over the config file.
+var Keys = []string{
+	"APP_ENV",
+	"PORT",
+	"BUCKET_BASE_PATH",
+	"OTEL_TRACES_EXPORTER",
+	"OTEL_EXPORTER_OTLP_ENDPOINT",
+}
+
+// Config is the effective configuration of the proxy.
+type Config struct {
+	AppEnv         string
+	Port           int
+	BucketBasePath string
+	TracesExporter string
+	OTLPEndpoint   string
+}
+
+// Load reads the config from the environment and the YAML file at path, when
+// path is not empty, and reports every missing or invalid setting.
+func Load(path string) (*Config, error) {
+	var file settings
+	if path != "" {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := &Config{
+		AppEnv:         file.lookup("APP_ENV"),
+		BucketBasePath: strings.TrimSuffix(file.lookup("BUCKET_BASE_PATH"), "/"),
+		TracesExporter: file.lookup("OTEL_TRACES_EXPORTER"),
+		OTLPEndpoint:   file.lookup("OTEL_EXPORTER_OTLP_ENDPOINT"),
+	}
+
+	var errs []error
+	port, err := strconv.Atoi(file.lookup("PORT"))
+	if err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid PORT: %q", file.lookup("PORT")))
+	}
+	cfg.Port = port
+
+	if base, err := url.Parse(cfg.BucketBasePath); cfg.BucketBasePath == "" || err != nil || base.Scheme == "" || base.Host == "" {
+		errs = append(errs, fmt.Errorf("invalid BUCKET_BASE_PATH, it must be an absolute URL: %q", cfg.BucketBasePath))
+	}
+
+	if cfg.TracesExporter == "" {
+		cfg.TracesExporter = "none"
+	}
+	if !slices.Contains([]string{"none", "stdout", "otlp"}, cfg.TracesExporter) {
+		errs = append(errs, fmt.Errorf("invalid OTEL_TRACES_EXPORTER, it must be none, stdout or otlp: %q", cfg.TracesExporter))
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// settings are the values of a config file.
+type settings map[string]string
+
+// lookup returns the environment variable key, or the value of the config file
+// when it is not set.
+func (s settings) lookup(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return s[key]
+}
+
+// readFile returns the settings of a YAML file. The environment is left
+// untouched.
+func readFile(path string) (settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	file := make(settings, len(values))
+	var errs []error
+	for key, value := range values {
+		switch value.(type) {
+		case nil:
+			continue
+		case []any, map[string]any:
+			errs = append(errs, fmt.Errorf("setting %s in config file %s must be a single value", key, path))
+			continue
+		}
+		if !slices.Contains(Keys, key) {
+			errs = append(errs, fmt.Errorf("unknown setting %s in config file %s", key, path))
+			continue
+		}
+		file[key] = fmt.Sprint(value)
+	}
+	return file, errors.Join(errs...)
+}
+
+// Print writes the config. The proxy has no secrets to redact.
+func (c *Config) Print(w io.Writer) {
+	fmt.Fprintf(w, "app env:          %s\n", c.AppEnv)
+	fmt.Fprintf(w, "port:             %d\n", c.Port)
+	fmt.Fprintf(w, "bucket base path: %s\n", c.BucketBasePath)
+	fmt.Fprintf(w, "traces exporter:  %s\n", c.TracesExporter)
+	fmt.Fprintf(w, "otlp endpoint:    %s\n", c.OTLPEndpoint)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// pointerTTL is how long a resolved pointer is served before it is read
+// again, which bounds how long a promote or rollback takes to show up.
+const pointerTTL = 5 * time.Second
+
+// errSiteNotFound is returned when a site has no pointer object, it was
+// never published or its preview was deleted.
+var errSiteNotFound = errors.New("site not found")
+
+var deploymentIDRe = regexp.MustCompile("^[A-Za-z0-9-]{1,64}$")
+
+type cachedPointer struct {
+	deploymentID string
+	err          error
+	expires      time.Time
+}
+
+// pointerResolver reads the pointer objects the worker publishes for the
+// live site and each branch preview, and returns the deployment they name.
+// Switching a pointer is a single PUT, so a site never serves a mix of two
+// deployments.
+type pointerResolver struct {
+	basePath string
+	client   *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedPointer
+}
+
+func newPointerResolver(basePath string) *pointerResolver {
+	return &pointerResolver{
+		basePath: strings.TrimSuffix(basePath, "/"),
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+		cache: make(map[string]cachedPointer),
+	}
+}
+
+// pointerPath returns the path of the pointer object of a site, relative
+// to the bucket base path.
+func pointerPath(projectID, preview string) string {
+	if preview != "" {
+		return fmt.Sprintf("%s/previews/%s", projectID, preview)
+	}
+	return fmt.Sprintf("%s/live", projectID)
+}
+
+// resolve returns the deployment served for a project, or for one of its
+// branch previews when preview is not empty.
+func (p *pointerResolver) resolve(ctx context.Context, projectID, preview string) (string, error) {
+	key := pointerPath(projectID, preview)
+
+	p.mu.Lock()
+	cached, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.deploymentID, cached.err
+	}
+
+	deploymentID, err := p.fetch(ctx, key)
+	// Failures reaching the bucket are not cached, only definite answers
+	if err == nil || errors.Is(err, errSiteNotFound) {
+		p.mu.Lock()
+		p.cache[key] = cachedPointer{deploymentID: deploymentID, err: err, expires: time.Now().Add(pointerTTL)}
+		p.mu.Unlock()
+	}
+	return deploymentID, err
+}
+
+func (p *pointerResolver) fetch(ctx context.Context, key string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.basePath+"/"+key, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pointer %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	// The bucket only grants public reads on objects, so a missing object
+	// is reported as forbidden rather than not found
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden:
+		return "", errSiteNotFound
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("failed to read pointer %s: %s", key, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 128))
+	if err != nil {
+		return "", fmt.Errorf("failed to read pointer %s: %w", key, err)
+	}
+	deploymentID := strings.TrimSpace(string(body))
+	if !deploymentIDRe.MatchString(deploymentID) {
+		return "", fmt.Errorf("pointer %s names an invalid deployment %q", key, deploymentID)
+	}
+	return deploymentID, nil
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"golang.org/x/time/rate"
 )
 
@@ -69,30 +71,46 @@ func (s *Server) proxyMiddleware(next http.Handler) http.Handler {
 		defer cancel()
 		r = r.WithContext(ctx)
 
+		// Sites are <project id> for the live files and <branch>--<project id> for
+		// branch previews, in the first host label or path segment. Hosts are
+		// case-insensitive, so they are matched lowercased
+		var site, stripPrefix string
 		pathParts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
-
-		var projectID string
-		if len(pathParts) > 0 && isValidUUID(pathParts[0]) {
-			projectID = pathParts[0]
+		if label, _, _ := strings.Cut(strings.ToLower(r.Host), "."); isValidSite(label) {
+			site = label
+		} else if len(pathParts) > 0 && isValidSite(pathParts[0]) {
+			site = pathParts[0]
+			stripPrefix = "/" + site
 			http.SetCookie(w, &http.Cookie{
 				Name:     "projectID",
-				Value:    projectID,
+				Value:    site,
 				Path:     "/",
 				HttpOnly: true, // Prevents JavaScript access to the cookie
 				SameSite: http.SameSiteLaxMode,
 			})
-			log.Printf("Set cookie with projectID: %s", projectID)
+			log.Printf("Set cookie with projectID: %s", site)
 		} else {
 			cookie, err := r.Cookie("projectID")
-			if err != nil {
+			if err != nil || !isValidSite(cookie.Value) {
 				log.Printf("No projectID cookie found and no valid UUID in path: %v", err)
 				http.Error(w, "Project ID not found", http.StatusBadRequest)
 				return
 			}
-			projectID = cookie.Value
+			site = cookie.Value
 		}
 
-		resolvesTo := fmt.Sprintf("%s/%s/build", s.basePath, projectID)
+		projectID, preview := parseSite(site)
+		deploymentID, err := s.pointers.resolve(ctx, projectID, preview)
+		if errors.Is(err, errSiteNotFound) {
+			http.Error(w, "Site not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error resolving site %s: %v", site, err)
+			http.Error(w, "Proxy Error", http.StatusBadGateway)
+			return
+		}
+		resolvesTo := fmt.Sprintf("%s/%s/deployments/%s", s.basePath, projectID, deploymentID)
 
 		target, err := url.Parse(resolvesTo)
 		if err != nil {
@@ -102,23 +120,42 @@ func (s *Server) proxyMiddleware(next http.Handler) http.Handler {
 		}
 
 		proxy := httputil.NewSingleHostReverseProxy(target)
-		proxy.Director = s.modifyRequest(target, projectID)
+		proxy.Director = s.modifyRequest(target, stripPrefix)
 		proxy.ErrorHandler = s.errorHandler
+		// Requests to the bucket are traced as children of the request span
+		proxy.Transport = otelhttp.NewTransport(http.DefaultTransport)
 		proxy.ServeHTTP(w, r)
 	})
 }
 
-func isValidUUID(uuid string) bool {
-	re := regexp.MustCompile("^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}$")
-	return re.MatchString(uuid)
+// siteRe matches a project id, optionally preceded by the slug of a branch
+// preview and "--". Slugs and project ids are lowercase, like the keys of
+// their pointer objects.
+var siteRe = regexp.MustCompile("^(?:([a-z0-9]+(?:-[a-z0-9]+)*)--)?([a-f0-9]{8}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{12})$")
+
+func isValidSite(site string) bool {
+	return siteRe.MatchString(site)
 }
 
-func (s *Server) modifyRequest(target *url.URL, projectID string) func(*http.Request) {
+// parseSite returns the project id of a site, and the branch preview it
+// serves or an empty string for the live files.
+func parseSite(site string) (projectID, preview string) {
+	match := siteRe.FindStringSubmatch(site)
+	if match == nil {
+		return "", ""
+	}
+	return match[2], match[1]
+}
+
+func (s *Server) modifyRequest(target *url.URL, stripPrefix string) func(*http.Request) {
 	return func(req *http.Request) {
 		req.URL.Scheme = target.Scheme
 		req.URL.Host = target.Host
 
-		path := strings.TrimPrefix(req.URL.Path, "/"+projectID)
+		path := req.URL.Path
+		if stripPrefix != "" {
+			path = strings.TrimPrefix(path, stripPrefix)
+		}
 		if path == "" || path == "/" {
 			path = "/index.html"
 		}
@@ -4,44 +4,36 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"strconv"
+	"proxy/internal/config"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	_ "github.com/joho/godotenv/autoload"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type Server struct {
 	port     int
 	basePath string
+	pointers *pointerResolver
 	router   *chi.Mux
 }
 
-func NewServer() *http.Server {
-	port, err := strconv.Atoi(os.Getenv("PORT"))
-	if err != nil {
-		log.Fatalf("Invalid PORT: %v", err)
-	}
-
-	basePath := os.Getenv("BUCKET_BASE_PATH")
-	if basePath == "" {
-		log.Fatal("BUCKET_BASE_PATH environment variable is not set")
-	}
-
+func NewServer(cfg *config.Config) *http.Server {
 	s := &Server{
-		port:     port,
-		basePath: basePath,
+		port:     cfg.Port,
+		basePath: cfg.BucketBasePath,
+		pointers: newPointerResolver(cfg.BucketBasePath),
 		router:   chi.NewRouter(),
 	}
 
 	s.setupRoutes()
 
-	log.Println("Reverse proxy running on", port)
+	log.Println("Reverse proxy running on", s.port)
 
 	return &http.Server{
 		Addr:         fmt.Sprintf(":%d", s.port),
-		Handler:      s.router,
+		Handler:      otelhttp.NewHandler(s.router, "proxy"),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
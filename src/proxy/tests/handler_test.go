@@ -1,8 +1,77 @@
 package tests
 
 import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"proxy/internal/config"
+	"proxy/internal/server"
+	"strings"
 	"testing"
 )
 
+const projectID = "3f1c2a4e-1111-4222-8333-123456789abc"
+
+// newBucket serves the live site of the project from deployment d1 and the
+// preview of feature-x-452392 from d2.
+func newBucket(t *testing.T) *httptest.Server {
+	objects := map[string]string{
+		"/" + projectID + "/live":                          "d1",
+		"/" + projectID + "/previews/feature-x-452392":     "d2",
+		"/" + projectID + "/deployments/d1/index.html":     "live",
+		"/" + projectID + "/deployments/d2/index.html":     "preview",
+		"/" + projectID + "/deployments/d1/app/index.html": "live app",
+	}
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		object, ok := objects[r.URL.Path]
+		if !ok {
+			// The bucket reports missing objects as forbidden
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, object)
+	}))
+	t.Cleanup(bucket.Close)
+	return bucket
+}
+
 func TestHandler(t *testing.T) {
+	upper := strings.ToUpper(projectID)
+	tests := []struct {
+		name   string
+		host   string
+		path   string
+		status int
+		body   string
+	}{
+		{"project host", projectID + ".sites.example.com", "/", http.StatusOK, "live"},
+		{"project host path", projectID + ".sites.example.com", "/app/index.html", http.StatusOK, "live app"},
+		{"uppercase project host", upper + ".Sites.Example.com", "/", http.StatusOK, "live"},
+		{"preview host", "feature-x-452392--" + projectID + ".sites.example.com", "/", http.StatusOK, "preview"},
+		{"uppercase preview host", "Feature-X-452392--" + upper + ".sites.example.com", "/", http.StatusOK, "preview"},
+		{"project path", "sites.example.com", "/" + projectID, http.StatusOK, "live"},
+		{"preview path", "sites.example.com", "/feature-x-452392--" + projectID + "/", http.StatusOK, "preview"},
+		{"unknown preview", "feature-y--" + projectID + ".sites.example.com", "/", http.StatusNotFound, ""},
+		{"not a project", "www.sites.example.com", "/", http.StatusBadRequest, ""},
+		{"invalid slug", "feature_x--" + projectID + ".sites.example.com", "/", http.StatusBadRequest, ""},
+		{"empty slug", "--" + projectID + ".sites.example.com", "/", http.StatusBadRequest, ""},
+		{"double dash in slug", "feature--x--" + projectID + ".sites.example.com", "/", http.StatusBadRequest, ""},
+		{"short project id", "3f1c2a4e-1111-4222-8333.sites.example.com", "/", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := server.NewServer(&config.Config{Port: 8080, BucketBasePath: newBucket(t).URL}).Handler
+
+			req := httptest.NewRequest(http.MethodGet, "http://"+tt.host+tt.path, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("got body %q, want %q", rec.Body, tt.body)
+			}
+		})
+	}
 }
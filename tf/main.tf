@@ -291,7 +291,7 @@ resource "aws_s3_bucket_policy" "aether_bucket_policy" {
         Effect    = "Allow"
         Principal = "*"
         Action    = "s3:GetObject"
-        Resource  = "${aws_s3_bucket.aether.arn}/*"
+        Resource  = "${aws_s3_bucket.aether.arn}/sites/*"
       }
     ]
   })
@@ -306,8 +306,38 @@ resource "aws_s3_bucket_public_access_block" "aether" {
   restrict_public_buckets = false
 }
 
+resource "aws_sqs_queue" "aether_dlq" {
+  name                      = "${var.sqs_queue_name}-dlq"
+  message_retention_seconds = 1209600
+}
+
+# Forge dead-letters messages after BUILD_MAX_ATTEMPTS failed builds itself,
+# the redrive policy only catches messages no worker could settle
 resource "aws_sqs_queue" "aether" {
   name = var.sqs_queue_name
+
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.aether_dlq.arn
+    maxReceiveCount     = var.sqs_max_receive_count
+  })
+}
+
+resource "aws_sqs_queue" "aether_control" {
+  name = "aether-control-queue"
+
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.aether_dlq.arn
+    maxReceiveCount     = var.sqs_max_receive_count
+  })
+}
+
+resource "aws_sqs_queue_redrive_allow_policy" "aether_dlq" {
+  queue_url = aws_sqs_queue.aether_dlq.id
+
+  redrive_allow_policy = jsonencode({
+    redrivePermission = "byQueue"
+    sourceQueueArns   = [aws_sqs_queue.aether.arn, aws_sqs_queue.aether_control.arn]
+  })
 }
 
 resource "aws_kinesis_stream" "aether" {
@@ -18,6 +18,14 @@ output "sqs_queue_url" {
   value = aws_sqs_queue.aether.url
 }
 
+output "sqs_dlq_url" {
+  value = aws_sqs_queue.aether_dlq.url
+}
+
+output "sqs_control_queue_url" {
+  value = aws_sqs_queue.aether_control.url
+}
+
 output "kinesis_stream_arn" {
   value = aws_kinesis_stream.aether.arn
 }
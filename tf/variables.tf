@@ -34,6 +34,12 @@ variable "sqs_queue_name" {
   type        = string
 }
 
+variable "sqs_max_receive_count" {
+  description = "Receives after which SQS moves a message to the dead-letter queue"
+  type        = number
+  default     = 50
+}
+
 variable "kinesis_stream_name" {
   description = "Name of the Kinesis stream"
   type        = string